@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
-	"strconv"
 	"strings"
 	"time"
 
@@ -15,10 +14,6 @@ import (
 	"github.com/kamalshkeir/lg"
 )
 
-var (
-	caches = kmap.New[string, *kmap.SafeMap[dbCache, any]](cacheMaxMemoryMb)
-)
-
 // BuilderM is query builder map string any
 type BuilderM struct {
 	nocache    bool
@@ -32,31 +27,40 @@ type BuilderM struct {
 	offset     string
 	statement  string
 	db         *DatabaseEntity
+	c          *Client
 	args       []any
 	order      []string
 	ctx        context.Context
 	trace      bool
+	primary    bool
 }
 
 // Table is a starter for BuiderM
 func Table(tableName string) *BuilderM {
+	return defaultClient.Table(tableName)
+}
+
+// Table is a starter for BuiderM using databases of c
+func (c *Client) Table(tableName string) *BuilderM {
 	return &BuilderM{
 		tableName: tableName,
-		db:        &databases[0],
+		db:        &c.databases[0],
+		c:         c,
 	}
 }
 
 func BuilderMap() *BuilderM {
 	return &BuilderM{
-		db: &databases[0],
+		db: &defaultClient.databases[0],
+		c:  defaultClient,
 	}
 }
 
 // Database allow to choose database to execute query on
 func (b *BuilderM) Database(dbName string) *BuilderM {
-	db, err := GetMemoryDatabase(dbName)
+	db, err := b.c.GetMemoryDatabase(dbName)
 	if lg.CheckError(err) {
-		db = &databases[0]
+		db = &b.c.databases[0]
 	}
 	b.db = db
 	return b
@@ -67,13 +71,10 @@ func (b *BuilderM) Select(columns ...string) *BuilderM {
 	if b == nil || b.tableName == "" {
 		return nil
 	}
-	quote := "`"
-	if b.db.Dialect == POSTGRES || b.db.Dialect == COCKROACH {
-		quote = "\""
-	}
+	d := dialectOf(b.db.Dialect)
 	for i := range columns {
-		if !strings.HasPrefix(columns[i], quote) && !strings.HasPrefix(columns[i], "'") {
-			columns[i] = quote + columns[i] + quote
+		if !strings.HasPrefix(columns[i], "'") {
+			columns[i] = d.Quote(columns[i])
 		}
 	}
 	b.selected = strings.Join(columns, ",")
@@ -255,6 +256,12 @@ func (b *BuilderM) Debug() *BuilderM {
 	return b
 }
 
+// Primary force reads to use the primary connection even if replicas exist, useful to read your own writes
+func (b *BuilderM) Primary() *BuilderM {
+	b.primary = true
+	return b
+}
+
 func (b *BuilderM) NoCache() *BuilderM {
 	b.nocache = true
 	return b
@@ -271,7 +278,7 @@ func (b *BuilderM) All() ([]map[string]any, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -279,7 +286,7 @@ func (b *BuilderM) All() ([]map[string]any, error) {
 		return nil, ErrTableNotFound
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 
 	c := dbCache{
@@ -293,11 +300,12 @@ func (b *BuilderM) All() ([]map[string]any, error) {
 		limit:      b.limit,
 		page:       b.page,
 		args:       fmt.Sprint(b.args...),
+		primary:    b.primary,
 	}
 	// Use database+table as cache key to prevent cross-database cache pollution
 	cacheKey := b.db.Name + "::m::" + b.tableName
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			if vv, ok := v.Get(c); ok {
 				if vvs, ok := vv.([]map[string]any); ok {
 					return vvs, nil
@@ -321,12 +329,7 @@ func (b *BuilderM) All() ([]map[string]any, error) {
 	}
 
 	if b.limit > 0 {
-		i := strconv.Itoa(b.limit)
-		b.statement += " LIMIT " + i
-		if b.page > 0 {
-			o := strconv.Itoa((b.page - 1) * b.limit)
-			b.statement += " OFFSET " + o
-		}
+		b.statement += b.db.limitClause(b.limit, b.page, b.orderBys != "")
 	}
 
 	if b.debug {
@@ -337,13 +340,13 @@ func (b *BuilderM) All() ([]map[string]any, error) {
 		return nil, err
 	}
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			v.Set(c, models)
-			caches.Set(cacheKey, v)
+			b.c.caches.Set(cacheKey, v)
 		} else {
 			new := kmap.New[dbCache, any]()
 			new.Set(c, models)
-			caches.Set(cacheKey, new)
+			b.c.caches.Set(cacheKey, new)
 		}
 	}
 	return models, nil
@@ -362,7 +365,7 @@ func (b *BuilderM) One() (map[string]any, error) {
 		return nil, ErrTableNotFound
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 
 	c := dbCache{
@@ -376,11 +379,12 @@ func (b *BuilderM) One() (map[string]any, error) {
 		limit:      b.limit,
 		page:       b.page,
 		args:       fmt.Sprint(b.args...),
+		primary:    b.primary,
 	}
 	// Use database+table as cache key to prevent cross-database cache pollution
 	cacheKey := b.db.Name + "::m::" + b.tableName
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			if vv, ok := v.Get(c); ok {
 				if vvmap, ok := vv.(map[string]any); ok {
 					return vvmap, nil
@@ -404,10 +408,9 @@ func (b *BuilderM) One() (map[string]any, error) {
 	}
 
 	if b.limit > 0 {
-		i := strconv.Itoa(b.limit)
-		b.statement += " LIMIT " + i
+		b.statement += b.db.limitClause(b.limit, 0, b.orderBys != "")
 	} else {
-		b.statement += " LIMIT 1"
+		b.statement += b.db.limitClause(1, 0, b.orderBys != "")
 	}
 
 	if b.debug {
@@ -423,13 +426,13 @@ func (b *BuilderM) One() (map[string]any, error) {
 		return nil, ErrNoData
 	}
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			v.Set(c, models[0])
-			caches.Set(cacheKey, v)
+			b.c.caches.Set(cacheKey, v)
 		} else {
 			new := kmap.New[dbCache, any]()
 			new.Set(c, models[0])
-			caches.Set(cacheKey, new)
+			b.c.caches.Set(cacheKey, new)
 		}
 	}
 
@@ -447,7 +450,7 @@ func (b *BuilderM) Insert(rowData map[string]any) (int, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -458,7 +461,7 @@ func (b *BuilderM) Insert(rowData map[string]any) (int, error) {
 		return 0, ErrTableNotFound
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	pk := ""
 	var tbmem TableEntity
@@ -468,26 +471,16 @@ func (b *BuilderM) Insert(rowData map[string]any) (int, error) {
 			tbmem = t
 		}
 	}
-	quote := "`"
-	if b.db.Dialect == POSTGRES || b.db.Dialect == COCKROACH {
-		quote = "\""
-	}
+	d := dialectOf(b.db.Dialect)
 	placeholdersSlice := []string{}
 	keys := []string{}
 	values := []any{}
 	count := 0
 	for k, v := range rowData {
-		switch b.db.Dialect {
-		case POSTGRES, SQLITE:
-			placeholdersSlice = append(placeholdersSlice, "$"+strconv.Itoa(count+1))
-		case MYSQL, MARIA:
-			placeholdersSlice = append(placeholdersSlice, "?")
-		default:
-			return 0, errors.New("database is neither sqlite3, postgres or mysql")
-		}
+		placeholdersSlice = append(placeholdersSlice, d.Placeholder(count+1))
 
-		if !strings.HasPrefix(k, quote) && !strings.HasPrefix(k, "'") {
-			keys = append(keys, quote+k+quote)
+		if !strings.HasPrefix(k, "'") {
+			keys = append(keys, d.Quote(k))
 		} else {
 			keys = append(keys, k)
 		}
@@ -519,25 +512,22 @@ func (b *BuilderM) Insert(rowData map[string]any) (int, error) {
 		count++
 	}
 	placeholders := strings.Join(placeholdersSlice, ",")
+	output, returning := d.Returning(pk)
 	stat := strings.Builder{}
-	stat.WriteString("INSERT INTO " + quote + b.tableName + quote + " (")
+	stat.WriteString("INSERT INTO " + d.Quote(b.tableName) + " (")
 	stat.WriteString(strings.Join(keys, ","))
-	stat.WriteString(") VALUES (")
+	stat.WriteString(")" + output + " VALUES (")
 	stat.WriteString(placeholders)
-	stat.WriteString(")")
+	stat.WriteString(")" + returning)
 	statement := stat.String()
 	var id int
-	if b.db.Dialect != POSTGRES {
+	if output == "" && returning == "" {
 		if b.debug {
 			lg.InfoC("debug", "statement", b.statement, "args", values)
 		}
 		var res sql.Result
 		var err error
-		if b.ctx != nil {
-			res, err = b.db.Conn.ExecContext(b.ctx, statement, values...)
-		} else {
-			res, err = b.db.Conn.Exec(statement, values...)
-		}
+		res, err = b.db.execContext(b.ctx, statement, values...)
 		if err != nil {
 			return 0, err
 		}
@@ -549,14 +539,10 @@ func (b *BuilderM) Insert(rowData map[string]any) (int, error) {
 		}
 	} else {
 		if b.debug {
-			lg.InfoC("debug", "statement", statement+" RETURNING "+pk, "args", values)
+			lg.InfoC("debug", "statement", statement, "args", values)
 		}
 		var err error
-		if b.ctx != nil {
-			err = b.db.Conn.QueryRowContext(b.ctx, statement+" RETURNING "+pk, values...).Scan(&id)
-		} else {
-			err = b.db.Conn.QueryRow(statement+" RETURNING "+pk, values...).Scan(&id)
-		}
+		err = b.db.queryRowScan(b.ctx, []any{&id}, statement, values...)
 		if err != nil {
 			id = -1
 			return id, err
@@ -576,7 +562,7 @@ func (b *BuilderM) InsertR(rowData map[string]any) (map[string]any, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -585,7 +571,7 @@ func (b *BuilderM) InsertR(rowData map[string]any) (map[string]any, error) {
 	}
 
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	pk := ""
 	var tbmem TableEntity
@@ -595,25 +581,15 @@ func (b *BuilderM) InsertR(rowData map[string]any) (map[string]any, error) {
 			tbmem = t
 		}
 	}
-	quote := "`"
-	if b.db.Dialect == POSTGRES || b.db.Dialect == COCKROACH {
-		quote = "\""
-	}
+	d := dialectOf(b.db.Dialect)
 	placeholdersSlice := []string{}
 	keys := []string{}
 	values := []any{}
 	count := 0
 	for k, v := range rowData {
-		switch b.db.Dialect {
-		case POSTGRES, SQLITE:
-			placeholdersSlice = append(placeholdersSlice, "$"+strconv.Itoa(count+1))
-		case MYSQL, MARIA:
-			placeholdersSlice = append(placeholdersSlice, "?")
-		default:
-			return nil, errors.New("database is neither sqlite3, postgres or mysql")
-		}
-		if !strings.HasPrefix(k, quote) && !strings.HasPrefix(k, "'") {
-			keys = append(keys, quote+k+quote)
+		placeholdersSlice = append(placeholdersSlice, d.Placeholder(count+1))
+		if !strings.HasPrefix(k, "'") {
+			keys = append(keys, d.Quote(k))
 		} else {
 			keys = append(keys, k)
 		}
@@ -646,25 +622,33 @@ func (b *BuilderM) InsertR(rowData map[string]any) (map[string]any, error) {
 		count++
 	}
 	placeholders := strings.Join(placeholdersSlice, ",")
+	// dialects using OUTPUT return the inserted row directly
+	output, returning := d.Returning("*")
+	if output == "" {
+		output, returning = d.Returning(pk)
+	}
 	stat := strings.Builder{}
-	stat.WriteString("INSERT INTO " + quote + b.tableName + quote + " (")
+	stat.WriteString("INSERT INTO " + d.Quote(b.tableName) + " (")
 	stat.WriteString(strings.Join(keys, ","))
-	stat.WriteString(") VALUES (")
+	stat.WriteString(")" + output + " VALUES (")
 	stat.WriteString(placeholders)
-	stat.WriteString(")")
+	stat.WriteString(")" + returning)
 	statement := stat.String()
 	if b.debug {
 		lg.InfoC("debug", "statement", statement, "args", values)
 	}
+	if output != "" {
+		rows, err := b.c.Table(b.tableName).Database(b.db.Name).Primary().NoCache().Context(b.ctx).QueryM(statement, values...)
+		if err != nil {
+			return nil, err
+		}
+		return rows[0], nil
+	}
 	var id int
-	if b.db.Dialect != POSTGRES {
+	if output == "" && returning == "" {
 		var res sql.Result
 		var err error
-		if b.ctx != nil {
-			res, err = b.db.Conn.ExecContext(b.ctx, statement, values...)
-		} else {
-			res, err = b.db.Conn.Exec(statement, values...)
-		}
+		res, err = b.db.execContext(b.ctx, statement, values...)
 		if err != nil {
 			return nil, err
 		}
@@ -676,16 +660,12 @@ func (b *BuilderM) InsertR(rowData map[string]any) (map[string]any, error) {
 		}
 	} else {
 		var err error
-		if b.ctx != nil {
-			err = b.db.Conn.QueryRowContext(b.ctx, statement+" RETURNING "+pk, values...).Scan(&id)
-		} else {
-			err = b.db.Conn.QueryRow(statement+" RETURNING "+pk, values...).Scan(&id)
-		}
+		err = b.db.queryRowScan(b.ctx, []any{&id}, statement, values...)
 		if err != nil {
 			return nil, err
 		}
 	}
-	m, err := Table(b.tableName).Database(b.db.Name).Where(pk+"= ?", id).One()
+	m, err := b.c.Table(b.tableName).Database(b.db.Name).Primary().Where(pk+"= ?", id).One()
 	if err != nil {
 		return nil, err
 	}
@@ -703,7 +683,7 @@ func (b *BuilderM) BulkInsert(rowsData ...map[string]any) ([]int, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -711,19 +691,20 @@ func (b *BuilderM) BulkInsert(rowsData ...map[string]any) ([]int, error) {
 		return nil, ErrTableNotFound
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 
-	tx, err := b.db.Conn.BeginTx(context.Background(), &sql.TxOptions{})
+	ctx := b.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tx, err := b.db.Conn.BeginTx(ctx, &sql.TxOptions{})
 	if err != nil {
 		return nil, err
 	}
 	ids := []int{}
 	pk := ""
-	quote := "`"
-	if b.db.Dialect == POSTGRES || b.db.Dialect == COCKROACH {
-		quote = "\""
-	}
+	d := dialectOf(b.db.Dialect)
 	var tbmem TableEntity
 	for _, t := range b.db.Tables {
 		if t.Name == b.tableName {
@@ -737,16 +718,9 @@ func (b *BuilderM) BulkInsert(rowsData ...map[string]any) ([]int, error) {
 		values := []any{}
 		count := 0
 		for k, v := range rowsData[ii] {
-			switch b.db.Dialect {
-			case POSTGRES, SQLITE:
-				placeholdersSlice = append(placeholdersSlice, "$"+strconv.Itoa(count+1))
-			case MYSQL, MARIA:
-				placeholdersSlice = append(placeholdersSlice, "?")
-			default:
-				return nil, errors.New("database is neither sqlite3, postgres or mysql")
-			}
-			if !strings.HasPrefix(k, quote) && !strings.HasPrefix(k, "'") {
-				keys = append(keys, quote+k+quote)
+			placeholdersSlice = append(placeholdersSlice, d.Placeholder(count+1))
+			if !strings.HasPrefix(k, "'") {
+				keys = append(keys, d.Quote(k))
 			} else {
 				keys = append(keys, k)
 			}
@@ -777,24 +751,21 @@ func (b *BuilderM) BulkInsert(rowsData ...map[string]any) ([]int, error) {
 		}
 		placeholders := strings.Join(placeholdersSlice, ",")
 
+		output, returning := d.Returning(pk)
 		stat := strings.Builder{}
-		stat.WriteString("INSERT INTO " + quote + b.tableName + quote + " (")
+		stat.WriteString("INSERT INTO " + d.Quote(b.tableName) + " (")
 		stat.WriteString(strings.Join(keys, ","))
-		stat.WriteString(") VALUES (")
+		stat.WriteString(")" + output + " VALUES (")
 		stat.WriteString(placeholders)
-		stat.WriteString(")")
+		stat.WriteString(")" + returning)
 		statement := stat.String()
 		if b.debug {
 			lg.InfoC("debug", "statement", statement, "args", values)
 		}
-		if b.db.Dialect != POSTGRES {
+		if output == "" && returning == "" {
 			var res sql.Result
 			var err error
-			if b.ctx != nil {
-				res, err = b.db.Conn.ExecContext(b.ctx, statement, values...)
-			} else {
-				res, err = b.db.Conn.Exec(statement, values...)
-			}
+			res, err = tx.ExecContext(ctx, statement, values...)
 			if err != nil {
 				errRoll := tx.Rollback()
 				if errRoll != nil {
@@ -809,12 +780,9 @@ func (b *BuilderM) BulkInsert(rowsData ...map[string]any) ([]int, error) {
 			ids = append(ids, int(idInserted))
 		} else {
 			var idInserted int
-			if b.ctx != nil {
-				err = b.db.Conn.QueryRowContext(b.ctx, statement+" RETURNING "+pk, values...).Scan(&idInserted)
-			} else {
-				err = b.db.Conn.QueryRow(statement+" RETURNING "+pk, values...).Scan(&idInserted)
-			}
+			err = tx.QueryRowContext(ctx, statement, values...).Scan(&idInserted)
 			if err != nil {
+				_ = tx.Rollback()
 				return ids, err
 			}
 			ids = append(ids, idInserted)
@@ -838,7 +806,7 @@ func (b *BuilderM) Set(query string, args ...any) (int, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -846,7 +814,7 @@ func (b *BuilderM) Set(query string, args ...any) (int, error) {
 		return 0, ErrTableNotFound
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	if b.whereQuery == "" {
 		return 0, errors.New("you should use Where before Update")
@@ -862,11 +830,7 @@ func (b *BuilderM) Set(query string, args ...any) (int, error) {
 
 	var res sql.Result
 	var err error
-	if b.ctx != nil {
-		res, err = b.db.Conn.ExecContext(b.ctx, b.statement, args...)
-	} else {
-		res, err = b.db.Conn.Exec(b.statement, args...)
-	}
+	res, err = b.db.execContext(b.ctx, b.statement, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -887,7 +851,7 @@ func (b *BuilderM) SetM(data map[string]any) (int, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -895,7 +859,7 @@ func (b *BuilderM) SetM(data map[string]any) (int, error) {
 		return 0, ErrTableNotFound
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	if b.whereQuery == "" {
 		return 0, errors.New("you should use Where before Update")
@@ -917,11 +881,7 @@ func (b *BuilderM) SetM(data map[string]any) (int, error) {
 
 	var res sql.Result
 	var err error
-	if b.ctx != nil {
-		res, err = b.db.Conn.ExecContext(b.ctx, b.statement, args...)
-	} else {
-		res, err = b.db.Conn.Exec(b.statement, args...)
-	}
+	res, err = b.db.execContext(b.ctx, b.statement, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -943,7 +903,7 @@ func (b *BuilderM) Delete() (int, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -951,7 +911,7 @@ func (b *BuilderM) Delete() (int, error) {
 		return 0, ErrTableNotFound
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	b.statement = "DELETE FROM " + b.tableName
 	if b.whereQuery != "" {
@@ -966,11 +926,7 @@ func (b *BuilderM) Delete() (int, error) {
 
 	var res sql.Result
 	var err error
-	if b.ctx != nil {
-		res, err = b.db.Conn.ExecContext(b.ctx, b.statement, b.args...)
-	} else {
-		res, err = b.db.Conn.Exec(b.statement, b.args...)
-	}
+	res, err = b.db.execContext(b.ctx, b.statement, b.args...)
 	if err != nil {
 		return 0, err
 	}
@@ -987,9 +943,9 @@ func (b *BuilderM) Drop() (int, error) {
 		return 0, ErrTableNotFound
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
-	if v, ok := hooks.Get("drop"); ok {
+	if v, ok := b.c.hooks.Get("drop"); ok {
 		for _, vv := range v {
 			vv(HookData{
 				Table:     b.tableName,
@@ -1001,11 +957,7 @@ func (b *BuilderM) Drop() (int, error) {
 	b.statement = "DROP TABLE IF EXISTS " + b.tableName
 	var res sql.Result
 	var err error
-	if b.ctx != nil {
-		res, err = b.db.Conn.ExecContext(b.ctx, b.statement)
-	} else {
-		res, err = b.db.Conn.Exec(b.statement)
-	}
+	res, err = b.db.execContext(b.ctx, b.statement)
 	if err != nil {
 		return 0, err
 	}
@@ -1022,13 +974,13 @@ func (b *BuilderM) AddRelated(relatedTable string, whereRelatedTable string, whe
 		return 0, errors.New("unable to find model, try korm.AutoMigrate before")
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 
 	relationTableName := "m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable
-	if _, ok := relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
+	if _, ok := b.c.relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
 		relationTableName = "m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName
-		if _, ok2 := relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
+		if _, ok2 := b.c.relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
 			return 0, fmt.Errorf("no relations many to many between theses 2 tables: %s, %s", b.tableName, relatedTable)
 		}
 	}
@@ -1047,18 +999,18 @@ func (b *BuilderM) AddRelated(relatedTable string, whereRelatedTable string, whe
 		wherecols = relatedTable + "_id = ? and " + b.tableName + "_id = ?"
 	}
 
-	memoryRelatedTable, err := GetMemoryTable(relatedTable, b.db.Name)
+	memoryRelatedTable, err := b.c.GetMemoryTable(relatedTable, b.db.Name)
 	if err != nil {
 		return 0, fmt.Errorf("memory table not found: %s", relatedTable)
 	}
-	memoryTypedTable, err := GetMemoryTable(b.tableName, b.db.Name)
+	memoryTypedTable, err := b.c.GetMemoryTable(b.tableName, b.db.Name)
 	if err != nil {
 		return 0, fmt.Errorf("memory table not found: %s", relatedTable)
 	}
 	ids := make([]any, 4)
 	adaptTimeToUnixArgs(&whereRelatedArgs)
 	whereRelatedTable = adaptConcatAndLen(whereRelatedTable, b.db.Dialect)
-	data, err := Table(relatedTable).Database(b.db.Name).Where(whereRelatedTable, whereRelatedArgs...).One()
+	data, err := b.c.Table(relatedTable).Database(b.db.Name).Where(whereRelatedTable, whereRelatedArgs...).One()
 	if err != nil {
 		return 0, err
 	}
@@ -1075,7 +1027,7 @@ func (b *BuilderM) AddRelated(relatedTable string, whereRelatedTable string, whe
 	if b.whereQuery == "" {
 		return 0, fmt.Errorf("you must specify a where for the typed struct")
 	}
-	typedModel, err := Table(b.tableName).Database(b.db.Name).Where(b.whereQuery, b.args...).One()
+	typedModel, err := b.c.Table(b.tableName).Database(b.db.Name).Where(b.whereQuery, b.args...).One()
 	if err != nil {
 		return 0, err
 	}
@@ -1093,7 +1045,7 @@ func (b *BuilderM) AddRelated(relatedTable string, whereRelatedTable string, whe
 	if b.debug {
 		lg.InfoC("debug", "statement", stat, "args", ids)
 	}
-	err = Exec(b.db.Name, stat, ids...)
+	err = b.c.Exec(b.db.Name, stat, ids...)
 	if err != nil {
 		return 0, err
 	}
@@ -1106,12 +1058,12 @@ func (b *BuilderM) GetRelated(relatedTable string, dest *[]map[string]any) error
 		return errors.New("unable to find model, try db.Table before")
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	relationTableName := "m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable
-	if _, ok := relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
+	if _, ok := b.c.relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
 		relationTableName = "m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName
-		if _, ok2 := relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
+		if _, ok2 := b.c.relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
 			return fmt.Errorf("no relations many to many between theses 2 tables: %s, %s", b.tableName, relatedTable)
 		}
 	}
@@ -1154,18 +1106,13 @@ func (b *BuilderM) GetRelated(relatedTable string, dest *[]map[string]any) error
 		b.statement += " " + b.orderBys
 	}
 	if b.limit > 0 {
-		i := strconv.Itoa(b.limit)
-		b.statement += " LIMIT " + i
-		if b.page > 0 {
-			o := strconv.Itoa((b.page - 1) * b.limit)
-			b.statement += " OFFSET " + o
-		}
+		b.statement += b.db.limitClause(b.limit, b.page, b.orderBys != "")
 	}
 	if b.debug {
 		lg.InfoC("debug", "statement", b.statement, "args", b.args)
 	}
 	var err error
-	*dest, err = Table(relationTableName).Database(b.db.Name).QueryM(b.statement, b.args...)
+	*dest, err = b.c.Table(relationTableName).Database(b.db.Name).QueryM(b.statement, b.args...)
 	if err != nil {
 		return err
 	}
@@ -1179,12 +1126,12 @@ func (b *BuilderM) JoinRelated(relatedTable string, dest *[]map[string]any) erro
 		return errors.New("unable to find model, try db.Table before")
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	relationTableName := "m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable
-	if _, ok := relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
+	if _, ok := b.c.relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
 		relationTableName = "m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName
-		if _, ok2 := relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
+		if _, ok2 := b.c.relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
 			return fmt.Errorf("no relations many to many between theses 2 tables: %s, %s", b.tableName, relatedTable)
 		}
 	}
@@ -1226,18 +1173,13 @@ func (b *BuilderM) JoinRelated(relatedTable string, dest *[]map[string]any) erro
 		b.statement += " " + b.orderBys
 	}
 	if b.limit > 0 {
-		i := strconv.Itoa(b.limit)
-		b.statement += " LIMIT " + i
-		if b.page > 0 {
-			o := strconv.Itoa((b.page - 1) * b.limit)
-			b.statement += " OFFSET " + o
-		}
+		b.statement += b.db.limitClause(b.limit, b.page, b.orderBys != "")
 	}
 	if b.debug {
 		lg.InfoC("debug", "statement", b.statement, "args", b.args)
 	}
 	var err error
-	*dest, err = Table(relationTableName).Database(b.db.Name).QueryM(b.statement, b.args...)
+	*dest, err = b.c.Table(relationTableName).Database(b.db.Name).QueryM(b.statement, b.args...)
 	if err != nil {
 		return err
 	}
@@ -1251,9 +1193,9 @@ func (b *BuilderM) DeleteRelated(relatedTable string, whereRelatedTable string,
 		return 0, errors.New("unable to find model, try db.Table before")
 	}
 	relationTableName := "m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable
-	if _, ok := relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
+	if _, ok := b.c.relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
 		relationTableName = "m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName
-		if _, ok2 := relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
+		if _, ok2 := b.c.relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
 			return 0, fmt.Errorf("no relations many to many between theses 2 tables: %s, %s", b.tableName, relatedTable)
 		}
 	}
@@ -1268,11 +1210,11 @@ func (b *BuilderM) DeleteRelated(relatedTable string, whereRelatedTable string,
 		relationTableName = "m2m_" + relatedTable + "_" + b.tableName
 		wherecols = relatedTable + "_id = ? and " + b.tableName + "_id = ?"
 	}
-	memoryRelatedTable, err := GetMemoryTable(relatedTable, b.db.Name)
+	memoryRelatedTable, err := b.c.GetMemoryTable(relatedTable, b.db.Name)
 	if err != nil {
 		return 0, fmt.Errorf("memory table not found: %s", relatedTable)
 	}
-	memoryTypedTable, err := GetMemoryTable(b.tableName, b.db.Name)
+	memoryTypedTable, err := b.c.GetMemoryTable(b.tableName, b.db.Name)
 	if err != nil {
 		return 0, fmt.Errorf("memory table not found: %s", relatedTable)
 	}
@@ -1280,7 +1222,7 @@ func (b *BuilderM) DeleteRelated(relatedTable string, whereRelatedTable string,
 	adaptTimeToUnixArgs(&whereRelatedArgs)
 	whereRelatedTable = adaptConcatAndLen(whereRelatedTable, b.db.Dialect)
 
-	data, err := Table(relatedTable).Database(b.db.Name).Where(whereRelatedTable, whereRelatedArgs...).One()
+	data, err := b.c.Table(relatedTable).Database(b.db.Name).Where(whereRelatedTable, whereRelatedArgs...).One()
 	if err != nil {
 		return 0, err
 	}
@@ -1295,7 +1237,7 @@ func (b *BuilderM) DeleteRelated(relatedTable string, whereRelatedTable string,
 	if b.whereQuery == "" {
 		return 0, fmt.Errorf("you must specify a where for the typed struct")
 	}
-	typedModel, err := Table(b.tableName).Database(b.db.Name).Where(b.whereQuery, b.args...).One()
+	typedModel, err := b.c.Table(b.tableName).Database(b.db.Name).Where(b.whereQuery, b.args...).One()
 	if err != nil {
 		return 0, err
 	}
@@ -1306,7 +1248,7 @@ func (b *BuilderM) DeleteRelated(relatedTable string, whereRelatedTable string,
 			ids[1] = v
 		}
 	}
-	n, err := Table(relationTableName).Database(b.db.Name).Where(wherecols, ids...).Delete()
+	n, err := b.c.Table(relationTableName).Database(b.db.Name).Where(wherecols, ids...).Delete()
 	if err != nil {
 		return 0, err
 	}
@@ -1323,7 +1265,7 @@ func (b *BuilderM) QueryM(statement string, args ...any) ([]map[string]any, erro
 	}
 
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	if b.db.Conn == nil {
 		return nil, errors.New("no connection")
@@ -1332,11 +1274,12 @@ func (b *BuilderM) QueryM(statement string, args ...any) ([]map[string]any, erro
 		database:  b.db.Name,
 		statement: statement,
 		args:      fmt.Sprint(args...),
+		primary:   b.primary,
 	}
 	// Use database+table as cache key to prevent cross-database cache pollution
 	cacheKey := b.db.Name + "::m::" + b.tableName
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			if vv, ok := v.Get(c); ok {
 				if vvs, ok := vv.([]map[string]any); ok {
 					return vvs, nil
@@ -1346,16 +1289,11 @@ func (b *BuilderM) QueryM(statement string, args ...any) ([]map[string]any, erro
 	}
 	AdaptPlaceholdersToDialect(&statement, b.db.Dialect)
 	adaptTimeToUnixArgs(&args)
-	var rows *sql.Rows
-	var err error
 	if b.debug {
 		lg.Info("", "query", statement, "args", args)
 	}
-	if b.ctx != nil {
-		rows, err = b.db.Conn.QueryContext(b.ctx, statement, args...)
-	} else {
-		rows, err = b.db.Conn.Query(statement, args...)
-	}
+	rows, cancel, err := b.db.queryContext(b.ctx, b.primary, statement, args...)
+	defer cancel()
 	if err == sql.ErrNoRows {
 		return nil, ErrNoData
 	} else if err != nil {
@@ -1401,13 +1339,13 @@ func (b *BuilderM) QueryM(statement string, args ...any) ([]map[string]any, erro
 		return nil, ErrNoData
 	}
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(b.db.Name + "::qmm" + b.tableName); ok {
+		if v, ok := b.c.caches.Get(b.db.Name + "::qmm" + b.tableName); ok {
 			v.Set(c, listMap)
-			caches.Set(cacheKey, v)
+			b.c.caches.Set(cacheKey, v)
 		} else {
 			new := kmap.New[dbCache, any]()
 			new.Set(c, listMap)
-			caches.Set(cacheKey, new)
+			b.c.caches.Set(cacheKey, new)
 		}
 	}
 	return listMap, nil
@@ -1429,7 +1367,7 @@ func (b *BuilderM) QueryMNamed(statement string, args map[string]any, unsafe ...
 	}
 
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	if b.db.Conn == nil {
 		return nil, errors.New("no connection")
@@ -1442,11 +1380,12 @@ func (b *BuilderM) QueryMNamed(statement string, args map[string]any, unsafe ...
 		database:  b.db.Name,
 		statement: statement,
 		args:      rgs,
+		primary:   b.primary,
 	}
 	// Use database+table as cache key to prevent cross-database cache pollution
 	cacheKey := b.db.Name + "::m::" + b.tableName
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			if vv, ok := v.Get(c); ok {
 				if vvs, ok := vv.([]map[string]any); ok {
 					return vvs, nil
@@ -1469,13 +1408,8 @@ func (b *BuilderM) QueryMNamed(statement string, args map[string]any, unsafe ...
 			return nil, err
 		}
 	}
-	var rows *sql.Rows
-	var err error
-	if b.ctx != nil {
-		rows, err = b.db.Conn.QueryContext(b.ctx, query, newargs...)
-	} else {
-		rows, err = b.db.Conn.Query(query, newargs...)
-	}
+	rows, cancel, err := b.db.queryContext(b.ctx, b.primary, query, newargs...)
+	defer cancel()
 	if err == sql.ErrNoRows {
 		return nil, ErrNoData
 	} else if err != nil {
@@ -1521,13 +1455,13 @@ func (b *BuilderM) QueryMNamed(statement string, args map[string]any, unsafe ...
 		return nil, ErrNoData
 	}
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(b.db.Name + "::qmnn" + b.tableName); ok {
+		if v, ok := b.c.caches.Get(b.db.Name + "::qmnn" + b.tableName); ok {
 			v.Set(c, listMap)
-			caches.Set(cacheKey, v)
+			b.c.caches.Set(cacheKey, v)
 		} else {
 			new := kmap.New[dbCache, any]()
 			new.Set(c, listMap)
-			caches.Set(cacheKey, new)
+			b.c.caches.Set(cacheKey, new)
 		}
 	}
 	return listMap, nil
@@ -1538,20 +1472,15 @@ func (b *BuilderM) queryS(ptrStrctSlice any, statement string, args ...any) erro
 		return ErrTableNotFound
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	AdaptPlaceholdersToDialect(&statement, b.db.Dialect)
 	adaptTimeToUnixArgs(&args)
 	if b.db.Conn == nil {
 		return errors.New("no connection")
 	}
-	var rows *sql.Rows
-	var err error
-	if b.ctx != nil {
-		rows, err = b.db.Conn.QueryContext(b.ctx, statement, args...)
-	} else {
-		rows, err = b.db.Conn.Query(statement, args...)
-	}
+	rows, cancel, err := b.db.queryContext(b.ctx, b.primary, statement, args...)
+	defer cancel()
 	if err == sql.ErrNoRows {
 		return ErrNoData
 	} else if err != nil {
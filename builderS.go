@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
-	"strconv"
 	"strings"
 	"time"
 
@@ -33,70 +32,91 @@ type BuilderS[T any] struct {
 	offset     string
 	statement  string
 	db         *DatabaseEntity
+	c          *Client
 	args       []any
 	order      []string
 	ctx        context.Context
 	trace      bool
+	primary    bool
+	// dbChosen is true when Database was called, disabling shard routing
+	dbChosen    bool
+	shardRouted bool
 }
 
 // BuilderStruct empty query to struct starter, default db first connected
 func BuilderStruct[T any](model ...T) *BuilderS[T] {
 	return &BuilderS[T]{
-		db: getDefaultDbEntity(),
+		db: defaultClient.defaultDbEntity(),
+		c:  defaultClient,
 	}
 }
 
-func getDefaultDbEntity() *DatabaseEntity {
-	db, _ := GetMemoryDatabase(defaultDB)
+func (c *Client) defaultDbEntity() *DatabaseEntity {
+	db, _ := c.GetMemoryDatabase(c.defaultDB)
 	return db
 }
 
 // Model is a starter for Buider
 func Model[T any](model ...T) *BuilderS[T] {
-	tName := getTableName[T]()
+	return ModelOn[T](defaultClient)
+}
+
+// ModelOn is a starter for Buider using databases of client c
+func ModelOn[T any](c *Client) *BuilderS[T] {
+	tName := getTableNameOn[T](c)
 	if tName == "" {
 		rs := reflect.ValueOf(*new(T))
 		if rs.Kind() == reflect.Chan {
 			chanType := reflect.New(rs.Type().Elem()).Elem()
-			mutexModelTablename.RLock()
-			for tname, mod := range mModelTablename {
+			c.mutexModelTablename.RLock()
+			for tname, mod := range c.mModelTablename {
 				if mod == chanType.Interface() {
+					c.mutexModelTablename.RUnlock()
 					return &BuilderS[T]{
 						tableName: tname,
-						db:        &databases[0],
+						db:        &c.databases[0],
+						c:         c,
 					}
 				}
 			}
-			mutexModelTablename.RUnlock()
+			c.mutexModelTablename.RUnlock()
 		}
 		return nil
 	}
 
 	return &BuilderS[T]{
 		tableName: tName,
-		db:        getDefaultDbEntity(),
+		db:        c.defaultDbEntity(),
+		c:         c,
 	}
 }
 
 func ModelTable[T any](tableName string, model ...T) *BuilderS[T] {
-	tName := getTableName[T]()
+	return ModelTableOn[T](defaultClient, tableName)
+}
+
+// ModelTableOn is ModelTable using client c
+func ModelTableOn[T any](c *Client, tableName string) *BuilderS[T] {
+	tName := getTableNameOn[T](c)
 	if tName != tableName {
-		mutexModelTablename.Lock()
-		mModelTablename[tableName] = new(T)
-		mutexModelTablename.Unlock()
+		c.mutexModelTablename.Lock()
+		c.mModelTablename[tableName] = new(T)
+		c.mutexModelTablename.Unlock()
 		tName = tableName
 	}
 	return &BuilderS[T]{
 		tableName: tName,
-		db:        &databases[0],
+		db:        &c.databases[0],
+		c:         c,
 	}
 }
 
 // Database allow to choose database to execute query on
 func (b *BuilderS[T]) Database(dbName string) *BuilderS[T] {
-	for i := range databases {
-		if databases[i].Name == dbName {
-			b.db = &databases[i]
+	for i := range b.c.databases {
+		if b.c.databases[i].Name == dbName {
+			b.db = &b.c.databases[i]
+			b.dbChosen = true
 		}
 	}
 	return b
@@ -107,6 +127,12 @@ func (b *BuilderS[T]) NoCache() *BuilderS[T] {
 	return b
 }
 
+// Primary force reads to use the primary connection even if replicas exist, useful to read your own writes
+func (b *BuilderS[T]) Primary() *BuilderS[T] {
+	b.primary = true
+	return b
+}
+
 func SliceToString(slice interface{}) string {
 	v := reflect.ValueOf(slice)
 
@@ -124,6 +150,10 @@ func SliceToString(slice interface{}) string {
 }
 
 func (b *BuilderS[T]) Insert(model *T) (int, error) {
+	if b == nil {
+		// Model return nil for models that are not migrated
+		return 0, ErrTableNotFound
+	}
 	if b.trace {
 		trace := TraceData{
 			Query:     b.statement,
@@ -133,15 +163,18 @@ func (b *BuilderS[T]) Insert(model *T) (int, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
 	if b == nil || b.tableName == "" {
 		return 0, ErrTableNotFound
 	}
+	if err := b.routeShardModel(model); err != nil {
+		return 0, err
+	}
 
-	t, err := GetMemoryTable(b.tableName, b.db.Name)
+	t, err := b.c.GetMemoryTable(b.tableName, b.db.Name)
 	if lg.CheckError(err) {
 		return 0, err
 	}
@@ -149,10 +182,7 @@ func (b *BuilderS[T]) Insert(model *T) (int, error) {
 	if len(names) < len(mvalues) {
 		return 0, errors.New("more values than fields")
 	}
-	quote := "`"
-	if b.db.Dialect == POSTGRES || b.db.Dialect == COCKROACH {
-		quote = "\""
-	}
+	d := dialectOf(b.db.Dialect)
 
 	for k, v := range mvalues {
 		typ := mTypes[k]
@@ -212,30 +242,27 @@ func (b *BuilderS[T]) Insert(model *T) (int, error) {
 	newkeys := make([]string, 0, len(mvalues))
 	newvalues := make([]any, 0, len(mvalues))
 	for k, v := range mvalues {
-		newkeys = append(newkeys, quote+k+quote)
+		newkeys = append(newkeys, d.Quote(k))
 		newvalues = append(newvalues, v)
 	}
 	fields_comma_separated := strings.Join(newkeys, ",")
 
+	output, returning := d.Returning(t.Pk)
 	stat := strings.Builder{}
-	stat.WriteString("INSERT INTO " + quote + b.tableName + quote + " (")
+	stat.WriteString("INSERT INTO " + d.Quote(b.tableName) + " (")
 	stat.WriteString(fields_comma_separated)
-	stat.WriteString(") VALUES (")
+	stat.WriteString(")" + output + " VALUES (")
 	stat.WriteString(placeholders)
-	stat.WriteString(")")
+	stat.WriteString(")" + returning)
 	b.statement = stat.String()
 	AdaptPlaceholdersToDialect(&b.statement, b.db.Dialect)
 
-	if b.db.Dialect != POSTGRES {
+	if output == "" && returning == "" {
 		var res sql.Result
 		if b.debug {
 			lg.InfoC("debug", "stat", b.statement, "args", newvalues)
 		}
-		if b.ctx != nil {
-			res, err = b.db.Conn.ExecContext(b.ctx, b.statement, newvalues...)
-		} else {
-			res, err = b.db.Conn.Exec(b.statement, newvalues...)
-		}
+		res, err = b.db.execContext(b.ctx, b.statement, newvalues...)
 		if err != nil {
 			return 0, err
 		}
@@ -247,13 +274,9 @@ func (b *BuilderS[T]) Insert(model *T) (int, error) {
 	} else {
 		var id int
 		if b.debug {
-			lg.InfoC("debug", "stat", b.statement+" RETURNING "+t.Pk, "args", newvalues)
-		}
-		if b.ctx != nil {
-			err = b.db.Conn.QueryRowContext(b.ctx, b.statement+" RETURNING "+t.Pk, newvalues...).Scan(&id)
-		} else {
-			err = b.db.Conn.QueryRow(b.statement+" RETURNING "+t.Pk, newvalues...).Scan(&id)
+			lg.InfoC("debug", "stat", b.statement, "args", newvalues)
 		}
+		err = b.db.queryRowScan(b.ctx, []any{&id}, b.statement, newvalues...)
 		if err != nil {
 			return id, err
 		}
@@ -272,15 +295,18 @@ func (b *BuilderS[T]) InsertR(model *T) (T, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
 	if b == nil || b.tableName == "" {
 		return *new(T), ErrTableNotFound
 	}
+	if err := b.routeShardModel(model); err != nil {
+		return *new(T), err
+	}
 
-	t, err := GetMemoryTable(b.tableName, b.db.Name)
+	t, err := b.c.GetMemoryTable(b.tableName, b.db.Name)
 	if lg.CheckError(err) {
 		return *new(T), err
 	}
@@ -288,10 +314,7 @@ func (b *BuilderS[T]) InsertR(model *T) (T, error) {
 	if len(names) < len(mvalues) {
 		return *new(T), errors.New("more values than fields")
 	}
-	quote := "`"
-	if b.db.Dialect == POSTGRES || b.db.Dialect == COCKROACH {
-		quote = "\""
-	}
+	d := dialectOf(b.db.Dialect)
 
 	for k, v := range mvalues {
 		typ := mTypes[k]
@@ -355,30 +378,38 @@ func (b *BuilderS[T]) InsertR(model *T) (T, error) {
 	newkeys := make([]string, 0, len(mvalues))
 	newvalues := make([]any, 0, len(mvalues))
 	for k, v := range mvalues {
-		newkeys = append(newkeys, quote+k+quote)
+		newkeys = append(newkeys, d.Quote(k))
 		newvalues = append(newvalues, v)
 	}
 	fields_comma_separated := strings.Join(newkeys, ",")
 
+	// dialects using OUTPUT return the inserted row directly
+	output, returning := d.Returning("*")
+	if output == "" {
+		output, returning = d.Returning(t.Pk)
+	}
 	stat := strings.Builder{}
-	stat.WriteString("INSERT INTO " + quote + b.tableName + quote + " (")
+	stat.WriteString("INSERT INTO " + d.Quote(b.tableName) + " (")
 	stat.WriteString(fields_comma_separated)
-	stat.WriteString(") VALUES (")
+	stat.WriteString(")" + output + " VALUES (")
 	stat.WriteString(placeholders)
-	stat.WriteString(")")
+	stat.WriteString(")" + returning)
 	b.statement = stat.String()
 	AdaptPlaceholdersToDialect(&b.statement, b.db.Dialect)
 	if b.debug {
 		lg.InfoC("debug", "stat", b.statement, "args", newvalues)
 	}
+	if output != "" {
+		rows, err := ModelOn[T](b.c).Database(b.db.Name).Primary().NoCache().Context(b.ctx).QueryS(b.statement, newvalues...)
+		if err != nil {
+			return *new(T), err
+		}
+		return rows[0], nil
+	}
 	var id int
-	if b.db.Dialect != POSTGRES {
+	if output == "" && returning == "" {
 		var res sql.Result
-		if b.ctx != nil {
-			res, err = b.db.Conn.ExecContext(b.ctx, b.statement, newvalues...)
-		} else {
-			res, err = b.db.Conn.Exec(b.statement, newvalues...)
-		}
+		res, err = b.db.execContext(b.ctx, b.statement, newvalues...)
 		if err != nil {
 			return *new(T), err
 		}
@@ -388,16 +419,12 @@ func (b *BuilderS[T]) InsertR(model *T) (T, error) {
 		}
 		id = int(rows)
 	} else {
-		if b.ctx != nil {
-			err = b.db.Conn.QueryRowContext(b.ctx, b.statement+" RETURNING "+t.Pk, newvalues...).Scan(&id)
-		} else {
-			err = b.db.Conn.QueryRow(b.statement+" RETURNING "+t.Pk, newvalues...).Scan(&id)
-		}
+		err = b.db.queryRowScan(b.ctx, []any{&id}, b.statement, newvalues...)
 		if err != nil {
 			return *new(T), err
 		}
 	}
-	m, err := Model[T]().Database(b.db.Name).Where(t.Pk+"=?", id).One()
+	m, err := ModelOn[T](b.c).Database(b.db.Name).Primary().Where(t.Pk+"=?", id).One()
 	if err != nil {
 		return *new(T), err
 	}
@@ -415,7 +442,7 @@ func (b *BuilderS[T]) AddRelated(relatedTable string, whereRelatedTable string,
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -424,9 +451,9 @@ func (b *BuilderS[T]) AddRelated(relatedTable string, whereRelatedTable string,
 	}
 
 	relationTableName := "m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable
-	if _, ok := relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
+	if _, ok := b.c.relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
 		relationTableName = "m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName
-		if _, ok2 := relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
+		if _, ok2 := b.c.relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
 			return 0, fmt.Errorf("no relations many to many between theses 2 tables: %s, %s", b.tableName, relatedTable)
 		}
 	}
@@ -443,18 +470,18 @@ func (b *BuilderS[T]) AddRelated(relatedTable string, whereRelatedTable string,
 		cols = relatedTable + "_id," + b.tableName + "_id"
 		wherecols = relatedTable + "_id = ? and " + b.tableName + "_id = ?"
 	}
-	memoryRelatedTable, err := GetMemoryTable(relatedTable, b.db.Name)
+	memoryRelatedTable, err := b.c.GetMemoryTable(relatedTable, b.db.Name)
 	if err != nil {
 		return 0, fmt.Errorf("memory table not found: %s", relatedTable)
 	}
-	memoryTypedTable, err := GetMemoryTable(b.tableName, b.db.Name)
+	memoryTypedTable, err := b.c.GetMemoryTable(b.tableName, b.db.Name)
 	if err != nil {
 		return 0, fmt.Errorf("memory table not found: %s", b.tableName)
 	}
 
 	adaptTimeToUnixArgs(&whereRelatedArgs)
 	whereRelatedTable = adaptConcatAndLen(whereRelatedTable, b.db.Dialect)
-	data, err := Table(relatedTable).Database(b.db.Name).Where(whereRelatedTable, whereRelatedArgs...).One()
+	data, err := b.c.Table(relatedTable).Database(b.db.Name).Where(whereRelatedTable, whereRelatedArgs...).One()
 	if err != nil {
 		return 0, err
 	}
@@ -472,7 +499,7 @@ func (b *BuilderS[T]) AddRelated(relatedTable string, whereRelatedTable string,
 	if b.whereQuery == "" {
 		return 0, fmt.Errorf("you must specify a where for the typed struct")
 	}
-	typedModel, err := Table(b.tableName).Database(b.db.Name).Where(b.whereQuery, b.args...).One()
+	typedModel, err := b.c.Table(b.tableName).Database(b.db.Name).Where(b.whereQuery, b.args...).One()
 	if err != nil {
 		return 0, err
 	}
@@ -490,7 +517,7 @@ func (b *BuilderS[T]) AddRelated(relatedTable string, whereRelatedTable string,
 	if b.debug {
 		lg.InfoC("debug", "stat", stat, "args", ids)
 	}
-	err = Exec(b.db.Name, stat, ids...)
+	err = b.c.Exec(b.db.Name, stat, ids...)
 	if err != nil {
 		return 0, err
 	}
@@ -508,7 +535,7 @@ func (b *BuilderS[T]) DeleteRelated(relatedTable string, whereRelatedTable strin
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -516,9 +543,9 @@ func (b *BuilderS[T]) DeleteRelated(relatedTable string, whereRelatedTable strin
 		return 0, ErrTableNotFound
 	}
 	relationTableName := "m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable
-	if _, ok := relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
+	if _, ok := b.c.relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
 		relationTableName = "m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName
-		if _, ok2 := relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
+		if _, ok2 := b.c.relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
 			return 0, fmt.Errorf("no relations many to many between theses 2 tables: %s, %s", b.tableName, relatedTable)
 		}
 	}
@@ -533,22 +560,22 @@ func (b *BuilderS[T]) DeleteRelated(relatedTable string, whereRelatedTable strin
 		relationTableName = "m2m_" + relatedTable + "_" + b.tableName
 		wherecols = relatedTable + "_id = ? and " + b.tableName + "_id = ?"
 	}
-	memoryRelatedTable, err := GetMemoryTable(relatedTable, b.db.Name)
+	memoryRelatedTable, err := b.c.GetMemoryTable(relatedTable, b.db.Name)
 	if err != nil {
 		return 0, fmt.Errorf("memory table not found: %s", relatedTable)
 	}
-	memoryTypedTable, err := GetMemoryTable(b.tableName, b.db.Name)
+	memoryTypedTable, err := b.c.GetMemoryTable(b.tableName, b.db.Name)
 	if err != nil {
 		return 0, fmt.Errorf("memory table not found: %s", b.tableName)
 	}
 	ids := make([]any, 2)
 	adaptTimeToUnixArgs(&whereRelatedArgs)
-	if b.db == nil && len(databases) == 1 {
-		whereRelatedTable = adaptConcatAndLen(whereRelatedTable, databases[0].Dialect)
+	if b.db == nil && len(b.c.databases) == 1 {
+		whereRelatedTable = adaptConcatAndLen(whereRelatedTable, b.c.databases[0].Dialect)
 	} else if b.db != nil {
 		whereRelatedTable = adaptConcatAndLen(whereRelatedTable, b.db.Dialect)
 	}
-	data, err := Table(relatedTable).Database(b.db.Name).Where(whereRelatedTable, whereRelatedArgs...).One()
+	data, err := b.c.Table(relatedTable).Database(b.db.Name).Where(whereRelatedTable, whereRelatedArgs...).One()
 	if err != nil {
 		return 0, err
 	}
@@ -563,7 +590,7 @@ func (b *BuilderS[T]) DeleteRelated(relatedTable string, whereRelatedTable strin
 	if b.whereQuery == "" {
 		return 0, fmt.Errorf("you must specify a where for the typed struct")
 	}
-	typedModel, err := Table(b.tableName).Database(b.db.Name).Where(b.whereQuery, b.args...).One()
+	typedModel, err := b.c.Table(b.tableName).Database(b.db.Name).Where(b.whereQuery, b.args...).One()
 	if err != nil {
 		return 0, err
 	}
@@ -574,7 +601,7 @@ func (b *BuilderS[T]) DeleteRelated(relatedTable string, whereRelatedTable strin
 			ids[1] = v
 		}
 	}
-	n, err := Table(relationTableName).Database(b.db.Name).Where(wherecols, ids...).Delete()
+	n, err := b.c.Table(relationTableName).Database(b.db.Name).Where(wherecols, ids...).Delete()
 	if err != nil {
 		return 0, err
 	}
@@ -592,7 +619,7 @@ func (b *BuilderS[T]) GetRelated(relatedTable string, dest any) error {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -600,9 +627,9 @@ func (b *BuilderS[T]) GetRelated(relatedTable string, dest any) error {
 		return ErrTableNotFound
 	}
 	relationTableName := "m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable
-	if _, ok := relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
+	if _, ok := b.c.relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
 		relationTableName = "m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName
-		if _, ok2 := relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
+		if _, ok2 := b.c.relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
 			return fmt.Errorf("no relations many to many between theses 2 tables: %s, %s", b.tableName, relatedTable)
 		}
 	}
@@ -642,17 +669,12 @@ func (b *BuilderS[T]) GetRelated(relatedTable string, dest any) error {
 		b.statement += " " + b.orderBys
 	}
 	if b.limit > 0 {
-		i := strconv.Itoa(b.limit)
-		b.statement += " LIMIT " + i
-		if b.page > 0 {
-			o := strconv.Itoa((b.page - 1) * b.limit)
-			b.statement += " OFFSET " + o
-		}
+		b.statement += b.db.limitClause(b.limit, b.page, b.orderBys != "")
 	}
 	if b.debug {
 		lg.InfoC("debug", "stat", b.statement, "args", b.args)
 	}
-	err := Table(relationTableName).Database(b.db.Name).queryS(dest, b.statement, b.args...)
+	err := b.c.Table(relationTableName).Database(b.db.Name).queryS(dest, b.statement, b.args...)
 	if err != nil {
 		return err
 	}
@@ -670,7 +692,7 @@ func (b *BuilderS[T]) JoinRelated(relatedTable string, dest any) error {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -678,9 +700,9 @@ func (b *BuilderS[T]) JoinRelated(relatedTable string, dest any) error {
 		return ErrTableNotFound
 	}
 	relationTableName := "m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable
-	if _, ok := relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
+	if _, ok := b.c.relationsMap.Get("m2m_" + b.tableName + "-" + b.db.Name + "-" + relatedTable); !ok {
 		relationTableName = "m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName
-		if _, ok2 := relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
+		if _, ok2 := b.c.relationsMap.Get("m2m_" + relatedTable + "-" + b.db.Name + "-" + b.tableName); !ok2 {
 			return fmt.Errorf("no relations many to many between theses 2 tables: %s, %s", b.tableName, relatedTable)
 		}
 	}
@@ -723,17 +745,12 @@ func (b *BuilderS[T]) JoinRelated(relatedTable string, dest any) error {
 		b.statement += " " + b.orderBys
 	}
 	if b.limit > 0 {
-		i := strconv.Itoa(b.limit)
-		b.statement += " LIMIT " + i
-		if b.page > 0 {
-			o := strconv.Itoa((b.page - 1) * b.limit)
-			b.statement += " OFFSET " + o
-		}
+		b.statement += b.db.limitClause(b.limit, b.page, b.orderBys != "")
 	}
 	if b.debug {
 		lg.InfoC("debug", "stat", b.statement, "args", b.args)
 	}
-	err := Table(relationTableName).Database(b.db.Name).queryS(dest, b.statement, b.args...)
+	err := b.c.Table(relationTableName).Database(b.db.Name).queryS(dest, b.statement, b.args...)
 	if err != nil {
 		return err
 	}
@@ -751,7 +768,7 @@ func (b *BuilderS[T]) Set(query string, args ...any) (int, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -761,6 +778,11 @@ func (b *BuilderS[T]) Set(query string, args ...any) (int, error) {
 	if b.whereQuery == "" {
 		return 0, fmt.Errorf("you should use Where before Update")
 	}
+	if dbs := b.shardTargets(); len(dbs) > 0 {
+		return b.execShards(dbs, func(clone *BuilderS[T]) (int, error) {
+			return clone.Set(query, append([]any{}, args...)...)
+		})
+	}
 	adaptSetQuery(&query)
 	adaptTimeToUnixArgs(&args)
 	b.statement = "UPDATE " + b.tableName + " SET " + query + " WHERE " + b.whereQuery
@@ -774,11 +796,7 @@ func (b *BuilderS[T]) Set(query string, args ...any) (int, error) {
 		res sql.Result
 		err error
 	)
-	if b.ctx != nil {
-		res, err = b.db.Conn.ExecContext(b.ctx, b.statement, args...)
-	} else {
-		res, err = b.db.Conn.Exec(b.statement, args...)
-	}
+	res, err = b.db.execContext(b.ctx, b.statement, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -799,7 +817,7 @@ func (b *BuilderS[T]) SetM(data map[string]any) (int, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
@@ -809,6 +827,11 @@ func (b *BuilderS[T]) SetM(data map[string]any) (int, error) {
 	if b.whereQuery == "" {
 		return 0, errors.New("you should use Where before Update")
 	}
+	if dbs := b.shardTargets(); len(dbs) > 0 {
+		return b.execShards(dbs, func(clone *BuilderS[T]) (int, error) {
+			return clone.SetM(data)
+		})
+	}
 	sss := make([]string, 0, len(data))
 	args := make([]any, 0, len(data))
 	for k, v := range data {
@@ -826,11 +849,7 @@ func (b *BuilderS[T]) SetM(data map[string]any) (int, error) {
 
 	var res sql.Result
 	var err error
-	if b.ctx != nil {
-		res, err = b.db.Conn.ExecContext(b.ctx, b.statement, args...)
-	} else {
-		res, err = b.db.Conn.Exec(b.statement, args...)
-	}
+	res, err = b.db.execContext(b.ctx, b.statement, args...)
 	if err != nil {
 		return 0, err
 	}
@@ -852,20 +871,23 @@ func (b *BuilderS[T]) Delete() (int, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
 	if b == nil || b.tableName == "" {
 		return 0, ErrTableNotFound
 	}
-
-	b.statement = "DELETE FROM " + b.tableName
-	if b.whereQuery != "" {
-		b.statement += " WHERE " + b.whereQuery
-	} else {
+	if b.whereQuery == "" {
 		return 0, errors.New("no Where was given for this query:" + b.whereQuery)
 	}
+	if dbs := b.shardTargets(); len(dbs) > 0 {
+		return b.execShards(dbs, func(clone *BuilderS[T]) (int, error) {
+			return clone.Delete()
+		})
+	}
+
+	b.statement = "DELETE FROM " + b.tableName + " WHERE " + b.whereQuery
 	AdaptPlaceholdersToDialect(&b.statement, b.db.Dialect)
 	if b.debug {
 		lg.InfoC("debug", "stat", b.statement, "args", b.args)
@@ -873,11 +895,7 @@ func (b *BuilderS[T]) Delete() (int, error) {
 
 	var res sql.Result
 	var err error
-	if b.ctx != nil {
-		res, err = b.db.Conn.ExecContext(b.ctx, b.statement, b.args...)
-	} else {
-		res, err = b.db.Conn.Exec(b.statement, b.args...)
-	}
+	res, err = b.db.execContext(b.ctx, b.statement, b.args...)
 	if err != nil {
 		return 0, err
 	}
@@ -899,14 +917,14 @@ func (b *BuilderS[T]) Drop() (int, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
 	if b == nil || b.tableName == "" {
 		return 0, ErrTableNotFound
 	}
-	if v, ok := hooks.Get("drop"); ok {
+	if v, ok := b.c.hooks.Get("drop"); ok {
 		for _, vv := range v {
 			vv(HookData{
 				Table:     b.tableName,
@@ -920,11 +938,7 @@ func (b *BuilderS[T]) Drop() (int, error) {
 		res sql.Result
 		err error
 	)
-	if b.ctx != nil {
-		res, err = b.db.Conn.ExecContext(b.ctx, b.statement)
-	} else {
-		res, err = b.db.Conn.Exec(b.statement)
-	}
+	res, err = b.db.execContext(b.ctx, b.statement)
 	if err != nil {
 		return 0, err
 	}
@@ -940,13 +954,10 @@ func (b *BuilderS[T]) Select(columns ...string) *BuilderS[T] {
 	if b == nil || b.tableName == "" {
 		return nil
 	}
-	quote := "`"
-	if b.db.Dialect == POSTGRES || b.db.Dialect == COCKROACH {
-		quote = "\""
-	}
+	d := dialectOf(b.db.Dialect)
 	for i := range columns {
-		if !strings.HasPrefix(columns[i], quote) && !strings.HasPrefix(columns[i], "'") {
-			columns[i] = quote + columns[i] + quote
+		if !strings.HasPrefix(columns[i], "'") {
+			columns[i] = d.Quote(columns[i])
 		}
 	}
 	b.selected = strings.Join(columns, ",")
@@ -959,8 +970,8 @@ func (b *BuilderS[T]) Where(query string, args ...any) *BuilderS[T] {
 	if b == nil || b.tableName == "" {
 		return nil
 	}
-	if b.db == nil && len(databases) == 1 {
-		query = adaptConcatAndLen(query, databases[0].Dialect)
+	if b.db == nil && len(b.c.databases) == 1 {
+		query = adaptConcatAndLen(query, b.c.databases[0].Dialect)
 	} else if b.db != nil {
 		query = adaptConcatAndLen(query, b.db.Dialect)
 	}
@@ -1035,6 +1046,7 @@ func (b *BuilderS[T]) Where(query string, args ...any) *BuilderS[T] {
 	b.whereQuery = result.String()
 	b.args = append(b.args, expandedArgs...)
 	b.order = append(b.order, "where")
+	b.routeShard(b.whereQuery, expandedArgs)
 	return b
 }
 
@@ -1043,11 +1055,19 @@ func (b *BuilderS[T]) WhereNamed(query string, args map[string]any) *BuilderS[T]
 	if b == nil || b.tableName == "" {
 		return nil
 	}
-	if b.db == nil && len(databases) == 1 {
-		query = adaptConcatAndLen(query, databases[0].Dialect)
+	if b.db == nil && len(b.c.databases) == 1 {
+		query = adaptConcatAndLen(query, b.c.databases[0].Dialect)
 	} else if b.db != nil {
 		query = adaptConcatAndLen(query, b.db.Dialect)
 	}
+	if sc := b.shardConfig(); sc != nil {
+		if v, ok := shardKeyValueNamed(query, args, sc.key); ok {
+			if db, err := sc.database(v); err == nil {
+				b.db = db
+				b.shardRouted = true
+			}
+		}
+	}
 	q, newargs, err := AdaptNamedParams(b.db.Dialect, query, args)
 	if err != nil {
 		b.whereQuery = query
@@ -1150,6 +1170,9 @@ func (b *BuilderS[T]) All() ([]T, error) {
 	if b == nil || b.tableName == "" {
 		return nil, ErrTableNotFound
 	}
+	if dbs := b.shardTargets(); len(dbs) > 0 {
+		return b.allShards(dbs, false)
+	}
 	c := dbCache{
 		database:   b.db.Name,
 		table:      b.tableName,
@@ -1161,11 +1184,12 @@ func (b *BuilderS[T]) All() ([]T, error) {
 		limit:      b.limit,
 		page:       b.page,
 		args:       fmt.Sprint(b.args...),
+		primary:    b.primary,
 	}
 	// Use database+table as cache key to prevent cross-database cache pollution
 	cacheKey := b.db.Name + "::s::" + b.tableName
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			if vv, ok := v.Get(c); ok {
 				if vvTyped, ok := vv.([]T); ok {
 					return vvTyped, nil
@@ -1188,12 +1212,7 @@ func (b *BuilderS[T]) All() ([]T, error) {
 	}
 
 	if b.limit > 0 {
-		i := strconv.Itoa(b.limit)
-		b.statement += " LIMIT " + i
-		if b.page > 0 {
-			o := strconv.Itoa((b.page - 1) * b.limit)
-			b.statement += " OFFSET " + o
-		}
+		b.statement += b.db.limitClause(b.limit, b.page, b.orderBys != "")
 	}
 
 	if b.debug {
@@ -1201,23 +1220,23 @@ func (b *BuilderS[T]) All() ([]T, error) {
 	}
 
 	var models []T
-	selector := To(&models).Database(b.db.Name)
-	if b.trace {
-		selector.ctx = b.ctx
-		selector.trace = true
-	}
+	selector := ToOn(b.c, &models).Database(b.db.Name)
+	selector.primary = b.primary
+	selector.nocache = b.nocache
+	selector.ctx = b.ctx
+	selector.trace = b.trace
 	err := selector.Query(b.statement, b.args...)
 	if err != nil {
 		return nil, err
 	}
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			v.Set(c, models)
-			caches.Set(cacheKey, v)
+			b.c.caches.Set(cacheKey, v)
 		} else {
 			new := kmap.New[dbCache, any]()
 			new.Set(c, models)
-			caches.Set(cacheKey, new)
+			b.c.caches.Set(cacheKey, new)
 		}
 	}
 	return models, nil
@@ -1238,11 +1257,12 @@ func (b *BuilderS[T]) ToChan(ptrChan *chan T) ([]T, error) {
 		limit:      b.limit,
 		page:       b.page,
 		args:       fmt.Sprint(b.args...),
+		primary:    b.primary,
 	}
 	// Use database+table as cache key to prevent cross-database cache pollution
 	cacheKey := b.db.Name + "::s::" + b.tableName
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			if vv, ok := v.Get(c); ok {
 				if vvTyped, ok := vv.([]T); ok {
 					for _, val := range vvTyped {
@@ -1268,12 +1288,7 @@ func (b *BuilderS[T]) ToChan(ptrChan *chan T) ([]T, error) {
 	}
 
 	if b.limit > 0 {
-		i := strconv.Itoa(b.limit)
-		b.statement += " LIMIT " + i
-		if b.page > 0 {
-			o := strconv.Itoa((b.page - 1) * b.limit)
-			b.statement += " OFFSET " + o
-		}
+		b.statement += b.db.limitClause(b.limit, b.page, b.orderBys != "")
 	}
 
 	if b.debug {
@@ -1289,13 +1304,8 @@ func (b *BuilderS[T]) ToChan(ptrChan *chan T) ([]T, error) {
 			}
 		}
 	}
-	var rows *sql.Rows
-	var err error
-	if b.ctx != nil {
-		rows, err = b.db.Conn.QueryContext(b.ctx, b.statement, b.args...)
-	} else {
-		rows, err = b.db.Conn.Query(b.statement, b.args...)
-	}
+	rows, cancel, err := b.db.queryContext(b.ctx, b.primary, b.statement, b.args...)
+	defer cancel()
 	if err == sql.ErrNoRows {
 		return nil, ErrNoData
 	} else if err != nil {
@@ -1367,13 +1377,13 @@ func (b *BuilderS[T]) ToChan(ptrChan *chan T) ([]T, error) {
 		return res, ErrNoData
 	}
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			v.Set(c, res)
-			caches.Set(cacheKey, v)
+			b.c.caches.Set(cacheKey, v)
 		} else {
 			new := kmap.New[dbCache, any]()
 			new.Set(c, res)
-			caches.Set(cacheKey, new)
+			b.c.caches.Set(cacheKey, new)
 		}
 	}
 	return res, nil
@@ -1396,12 +1406,12 @@ func (b *BuilderS[T]) QuerySNamed(statement string, args map[string]any, unsafe
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	if b.db.Conn == nil {
 		return nil, errors.New("no connection")
@@ -1414,11 +1424,12 @@ func (b *BuilderS[T]) QuerySNamed(statement string, args map[string]any, unsafe
 		database:  b.db.Name,
 		statement: statement,
 		args:      rgs,
+		primary:   b.primary,
 	}
 	// Use database+table as cache key to prevent cross-database cache pollution
 	cacheKey := b.db.Name + "::s::" + b.tableName
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			if vv, ok := v.Get(c); ok {
 				if vvTyped, ok := vv.([]T); ok {
 					return vvTyped, nil
@@ -1443,7 +1454,7 @@ func (b *BuilderS[T]) QuerySNamed(statement string, args map[string]any, unsafe
 	}
 
 	if b.tableName == "" {
-		b.tableName = getTableName[T]()
+		b.tableName = getTableNameOn[T](b.c)
 	}
 	pk := ""
 	if b.tableName != "" {
@@ -1453,13 +1464,8 @@ func (b *BuilderS[T]) QuerySNamed(statement string, args map[string]any, unsafe
 			}
 		}
 	}
-	var rows *sql.Rows
-	var err error
-	if b.ctx != nil {
-		rows, err = b.db.Conn.QueryContext(b.ctx, query, newargs...)
-	} else {
-		rows, err = b.db.Conn.Query(query, newargs...)
-	}
+	rows, cancel, err := b.db.queryContext(b.ctx, b.primary, query, newargs...)
+	defer cancel()
 	if err == sql.ErrNoRows {
 		return nil, ErrNoData
 	} else if err != nil {
@@ -1528,13 +1534,13 @@ func (b *BuilderS[T]) QuerySNamed(statement string, args map[string]any, unsafe
 		return nil, ErrNoData
 	}
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			v.Set(c, res)
-			caches.Set(cacheKey, v)
+			b.c.caches.Set(cacheKey, v)
 		} else {
 			new := kmap.New[dbCache, any]()
 			new.Set(c, res)
-			caches.Set(cacheKey, new)
+			b.c.caches.Set(cacheKey, new)
 		}
 	}
 	return res, nil
@@ -1551,12 +1557,12 @@ func (b *BuilderS[T]) QueryS(statement string, args ...any) ([]T, error) {
 		}
 		defer func() {
 			trace.Duration = time.Since(trace.StartTime)
-			defaultTracer.addTrace(trace)
+			b.c.tracer.addTrace(trace)
 		}()
 	}
 
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
 	}
 	if b.db.Conn == nil {
 		return nil, errors.New("no connection")
@@ -1565,11 +1571,12 @@ func (b *BuilderS[T]) QueryS(statement string, args ...any) ([]T, error) {
 		database:  b.db.Name,
 		statement: statement,
 		args:      fmt.Sprint(args...),
+		primary:   b.primary,
 	}
 	// Use database+table as cache key to prevent cross-database cache pollution
 	cacheKey := b.db.Name + "::s::" + b.tableName
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			if vv, ok := v.Get(c); ok {
 				if vvTyped, ok := vv.([]T); ok {
 					return vvTyped, nil
@@ -1587,13 +1594,8 @@ func (b *BuilderS[T]) QueryS(statement string, args ...any) ([]T, error) {
 			}
 		}
 	}
-	var rows *sql.Rows
-	var err error
-	if b.ctx != nil {
-		rows, err = b.db.Conn.QueryContext(b.ctx, statement, args...)
-	} else {
-		rows, err = b.db.Conn.Query(statement, args...)
-	}
+	rows, cancel, err := b.db.queryContext(b.ctx, b.primary, statement, args...)
+	defer cancel()
 	if err == sql.ErrNoRows {
 		return nil, ErrNoData
 	} else if err != nil {
@@ -1661,13 +1663,13 @@ func (b *BuilderS[T]) QueryS(statement string, args ...any) ([]T, error) {
 		return nil, ErrNoData
 	}
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			v.Set(c, res)
-			caches.Set(cacheKey, v)
+			b.c.caches.Set(cacheKey, v)
 		} else {
 			new := kmap.New[dbCache, any]()
 			new.Set(c, res)
-			caches.Set(cacheKey, new)
+			b.c.caches.Set(cacheKey, new)
 		}
 	}
 	return res, nil
@@ -1686,7 +1688,16 @@ func (b *BuilderS[T]) One() (T, error) {
 		return *new(T), ErrTableNotFound
 	}
 	if b.db == nil {
-		b.db = &databases[0]
+		b.db = &b.c.databases[0]
+	}
+	if dbs := b.shardTargets(); len(dbs) > 0 {
+		res, err := b.allShards(dbs, true)
+		if err != nil {
+			return *new(T), err
+		} else if len(res) == 0 {
+			return *new(T), ErrNoData
+		}
+		return res[0], nil
 	}
 	c := dbCache{
 		database:   b.db.Name,
@@ -1699,11 +1710,12 @@ func (b *BuilderS[T]) One() (T, error) {
 		limit:      b.limit,
 		page:       b.page,
 		args:       fmt.Sprint(b.args...),
+		primary:    b.primary,
 	}
 	// Use database+table as cache key to prevent cross-database cache pollution
 	cacheKey := b.db.Name + "::s::" + b.tableName
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			if vv, ok := v.Get(c); ok {
 				if vvTyped, ok := vv.(T); ok {
 					return vvTyped, nil
@@ -1729,26 +1741,31 @@ func (b *BuilderS[T]) One() (T, error) {
 		b.statement += " " + b.orderBys
 	}
 
-	b.statement += " LIMIT 1"
+	b.statement += b.db.limitClause(1, 0, b.orderBys != "")
 
 	if b.debug {
 		lg.InfoC("debug", "stat", b.statement, "args", b.args)
 	}
 	var model []T
-	err := To(&model).Database(b.db.Name).Query(b.statement, b.args...)
+	selector := ToOn(b.c, &model).Database(b.db.Name)
+	selector.primary = b.primary
+	selector.nocache = b.nocache
+	selector.ctx = b.ctx
+	selector.trace = b.trace
+	err := selector.Query(b.statement, b.args...)
 	if err != nil {
 		return *new(T), err
 	} else if len(model) == 0 {
 		return *new(T), ErrNoData
 	}
 	if useCache && !b.nocache {
-		if v, ok := caches.Get(cacheKey); ok {
+		if v, ok := b.c.caches.Get(cacheKey); ok {
 			v.Set(c, model[0])
-			caches.Set(cacheKey, v)
+			b.c.caches.Set(cacheKey, v)
 		} else {
 			new := kmap.New[dbCache, any]()
 			new.Set(c, model[0])
-			caches.Set(cacheKey, new)
+			b.c.caches.Set(cacheKey, new)
 		}
 	}
 	return model[0], nil
@@ -2,7 +2,6 @@ package korm
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
@@ -128,13 +127,14 @@ func max(a, b int) int {
 }
 
 func AdaptPlaceholdersToDialect(query *string, dialect string) {
-	if strings.Contains(*query, "?") && (dialect != MYSQL) {
+	d := dialectOf(dialect)
+	if strings.Contains(*query, "?") && d.Placeholder(1) != "?" {
 		split := strings.Split(*query, "?")
 		counter := 0
 		for i := range split {
 			if i < len(split)-1 {
 				counter++
-				split[i] = split[i] + "$" + strconv.Itoa(counter)
+				split[i] = split[i] + d.Placeholder(counter)
 			}
 		}
 		*query = strings.Join(split, "")
@@ -175,7 +175,7 @@ func adaptSetQuery(query *string) {
 	*query = strings.Join(sp, ",")
 }
 
-func adaptConcatAndLen(str string, dialect Dialect) string {
+func adaptConcatAndLen(str string, dialect string) string {
 	if strings.Contains(str, "len(") || strings.Contains(str, "concat") {
 		if dialect == SQLITE {
 			strt := strings.Replace(str, "len(", "length(", -1)
@@ -0,0 +1,241 @@
+package korm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/ksmux"
+	"github.com/kamalshkeir/ksmux/ksps"
+	"github.com/kamalshkeir/lg"
+)
+
+// ChangeStreamHeartbeat is the interval of comments sent on idle change streams, keeping connections open through proxies
+var ChangeStreamHeartbeat = 15 * time.Second
+
+// StreamRowFilter is called with the request of a change stream for every change, returning false do not send the change to this client.
+// It is used to send to a user only the rows the user can read
+type StreamRowFilter func(c *ksmux.Context, hd HookData) bool
+
+// ChangeStreamOption configure WithChangeStream and ChangeStreamHandler
+type ChangeStreamOption func(*changeStreamOptions)
+
+type changeStreamOptions struct {
+	database string
+	filter   StreamRowFilter
+}
+
+// StreamFilter set the per request row filter of a change stream
+func StreamFilter(fn StreamRowFilter) ChangeStreamOption {
+	return func(o *changeStreamOptions) { o.filter = fn }
+}
+
+// StreamDatabase stream changes of dbName instead of the first database
+func StreamDatabase(dbName string) ChangeStreamOption {
+	return func(o *changeStreamOptions) { o.database = dbName }
+}
+
+// streamQuery is the filter of a change stream request
+type streamQuery struct {
+	tables []string
+	ops    []string
+	from   uint64
+	resume bool
+}
+
+// WithChangeStream register on the server bus a Server-Sent Events handler at path streaming changes of the _changes log, see ChangeStreamHandler.
+// authMiddleware, like korm.Auth or korm.Admin, wrap the handler if not nil
+//
+//	Example:
+//	  korm.WithChangeStream("/changes", korm.Auth, korm.StreamFilter(func(c *ksmux.Context, hd korm.HookData) bool {
+//	  	user, _ := c.GetKey("korm-user")
+//	  	return hd.Table != "orders" || canRead(user, hd)
+//	  }))
+//	  // js: new EventSource("/changes?tables=orders&ops=insert,update").addEventListener("insert", e => console.log(JSON.parse(e.data)))
+func WithChangeStream(path string, authMiddleware func(handler ksmux.Handler) ksmux.Handler, opts ...ChangeStreamOption) *ksps.ServerBus {
+	if defaultClient.serverBus == nil {
+		lg.DebugC("using default bus :9313")
+		defaultClient.serverBus = WithBus()
+	}
+	handler, err := defaultClient.ChangeStreamHandler(opts...)
+	if err != nil {
+		lg.ErrorC("could not enable change stream", "path", path, "err", err)
+		return defaultClient.serverBus
+	}
+	if authMiddleware != nil {
+		handler = authMiddleware(handler)
+	}
+	defaultClient.serverBus.App().Get(path, handler)
+	return defaultClient.serverBus
+}
+
+// ChangeStreamHandler return a Server-Sent Events handler streaming changes published by change workers, creating the _changes log like Watch.
+// Events have the sequence number of the change as id, the operation as event and the HookData as JSON data.
+// Query parameters 'tables' and 'ops' (insert, update, delete) filter changes, comma separated.
+// Clients reconnecting with the Last-Event-ID header, or the 'last_event_id' query parameter, receive changes missed since this id,
+// otherwise only new changes are sent. Streams end when the client disconnect or on Shutdown
+func ChangeStreamHandler(opts ...ChangeStreamOption) (ksmux.Handler, error) {
+	return defaultClient.ChangeStreamHandler(opts...)
+}
+
+// ChangeStreamHandler is korm.ChangeStreamHandler for client c
+func (c *Client) ChangeStreamHandler(opts ...ChangeStreamOption) (ksmux.Handler, error) {
+	o := changeStreamOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	db, err := c.changeLogDatabase(o.database)
+	if err != nil {
+		return nil, err
+	}
+	return func(kc *ksmux.Context) {
+		q, err := parseStreamQuery(kc.Request)
+		if err != nil {
+			kc.Status(http.StatusBadRequest).Error(err.Error())
+			return
+		}
+		if _, ok := kc.ResponseWriter.(http.Flusher); !ok {
+			kc.Status(http.StatusInternalServerError).Error("streaming not supported")
+			return
+		}
+		ctx := kc.Request.Context()
+		if !q.resume {
+			if q.from, err = c.lastChangeSeq(ctx, db); err != nil {
+				kc.Status(http.StatusInternalServerError).Error(err.Error())
+				return
+			}
+		}
+		h := kc.ResponseWriter.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Connection", "keep-alive")
+		h.Set("X-Accel-Buffering", "no")
+		kc.ResponseWriter.WriteHeader(http.StatusOK)
+		kc.Flush()
+		done := make(chan struct{})
+		c.goWorker("change stream "+kc.Request.RemoteAddr, db.Name, func(wctx context.Context) {
+			defer close(done)
+			c.streamChanges(ctx, wctx, kc, db, q, o.filter)
+		})
+		<-done
+	}, nil
+}
+
+// streamChanges write changes after q.from matching q and filter to kc until ctx or wctx is done or the client is gone
+func (c *Client) streamChanges(ctx, wctx context.Context, kc *ksmux.Context, db *DatabaseEntity, q streamQuery, filter StreamRowFilter) {
+	last := q.from
+	heartbeat := time.NewTicker(ChangeStreamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		signal := c.changesSignal()
+		entries, err := ModelOn[ChangeEntry](c).Database(db.Name).Context(ctx).NoCache().Where("id > ?", last).OrderBy("id").Limit(500).All()
+		if err != nil && !errors.Is(err, ErrNoData) && ctx.Err() == nil && wctx.Err() == nil {
+			lg.ErrorC("could not read changes", "err", err)
+		}
+		for _, e := range entries {
+			last = e.Id
+			if !q.keep(kc, e, filter) {
+				continue
+			}
+			if writeChangeEvent(kc.ResponseWriter, e) != nil {
+				return
+			}
+		}
+		if len(entries) == 500 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wctx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(kc.ResponseWriter, ": ping\n\n"); err != nil {
+				return
+			}
+			kc.Flush()
+		case <-signal:
+		case <-time.After(WatchPollEvery):
+		}
+	}
+}
+
+// lastChangeSeq return the sequence number of the last change of db, or 0 if empty
+func (c *Client) lastChangeSeq(ctx context.Context, db *DatabaseEntity) (uint64, error) {
+	e, err := ModelOn[ChangeEntry](c).Database(db.Name).Context(ctx).NoCache().OrderBy("-id").Limit(1).One()
+	if errors.Is(err, ErrNoData) {
+		return 0, nil
+	}
+	return e.Id, err
+}
+
+// parseStreamQuery read tables, ops and the resume point of a change stream request
+func parseStreamQuery(r *http.Request) (streamQuery, error) {
+	q := streamQuery{}
+	values := r.URL.Query()
+	q.tables = splitStreamList(values.Get("tables"))
+	q.ops = splitStreamList(values.Get("ops"))
+	for _, op := range q.ops {
+		if op != "insert" && op != "update" && op != "delete" {
+			return q, fmt.Errorf("unknown operation %q", op)
+		}
+	}
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = values.Get("last_event_id")
+	}
+	if last != "" {
+		seq, err := strconv.ParseUint(last, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("invalid last event id %q", last)
+		}
+		q.from = seq
+		q.resume = true
+	}
+	return q, nil
+}
+
+func splitStreamList(v string) []string {
+	list := []string{}
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// keep return true if e match tables and ops of q and the row filter
+func (q streamQuery) keep(kc *ksmux.Context, e ChangeEntry, filter StreamRowFilter) bool {
+	if len(q.tables) > 0 && !SliceContains(q.tables, e.TableName) {
+		return false
+	}
+	if len(q.ops) > 0 && !SliceContains(q.ops, e.Op) {
+		return false
+	}
+	if filter == nil {
+		return true
+	}
+	hd := HookData{}
+	if err := json.Unmarshal([]byte(e.Data), &hd); err != nil {
+		lg.ErrorC("could not decode change", "table", e.TableName, "seq", e.Id, "err", err)
+		return false
+	}
+	return filter(kc, hd)
+}
+
+// writeChangeEvent write e as a Server-Sent Event and flush it
+func writeChangeEvent(w http.ResponseWriter, e ChangeEntry) error {
+	if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Op, e.Data); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
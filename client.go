@@ -0,0 +1,106 @@
+package korm
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/kamalshkeir/kmap"
+	"github.com/kamalshkeir/ksmux/ksps"
+)
+
+// Client own the databases, caches, hooks, tracer and bus of an isolated korm setup, package level functions
+// like New, Model, Table and OnInsert use the default client returned by DefaultClient
+//
+//	Example:
+//	  c := korm.NewClient()
+//	  err := c.New(korm.SQLITE, "tenant1", sqlitedriver.Use())
+//	  err = korm.AutoMigrateOn[User](c, "users")
+//	  users, err := korm.ModelOn[User](c).Where("id > ?", 1).All()
+type Client struct {
+	// defaultDB keep tracking of the first database connected
+	defaultDB           string
+	databases           []DatabaseEntity
+	mutexModelTablename sync.RWMutex
+	mModelTablename     map[string]any
+	caches              *kmap.SafeMap[string, *kmap.SafeMap[dbCache, any]]
+	cacheQ              *kmap.SafeMap[string, any]
+	cacheAllTables      *kmap.SafeMap[string, []string]
+	cacheAllCols        *kmap.SafeMap[string, map[string]string]
+	cacheAllColsOrdered *kmap.SafeMap[string, []string]
+	relationsMap        *kmap.SafeMap[string, struct{}]
+	hooks               *kmap.SafeMap[string, []HookFunc]
+	errHooks            *kmap.SafeMap[string, []HookErrFunc]
+	hooksMu             sync.Mutex
+	errHooksMu          sync.Mutex
+	queryHooks          *kmap.SafeMap[string, []Hooks]
+	queryHooksMu        sync.Mutex
+	spans               spanExport
+	slow                slowQueryLog
+	tracer              *Tracer
+	serverBus           *ksps.ServerBus
+	nodeManager         *NodeManager
+	cacheHooksOnce      sync.Once
+	// replicasCheckerStarted is true once the replicas health checker of c is running
+	replicasCheckerStarted atomic.Bool
+	shardings              *kmap.SafeMap[string, *shardConfig]
+	triggersTables         *kmap.SafeMap[string, struct{}]
+	life                   lifecycle
+	jobHandlers            *kmap.SafeMap[string, JobHandler]
+	// internalTables hold 'db.table' of korm tables already migrated, like _jobs and _schedules
+	internalTables *kmap.SafeMap[string, struct{}]
+	schedules      *kmap.SafeMap[string, *scheduled]
+	schedulerOnce  sync.Once
+	// node identify c in schedules runs when the node manager is not used
+	node       string
+	locks      lockRegistry
+	changes    changeLog
+	deliveries deliveries
+	// webhooksWake wake webhook workers when deliveries are queued
+	webhooksWake chan struct{}
+}
+
+var defaultClient = NewClient()
+
+// NewClient create an empty client, databases are added using c.New or c.NewWithOptions
+func NewClient() *Client {
+	return &Client{
+		databases:           []DatabaseEntity{},
+		mModelTablename:     map[string]any{},
+		caches:              kmap.New[string, *kmap.SafeMap[dbCache, any]](cacheMaxMemoryMb),
+		cacheQ:              kmap.New[string, any](cacheMaxMemoryMb),
+		cacheAllTables:      kmap.New[string, []string](),
+		cacheAllCols:        kmap.New[string, map[string]string](),
+		cacheAllColsOrdered: kmap.New[string, []string](),
+		relationsMap:        kmap.New[string, struct{}](),
+		hooks:               kmap.New[string, []HookFunc](),
+		errHooks:            kmap.New[string, []HookErrFunc](),
+		queryHooks:          kmap.New[string, []Hooks](),
+		shardings:           kmap.New[string, *shardConfig](),
+		triggersTables:      kmap.New[string, struct{}](),
+		jobHandlers:         kmap.New[string, JobHandler](),
+		internalTables:      kmap.New[string, struct{}](),
+		schedules:           kmap.New[string, *scheduled](),
+		node:                GenerateUUID(),
+		webhooksWake:        make(chan struct{}, 1),
+		tracer: &Tracer{
+			enabled: false,
+			traces:  make([]TraceData, 0),
+			maxSize: 500, // Default to keeping last 1000 traces
+		},
+	}
+}
+
+// DefaultClient return the client used by package level functions
+func DefaultClient() *Client {
+	return defaultClient
+}
+
+// DefaultDB return the name of the first database connected to c
+func (c *Client) DefaultDB() string {
+	return c.defaultDB
+}
+
+// Tracer return the query tracer of c
+func (c *Client) Tracer() *Tracer {
+	return c.tracer
+}
@@ -24,7 +24,7 @@ func cloneAndMigrateDashboard(staticAndTemplatesEmbeded ...embed.FS) {
 		Func: statsNbRecords,
 	})
 	AddDashStats(StatsFunc{
-		Name: "Database <span style='color:var(--theme-color)'>" + defaultDB + "</span> size",
+		Name: "Database <span style='color:var(--theme-color)'>" + defaultClient.defaultDB + "</span> size",
 		Func: statsDbSize,
 	})
 	if _, err := os.Stat(assetsDir); err != nil && !embededDashboard {
@@ -47,24 +47,24 @@ func cloneAndMigrateDashboard(staticAndTemplatesEmbeded ...embed.FS) {
 
 	if len(staticAndTemplatesEmbeded) > 0 {
 		staticAndTemplatesFS = staticAndTemplatesEmbeded
-		lg.CheckError(serverBus.App().EmbededStatics(staticAndTemplatesEmbeded[0], staticDir, staticUrl))
-		err := serverBus.App().EmbededTemplates(staticAndTemplatesEmbeded[1], templatesDir)
+		lg.CheckError(defaultClient.serverBus.App().EmbededStatics(staticAndTemplatesEmbeded[0], staticDir, staticUrl))
+		err := defaultClient.serverBus.App().EmbededTemplates(staticAndTemplatesEmbeded[1], templatesDir)
 		lg.CheckError(err)
 	} else {
-		lg.CheckError(serverBus.App().LocalStatics(staticDir, staticUrl))
-		err := serverBus.App().LocalTemplates(templatesDir)
+		lg.CheckError(defaultClient.serverBus.App().LocalStatics(staticDir, staticUrl))
+		err := defaultClient.serverBus.App().LocalTemplates(templatesDir)
 		lg.CheckError(err)
 	}
-	err := AutoMigrate[User]("users", defaultDB)
+	err := AutoMigrate[User]("users", defaultClient.defaultDB)
 	if lg.CheckError(err) {
 		return
 	}
 	if kanbanUIEnabled {
-		err = AutoMigrate[Board]("_boards", defaultDB)
+		err = AutoMigrate[Board]("_boards", defaultClient.defaultDB)
 		if lg.CheckError(err) {
 			return
 		}
-		err = AutoMigrate[Task]("_tasks", defaultDB)
+		err = AutoMigrate[Task]("_tasks", defaultClient.defaultDB)
 		if lg.CheckError(err) {
 			return
 		}
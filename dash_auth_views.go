@@ -3,7 +3,6 @@ package korm
 import (
 	"net/http"
 
-	"github.com/kamalshkeir/aes"
 	"github.com/kamalshkeir/argon"
 	"github.com/kamalshkeir/ksmux"
 	"github.com/kamalshkeir/lg"
@@ -18,7 +17,7 @@ var LoginPOSTView = func(c *ksmux.Context) {
 	email := requestData["email"]
 	passRequest := requestData["password"]
 
-	data, err := Table("users").Database(defaultDB).Where("email = ?", email).One()
+	data, err := Table("users").Database(defaultClient.defaultDB).Where("email = ?", email).One()
 	if err != nil {
 		c.Status(http.StatusUnauthorized).Json(map[string]any{
 			"error": err.Error(),
@@ -47,9 +46,17 @@ var LoginPOSTView = func(c *ksmux.Context) {
 				return
 			} else {
 				if uuid, ok := data["uuid"].(string); ok {
-					uuid, err = aes.Encrypt(uuid)
-					lg.CheckError(err)
-					c.SetCookie("session", uuid)
+					store, err := Sessions()
+					if err == nil {
+						err = store.Login(c, uuid)
+					}
+					if err != nil {
+						lg.ErrorC("could not create session", "err", err)
+						c.Status(http.StatusInternalServerError).Json(map[string]any{
+							"error": "could not create session",
+						})
+						return
+					}
 					c.Json(map[string]any{
 						"success": "U Are Logged In",
 					})
@@ -60,7 +67,20 @@ var LoginPOSTView = func(c *ksmux.Context) {
 	}
 }
 
+// LogoutView revoke the current session, or all sessions of the user with ?all=1 (logout everywhere)
 var LogoutView = func(c *ksmux.Context) {
-	c.DeleteCookie("session")
+	store, err := Sessions()
+	if err != nil {
+		c.DeleteCookie(SessionCookie)
+		c.Status(http.StatusTemporaryRedirect).Redirect("/")
+		return
+	}
+	if c.QueryParam("all") != "" {
+		if session, err := store.Current(c); err == nil {
+			_, err = store.RevokeUser(session.UserUuid)
+			lg.CheckError(err)
+		}
+	}
+	lg.CheckError(store.Logout(c))
 	c.Status(http.StatusTemporaryRedirect).Redirect("/")
 }
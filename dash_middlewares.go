@@ -3,8 +3,8 @@ package korm
 import (
 	"net/http"
 
-	"github.com/kamalshkeir/aes"
 	"github.com/kamalshkeir/ksmux"
+	"github.com/kamalshkeir/lg"
 )
 
 var (
@@ -14,26 +14,12 @@ var (
 
 var Auth = func(handler ksmux.Handler) ksmux.Handler {
 	return func(c *ksmux.Context) {
-		session, err := c.GetCookie("session")
-		if err != nil || session == "" {
+		user, ok := sessionUser(c)
+		if !ok {
 			// NOT AUTHENTICATED
-			c.DeleteCookie("session")
 			handler(c)
 			return
 		}
-		session, err = aes.Decrypt(session)
-		if err != nil {
-			handler(c)
-			return
-		}
-		// Check session
-		user, err := Model[User]().Where("uuid = ?", session).One()
-		if err != nil {
-			// session fail
-			handler(c)
-			return
-		}
-
 		// AUTHENTICATED AND FOUND IN DB
 		c.SetKey("korm-user", user)
 		handler(c)
@@ -42,22 +28,9 @@ var Auth = func(handler ksmux.Handler) ksmux.Handler {
 
 var Admin = func(handler ksmux.Handler) ksmux.Handler {
 	return func(c *ksmux.Context) {
-		session, err := c.GetCookie("session")
-		if err != nil || session == "" {
-			// NOT AUTHENTICATED
-			c.DeleteCookie("session")
-			c.Status(http.StatusTemporaryRedirect).Redirect(adminPathNameGroup + "/login")
-			return
-		}
-		session, err = aes.Decrypt(session)
-		if err != nil {
-			c.Status(http.StatusTemporaryRedirect).Redirect(adminPathNameGroup + "/login")
-			return
-		}
-		user, err := Model[User]().Where("uuid = ?", session).One()
-
-		if err != nil {
-			// AUTHENTICATED BUT NOT FOUND IN DB
+		user, ok := sessionUser(c)
+		if !ok {
+			// NOT AUTHENTICATED, EXPIRED, REVOKED OR NOT FOUND IN DB
 			c.Status(http.StatusTemporaryRedirect).Redirect(adminPathNameGroup + "/login")
 			return
 		}
@@ -72,6 +45,26 @@ var Admin = func(handler ksmux.Handler) ksmux.Handler {
 	}
 }
 
+// sessionUser return the user of the session cookie of c, renewing the session if needed
+func sessionUser(c *ksmux.Context) (User, bool) {
+	store, err := Sessions()
+	if err != nil {
+		lg.ErrorC("sessions unavailable", "err", err)
+		return User{}, false
+	}
+	session, err := store.Current(c)
+	if err != nil {
+		return User{}, false
+	}
+	user, err := Model[User]().Where("uuid = ?", session.UserUuid).One()
+	if err != nil {
+		return User{}, false
+	}
+	c.SetKey("korm-session", session)
+	c.Request = c.Request.WithContext(WithActor(c.Request.Context(), user.Email))
+	return user, true
+}
+
 var BasicAuth = func(handler ksmux.Handler) ksmux.Handler {
 	return ksmux.BasicAuth(handler, BASIC_AUTH_USER, BASIC_AUTH_PASS)
 }
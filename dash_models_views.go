@@ -20,7 +20,7 @@ import (
 )
 
 var TablesView = func(c *ksmux.Context) {
-	allTables := GetAllTables(defaultDB)
+	allTables := GetAllTables(defaultClient.defaultDB)
 	q := []string{}
 	for _, t := range allTables {
 		q = append(q, "SELECT '"+t+"' AS table_name,COUNT(*) AS count FROM "+t)
@@ -31,7 +31,7 @@ var TablesView = func(c *ksmux.Context) {
 		TableName string `db:"table_name"`
 		Count     int    `db:"count"`
 	}
-	if err := To(&results).Database(defaultDB).Query(query); lg.CheckError(err) {
+	if err := To(&results).Database(defaultClient.defaultDB).Query(query); lg.CheckError(err) {
 		c.Error("something wrong happened")
 		return
 	}
@@ -50,10 +50,10 @@ var TableGetAll = func(c *ksmux.Context) {
 		})
 		return
 	}
-	dbMem, _ := GetMemoryDatabase(defaultDB)
+	dbMem, _ := GetMemoryDatabase(defaultClient.defaultDB)
 	if dbMem == nil {
-		lg.ErrorC("unable to find db in mem", "db", defaultDB)
-		dbMem = &databases[0]
+		lg.ErrorC("unable to find db in mem", "db", defaultClient.defaultDB)
+		dbMem = &defaultClient.databases[0]
 	}
 	idString := "id"
 	var t *TableEntity
@@ -74,7 +74,7 @@ var TableGetAll = func(c *ksmux.Context) {
 	if body.Page == 0 {
 		body.Page = 1
 	}
-	rows, err := Table(model).Database(defaultDB).OrderBy("-" + idString).Limit(paginationPer).Page(body.Page).All()
+	rows, err := Table(model).Database(defaultClient.defaultDB).OrderBy("-" + idString).Limit(paginationPer).Page(body.Page).All()
 	if err != nil {
 		if err != ErrNoData {
 			c.Status(404).Error("Unable to find this model")
@@ -86,12 +86,12 @@ var TableGetAll = func(c *ksmux.Context) {
 	// Get total count for pagination
 	var total int64
 	var totalRows []int64
-	err = To(&totalRows).Database(defaultDB).Query("SELECT COUNT(*) FROM " + model)
+	err = To(&totalRows).Database(defaultClient.defaultDB).Query("SELECT COUNT(*) FROM " + model)
 	if err == nil {
 		total = totalRows[0]
 	}
 
-	dbCols, cols := GetAllColumnsTypes(model, defaultDB)
+	dbCols, cols := GetAllColumnsTypes(model, defaultClient.defaultDB)
 	mmfkeysModels := map[string][]map[string]any{}
 	mmfkeys := map[string][]any{}
 	if t != nil {
@@ -173,10 +173,10 @@ var AllModelsGet = func(c *ksmux.Context) {
 		return
 	}
 
-	dbMem, _ := GetMemoryDatabase(defaultDB)
+	dbMem, _ := GetMemoryDatabase(defaultClient.defaultDB)
 	if dbMem == nil {
-		lg.ErrorC("unable to find db in mem", "db", defaultDB)
-		dbMem = &databases[0]
+		lg.ErrorC("unable to find db in mem", "db", defaultClient.defaultDB)
+		dbMem = &defaultClient.databases[0]
 	}
 	idString := "id"
 	var t *TableEntity
@@ -187,9 +187,9 @@ var AllModelsGet = func(c *ksmux.Context) {
 		}
 	}
 
-	rows, err := Table(model).Database(defaultDB).OrderBy("-" + idString).Limit(paginationPer).Page(1).All()
+	rows, err := Table(model).Database(defaultClient.defaultDB).OrderBy("-" + idString).Limit(paginationPer).Page(1).All()
 	if err != nil {
-		rows, err = Table(model).Database(defaultDB).All()
+		rows, err = Table(model).Database(defaultClient.defaultDB).All()
 		if err != nil {
 			if err != ErrNoData {
 				c.Status(404).Error("Unable to find this model")
@@ -197,7 +197,7 @@ var AllModelsGet = func(c *ksmux.Context) {
 			}
 		}
 	}
-	dbCols, cols := GetAllColumnsTypes(model, defaultDB)
+	dbCols, cols := GetAllColumnsTypes(model, defaultClient.defaultDB)
 	mmfkeysModels := map[string][]map[string]any{}
 	mmfkeys := map[string][]any{}
 	if t != nil {
@@ -253,6 +253,7 @@ var AllModelsGet = func(c *ksmux.Context) {
 		} else {
 			data["columns"] = dbCols
 		}
+		_, data["history"] = defaultClient.internalTables.Get(dbMem.Name + "." + model + "_history")
 		c.Html("admin/admin_single_table.html", data)
 	} else {
 		lg.ErrorC("table not found", "table", model)
@@ -271,7 +272,7 @@ var AllModelsSearch = func(c *ksmux.Context) {
 
 	body := c.BodyJson()
 
-	blder := Table(model).Database(defaultDB)
+	blder := Table(model).Database(defaultClient.defaultDB)
 	if query, ok := body["query"]; ok {
 		if v, ok := query.(string); ok {
 			if v != "" {
@@ -286,7 +287,7 @@ var AllModelsSearch = func(c *ksmux.Context) {
 	}
 
 	oB := ""
-	t, err := GetMemoryTable(model, defaultDB)
+	t, err := GetMemoryTable(model, defaultClient.defaultDB)
 	if lg.CheckError(err) {
 		c.Json(map[string]any{
 			"error": err,
@@ -366,7 +367,7 @@ var AllModelsSearch = func(c *ksmux.Context) {
 			query += " WHERE " + vStr
 		}
 	}
-	err = To(&totalRows).Database(defaultDB).Query(query)
+	err = To(&totalRows).Database(defaultClient.defaultDB).Query(query)
 	if err == nil {
 		total = totalRows[0]
 	}
@@ -392,7 +393,7 @@ var BulkDeleteRowPost = func(c *ksmux.Context) {
 		return
 	}
 	idString := "id"
-	t, err := GetMemoryTable(data.Table, defaultDB)
+	t, err := GetMemoryTable(data.Table, defaultClient.defaultDB)
 	if err != nil {
 		c.Status(404).Json(map[string]any{
 			"error": "table not found",
@@ -402,7 +403,7 @@ var BulkDeleteRowPost = func(c *ksmux.Context) {
 	if t.Pk != "" && t.Pk != "id" {
 		idString = t.Pk
 	}
-	_, err = Table(data.Table).Database(defaultDB).Where(idString+" IN (?)", data.Ids).Delete()
+	_, err = Table(data.Table).Database(defaultClient.defaultDB).Context(c.Request.Context()).Where(idString+" IN (?)", data.Ids).Delete()
 	if lg.CheckError(err) {
 		c.Status(http.StatusBadRequest).Json(map[string]any{
 			"error": err.Error(),
@@ -456,7 +457,7 @@ var CreateModelView = func(c *ksmux.Context) {
 			}
 		}
 	}
-	inserted, err := Table(model).Database(defaultDB).InsertR(m)
+	inserted, err := Table(model).Database(defaultClient.defaultDB).Context(c.Request.Context()).InsertR(m)
 	if err != nil {
 		lg.ErrorC("CreateModelView error", "err", err)
 		c.Status(http.StatusBadRequest).Json(map[string]any{
@@ -466,7 +467,7 @@ var CreateModelView = func(c *ksmux.Context) {
 	}
 
 	idString := "id"
-	t, _ := GetMemoryTable(data["table"][0], defaultDB)
+	t, _ := GetMemoryTable(data["table"][0], defaultClient.defaultDB)
 	if t.Pk != "" && t.Pk != "id" {
 		idString = t.Pk
 	}
@@ -492,7 +493,7 @@ var UpdateRowPost = func(c *ksmux.Context) {
 	data, files := c.ParseMultipartForm()
 	id := data["row_id"][0]
 	idString := "id"
-	db, _ := GetMemoryDatabase(defaultDB)
+	db, _ := GetMemoryDatabase(defaultClient.defaultDB)
 	var t TableEntity
 	for _, tab := range db.Tables {
 		if tab.Name == data["table"][0] {
@@ -510,7 +511,7 @@ var UpdateRowPost = func(c *ksmux.Context) {
 		return
 	}
 
-	modelDB, err := Table(data["table"][0]).Database(defaultDB).Where(idString+" = ?", id).One()
+	modelDB, err := Table(data["table"][0]).Database(defaultClient.defaultDB).Where(idString+" = ?", id).One()
 
 	if err != nil {
 		c.Status(http.StatusBadRequest).Json(map[string]any{
@@ -521,10 +522,7 @@ var UpdateRowPost = func(c *ksmux.Context) {
 
 	ignored := []string{idString, "file", "image", "photo", "img", "fichier", "row_id", "table"}
 	toUpdate := map[string]any{}
-	quote := "`"
-	if db.Dialect == POSTGRES || db.Dialect == COCKROACH {
-		quote = "\""
-	}
+	d := dialectOf(db.Dialect)
 	for key, val := range data {
 		if !SliceContains(ignored, key) {
 			if modelDB[key] == val[0] {
@@ -537,9 +535,9 @@ var UpdateRowPost = func(c *ksmux.Context) {
 					c.Error("unable to hash pass")
 					return
 				}
-				toUpdate[quote+key+quote] = hash
+				toUpdate[d.Quote(key)] = hash
 			} else {
-				toUpdate[quote+key+quote] = val[0]
+				toUpdate[d.Quote(key)] = val[0]
 			}
 		}
 	}
@@ -557,7 +555,7 @@ var UpdateRowPost = func(c *ksmux.Context) {
 		}
 	}
 	if s != "" {
-		_, err := Table(data["table"][0]).Database(defaultDB).Where(idString+" = ?", id).Set(s, values...)
+		_, err := Table(data["table"][0]).Database(defaultClient.defaultDB).Context(c.Request.Context()).Where(idString+" = ?", id).Set(s, values...)
 		if err != nil {
 			c.Status(http.StatusBadRequest).Json(map[string]any{
 				"error": err.Error(),
@@ -585,7 +583,7 @@ var UpdateRowPost = func(c *ksmux.Context) {
 		}
 	}
 
-	ret, err := Table(data["table"][0]).Database(defaultDB).Where(idString+" = ?", id).One()
+	ret, err := Table(data["table"][0]).Database(defaultClient.defaultDB).Where(idString+" = ?", id).One()
 	if err != nil {
 		c.Status(500).Error("something wrong happened")
 		return
@@ -605,7 +603,7 @@ func handleFilesUpload(files map[string][]*multipart.FileHeader, model string, i
 			if err != nil {
 				return uploadedPath, formName, err
 			}
-			row, err := Table(model).Database(defaultDB).Where(pkKey+" = ?", id).One()
+			row, err := Table(model).Database(defaultClient.defaultDB).Where(pkKey+" = ?", id).One()
 			if err != nil {
 				return uploadedPath, formName, err
 			}
@@ -619,12 +617,12 @@ func handleFilesUpload(files map[string][]*multipart.FileHeader, model string, i
 					err := c.DeleteFile(v)
 					if err != nil {
 						//le fichier n'existe pas
-						_, err := Table(model).Database(defaultDB).Where(pkKey+" = ?", id).Set(key+" = ?", uploadedImage)
+						_, err := Table(model).Database(defaultClient.defaultDB).Context(c.Request.Context()).Where(pkKey+" = ?", id).Set(key+" = ?", uploadedImage)
 						lg.CheckError(err)
 						continue
 					} else {
 						//le fichier existe et donc supprimer
-						_, err := Table(model).Database(defaultDB).Where(pkKey+" = ?", id).Set(key+" = ?", uploadedImage)
+						_, err := Table(model).Database(defaultClient.defaultDB).Context(c.Request.Context()).Where(pkKey+" = ?", id).Set(key+" = ?", uploadedImage)
 						lg.CheckError(err)
 						continue
 					}
@@ -635,11 +633,58 @@ func handleFilesUpload(files map[string][]*multipart.FileHeader, model string, i
 	return uploadedPath, formName, nil
 }
 
+// RowHistoryView return the versions of the row ?pk= of a table having history, oldest first, for the history tab of the row edit page
+var RowHistoryView = func(c *ksmux.Context) {
+	model := c.Param("model")
+	db, err := GetMemoryDatabase(defaultClient.defaultDB)
+	if err != nil {
+		c.Status(500).Json(map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+	if _, ok := defaultClient.internalTables.Get(db.Name + "." + model + "_history"); !ok {
+		c.Status(404).Json(map[string]any{
+			"error": "history is not enabled for " + model,
+		})
+		return
+	}
+	pk := db.tablePk(model)
+	if pk == "" {
+		c.Status(404).Json(map[string]any{
+			"error": "table not found",
+		})
+		return
+	}
+	d := dialectOf(db.Dialect)
+	rows, err := Table(model+"_history").Database(db.Name).NoCache().QueryM("SELECT * FROM "+d.Quote(model+"_history")+" WHERE "+d.Quote(pk)+" = ? ORDER BY "+d.Quote("history_id"), c.QueryParam("pk"))
+	if err != nil && !errors.Is(err, ErrNoData) {
+		c.Status(500).Json(map[string]any{
+			"error": err.Error(),
+		})
+		return
+	}
+	for _, r := range rows {
+		if v, ok := r["diff"].(string); ok {
+			diff := map[string]any{}
+			if json.Unmarshal([]byte(v), &diff) == nil {
+				r["diff"] = diff
+			}
+		}
+	}
+	if rows == nil {
+		rows = []map[string]any{}
+	}
+	c.Json(map[string]any{
+		"history": rows,
+	})
+}
+
 var DropTablePost = func(c *ksmux.Context) {
 	data := c.BodyJson()
 	if table, ok := data["table"]; ok && table != "" {
 		if t, ok := data["table"].(string); ok {
-			_, err := Table(t).Database(defaultDB).Drop()
+			_, err := Table(t).Database(defaultClient.defaultDB).Drop()
 			if lg.CheckError(err) {
 				c.Status(http.StatusBadRequest).Json(map[string]any{
 					"error": err.Error(),
@@ -669,7 +714,7 @@ var ExportView = func(c *ksmux.Context) {
 		})
 		return
 	}
-	data, err := Table(table).Database(defaultDB).All()
+	data, err := Table(table).Database(defaultClient.defaultDB).All()
 	lg.CheckError(err)
 
 	data_bytes, err := json.Marshal(data)
@@ -686,13 +731,13 @@ var ExportCSVView = func(c *ksmux.Context) {
 		})
 		return
 	}
-	data, err := Table(table).Database(defaultDB).All()
+	data, err := Table(table).Database(defaultClient.defaultDB).All()
 	lg.CheckError(err)
 	var buff bytes.Buffer
 	writer := csv.NewWriter(&buff)
 
 	cols := []string{}
-	tab, _ := GetMemoryTable(table, defaultDB)
+	tab, _ := GetMemoryTable(table, defaultClient.defaultDB)
 	if len(tab.Columns) > 0 {
 		cols = tab.Columns
 	} else if len(data) > 0 {
@@ -745,7 +790,7 @@ var ImportView = func(c *ksmux.Context) {
 		})
 		return
 	}
-	t, err := GetMemoryTable(table, defaultDB)
+	t, err := GetMemoryTable(table, defaultClient.defaultDB)
 	if lg.CheckError(err) {
 		c.Status(http.StatusBadRequest).Json(map[string]any{
 			"error": err.Error(),
@@ -763,7 +808,7 @@ var ImportView = func(c *ksmux.Context) {
 	isCsv := strings.HasSuffix(fname, ".csv")
 
 	// get old data and backup
-	modelsOld, _ := Table(table).Database(defaultDB).All()
+	modelsOld, _ := Table(table).Database(defaultClient.defaultDB).All()
 	if len(modelsOld) > 0 {
 		modelsOldBytes, err := json.Marshal(modelsOld)
 		if !lg.CheckError(err) {
@@ -808,7 +853,7 @@ var ImportView = func(c *ksmux.Context) {
 	// create models in database
 	var retErr []error
 	for _, m := range list_map {
-		_, err = Table(table).Database(defaultDB).Insert(m)
+		_, err = Table(table).Database(defaultClient.defaultDB).Context(c.Request.Context()).Insert(m)
 		if err != nil {
 			retErr = append(retErr, err)
 		}
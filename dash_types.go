@@ -21,6 +21,10 @@ var (
 	adminPathNameGroup = "/admin"
 	terminalUIEnabled  = false
 	kanbanUIEnabled    = false
+	jobsUIEnabled      = false
+	schedulesUIEnabled = false
+	webhooksUIEnabled  = false
+	slowQueryUIEnabled = false
 	// Debug when true show extra useful logs for queries executed for migrations and queries statements
 	Debug = false
 	// FlushCacheEvery execute korm.FlushCache() every 10 min by default, you should not worry about it, but useful that you can change it
@@ -13,10 +13,14 @@ func init() {
 	ksmux.BeforeRenderHtml("korm-user", func(c *ksmux.Context, data *map[string]any) {
 		(*data)["admin_path"] = adminPathNameGroup
 		(*data)["static_url"] = staticUrl
-		(*data)["trace_enabled"] = defaultTracer.enabled
+		(*data)["trace_enabled"] = defaultClient.tracer.enabled
 		(*data)["terminal_enabled"] = terminalUIEnabled
 		(*data)["kanban_enabled"] = kanbanUIEnabled
-		(*data)["nodemanager_enabled"] = nodeManager != nil
+		(*data)["jobs_enabled"] = jobsUIEnabled
+		(*data)["schedules_enabled"] = schedulesUIEnabled
+		(*data)["webhooks_enabled"] = webhooksUIEnabled
+		(*data)["slow_queries_enabled"] = slowQueryUIEnabled
+		(*data)["nodemanager_enabled"] = defaultClient.nodeManager != nil
 		user, ok := c.GetKey(kormKeyUser)
 		if ok {
 			(*data)["IsAuthenticated"] = true
@@ -57,6 +61,7 @@ func initAdminUrlPatterns(withReqCounter bool, r *ksmux.Router) {
 		})
 	}
 	r.Get("/mon/ping", func(c *ksmux.Context) { c.Status(200).Text("pong") })
+	initHealthUrls(r)
 	r.Get("/offline", OfflineView)
 	r.Get("/manifest.webmanifest", ManifestView)
 	r.Get("/sw.js", ServiceWorkerView)
@@ -70,6 +75,7 @@ func initAdminUrlPatterns(withReqCounter bool, r *ksmux.Router) {
 	adminGroup.Post("/tables/all/:model", Admin(TableGetAll))
 	adminGroup.Get("/tables/:model", Admin(AllModelsGet))
 	adminGroup.Post("/tables/:model/search", Admin(AllModelsSearch))
+	adminGroup.Get("/tables/:model/history", Admin(RowHistoryView))
 	adminGroup.Post("/delete/rows", Admin(BulkDeleteRowPost))
 	adminGroup.Post("/update/row", Admin(UpdateRowPost))
 	adminGroup.Post("/create/row", Admin(CreateModelView))
@@ -81,7 +87,10 @@ func initAdminUrlPatterns(withReqCounter bool, r *ksmux.Router) {
 	adminGroup.Get("/metrics/get", Admin(GetMetricsView))
 	adminGroup.Post("/import", Admin(ImportView))
 	adminGroup.Get("/restart", Admin(RestartView))
-	if defaultTracer.enabled {
+	adminGroup.Get("/sessions", Admin(SessionsView))
+	adminGroup.Get("/sessions/get", Admin(GetSessionsView))
+	adminGroup.Post("/sessions/revoke", Admin(SessionsRevokePost))
+	if defaultClient.tracer.enabled {
 		adminGroup.Get("/traces", Admin(TracingGetView))
 		adminGroup.Get("/traces/get", Admin(GetTraces))
 		adminGroup.Post("/traces/clear", Admin(ClearTraces))
@@ -91,6 +100,29 @@ func initAdminUrlPatterns(withReqCounter bool, r *ksmux.Router) {
 		adminGroup.Post("/terminal/execute", Admin(TerminalExecute))
 		adminGroup.Get("/terminal/complete", Admin(TerminalComplete))
 	}
+	if jobsUIEnabled {
+		adminGroup.Get("/jobs", Admin(JobsView))
+		adminGroup.Get("/jobs/get", Admin(GetJobsView))
+		adminGroup.Post("/jobs/retry", Admin(JobsRetryPost))
+		adminGroup.Post("/jobs/delete", Admin(JobsDeletePost))
+	}
+	if schedulesUIEnabled {
+		adminGroup.Get("/schedules", Admin(SchedulesView))
+		adminGroup.Get("/schedules/get", Admin(GetSchedulesView))
+	}
+	if webhooksUIEnabled {
+		adminGroup.Get("/webhooks", Admin(WebhooksView))
+		adminGroup.Get("/webhooks/get", Admin(GetWebhooksView))
+		adminGroup.Post("/webhooks/add", Admin(WebhooksAddPost))
+		adminGroup.Post("/webhooks/active", Admin(WebhooksActivePost))
+		adminGroup.Post("/webhooks/delete", Admin(WebhooksDeletePost))
+		adminGroup.Post("/webhooks/redeliver", Admin(WebhooksRedeliverPost))
+	}
+	if slowQueryUIEnabled {
+		adminGroup.Get("/slow-queries", Admin(SlowQueriesView))
+		adminGroup.Get("/slow-queries/get", Admin(GetSlowQueriesView))
+		adminGroup.Post("/slow-queries/clear", Admin(SlowQueriesClearPost))
+	}
 	if kanbanUIEnabled {
 		adminGroup.Get("/kanbans", Admin(KanbanListView))
 		adminGroup.Post("/kanbans/create", Admin(KanbanBoardCreate))
@@ -2,6 +2,7 @@ package korm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
@@ -51,8 +52,8 @@ var DashView = func(c *ksmux.Context) {
 }
 
 var RestartView = func(c *ksmux.Context) {
-	if serverBus != nil {
-		lg.CheckError(serverBus.App().Restart())
+	if defaultClient.serverBus != nil {
+		lg.CheckError(defaultClient.serverBus.App().Restart())
 	}
 }
 
@@ -219,6 +220,296 @@ var KanbanTaskMove = func(c *ksmux.Context) {
 	c.Json(map[string]any{"success": true})
 }
 
+var JobsView = func(c *ksmux.Context) {
+	c.Html("admin/admin_jobs.html", nil)
+}
+
+var GetJobsView = func(c *ksmux.Context) {
+	db, err := defaultClient.jobsDatabase("")
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	jobs, err := Model[Job]().Database(db.Name).NoCache().OrderBy("run_at").Limit(500).All()
+	if err != nil && !errors.Is(err, ErrNoData) {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	dead, err := Model[DeadJob]().Database(db.Name).NoCache().OrderBy("-failed_at").Limit(500).All()
+	if err != nil && !errors.Is(err, ErrNoData) {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{
+		"jobs": jobs,
+		"dead": dead,
+	})
+}
+
+var JobsRetryPost = func(c *ksmux.Context) {
+	var payload struct {
+		Id   uint `json:"id"`
+		Dead bool `json:"dead"`
+	}
+	if err := c.BodyStruct(&payload); err != nil {
+		c.Status(400).Error("Invalid request body")
+		return
+	}
+	if err := RetryJob(payload.Id, payload.Dead); err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{"success": true})
+}
+
+var JobsDeletePost = func(c *ksmux.Context) {
+	var payload struct {
+		Id   uint `json:"id"`
+		Dead bool `json:"dead"`
+	}
+	if err := c.BodyStruct(&payload); err != nil {
+		c.Status(400).Error("Invalid request body")
+		return
+	}
+	if err := DeleteJob(payload.Id, payload.Dead); err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{"success": true})
+}
+
+var WebhooksView = func(c *ksmux.Context) {
+	c.Html("admin/admin_webhooks.html", nil)
+}
+
+var GetWebhooksView = func(c *ksmux.Context) {
+	wh, err := Webhooks("")
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	hooks, err := wh.List()
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	deliveries, err := wh.Deliveries(0, 500)
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{
+		"webhooks":   hooks,
+		"deliveries": deliveries,
+	})
+}
+
+var WebhooksAddPost = func(c *ksmux.Context) {
+	var payload struct {
+		Url        string `json:"url"`
+		Tables     string `json:"tables"`
+		Operations string `json:"operations"`
+		Secret     string `json:"secret"`
+	}
+	if err := c.BodyStruct(&payload); err != nil {
+		c.Status(400).Error("Invalid request body")
+		return
+	}
+	wh, err := Webhooks("")
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	hook, err := wh.Add(Webhook{Url: payload.Url, Tables: payload.Tables, Operations: payload.Operations, Secret: payload.Secret})
+	if err != nil {
+		c.Status(400).Error(err.Error())
+		return
+	}
+	// the secret is only shown once, when the webhook is created
+	c.Json(map[string]any{"success": true, "webhook": hook, "secret": hook.Secret})
+}
+
+var WebhooksActivePost = func(c *ksmux.Context) {
+	var payload struct {
+		Id     uint `json:"id"`
+		Active bool `json:"active"`
+	}
+	if err := c.BodyStruct(&payload); err != nil {
+		c.Status(400).Error("Invalid request body")
+		return
+	}
+	wh, err := Webhooks("")
+	if err == nil {
+		err = wh.SetActive(payload.Id, payload.Active)
+	}
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{"success": true})
+}
+
+var WebhooksDeletePost = func(c *ksmux.Context) {
+	var payload struct {
+		Id uint `json:"id"`
+	}
+	if err := c.BodyStruct(&payload); err != nil {
+		c.Status(400).Error("Invalid request body")
+		return
+	}
+	wh, err := Webhooks("")
+	if err == nil {
+		err = wh.Remove(payload.Id)
+	}
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{"success": true})
+}
+
+var WebhooksRedeliverPost = func(c *ksmux.Context) {
+	var payload struct {
+		Id uint `json:"id"`
+	}
+	if err := c.BodyStruct(&payload); err != nil {
+		c.Status(400).Error("Invalid request body")
+		return
+	}
+	wh, err := Webhooks("")
+	if err == nil {
+		err = wh.Redeliver(payload.Id)
+	}
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{"success": true})
+}
+
+var SlowQueriesView = func(c *ksmux.Context) {
+	c.Html("admin/admin_slow_queries.html", nil)
+}
+
+var GetSlowQueriesView = func(c *ksmux.Context) {
+	limit, err := strconv.Atoi(c.QueryParam("limit"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 200
+	}
+	// sort is "duration" (default) or "recent"
+	queries, err := SlowQueries(c.QueryParam("db"), c.QueryParam("sort"), limit)
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{
+		"queries": queries,
+	})
+}
+
+var SlowQueriesClearPost = func(c *ksmux.Context) {
+	if err := ClearSlowQueries(c.QueryParam("db")); err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{"success": true})
+}
+
+var SchedulesView = func(c *ksmux.Context) {
+	c.Html("admin/admin_schedules.html", nil)
+}
+
+var GetSchedulesView = func(c *ksmux.Context) {
+	db, err := defaultClient.GetMemoryDatabase("")
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	if _, ok := defaultClient.internalTables.Get(db.Name + "._schedules"); !ok {
+		c.Json(map[string]any{
+			"schedules": []ScheduleEntry{},
+			"runs":      []ScheduleRun{},
+		})
+		return
+	}
+	schedules, err := Model[ScheduleEntry]().Database(db.Name).NoCache().OrderBy("name").All()
+	if err != nil && !errors.Is(err, ErrNoData) {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	q := Model[ScheduleRun]().Database(db.Name).NoCache()
+	if name := c.QueryParam("name"); name != "" {
+		q = q.Where("name = ?", name)
+	}
+	runs, err := q.OrderBy("-started_at").Limit(200).All()
+	if err != nil && !errors.Is(err, ErrNoData) {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{
+		"schedules": schedules,
+		"runs":      runs,
+	})
+}
+
+var SessionsView = func(c *ksmux.Context) {
+	c.Html("admin/admin_sessions.html", nil)
+}
+
+var GetSessionsView = func(c *ksmux.Context) {
+	store, err := Sessions()
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	sessions, err := store.List(c.QueryParam("user"))
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	current := uint(0)
+	if v, ok := c.GetKey("korm-session"); ok {
+		if s, ok := v.(Session); ok {
+			current = s.Id
+		}
+	}
+	c.Json(map[string]any{
+		"sessions": sessions,
+		"current":  current,
+	})
+}
+
+// SessionsRevokePost kill a session by id, or all sessions of user_uuid
+var SessionsRevokePost = func(c *ksmux.Context) {
+	var payload struct {
+		Id       uint   `json:"id"`
+		UserUuid string `json:"user_uuid"`
+	}
+	if err := c.BodyStruct(&payload); err != nil {
+		c.Status(400).Error("Invalid request body")
+		return
+	}
+	store, err := Sessions()
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	switch {
+	case payload.UserUuid != "":
+		_, err = store.RevokeUser(payload.UserUuid)
+	case payload.Id != 0:
+		err = store.RevokeId(payload.Id)
+	default:
+		c.Status(400).Error("id or user_uuid required")
+		return
+	}
+	if err != nil {
+		c.Status(500).Error(err.Error())
+		return
+	}
+	c.Json(map[string]any{"success": true})
+}
+
 // WebSocket endpoint for terminal
 var TerminalExecute = func(c *ksmux.Context) {
 	var req struct {
@@ -350,7 +641,7 @@ var OfflineView = func(c *ksmux.Context) {
 }
 
 func statsNbRecords() string {
-	allTables := GetAllTables(defaultDB)
+	allTables := GetAllTables(defaultClient.defaultDB)
 	q := []string{}
 	for _, t := range allTables {
 		q = append(q, "SELECT '"+t+"' AS table_name,COUNT(*) AS count FROM "+t)
@@ -372,7 +663,7 @@ func statsNbRecords() string {
 }
 
 func statsDbSize() string {
-	size, err := GetDatabaseSize(defaultDB)
+	size, err := GetDatabaseSize(defaultClient.defaultDB)
 	if err != nil {
 		lg.Error(err)
 		size = "0 MB"
@@ -450,52 +741,17 @@ func reverseSlice[T any](slice []T) []T {
 
 // GetDatabaseSize returns the size of the database in GB or MB
 func GetDatabaseSize(dbName string) (string, error) {
-	db := databases[0] // default db
-	for _, d := range databases {
-		if d.Name == dbName {
-			db = d
+	db := &defaultClient.databases[0] // default db
+	for i := range defaultClient.databases {
+		if defaultClient.databases[i].Name == dbName {
+			db = &defaultClient.databases[i]
 			break
 		}
 	}
 
-	var size float64
-	var err error
-
-	switch db.Dialect {
-	case "sqlite", "sqlite3":
-		// For SQLite, get the file size
-		info, err := os.Stat(dbName + ".sqlite3")
-		if err != nil {
-			return "0 MB", fmt.Errorf("error getting sqlite db size: %v", err)
-		}
-		size = float64(info.Size())
-
-	case "postgres", "postgresql":
-		// For PostgreSQL, query the pg_database_size function
-		var sizeBytes int64
-		query := `SELECT pg_database_size($1)`
-
-		err = GetConnection().QueryRow(query, db.Name).Scan(&sizeBytes)
-		if err != nil {
-			return "0 MB", fmt.Errorf("error getting postgres db size: %v", err)
-		}
-		size = float64(sizeBytes)
-
-	case "mysql", "mariadb":
-		// For MySQL/MariaDB, query information_schema
-		var sizeBytes int64
-		query := `
-			SELECT SUM(data_length + index_length) 
-			FROM information_schema.TABLES 
-			WHERE table_schema = ?`
-		err = GetConnection().QueryRow(query, db.Name).Scan(&sizeBytes)
-		if err != nil {
-			return "0 MB", fmt.Errorf("error getting mysql db size: %v", err)
-		}
-		size = float64(sizeBytes)
-
-	default:
-		return "0 MB", fmt.Errorf("unsupported database dialect: %s", db.Dialect)
+	size, err := db.size(context.Background())
+	if err != nil {
+		return "0 MB", fmt.Errorf("error getting %s db size: %v", db.Dialect, err)
 	}
 
 	// Convert bytes to GB (1 GB = 1024^3 bytes)
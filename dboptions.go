@@ -0,0 +1,228 @@
+package korm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DbOptions configure a single database connected using NewWithOptions, zero values fallback to package defaults
+type DbOptions struct {
+	MaxOpenConns     int           // default MaxOpenConns, 10 for sqlite WAL and 1 for other sqlite journal modes
+	MaxIdleConns     int           // default MaxIdleConns
+	MaxLifetime      time.Duration // default MaxLifetime
+	MaxIdleTime      time.Duration // default MaxIdleTime
+	StatementTimeout time.Duration // timeout applied to builders statements when no context deadline is set, default no timeout
+	SqlitePragmas    []string      // pragmas executed on every new sqlite connection, like "busy_timeout = 5000"
+	Retry            *RetryPolicy  // retry policy for statements failing before they run, default DefaultRetryPolicy
+
+	SqliteSingleWriter bool          // sqlite only, funnel all writes through a single writer connection and read from a separate read only pool
+	GroupCommitSize    int           // max queued writes committed in a single transaction, default 64
+	GroupCommitWait    time.Duration // wait to fill a group before committing, default 0 commit what is already queued
+	WalCheckpointEvery time.Duration // interval between 'PRAGMA wal_checkpoint(PASSIVE)', default 5 minutes, negative disable it
+}
+
+// RetryPolicy retry transient errors (SQLITE_BUSY, postgres serialization failures and deadlocks, mysql deadlocks, refused connections) using exponential backoff with jitter.
+// These errors mean the statement did not run or was rolled back, so every statement is retried, inserts included
+type RetryPolicy struct {
+	MaxAttempts    int              // total attempts including the first one, 1 disable retries
+	InitialBackoff time.Duration    // wait before the second attempt
+	MaxBackoff     time.Duration    // max wait between 2 attempts
+	Multiplier     float64          // backoff multiplier between attempts
+	Jitter         float64          // between 0 and 1, randomize each wait by +/- Jitter
+	IsRetryable    func(error) bool // default IsRetryableError, it should only return true for errors of statements that did not run
+}
+
+// DefaultRetryPolicy is used by databases that don't specify a RetryPolicy
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 20 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// IsRetryableError return true for transient errors of statements that did not run or were rolled back, safe to retry for any statement.
+// Timeouts and connections lost while a statement run are not retryable, the statement may have been executed
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	// the connection was bad or the sqlite writer held by a transaction before the statement was sent
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, ErrWriterInTransaction) {
+		return true
+	}
+	// pgx and lib/pq errors expose the SQLSTATE code, 40001 is returned by cockroach for transactions to retry
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		switch stateErr.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+	}
+	msg := err.Error()
+	for _, s := range []string{
+		"database is locked",
+		"SQLITE_BUSY",
+		"database table is locked",
+		"SQLSTATE 40001",
+		"SQLSTATE 40P01",
+		"could not serialize access",
+		"deadlock detected",
+		"restart transaction",
+		"Error 1213",
+		"Error 1205",
+		"Deadlock found",
+		"Lock wait timeout exceeded",
+		"connection refused",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUniqueViolation return true if err is a unique or primary key constraint violation
+func isUniqueViolation(err error) bool {
+	if err == nil {
+		return false
+	}
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) && stateErr.SQLState() == "23505" {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range []string{
+		"UNIQUE constraint failed",
+		"SQLSTATE 23505",
+		"duplicate key value",
+		"Error 1062",
+		"Duplicate entry",
+		"Cannot insert duplicate key",
+		"Violation of UNIQUE KEY constraint",
+		"Violation of PRIMARY KEY constraint",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (opts DbOptions) retryPolicy() RetryPolicy {
+	if opts.Retry == nil {
+		return DefaultRetryPolicy
+	}
+	return *opts.Retry
+}
+
+// backoff return the wait duration before attempt (starting at 1 for the first retry)
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(mult, float64(attempt-1))
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		d = d * (1 + p.Jitter*(rand.Float64()*2-1))
+	}
+	return time.Duration(d)
+}
+
+// do execute fn until it succeed, return a non retryable error, or MaxAttempts is reached
+func (p RetryPolicy) do(ctx context.Context, fn func() error) error {
+	isRetryable := p.IsRetryable
+	if isRetryable == nil {
+		isRetryable = IsRetryableError
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= p.MaxAttempts || !isRetryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(p.backoff(attempt)):
+		}
+	}
+}
+
+// withTimeout return ctx with the database StatementTimeout applied if ctx has no deadline
+func (db *DatabaseEntity) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if db.Options.StatementTimeout > 0 {
+		if _, ok := ctx.Deadline(); !ok {
+			return context.WithTimeout(ctx, db.Options.StatementTimeout)
+		}
+	}
+	return ctx, func() {}
+}
+
+// execContext exec query on the primary, or through the sqlite writer queue, retrying errors of statements that did not run
+func (db *DatabaseEntity) execContext(ctx context.Context, query string, args ...any) (res sql.Result, err error) {
+	aw := db.actorWriteFor(ctx, query)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	exec := db.Conn.ExecContext
+	switch {
+	case db.writer != nil:
+		exec = func(ctx context.Context, query string, args ...any) (sql.Result, error) {
+			return db.writer.execAs(ctx, aw, query, args...)
+		}
+	case aw != nil:
+		exec = func(ctx context.Context, query string, args ...any) (res sql.Result, err error) {
+			err = aw.inTx(ctx, db, func(tx *sql.Tx) error {
+				res, err = tx.ExecContext(ctx, query, args...)
+				return err
+			})
+			return res, err
+		}
+	}
+	err = db.Options.retryPolicy().do(ctx, func() error {
+		var err error
+		res, err = exec(ctx, query, args...)
+		return err
+	})
+	return res, err
+}
+
+// queryContext query a replica or the primary, retrying transient errors, cancel should be called after rows are closed
+func (db *DatabaseEntity) queryContext(ctx context.Context, primary bool, query string, args ...any) (*sql.Rows, context.CancelFunc, error) {
+	ctx, cancel := db.withTimeout(ctx)
+	var rows *sql.Rows
+	err := db.Options.retryPolicy().do(ctx, func() error {
+		var err error
+		rows, err = db.readConn(primary).QueryContext(ctx, query, args...)
+		return err
+	})
+	return rows, cancel, err
+}
+
+// queryRowScan query a single row on the primary and scan it into dest, retrying errors of statements that did not run
+func (db *DatabaseEntity) queryRowScan(ctx context.Context, dest []any, query string, args ...any) error {
+	aw := db.actorWriteFor(ctx, query)
+	ctx, cancel := db.withTimeout(ctx)
+	defer cancel()
+	return db.Options.retryPolicy().do(ctx, func() error {
+		if aw != nil {
+			return aw.inTx(ctx, db, func(tx *sql.Tx) error {
+				return tx.QueryRowContext(ctx, query, args...).Scan(dest...)
+			})
+		}
+		return db.Conn.QueryRowContext(ctx, query, args...).Scan(dest...)
+	})
+}
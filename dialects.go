@@ -0,0 +1,503 @@
+package korm
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/kamalshkeir/kmap"
+)
+
+// Dialect implement the sql differences between databases, builtin dialects are SQLITE, POSTGRES, MYSQL, MARIA, COCKROACH and MSSQL,
+// others can be added using RegisterDialect
+type Dialect interface {
+	// Name is the name used in New, like "sqlite3"
+	Name() string
+	// Placeholder return the placeholder of the nth arg, n start at 1
+	Placeholder(n int) string
+	// Quote quote a table or column name, already quoted identifiers are returned as is
+	Quote(identifier string) string
+	// ColumnType return the sql type for kind ("int","bigint","string","text","json","float","bytes","time","uuid"), size is used by "string" and "bytes"
+	ColumnType(kind string, size string) string
+	// AutoIncrementPK return the definition of an auto increment integer primary key
+	AutoIncrementPK() string
+	// NowUnix return the expression of the current unix time used as default for 'now' and 'update' tags
+	NowUnix() string
+	// Limit return the pagination clause appended after ORDER BY, offset is ignored if 0, ordered is true if the query has an ORDER BY
+	Limit(limit, offset int, ordered bool) string
+	// Returning return the clauses added to an insert to return cols, output is inserted before VALUES and returning at the end,
+	// both empty if the dialect use LastInsertId
+	Returning(cols string) (output, returning string)
+	// CreateTable return the statement creating table if not exists using columns and constraints definitions defs
+	CreateTable(table string, defs []string) string
+	// Upsert return an insert statement using '?' placeholders that update cols when conflictCols already exist
+	Upsert(table string, cols, conflictCols []string) string
+	// JSON return the json functions used by JSON_EXTRACT, JSON_SET, JSON_REMOVE, JSON_ARRAY, JSON_OBJECT and JSON_CAST
+	JSON() JSONFunctions
+	// TablesQuery return a query selecting table names of dbName
+	TablesQuery(dbName string) string
+	// ColumnsQuery return a query selecting column name and type of table
+	ColumnsQuery(table, dbName string) string
+	// IndexQuery return a query, taking table and index name as args, selecting the index name if it exist
+	IndexQuery() string
+	// UpdatedAtTrigger return statements creating a trigger setting col to the current unix time on update
+	UpdatedAtTrigger(table, col, pk string) []string
+	// TriggerDDL return statements dropping then creating the trigger used by AddTrigger, event is like "AFTER INSERT"
+	TriggerDDL(table, col, event, stmt string) []string
+	// ChangeTriggers return the statements executed by AddChangesTrigger triggers to insert changes into _triggers_queue
+	ChangeTriggers(table, pk string, cols map[string]string) (insert, update, delete string)
+}
+
+// UUIDDefaulter can be implemented by a Dialect generating uuids, it is used as default of string columns tagged 'uuid'
+type UUIDDefaulter interface {
+	UUIDDefault() string
+}
+
+// JSONFunctions build json expressions for a dialect
+type JSONFunctions interface {
+	Extract(dataJson string, opts JsonOption) string
+	Set(dataJson string, opts JsonOption) string
+	Remove(dataJson string, opts JsonOption) string
+	Array(values []string, as string) string
+	Object(values []string, as string) string
+	Cast(value string, as string) string
+}
+
+var dialects = kmap.New[string, Dialect]()
+
+func init() {
+	RegisterDialect(sqliteDialect{}, "sqlite", "")
+	RegisterDialect(postgresDialect{}, "pg")
+	RegisterDialect(mysqlDialect{})
+	RegisterDialect(mariaDialect{}, "mariadb")
+	RegisterDialect(cockroachDialect{}, "cockroachdb")
+	RegisterDialect(mssqlDialect{}, "mssql")
+}
+
+// RegisterDialect register dialect d using d.Name() and aliases, it can then be used in New
+func RegisterDialect(d Dialect, aliases ...string) {
+	dialects.Set(d.Name(), d)
+	for _, a := range aliases {
+		dialects.Set(a, d)
+	}
+}
+
+// GetDialect return the dialect registered as name
+func GetDialect(name string) (Dialect, bool) {
+	return dialects.Get(name)
+}
+
+// dialectOf return the dialect registered as name, sqlite if not found
+func dialectOf(name string) Dialect {
+	if d, ok := dialects.Get(name); ok {
+		return d
+	}
+	return sqliteDialect{}
+}
+
+func quoteWith(identifier, open, close string) string {
+	if identifier == "" || strings.HasPrefix(identifier, open) || strings.Contains(identifier, "(") || identifier == "*" {
+		return identifier
+	}
+	return open + identifier + close
+}
+
+// limitOffset is the LIMIT clause used by sqlite, postgres and mysql
+func limitOffset(limit, offset int) string {
+	st := " LIMIT " + strconv.Itoa(limit)
+	if offset > 0 {
+		st += " OFFSET " + strconv.Itoa(offset)
+	}
+	return st
+}
+
+// createTableIfNotExists is the CREATE TABLE statement used by sqlite, postgres and mysql
+func createTableIfNotExists(d Dialect, table string, defs []string) string {
+	return "CREATE TABLE IF NOT EXISTS " + d.Quote(table) + " (" + strings.Join(defs, ",") + ");"
+}
+
+func upsertColumns(d Dialect, table string, cols []string) string {
+	quoted := make([]string, len(cols))
+	for i, c := range cols {
+		quoted[i] = d.Quote(c)
+	}
+	return "INSERT INTO " + d.Quote(table) + " (" + strings.Join(quoted, ",") + ") VALUES (" + strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",") + ")"
+}
+
+// SQLITE
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return SQLITE }
+
+func (sqliteDialect) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+
+func (sqliteDialect) Quote(identifier string) string { return quoteWith(identifier, "`", "`") }
+
+func (sqliteDialect) ColumnType(kind string, size string) string {
+	switch kind {
+	case "json", "text":
+		return "TEXT"
+	case "bytes":
+		return "BLOB"
+	}
+	return commonColumnType(kind, size)
+}
+
+func (sqliteDialect) Limit(limit, offset int, ordered bool) string { return limitOffset(limit, offset) }
+
+func (sqliteDialect) Returning(cols string) (string, string) { return "", "" }
+
+func (sqliteDialect) AutoIncrementPK() string { return "INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT" }
+
+func (sqliteDialect) NowUnix() string { return "(strftime('%s', 'now'))" }
+
+func (d sqliteDialect) CreateTable(table string, defs []string) string {
+	return createTableIfNotExists(d, table, defs)
+}
+
+func (d sqliteDialect) Upsert(table string, cols, conflictCols []string) string {
+	return upsertOnConflict(d, table, cols, conflictCols)
+}
+
+func (sqliteDialect) JSON() JSONFunctions { return sqlJSON{} }
+
+func (sqliteDialect) TablesQuery(dbName string) string {
+	return `select name FROM sqlite_master WHERE type ='table' AND name NOT LIKE 'sqlite_%';`
+}
+
+func (sqliteDialect) ColumnsQuery(table, dbName string) string {
+	return "select name,type FROM pragma_table_info('" + table + "')"
+}
+
+func (sqliteDialect) IndexQuery() string {
+	return `SELECT name FROM sqlite_master WHERE type='index' AND tbl_name=? AND name=?`
+}
+
+func (sqliteDialect) UpdatedAtTrigger(table, col, pk string) []string {
+	st := "CREATE TRIGGER IF NOT EXISTS "
+	st += table + "_update_trig AFTER UPDATE ON " + table
+	st += " BEGIN update " + table + " SET " + col + " = strftime('%s', 'now')"
+	st += " WHERE " + pk + " = " + "NEW." + pk + ";"
+	st += "End;"
+	return []string{st}
+}
+
+func (sqliteDialect) ChangeTriggers(table, pk string, cols map[string]string) (string, string, string) {
+	insertStmt := `INSERT INTO _triggers_queue(data) VALUES (json_object('operation','insert','table','` + table + `','actor',(SELECT actor FROM _triggers_actor),'data',json_object(` + buildJsonFields("NEW", cols) + `)))`
+	updateStmt := `INSERT INTO _triggers_queue(data) VALUES (json_object('operation','update','table','` + table + `','actor',(SELECT actor FROM _triggers_actor),'old',json_object(` + buildJsonFields("OLD", cols) + `),'new',json_object(` + buildJsonFields("NEW", cols) + `)))`
+	deleteStmt := `INSERT INTO _triggers_queue(data) VALUES (json_object('operation','delete','table','` + table + `','actor',(SELECT actor FROM _triggers_actor),'data',json_object(` + buildJsonFields("OLD", cols) + `)))`
+	return insertStmt, updateStmt, deleteStmt
+}
+
+func (sqliteDialect) TriggerDDL(table, col, event, stmt string) []string {
+	stat := []string{}
+	// Drop existing trigger first
+	dropSt := "DROP TRIGGER IF EXISTS " + table + "_trig"
+	if strings.Contains(event, "INSERT") {
+		dropSt += "_insert"
+	} else if strings.Contains(event, "UPDATE") {
+		dropSt += "_update"
+	} else if strings.Contains(event, "DELETE") {
+		dropSt += "_delete"
+	}
+	if col != "" {
+		dropSt += "_" + col
+	}
+	stat = append(stat, dropSt)
+
+	// Create new trigger with unique name
+	st := "CREATE TRIGGER IF NOT EXISTS " + table + "_trig"
+	if strings.Contains(event, "INSERT") {
+		st += "_insert"
+	} else if strings.Contains(event, "UPDATE") {
+		st += "_update"
+	} else if strings.Contains(event, "DELETE") {
+		st += "_delete"
+	}
+	if col != "" {
+		st += "_" + col
+	}
+	st += " " + event
+	if col != "" {
+		st += " OF " + col
+	}
+	st += " ON " + table + " FOR EACH ROW"
+	st += " BEGIN " + stmt + "; END;"
+	stat = append(stat, st)
+	return stat
+}
+
+// POSTGRES
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return POSTGRES }
+
+func (postgresDialect) Placeholder(n int) string { return "$" + strconv.Itoa(n) }
+
+func (postgresDialect) Quote(identifier string) string { return quoteWith(identifier, `"`, `"`) }
+
+func (postgresDialect) ColumnType(kind string, size string) string {
+	switch kind {
+	case "json":
+		return "JSONB"
+	case "uuid":
+		return "UUID"
+	case "bytes":
+		if size != "" {
+			return "BIT VARYING(" + size + ")"
+		}
+		return "BYTEA"
+	}
+	return commonColumnType(kind, size)
+}
+
+func (postgresDialect) Limit(limit, offset int, ordered bool) string {
+	return limitOffset(limit, offset)
+}
+
+func (postgresDialect) Returning(cols string) (string, string) { return "", " RETURNING " + cols }
+
+func (postgresDialect) AutoIncrementPK() string { return "SERIAL NOT NULL PRIMARY KEY" }
+
+func (postgresDialect) NowUnix() string { return "extract(epoch from now())" }
+
+func (postgresDialect) UUIDDefault() string { return "gen_random_uuid()" }
+
+func (d postgresDialect) CreateTable(table string, defs []string) string {
+	return createTableIfNotExists(d, table, defs)
+}
+
+func (d postgresDialect) Upsert(table string, cols, conflictCols []string) string {
+	return upsertOnConflict(d, table, cols, conflictCols)
+}
+
+func (postgresDialect) JSON() JSONFunctions { return pgJSON{} }
+
+func (postgresDialect) TablesQuery(dbName string) string {
+	return `select tablename FROM pg_catalog.pg_tables WHERE schemaname NOT IN ('pg_catalog','information_schema','crdb_internal','pg_extension') AND tableowner != 'node'`
+}
+
+func (postgresDialect) ColumnsQuery(table, dbName string) string {
+	return "select column_name,data_type FROM information_schema.columns WHERE table_name = '" + table + "'"
+}
+
+func (postgresDialect) IndexQuery() string {
+	return `SELECT indexname FROM pg_indexes WHERE tablename = $1 AND indexname = $2`
+}
+
+func (postgresDialect) UpdatedAtTrigger(table, col, pk string) []string {
+	st := "CREATE OR REPLACE FUNCTION updated_at_trig() RETURNS trigger AS $$"
+	st += " BEGIN NEW." + col + " = extract(epoch from now());RETURN NEW;"
+	st += "END;$$ LANGUAGE plpgsql;"
+	trigCreate := "CREATE OR REPLACE TRIGGER " + table + "_update_trig"
+	trigCreate += " BEFORE UPDATE ON public." + table
+	trigCreate += " FOR EACH ROW EXECUTE PROCEDURE updated_at_trig();"
+	return []string{st, trigCreate}
+}
+
+func (postgresDialect) ChangeTriggers(table, pk string, cols map[string]string) (string, string, string) {
+	insertStmt := `INSERT INTO "_triggers_queue"(data) VALUES (jsonb_build_object('operation', 'insert', 'table', '` + table + `', 'actor', current_setting('korm.actor', true), 'data', to_jsonb(NEW)));`
+	updateStmt := `INSERT INTO "_triggers_queue"(data) VALUES (jsonb_build_object('operation', 'update', 'table', '` + table + `', 'actor', current_setting('korm.actor', true), 'old', to_jsonb(OLD), 'new', to_jsonb(NEW)));`
+	deleteStmt := `INSERT INTO "_triggers_queue"(data) VALUES (jsonb_build_object('operation', 'delete', 'table', '` + table + `', 'actor', current_setting('korm.actor', true), 'data', to_jsonb(OLD)));`
+	return insertStmt, updateStmt, deleteStmt
+}
+
+func (postgresDialect) TriggerDDL(table, col, event, stmt string) []string {
+	stat := []string{}
+	// Drop existing trigger first
+	if col != "" {
+		stat = append(stat, `DROP TRIGGER IF EXISTS "`+table+`_trig_`+col+`" ON "`+table+`";`)
+		stat = append(stat, `DROP FUNCTION IF EXISTS "`+table+`_trig_`+col+`_func"();`)
+	}
+	stat = append(stat, `DROP TRIGGER IF EXISTS "`+table+`_trig_`+strings.ToLower(strings.Split(event, " ")[1])+`" ON "`+table+`";`)
+	stat = append(stat, `DROP FUNCTION IF EXISTS "`+table+`_trig_`+strings.ToLower(strings.Split(event, " ")[1])+`_func"();`)
+
+	// Create function for trigger
+	name := table + "_trig"
+	if col != "" {
+		name += "_" + col
+	} else {
+		name += "_" + strings.ToLower(strings.Split(event, " ")[1])
+	}
+	st := `CREATE OR REPLACE FUNCTION "` + name + `_func"() RETURNS trigger AS $$ 
+BEGIN 
+    RAISE NOTICE 'Trigger executing for %s', TG_TABLE_NAME;
+    ` + stmt + ` 
+    RAISE NOTICE 'Trigger completed for %s', TG_TABLE_NAME;
+    IF (TG_OP = 'DELETE') THEN
+        RETURN OLD;
+    ELSE
+        RETURN NEW;
+    END IF;
+END; 
+$$ LANGUAGE plpgsql;`
+	stat = append(stat, st)
+
+	// Create trigger
+	trigCreate := `CREATE TRIGGER "` + name + `" ` + event + ` ON "` + table + `" FOR EACH ROW EXECUTE FUNCTION "` + name + `_func"();`
+	stat = append(stat, trigCreate)
+	return stat
+}
+
+// MYSQL
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return MYSQL }
+
+func (mysqlDialect) Placeholder(n int) string { return "?" }
+
+func (mysqlDialect) Quote(identifier string) string { return quoteWith(identifier, "`", "`") }
+
+func (mysqlDialect) ColumnType(kind string, size string) string {
+	switch kind {
+	case "json":
+		return "JSON"
+	case "bytes":
+		if size != "" {
+			return "VARBINARY(" + size + ")"
+		}
+		return "BLOB"
+	}
+	return commonColumnType(kind, size)
+}
+
+func (mysqlDialect) Limit(limit, offset int, ordered bool) string { return limitOffset(limit, offset) }
+
+func (mysqlDialect) Returning(cols string) (string, string) { return "", "" }
+
+func (mysqlDialect) AutoIncrementPK() string { return "INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT" }
+
+// expression defaults need mysql 8.0.13 or mariadb 10.2
+func (mysqlDialect) NowUnix() string { return "(UNIX_TIMESTAMP())" }
+
+func (d mysqlDialect) CreateTable(table string, defs []string) string {
+	return createTableIfNotExists(d, table, defs)
+}
+
+func (d mysqlDialect) Upsert(table string, cols, conflictCols []string) string {
+	sets := make([]string, 0, len(cols))
+	for _, c := range cols {
+		if SliceContains(conflictCols, c) {
+			continue
+		}
+		sets = append(sets, d.Quote(c)+" = VALUES("+d.Quote(c)+")")
+	}
+	if len(sets) == 0 {
+		return strings.Replace(upsertColumns(d, table, cols), "INSERT INTO", "INSERT IGNORE INTO", 1)
+	}
+	return upsertColumns(d, table, cols) + " ON DUPLICATE KEY UPDATE " + strings.Join(sets, ",")
+}
+
+func (mysqlDialect) JSON() JSONFunctions { return sqlJSON{mysql: true} }
+
+func (mysqlDialect) TablesQuery(dbName string) string {
+	return "SELECT table_name FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_TYPE = 'BASE TABLE' AND table_schema ='" + dbName + "'"
+}
+
+func (mysqlDialect) ColumnsQuery(table, dbName string) string {
+	return "select column_name,data_type FROM information_schema.columns WHERE table_name = '" + table + "' AND TABLE_SCHEMA = '" + dbName + "'"
+}
+
+func (mysqlDialect) IndexQuery() string {
+	return `SELECT INDEX_NAME FROM information_schema.statistics WHERE table_name = ? AND index_name = ?`
+}
+
+func (mysqlDialect) UpdatedAtTrigger(table, col, pk string) []string {
+	name := "before_update_" + table + "_" + col
+	return []string{
+		"DROP TRIGGER IF EXISTS `" + name + "`;",
+		"CREATE TRIGGER `" + name + "` BEFORE UPDATE ON `" + table + "` FOR EACH ROW SET NEW.`" + col + "` = UNIX_TIMESTAMP();",
+	}
+}
+
+func (mysqlDialect) ChangeTriggers(table, pk string, cols map[string]string) (string, string, string) {
+	insertStmt := `INSERT INTO ` + "`_triggers_queue`" + `(data) VALUES (JSON_OBJECT('operation', 'insert', 'table', '` + table + `', 'actor', @korm_actor, 'data', JSON_OBJECT(` + buildJsonFields("NEW", cols) + `)))`
+	updateStmt := `INSERT INTO ` + "`_triggers_queue`" + `(data) VALUES (JSON_OBJECT('operation', 'update', 'table', '` + table + `', 'actor', @korm_actor, 'old', JSON_OBJECT(` + buildJsonFields("OLD", cols) + `), 'new', JSON_OBJECT(` + buildJsonFields("NEW", cols) + `)))`
+	deleteStmt := `INSERT INTO ` + "`_triggers_queue`" + `(data) VALUES (JSON_OBJECT('operation', 'delete', 'table', '` + table + `', 'actor', @korm_actor, 'data', JSON_OBJECT(` + buildJsonFields("OLD", cols) + `)))`
+	return insertStmt, updateStmt, deleteStmt
+}
+
+func (mysqlDialect) TriggerDDL(table, col, event, stmt string) []string {
+	stat := []string{}
+	// Drop existing triggers first
+	dropTriggerName := table + "_trig"
+	if strings.Contains(event, "INSERT") {
+		dropTriggerName += "_insert"
+	} else if strings.Contains(event, "UPDATE") {
+		dropTriggerName += "_update"
+	} else if strings.Contains(event, "DELETE") {
+		dropTriggerName += "_delete"
+	}
+	if col != "" {
+		dropTriggerName += "_" + col
+	}
+	stat = append(stat, "DROP TRIGGER IF EXISTS `"+dropTriggerName+"`;")
+
+	// Create trigger with operation-specific name
+	st := "CREATE TRIGGER `" + dropTriggerName + "` " + event + " ON `" + table + "` FOR EACH ROW BEGIN " + stmt
+	if !strings.HasSuffix(stmt, ";") {
+		st += ";"
+	}
+	st += " END;"
+	stat = append(stat, st)
+	return stat
+}
+
+// MARIA
+
+type mariaDialect struct {
+	mysqlDialect
+}
+
+func (mariaDialect) Name() string { return MARIA }
+
+func commonColumnType(kind string, size string) string {
+	switch kind {
+	case "int":
+		return "INTEGER"
+	case "bigint", "time":
+		return "BIGINT"
+	case "float":
+		return "DECIMAL(10,5)"
+	case "text":
+		return "TEXT"
+	case "string":
+		if size == "" {
+			size = "255"
+		}
+		return "VARCHAR(" + size + ")"
+	case "uuid":
+		return "VARCHAR(36)"
+	}
+	return "TEXT"
+}
+
+func upsertOnConflict(d Dialect, table string, cols, conflictCols []string) string {
+	quotedConflict := make([]string, len(conflictCols))
+	for i, c := range conflictCols {
+		quotedConflict[i] = d.Quote(c)
+	}
+	sets := make([]string, 0, len(cols))
+	for _, c := range cols {
+		if SliceContains(conflictCols, c) {
+			continue
+		}
+		sets = append(sets, d.Quote(c)+" = EXCLUDED."+d.Quote(c))
+	}
+	st := upsertColumns(d, table, cols) + " ON CONFLICT (" + strings.Join(quotedConflict, ",") + ")"
+	if len(sets) == 0 {
+		return st + " DO NOTHING"
+	}
+	return st + " DO UPDATE SET " + strings.Join(sets, ",")
+}
+
+// limitClause return the pagination clause of db dialect for limit and page (starting at 1, 0 for no offset)
+func (db *DatabaseEntity) limitClause(limit, page int, ordered bool) string {
+	offset := 0
+	if page > 0 {
+		offset = (page - 1) * limit
+	}
+	return dialectOf(db.Dialect).Limit(limit, offset, ordered)
+}
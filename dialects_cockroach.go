@@ -0,0 +1,31 @@
+package korm
+
+// cockroachDialect implement Dialect for CockroachDB, it speak the postgres protocol but keys use unique_rowid,
+// updated_at columns use ON UPDATE expressions, and changes are streamed using a changefeed instead of plpgsql triggers
+type cockroachDialect struct {
+	postgresDialect
+}
+
+func (cockroachDialect) Name() string { return COCKROACH }
+
+func (cockroachDialect) AutoIncrementPK() string {
+	return "INT8 NOT NULL PRIMARY KEY DEFAULT unique_rowid()"
+}
+
+func (cockroachDialect) NowUnix() string { return "(extract(epoch from now())::INT8)" }
+
+func (cockroachDialect) TablesQuery(dbName string) string {
+	return "SELECT table_name FROM information_schema.tables WHERE table_schema = 'public' AND table_type = 'BASE TABLE'"
+}
+
+func (d cockroachDialect) UpdatedAtTrigger(table, col, pk string) []string {
+	return []string{"ALTER TABLE " + d.Quote(table) + " ALTER COLUMN " + d.Quote(col) + " SET ON UPDATE " + d.NowUnix()}
+}
+
+// TriggerDDL return nothing, AddTrigger is not supported
+func (cockroachDialect) TriggerDDL(table, col, event, stmt string) []string { return nil }
+
+// ChangeTriggers return empty statements, changes are read by cockroachChangesWorker using a changefeed
+func (cockroachDialect) ChangeTriggers(table, pk string, cols map[string]string) (string, string, string) {
+	return "", "", ""
+}
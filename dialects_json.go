@@ -0,0 +1,427 @@
+package korm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/kamalshkeir/lg"
+)
+
+// sqlJSON implement JSONFunctions for sqlite and mysql
+type sqlJSON struct {
+	mysql bool
+}
+
+// pgJSON implement JSONFunctions for postgres and cockroach
+type pgJSON struct{}
+
+// jsonDataKind return "'" for quoted json, "{" for raw json, "" for a column
+func jsonDataKind(dataJson string) string {
+	if strings.HasPrefix(dataJson, "'") {
+		return "'"
+	} else if strings.HasPrefix(dataJson, "{") {
+		return "{"
+	}
+	return ""
+}
+
+func jsonAs(st, as string) string {
+	if as != "" {
+		st += " AS \"" + as + "\""
+	}
+	return st
+}
+
+func (sqlJSON) Array(values []string, as string) string {
+	return jsonAs("JSON_ARRAY("+strings.Join(values, ", ")+")", as)
+}
+
+func (pgJSON) Array(values []string, as string) string {
+	return jsonAs("JSONB_BUILD_ARRAY("+strings.Join(values, ", ")+")", as)
+}
+
+func (sqlJSON) Object(values []string, as string) string {
+	return jsonAs("JSON_OBJECT("+strings.Join(values, ", ")+")", as)
+}
+
+func (pgJSON) Object(values []string, as string) string {
+	return jsonAs("JSONB_BUILD_OBJECT("+strings.Join(values, ", ")+")", as)
+}
+
+func (j sqlJSON) Cast(value string, as string) string {
+	if j.mysql {
+		return jsonAs("JSON_EXTRACT("+value+", '$')", as)
+	}
+	return jsonAs("JSON("+value+")", as)
+}
+
+func (pgJSON) Cast(value string, as string) string {
+	return jsonAs(value+"::jsonb", as)
+}
+
+func (sqlJSON) Extract(dataJson string, opts JsonOption) string {
+	isData := jsonDataKind(dataJson)
+	st := "JSON_EXTRACT("
+	if isData == "{" {
+		st += "'" + dataJson + "'"
+	} else {
+		st += dataJson
+	}
+	if len(opts.Params) == 0 {
+		st += "),'$'"
+		return st
+	}
+	st += ","
+
+	for i, pp := range opts.Params {
+		jsonParam := ""
+		if v, ok := pp.(string); ok {
+			jsonParam = v
+		} else {
+			jsonParam = fmt.Sprint(pp)
+		}
+		if i > 0 {
+			st += ","
+		}
+		if strings.HasPrefix(jsonParam, "'") || strings.HasPrefix(jsonParam, "`") {
+			jsonParam = jsonParam[1 : len(jsonParam)-2]
+		}
+		st += "'$"
+		if strings.Contains(jsonParam, ".") {
+			sp := strings.Split(jsonParam, ".")
+			for _, s := range sp {
+				// check if s is a number
+				if _, err := strconv.Atoi(s); err == nil {
+					st += "[" + s + "]"
+				} else {
+					st += "." + s
+				}
+			}
+			st += "'"
+		} else {
+			st += "." + jsonParam + "'"
+		}
+		if i == len(opts.Params)-1 {
+			st += ")"
+		}
+	}
+	if opts.As != "" {
+		st += " AS \"" + opts.As + "\""
+	}
+	return st
+}
+
+func (pgJSON) Extract(dataJson string, opts JsonOption) string {
+	isData := jsonDataKind(dataJson)
+	if len(opts.Params) == 0 {
+		return dataJson
+	}
+	if isData == "'" || isData == "{" {
+		if isData == "{" {
+			dataJson = "'" + dataJson + "'::jsonb"
+		} else {
+			dataJson = dataJson + "::jsonb"
+		}
+	}
+
+	if len(opts.Params) == 1 {
+		ss := ""
+		if param, ok := opts.Params[0].(string); ok {
+			for _, s := range strings.Split(param, ".") {
+				ss += ", '" + s + "'"
+			}
+			return "JSONB_EXTRACT_PATH_TEXT(" + dataJson + ss + ")"
+		} else {
+			lg.ErrorC("param must be string", "param", param)
+			return dataJson
+		}
+	}
+	paramsString := ""
+	// opts.ParamsToExtract "a.2.name", "b.email", "b"
+	for i, extractParam := range opts.Params {
+		ep := ""
+		var ok bool
+		if ep, ok = extractParam.(string); !ok {
+			lg.ErrorC("param must be string", "param", extractParam)
+			return dataJson
+		}
+		if i > 0 {
+			paramsString += ", "
+		}
+		paramsString += "JSONB_EXTRACT_PATH_TEXT(" + dataJson
+		for _, s := range strings.Split(ep, ".") {
+			paramsString += ", '" + s + "'"
+		}
+		paramsString += ")"
+	}
+	if len(opts.Params) > 1 {
+		paramsString = "JSONB_BUILD_ARRAY(" + paramsString + ")"
+	}
+	if opts.As != "" {
+		paramsString += " AS \"" + opts.As + "\""
+	}
+	return paramsString
+}
+
+func (sqlJSON) Remove(dataJson string, opts JsonOption) string {
+	isData := jsonDataKind(dataJson)
+	st := "JSON_REMOVE("
+	if isData == "{" {
+		st += "'" + dataJson + "'"
+	} else {
+		st += dataJson
+	}
+	if len(opts.Params) == 0 {
+		st += "),'$'"
+		return st
+	}
+	st += ","
+
+	for i, pp := range opts.Params {
+		if i > 0 {
+			st += ","
+		}
+		var jsonParam string
+		var ok bool
+		if jsonParam, ok = pp.(string); !ok {
+			lg.ErrorC("expected string", "param", pp)
+			return st
+		}
+
+		if i%2 == 0 {
+			st += "'$"
+			if strings.Contains(jsonParam, ".") {
+				sp := strings.Split(jsonParam, ".")
+				for _, s := range sp {
+					// check if s is a number
+					if _, err := strconv.Atoi(s); err == nil {
+						st += "[" + s + "]"
+					} else {
+						st += "." + s
+					}
+				}
+				st += "'"
+			} else {
+				jsonParam = strings.ReplaceAll(jsonParam, "'", "")
+				st += "." + jsonParam + "'"
+			}
+			if i == len(opts.Params)-1 {
+				st += ")"
+			}
+		} else {
+			st += "'$"
+			if _, err := strconv.Atoi(jsonParam); err == nil {
+				st += "[" + jsonParam + "]"
+			} else {
+				st += "." + jsonParam + "'"
+			}
+			if i == len(opts.Params)-1 {
+				st += ")"
+			}
+		}
+
+	}
+	if opts.As != "" {
+		st += " AS \"" + opts.As + "\""
+	}
+	return st
+}
+
+func (pgJSON) Remove(dataJson string, opts JsonOption) string {
+	isData := jsonDataKind(dataJson)
+	// your_column_name #- '{a, 2, name}' #- '{b, email}'
+	if isData == "'" || isData == "{" {
+		if isData == "{" {
+			dataJson = "'" + dataJson + "'::jsonb"
+		} else {
+			dataJson = dataJson + "::jsonb"
+		}
+	}
+	st := dataJson
+	if len(opts.Params) == 0 {
+		return st
+	}
+	for i, pp := range opts.Params {
+		var jsonParam string
+		var ok bool
+		if jsonParam, ok = pp.(string); !ok && i%2 == 0 {
+			lg.ErrorC("expected string", "param", jsonParam)
+			return st
+		}
+		if i%2 == 0 && strings.Contains(jsonParam, ".") {
+			sp := strings.Split(jsonParam, ".")
+			tt := ""
+			for i, s := range sp {
+				if i > 0 {
+					tt += ","
+				}
+				tt += s
+			}
+			st += " #- '{" + tt + "}'"
+		} else {
+			st += " - " + jsonParam
+		}
+	}
+	if opts.As != "" {
+		st += " AS \"" + opts.As + "\""
+	}
+	return st
+}
+
+func (sqlJSON) Set(dataJson string, opts JsonOption) string {
+	isData := jsonDataKind(dataJson)
+	st := "JSON_SET("
+	if isData == "{" {
+		st += "'" + dataJson + "'"
+	} else {
+		st += dataJson
+	}
+	if len(opts.Params) == 0 {
+		st += "),'$'"
+		return st
+	}
+	st += ","
+
+	for i, pp := range opts.Params {
+		if i > 0 {
+			st += ","
+		}
+		var jsonParam string
+		var ok bool
+		if i%2 == 0 {
+			if jsonParam, ok = pp.(string); !ok {
+				lg.ErrorC("expected string", "param", pp)
+				return st
+			}
+			jsonParam = strings.ReplaceAll(jsonParam, "'", "")
+			st += "'$"
+			if strings.Contains(jsonParam, ".") {
+				sp := strings.Split(jsonParam, ".")
+				for _, s := range sp {
+					// check if s is a number
+					if _, err := strconv.Atoi(s); err == nil {
+						st += "[" + s + "]"
+					} else {
+						st += "." + s
+					}
+				}
+				st += "'"
+			} else {
+				st += "." + jsonParam + "'"
+			}
+		} else {
+			if jp, ok := pp.(string); ok {
+				if !strings.Contains(jp, "'") {
+					jp = "'" + jp + "'"
+				}
+				st += jp
+			} else {
+				st += fmt.Sprint(pp)
+			}
+		}
+
+		if i == len(opts.Params)-1 {
+			st += ")"
+		}
+	}
+	if opts.As != "" {
+		st += " AS \"" + opts.As + "\""
+	}
+	return st
+}
+
+func (pgJSON) Set(dataJson string, opts JsonOption) string {
+	isData := jsonDataKind(dataJson)
+	if isData == "'" || isData == "{" {
+		if isData == "{" {
+			dataJson = "'" + dataJson + "'::jsonb"
+		} else {
+			dataJson = dataJson + "::jsonb"
+		}
+	}
+	newws := make([]string, 0, len(opts.Params)/2)
+	st := "JSONB_SET(" + dataJson
+	if len(opts.Params) == 0 {
+		return st + ")"
+	} else if len(opts.Params) == 2 {
+		for i, pp := range opts.Params {
+			var jsonParam string
+			var ok bool
+			if i == 0 {
+				if jsonParam, ok = pp.(string); !ok {
+					lg.ErrorC("expected string", "param", pp)
+					return dataJson
+				}
+			}
+			st += ", "
+			if i%2 == 0 && strings.Contains(jsonParam, ".") {
+				tt := strings.ReplaceAll(jsonParam, ".", ",")
+				tt = strings.ReplaceAll(tt, "'", "")
+				st += " '{" + tt + "}'"
+			} else {
+				if v, ok := pp.(string); ok {
+					if !strings.Contains(v, "'") {
+						st += " '" + v + "'"
+					} else {
+						st += v
+					}
+				} else {
+					st += fmt.Sprint(pp)
+				}
+			}
+		}
+		st += ", 'true'"
+	} else {
+		new := "JSONB_SET(" + dataJson
+		for i, pp := range opts.Params {
+			var jsonParam string
+			var ok bool
+			if i%2 == 0 {
+				new = "JSONB_SET(%s"
+				if jsonParam, ok = pp.(string); !ok {
+					lg.ErrorC("expected string", "param", pp)
+					return dataJson
+				} else {
+					pp = strings.ReplaceAll(jsonParam, "'", "")
+				}
+			}
+			new += ", "
+			if i%2 == 0 {
+				tt := strings.ReplaceAll(jsonParam, ".", ",")
+				new += " '{" + tt + "}'"
+			} else {
+				if v, ok := pp.(string); ok && i%2 == 1 {
+					if !strings.Contains(v, "'") {
+						new += " '" + v + "', 'true'"
+					} else {
+						new += " " + v + ", 'true'"
+					}
+				} else {
+					new += fmt.Sprint(pp) + ", 'true'"
+				}
+				new += ")"
+				newws = append(newws, new)
+			}
+		}
+		res := newws[0]
+		for i, v := range newws {
+			if i == 0 {
+				continue
+			}
+			res = fmt.Sprintf(res, v)
+			if i == len(newws)-1 {
+				res = fmt.Sprintf(res, dataJson)
+			}
+		}
+		if opts.As != "" {
+			res += " AS \"" + opts.As + "\""
+		}
+		return res
+	}
+	st += ")"
+	if opts.As != "" {
+		st += " AS \"" + opts.As + "\""
+	}
+	return st
+}
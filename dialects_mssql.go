@@ -0,0 +1,231 @@
+package korm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// mssqlDialect implement Dialect for Microsoft SQL Server (2017+, JSON_ARRAY and JSON_OBJECT need 2022)
+//
+// Insert and InsertR use SCOPE_IDENTITY to get the inserted id, InsertR then select the row by pk, OUTPUT INSERTED.* is not used
+// because SQL Server refuse it without INTO on tables having triggers, like tables using AddChangesTrigger
+type mssqlDialect struct{}
+
+func (mssqlDialect) Name() string { return MSSQL }
+
+func (mssqlDialect) Placeholder(n int) string { return "@p" + strconv.Itoa(n) }
+
+func (mssqlDialect) Quote(identifier string) string { return quoteWith(identifier, "[", "]") }
+
+func (mssqlDialect) ColumnType(kind string, size string) string {
+	switch kind {
+	case "json", "text":
+		return "NVARCHAR(MAX)"
+	case "string":
+		if size == "" {
+			size = "255"
+		}
+		return "NVARCHAR(" + size + ")"
+	case "bytes":
+		if size == "" {
+			size = "MAX"
+		}
+		return "VARBINARY(" + size + ")"
+	case "uuid":
+		return "UNIQUEIDENTIFIER"
+	}
+	return commonColumnType(kind, size)
+}
+
+func (mssqlDialect) Limit(limit, offset int, ordered bool) string {
+	st := ""
+	if !ordered {
+		st = " ORDER BY (SELECT NULL)"
+	}
+	return st + " OFFSET " + strconv.Itoa(offset) + " ROWS FETCH NEXT " + strconv.Itoa(limit) + " ROWS ONLY"
+}
+
+func (mssqlDialect) Returning(cols string) (string, string) {
+	if cols == "*" {
+		return "", ""
+	}
+	return "", ";SELECT CAST(SCOPE_IDENTITY() AS BIGINT)"
+}
+
+func (mssqlDialect) UUIDDefault() string { return "NEWID()" }
+
+func (mssqlDialect) AutoIncrementPK() string { return "INT IDENTITY(1,1) NOT NULL PRIMARY KEY" }
+
+func (mssqlDialect) NowUnix() string { return "(DATEDIFF_BIG(SECOND, '1970-01-01', SYSUTCDATETIME()))" }
+
+func (d mssqlDialect) CreateTable(table string, defs []string) string {
+	return "IF OBJECT_ID(N'" + table + "', N'U') IS NULL CREATE TABLE " + d.Quote(table) + " (" + strings.Join(defs, ",") + ");"
+}
+
+func (d mssqlDialect) Upsert(table string, cols, conflictCols []string) string {
+	quoted := make([]string, len(cols))
+	sources := make([]string, len(cols))
+	for i, c := range cols {
+		quoted[i] = d.Quote(c)
+		sources[i] = "source." + d.Quote(c)
+	}
+	on := make([]string, len(conflictCols))
+	for i, c := range conflictCols {
+		on[i] = "target." + d.Quote(c) + " = source." + d.Quote(c)
+	}
+	sets := []string{}
+	for _, c := range cols {
+		if !SliceContains(conflictCols, c) {
+			sets = append(sets, "target."+d.Quote(c)+" = source."+d.Quote(c))
+		}
+	}
+	st := "MERGE INTO " + d.Quote(table) + " WITH (HOLDLOCK) AS target USING (VALUES (" + strings.TrimSuffix(strings.Repeat("?,", len(cols)), ",") + ")) AS source (" + strings.Join(quoted, ",") + ")"
+	st += " ON " + strings.Join(on, " AND ")
+	if len(sets) > 0 {
+		st += " WHEN MATCHED THEN UPDATE SET " + strings.Join(sets, ",")
+	}
+	st += " WHEN NOT MATCHED THEN INSERT (" + strings.Join(quoted, ",") + ") VALUES (" + strings.Join(sources, ",") + ");"
+	return st
+}
+
+func (mssqlDialect) JSON() JSONFunctions { return mssqlJSON{} }
+
+func (mssqlDialect) TablesQuery(dbName string) string {
+	return "SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_TYPE = 'BASE TABLE' AND TABLE_CATALOG = '" + dbName + "'"
+}
+
+func (mssqlDialect) ColumnsQuery(table, dbName string) string {
+	return "SELECT COLUMN_NAME,DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_NAME = '" + table + "' ORDER BY ORDINAL_POSITION"
+}
+
+func (mssqlDialect) IndexQuery() string {
+	return `SELECT name FROM sys.indexes WHERE object_id = OBJECT_ID(@p1) AND name = @p2`
+}
+
+func (d mssqlDialect) UpdatedAtTrigger(table, col, pk string) []string {
+	st := "CREATE OR ALTER TRIGGER " + d.Quote(table+"_update_trig") + " ON " + d.Quote(table) + " AFTER UPDATE AS BEGIN SET NOCOUNT ON;"
+	st += " UPDATE t SET t." + d.Quote(col) + " = DATEDIFF_BIG(SECOND, '1970-01-01', SYSUTCDATETIME())"
+	st += " FROM " + d.Quote(table) + " t INNER JOIN inserted i ON t." + d.Quote(pk) + " = i." + d.Quote(pk) + "; END"
+	return []string{st}
+}
+
+func (d mssqlDialect) TriggerDDL(table, col, event, stmt string) []string {
+	name := table + "_trig"
+	if strings.Contains(event, "INSERT") {
+		name += "_insert"
+	} else if strings.Contains(event, "UPDATE") {
+		name += "_update"
+	} else if strings.Contains(event, "DELETE") {
+		name += "_delete"
+	}
+	if col != "" {
+		name += "_" + col
+	}
+	// SQL Server only have statement level AFTER and INSTEAD OF triggers
+	event = strings.Replace(event, "BEFORE", "INSTEAD OF", 1)
+	st := "CREATE TRIGGER " + d.Quote(name) + " ON " + d.Quote(table) + " " + event + " AS BEGIN SET NOCOUNT ON; "
+	if col != "" {
+		st += "IF UPDATE(" + d.Quote(col) + ") BEGIN " + stmt + "; END"
+	} else {
+		st += stmt + ";"
+	}
+	st += " END"
+	return []string{"DROP TRIGGER IF EXISTS " + d.Quote(name), st}
+}
+
+func (d mssqlDialect) ChangeTriggers(table, pk string, cols map[string]string) (string, string, string) {
+	jsonRow := func(alias string) string {
+		fields := make([]string, 0, len(cols))
+		for col := range cols {
+			fields = append(fields, alias+"."+d.Quote(col)+" AS "+d.Quote(col))
+		}
+		return "JSON_QUERY((SELECT " + strings.Join(fields, ",") + " FOR JSON PATH, WITHOUT_ARRAY_WRAPPER, INCLUDE_NULL_VALUES))"
+	}
+	queue := "INSERT INTO " + d.Quote("_triggers_queue") + "(" + d.Quote("data") + ") "
+	insertStmt := queue + "SELECT (SELECT 'insert' AS [operation], '" + table + "' AS [table], CAST(SESSION_CONTEXT(N'korm_actor') AS NVARCHAR(255)) AS [actor], " + jsonRow("i") + " AS [data] FOR JSON PATH, WITHOUT_ARRAY_WRAPPER) FROM inserted i"
+	updateStmt := queue + "SELECT (SELECT 'update' AS [operation], '" + table + "' AS [table], CAST(SESSION_CONTEXT(N'korm_actor') AS NVARCHAR(255)) AS [actor], " + jsonRow("o") + " AS [old], " + jsonRow("i") + " AS [new] FOR JSON PATH, WITHOUT_ARRAY_WRAPPER) FROM inserted i INNER JOIN deleted o ON i." + d.Quote(pk) + " = o." + d.Quote(pk)
+	deleteStmt := queue + "SELECT (SELECT 'delete' AS [operation], '" + table + "' AS [table], CAST(SESSION_CONTEXT(N'korm_actor') AS NVARCHAR(255)) AS [actor], " + jsonRow("o") + " AS [data] FOR JSON PATH, WITHOUT_ARRAY_WRAPPER) FROM deleted o"
+	return insertStmt, updateStmt, deleteStmt
+}
+
+// mssqlJSON implement JSONFunctions using JSON_VALUE and JSON_MODIFY
+type mssqlJSON struct{}
+
+// mssqlJSONPath convert "a.2.name" to '$.a[2].name'
+func mssqlJSONPath(param string) string {
+	param = strings.Trim(param, "'`")
+	path := "'$"
+	for _, s := range strings.Split(param, ".") {
+		if _, err := strconv.Atoi(s); err == nil {
+			path += "[" + s + "]"
+		} else {
+			path += "." + s
+		}
+	}
+	return path + "'"
+}
+
+func mssqlJSONValue(v any) string {
+	if s, ok := v.(string); ok {
+		if !strings.Contains(s, "'") {
+			return "'" + s + "'"
+		}
+		return s
+	}
+	return fmt.Sprint(v)
+}
+
+func (mssqlJSON) data(dataJson string) string {
+	if jsonDataKind(dataJson) == "{" {
+		return "'" + dataJson + "'"
+	}
+	return dataJson
+}
+
+func (j mssqlJSON) Extract(dataJson string, opts JsonOption) string {
+	dataJson = j.data(dataJson)
+	if len(opts.Params) == 0 {
+		return jsonAs(dataJson, opts.As)
+	}
+	values := make([]string, 0, len(opts.Params))
+	for _, p := range opts.Params {
+		values = append(values, "JSON_VALUE("+dataJson+", "+mssqlJSONPath(fmt.Sprint(p))+")")
+	}
+	if len(values) == 1 {
+		return jsonAs(values[0], opts.As)
+	}
+	return jsonAs("JSON_ARRAY("+strings.Join(values, ", ")+")", opts.As)
+}
+
+func (j mssqlJSON) Set(dataJson string, opts JsonOption) string {
+	st := j.data(dataJson)
+	for i := 0; i+1 < len(opts.Params); i += 2 {
+		st = "JSON_MODIFY(" + st + ", " + mssqlJSONPath(fmt.Sprint(opts.Params[i])) + ", " + mssqlJSONValue(opts.Params[i+1]) + ")"
+	}
+	return jsonAs(st, opts.As)
+}
+
+func (j mssqlJSON) Remove(dataJson string, opts JsonOption) string {
+	st := j.data(dataJson)
+	for _, p := range opts.Params {
+		st = "JSON_MODIFY(" + st + ", " + mssqlJSONPath(fmt.Sprint(p)) + ", NULL)"
+	}
+	return jsonAs(st, opts.As)
+}
+
+func (mssqlJSON) Array(values []string, as string) string {
+	return jsonAs("JSON_ARRAY("+strings.Join(values, ", ")+")", as)
+}
+
+func (mssqlJSON) Object(values []string, as string) string {
+	pairs := make([]string, 0, len(values)/2)
+	for i := 0; i+1 < len(values); i += 2 {
+		pairs = append(pairs, values[i]+":"+values[i+1])
+	}
+	return jsonAs("JSON_OBJECT("+strings.Join(pairs, ", ")+")", as)
+}
+
+func (mssqlJSON) Cast(value string, as string) string {
+	return jsonAs("JSON_QUERY("+value+")", as)
+}
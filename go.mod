@@ -3,7 +3,6 @@ module github.com/kamalshkeir/korm
 go 1.25.4
 
 require (
-	github.com/kamalshkeir/aes v1.1.2
 	github.com/kamalshkeir/argon v1.0.1
 	github.com/kamalshkeir/kinput v0.1.0
 	github.com/kamalshkeir/kmap v1.1.8
@@ -11,13 +10,23 @@ require (
 	github.com/kamalshkeir/kstrct v1.9.23
 	github.com/kamalshkeir/lg v0.1.4
 	github.com/kamalshkeir/ulid v1.0.0
+	modernc.org/sqlite v1.38.2
 )
 
 require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v0.1.9 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
 	golang.org/x/crypto v0.47.0 // indirect
+	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b // indirect
 	golang.org/x/net v0.49.0 // indirect
 	golang.org/x/sys v0.40.0 // indirect
 	golang.org/x/term v0.39.0 // indirect
 	golang.org/x/text v0.33.0 // indirect
 	golang.org/x/time v0.14.0 // indirect
+	modernc.org/libc v1.66.3 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
 )
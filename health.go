@@ -0,0 +1,184 @@
+package korm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kamalshkeir/ksmux"
+	"github.com/kamalshkeir/ksmux/ksps"
+	"github.com/kamalshkeir/lg"
+)
+
+var (
+	// HealthCheckTimeout is the maximum duration of all pings and queries made by a health check
+	HealthCheckTimeout = 2 * time.Second
+)
+
+// HealthReport is the JSON body returned by /mon/health and /mon/ready
+type HealthReport struct {
+	Status    string           `json:"status"` // ok, down or stopping
+	Databases []DatabaseHealth `json:"databases"`
+	Nodes     []*Node          `json:"nodes,omitempty"`
+	Cache     CacheHealth      `json:"cache"`
+	CheckedAt time.Time        `json:"checked_at"`
+}
+
+// DatabaseHealth is the state of a database connection pool
+type DatabaseHealth struct {
+	Name    string      `json:"name"`
+	Dialect string      `json:"dialect"`
+	Up      bool        `json:"up"`
+	Error   string      `json:"error,omitempty"`
+	PingMs  float64     `json:"ping_ms"`
+	Stats   sql.DBStats `json:"stats"`
+	// TriggersQueue is the number of changes waiting in _triggers_queue, nil if the table does not exist
+	TriggersQueue *int64 `json:"triggers_queue,omitempty"`
+	// TriggersRetrying is the number of changes whose hooks failed and that wait for a redelivery
+	TriggersRetrying int `json:"triggers_retrying,omitempty"`
+	// TriggersDead is the number of changes moved to _triggers_dead
+	TriggersDead int64 `json:"triggers_dead,omitempty"`
+	// TriggersLagMs is the age of the oldest change being delivered or retried
+	TriggersLagMs float64         `json:"triggers_lag_ms,omitempty"`
+	Replicas      []ReplicaHealth `json:"replicas,omitempty"`
+}
+
+// ReplicaHealth is the state of a read replica
+type ReplicaHealth struct {
+	DSN       string  `json:"-"`
+	Healthy   bool    `json:"healthy"`
+	LatencyMs float64 `json:"latency_ms"`
+}
+
+// CacheHealth report the query cache usage
+type CacheHealth struct {
+	Enabled      bool    `json:"enabled"`
+	Tables       int     `json:"tables"`
+	Queries      int     `json:"queries"`
+	MaxMemoryMB  int     `json:"max_memory_mb"`
+	HeapMemoryMB float64 `json:"heap_memory_mb"`
+}
+
+// Health ping all databases of the default client and return their pool statistics, trigger queues backlog,
+// node manager peers and cache usage, Status is 'down' if a database is unreachable
+func Health(ctx context.Context) HealthReport {
+	return defaultClient.Health(ctx)
+}
+
+// Health is korm.Health for client c
+func (c *Client) Health(ctx context.Context) HealthReport {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+	report := HealthReport{
+		Status:    "ok",
+		Databases: make([]DatabaseHealth, 0, len(c.databases)),
+		CheckedAt: time.Now(),
+	}
+	for i := range c.databases {
+		db := &c.databases[i]
+		dh := DatabaseHealth{
+			Name:    db.Name,
+			Dialect: db.Dialect,
+			Stats:   db.Conn.Stats(),
+		}
+		start := time.Now()
+		if err := db.Conn.PingContext(ctx); err != nil {
+			dh.Error = err.Error()
+			report.Status = "down"
+		} else {
+			dh.Up = true
+			dh.PingMs = float64(time.Since(start).Microseconds()) / 1000
+			if qs, err := db.queueStats(ctx); err == nil {
+				dh.TriggersQueue = &qs.Depth
+				dh.TriggersRetrying = qs.Retrying
+				dh.TriggersDead = qs.Dead
+				dh.TriggersLagMs = float64(qs.Lag.Microseconds()) / 1000
+			}
+		}
+		for _, r := range db.Replicas() {
+			dh.Replicas = append(dh.Replicas, ReplicaHealth{
+				DSN:       r.DSN,
+				Healthy:   r.Healthy(),
+				LatencyMs: float64(r.Latency().Microseconds()) / 1000,
+			})
+		}
+		report.Databases = append(report.Databases, dh)
+	}
+	if c.nodeManager != nil {
+		report.Nodes = c.nodeManager.GetNodes()
+	}
+	report.Cache = CacheHealth{
+		Enabled:      useCache,
+		Tables:       c.caches.Len(),
+		Queries:      c.cacheQ.Len(),
+		MaxMemoryMB:  cacheMaxMemoryMb,
+		HeapMemoryMB: GetSystemMetrics().HeapMemoryMB,
+	}
+	if report.Status == "ok" && c.stopping() {
+		report.Status = "stopping"
+	}
+	return report
+}
+
+// HealthHandler return the /mon/health handler of the default client, it respond 503 if a database is unreachable
+func HealthHandler() http.HandlerFunc {
+	return defaultClient.HealthHandler()
+}
+
+// HealthHandler is korm.HealthHandler for client c
+func (c *Client) HealthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := c.Health(r.Context())
+		code := http.StatusOK
+		if report.Status == "down" {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealth(w, code, report)
+	}
+}
+
+// ReadyHandler return the /mon/ready handler of the default client, it respond 503 if no database is connected,
+// a database is unreachable or the client is shutting down
+func ReadyHandler() http.HandlerFunc {
+	return defaultClient.ReadyHandler()
+}
+
+// ReadyHandler is korm.ReadyHandler for client c
+func (c *Client) ReadyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := c.Health(r.Context())
+		code := http.StatusOK
+		if report.Status != "ok" || len(report.Databases) == 0 {
+			code = http.StatusServiceUnavailable
+		}
+		writeHealth(w, code, report)
+	}
+}
+
+func writeHealth(w http.ResponseWriter, code int, report HealthReport) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	lg.CheckError(json.NewEncoder(w).Encode(report))
+}
+
+// initHealthUrls register /mon/health and /mon/ready on r
+func initHealthUrls(r *ksmux.Router) {
+	r.Get("/mon/health", func(c *ksmux.Context) { HealthHandler()(c.ResponseWriter, c.Request) })
+	r.Get("/mon/ready", func(c *ksmux.Context) { ReadyHandler()(c.ResponseWriter, c.Request) })
+}
+
+// WithHealth enable /mon/health and /mon/ready on the server bus without the dashboard, WithDashboard already add them
+func WithHealth() *ksps.ServerBus {
+	if defaultClient.serverBus == nil {
+		lg.DebugC("using default bus :9313")
+		defaultClient.serverBus = WithBus()
+	}
+	initHealthUrls(defaultClient.serverBus.App())
+	return defaultClient.serverBus
+}
@@ -0,0 +1,497 @@
+package korm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/kstrct"
+	"github.com/kamalshkeir/lg"
+)
+
+// HistoryEntry is a version of a row of T read from its <table>_history table.
+// Row is the row after the change, or before it for deletes, Diff map changed columns to their "old" and "new" values
+type HistoryEntry[T any] struct {
+	Id        uint           `json:"history_id"`
+	Operation string         `json:"operation"`
+	ChangedAt time.Time      `json:"changed_at"`
+	Actor     string         `json:"actor"`
+	Diff      map[string]any `json:"diff"`
+	Row       T              `json:"row"`
+}
+
+type actorKey struct{}
+
+// WithActor return a copy of ctx holding actor, recorded in history tables for writes made using ctx.
+// The Auth and Admin middlewares set the email of the logged in user in the request context
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom return the actor set in ctx using WithActor, or empty
+func ActorFrom(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// ActorRecorder can be implemented by a Dialect to record in changes of AddChangesTrigger the actor of writes made with a context having WithActor.
+// The actor travel with the change in _triggers_queue, so it is kept on rollbacks, redeliveries and across nodes.
+// Setup return statements run before change triggers are added, set and clear are run in the transaction of a write on a table having
+// change triggers, set before it using the actor as single argument, clear after it if not empty
+type ActorRecorder interface {
+	ActorSetup() []string
+	ActorStatements() (set, clear string)
+}
+
+// sqlite have no session variables, the actor is kept in the single row of _triggers_actor, writes are serialized until commit
+func (sqliteDialect) ActorSetup() []string {
+	return []string{
+		"CREATE TABLE IF NOT EXISTS _triggers_actor (actor TEXT)",
+		"INSERT INTO _triggers_actor (actor) SELECT NULL WHERE NOT EXISTS (SELECT 1 FROM _triggers_actor)",
+	}
+}
+
+func (sqliteDialect) ActorStatements() (string, string) {
+	return "UPDATE _triggers_actor SET actor = ?", "UPDATE _triggers_actor SET actor = NULL"
+}
+
+// postgres set the actor for the transaction only
+func (postgresDialect) ActorSetup() []string { return nil }
+
+func (postgresDialect) ActorStatements() (string, string) {
+	return "SELECT set_config('korm.actor', ?, true)", ""
+}
+
+// cockroach changefeeds only stream rows, the actor is saved with the timestamp of the transaction and matched with the updated
+// timestamp of its changes by cockroachChangesWorker
+func (cockroachDialect) ActorSetup() []string {
+	return []string{`CREATE TABLE IF NOT EXISTS "_triggers_actors" ("ts" DECIMAL NOT NULL PRIMARY KEY, "actor" STRING)`}
+}
+
+func (cockroachDialect) ActorStatements() (string, string) {
+	return `UPSERT INTO "_triggers_actors" ("ts", "actor") VALUES (cluster_logical_timestamp(), ?)`, ""
+}
+
+func (mysqlDialect) ActorSetup() []string { return nil }
+
+func (mysqlDialect) ActorStatements() (string, string) {
+	return "SET @korm_actor = ?", "SET @korm_actor = NULL"
+}
+
+func (mssqlDialect) ActorSetup() []string { return nil }
+
+func (mssqlDialect) ActorStatements() (string, string) {
+	return "EXEC sp_set_session_context @key = N'korm_actor', @value = ?", "EXEC sp_set_session_context @key = N'korm_actor', @value = NULL"
+}
+
+type historyColumn struct {
+	name string
+	kind string
+}
+
+// WithHistory keep a full history of rows of the table of T in <table>_history, having all columns of T plus operation, changed_at, actor and diff.
+// History rows are written from the changes captured by AddChangesTrigger, rows existing before are recorded from their first change.
+// The actor is read from the context of writes made using korm builders, see WithActor, on dialects implementing ActorRecorder
+//
+//	Example:
+//	  err := korm.WithHistory[User]()
+//	  _, err = korm.Model[User]().Context(korm.WithActor(ctx, "admin@mail.com")).Where("id = ?", 1).Set("email = ?", "new@mail.com")
+//	  versions, err := korm.Model[User]().History(1)
+//	  users, err := korm.Model[User]().Where("id = ?", 1).AsOf(time.Now().Add(-24 * time.Hour))
+func WithHistory[T any](dbName ...string) error {
+	return WithHistoryOn[T](defaultClient, dbName...)
+}
+
+// WithHistoryOn is WithHistory using client c
+func WithHistoryOn[T any](c *Client, dbName ...string) error {
+	table := getTableNameOn[T](c)
+	if table == "" {
+		return ErrTableNotFound
+	}
+	dName := c.defaultDB
+	if len(dbName) > 0 {
+		dName = dbName[0]
+	}
+	db, err := c.GetMemoryDatabase(dName)
+	if err != nil {
+		return err
+	}
+	if _, ok := c.internalTables.Get(db.Name + "." + table + "_history"); ok {
+		return nil
+	}
+	cols, err := historyColumns(reflect.TypeFor[T]())
+	if err != nil {
+		return err
+	}
+	if err := db.createHistoryTable(table, cols); err != nil {
+		return err
+	}
+	if _, ok := c.triggersTables.Get(db.Name + "." + table); !ok {
+		if err := c.AddChangesTrigger(table, db.Name); err != nil {
+			return err
+		}
+		c.triggersTables.Set(db.Name+"."+table, struct{}{})
+	}
+	record := func(hd HookData) error {
+		if hd.database != "" && hd.database != db.Name {
+			return nil
+		}
+		return db.recordHistory(hd, cols)
+	}
+	c.OnInsertE(record, table)
+	c.OnSetE(record, table)
+	c.OnDeleteE(record, table)
+	c.internalTables.Set(db.Name+"."+table+"_history", struct{}{})
+	return nil
+}
+
+// historyColumns return columns of the model rt, with the kind used by Dialect.ColumnType
+func historyColumns(rt reflect.Type) ([]historyColumn, error) {
+	if rt.Kind() == reflect.Pointer {
+		rt = rt.Elem()
+	}
+	if rt.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("history need a struct model, got %s", rt)
+	}
+	cols := []historyColumn{}
+	for i := 0; i < rt.NumField(); i++ {
+		f := rt.Field(i)
+		if !f.IsExported() || f.Tag.Get("korm") == "-" {
+			continue
+		}
+		name := kstrct.ToSnakeCase(f.Name)
+		switch name {
+		case "history_id", "operation", "changed_at", "actor", "diff":
+			return nil, fmt.Errorf("history: column %s of %s is reserved", name, rt.Name())
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		kind := "text"
+		switch {
+		case ft == timeType:
+			kind = "time"
+		case ft.Kind() == reflect.Bool:
+			kind = "int"
+		case ft.Kind() >= reflect.Int && ft.Kind() <= reflect.Uint64:
+			kind = "bigint"
+		case ft.Kind() == reflect.Float32 || ft.Kind() == reflect.Float64:
+			kind = "float"
+		case ft.Kind() == reflect.Struct:
+			// relations are not columns
+			continue
+		}
+		cols = append(cols, historyColumn{name: name, kind: kind})
+	}
+	return cols, nil
+}
+
+// createHistoryTable create <table>_history having cols and indexes on the primary key of table and changed_at
+func (db *DatabaseEntity) createHistoryTable(table string, cols []historyColumn) error {
+	d := dialectOf(db.Dialect)
+	hist := table + "_history"
+	defs := []string{d.Quote("history_id") + " " + d.AutoIncrementPK()}
+	for _, col := range cols {
+		defs = append(defs, d.Quote(col.name)+" "+d.ColumnType(col.kind, ""))
+	}
+	defs = append(defs,
+		d.Quote("operation")+" "+d.ColumnType("string", "10"),
+		d.Quote("changed_at")+" "+d.ColumnType("time", ""),
+		d.Quote("actor")+" "+d.ColumnType("string", "255"),
+		d.Quote("diff")+" "+d.ColumnType("text", ""),
+	)
+	if _, err := db.execContext(context.Background(), d.CreateTable(hist, defs)); err != nil {
+		return err
+	}
+	for _, col := range []string{db.tablePk(table), "changed_at"} {
+		if col == "" {
+			continue
+		}
+		name := "idx_" + hist + "_" + col
+		if indexExists(db.Conn, hist, name, db.Dialect) {
+			continue
+		}
+		if _, err := db.execContext(context.Background(), "CREATE INDEX "+name+" ON "+d.Quote(hist)+" ("+d.Quote(col)+")"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recordHistory insert the row of the change hd in <table>_history
+func (db *DatabaseEntity) recordHistory(hd HookData, cols []historyColumn) error {
+	row := hd.Data
+	diff := map[string]any{}
+	switch hd.Operation {
+	case "insert":
+		for k, v := range hd.Data {
+			diff[k] = map[string]any{"new": v}
+		}
+	case "update":
+		row = hd.New
+		for k, v := range hd.New {
+			if old := hd.Old[k]; !reflect.DeepEqual(old, v) {
+				diff[k] = map[string]any{"old": old, "new": v}
+			}
+		}
+	case "delete":
+		for k, v := range hd.Data {
+			diff[k] = map[string]any{"old": v}
+		}
+	}
+	diffJson, err := json.Marshal(diff)
+	if err != nil {
+		return err
+	}
+	d := dialectOf(db.Dialect)
+	names := make([]string, 0, len(cols)+4)
+	values := make([]any, 0, len(cols)+4)
+	for _, col := range cols {
+		if v, ok := row[col.name]; ok {
+			names = append(names, d.Quote(col.name))
+			values = append(values, historyValue(v))
+		}
+	}
+	names = append(names, d.Quote("operation"), d.Quote("changed_at"), d.Quote("actor"), d.Quote("diff"))
+	values = append(values, hd.Operation, time.Now().Unix(), hd.Actor, string(diffJson))
+	st := "INSERT INTO " + d.Quote(hd.Table+"_history") + " (" + strings.Join(names, ",") + ") VALUES (" + strings.TrimSuffix(strings.Repeat("?,", len(names)), ",") + ")"
+	AdaptPlaceholdersToDialect(&st, db.Dialect)
+	_, err = db.execContext(context.WithoutCancel(hd.Context()), st, values...)
+	return err
+}
+
+// historyValue convert a value decoded from a change to a column value
+func historyValue(v any) any {
+	switch vv := v.(type) {
+	case float64:
+		if vv == math.Trunc(vv) && math.Abs(vv) < 1<<53 {
+			return int64(vv)
+		}
+	case bool:
+		if vv {
+			return 1
+		}
+		return 0
+	case map[string]any, []any:
+		b, err := json.Marshal(vv)
+		if err == nil {
+			return string(b)
+		}
+	}
+	return v
+}
+
+// historyTarget return the operation and table of an insert, update or delete statement
+func historyTarget(query string) (string, string) {
+	q := strings.TrimSpace(query)
+	op := ""
+	for prefix, o := range map[string]string{"INSERT INTO ": "insert", "UPDATE ": "update", "DELETE FROM ": "delete"} {
+		if len(q) > len(prefix) && strings.EqualFold(q[:len(prefix)], prefix) {
+			op, q = o, strings.TrimSpace(q[len(prefix):])
+			break
+		}
+	}
+	if op == "" {
+		return "", ""
+	}
+	if i := strings.IndexAny(q, " (\n\t"); i > 0 {
+		q = q[:i]
+	}
+	return op, strings.Trim(q, "\"`[]")
+}
+
+// execer is implemented by *sql.DB, *sql.Conn and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// actorWrite hold the statements setting and clearing the actor of a write for change triggers
+type actorWrite struct {
+	actor string
+	set   string
+	clear string
+}
+
+// actorWriteFor return the statements recording the actor of ctx for query, or nil if the dialect does not record actors,
+// ctx has no actor or the table written by query has no change triggers
+func (db *DatabaseEntity) actorWriteFor(ctx context.Context, query string) *actorWrite {
+	actor := ActorFrom(ctx)
+	if actor == "" || db.client == nil {
+		return nil
+	}
+	ar, ok := dialectOf(db.Dialect).(ActorRecorder)
+	if !ok {
+		return nil
+	}
+	_, table := historyTarget(query)
+	if table == "" {
+		return nil
+	}
+	if _, ok := db.client.triggersTables.Get(db.Name + "." + table); !ok {
+		return nil
+	}
+	aw := &actorWrite{actor: actor}
+	aw.set, aw.clear = ar.ActorStatements()
+	AdaptPlaceholdersToDialect(&aw.set, db.Dialect)
+	return aw
+}
+
+// run call write using ex between the statements setting and clearing the actor, ex should be a transaction
+func (aw *actorWrite) run(ctx context.Context, ex execer, write func() error) error {
+	if _, err := ex.ExecContext(ctx, aw.set, aw.actor); err != nil {
+		return err
+	}
+	if err := write(); err != nil {
+		return err
+	}
+	if aw.clear != "" {
+		if _, err := ex.ExecContext(ctx, aw.clear); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inTx call write in a transaction of db between the statements setting and clearing the actor
+func (aw *actorWrite) inTx(ctx context.Context, db *DatabaseEntity, write func(tx *sql.Tx) error) error {
+	tx, err := db.Conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := aw.run(ctx, tx, func() error { return write(tx) }); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// History return versions of the row having the primary key pk, oldest first
+func (b *BuilderS[T]) History(pk any) ([]HistoryEntry[T], error) {
+	if b == nil || b.tableName == "" {
+		return nil, ErrTableNotFound
+	}
+	idCol := b.db.tablePk(b.tableName)
+	if idCol == "" {
+		return nil, ErrTableNotFound
+	}
+	d := dialectOf(b.db.Dialect)
+	rows, err := b.c.Table(b.tableName+"_history").Database(b.db.Name).Context(b.context()).QueryM(
+		"SELECT * FROM "+d.Quote(b.tableName+"_history")+" WHERE "+d.Quote(idCol)+" = ? ORDER BY "+d.Quote("history_id"), pk)
+	if err != nil {
+		return nil, err
+	}
+	return decodeHistory[T](rows)
+}
+
+// AsOf return rows matching Where as they were at t, from the <table>_history table
+func (b *BuilderS[T]) AsOf(t time.Time) ([]T, error) {
+	if b == nil || b.tableName == "" {
+		return nil, ErrTableNotFound
+	}
+	idCol := b.db.tablePk(b.tableName)
+	if idCol == "" {
+		return nil, ErrTableNotFound
+	}
+	d := dialectOf(b.db.Dialect)
+	hist := d.Quote(b.tableName + "_history")
+	st := "SELECT * FROM " + hist + " WHERE " + d.Quote("history_id") + " IN (SELECT MAX(" + d.Quote("history_id") + ") FROM " + hist +
+		" WHERE " + d.Quote("changed_at") + " <= ? GROUP BY " + d.Quote(idCol) + ") AND " + d.Quote("operation") + " <> 'delete'"
+	args := []any{t.Unix()}
+	if b.whereQuery != "" {
+		st += " AND (" + b.whereQuery + ")"
+		args = append(args, b.args...)
+	}
+	st += " ORDER BY " + d.Quote(idCol)
+	rows, err := b.c.Table(b.tableName+"_history").Database(b.db.Name).Context(b.context()).QueryM(st, args...)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := decodeHistory[T](rows)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]T, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, e.Row)
+	}
+	if len(res) == 0 {
+		return nil, ErrNoData
+	}
+	return res, nil
+}
+
+func (b *BuilderS[T]) context() context.Context {
+	if b.ctx == nil {
+		return context.Background()
+	}
+	return b.ctx
+}
+
+// decodeHistory convert rows of a history table to entries
+func decodeHistory[T any](rows []map[string]any) ([]HistoryEntry[T], error) {
+	entries := make([]HistoryEntry[T], 0, len(rows))
+	for _, r := range rows {
+		e := HistoryEntry[T]{
+			Operation: fmt.Sprint(r["operation"]),
+		}
+		if v, ok := r["actor"].(string); ok {
+			e.Actor = v
+		}
+		if v, ok := historyInt(r["history_id"]); ok {
+			e.Id = uint(v)
+		}
+		if v, ok := historyInt(r["changed_at"]); ok {
+			e.ChangedAt = time.Unix(v, 0)
+		}
+		if v, ok := r["diff"].(string); ok && v != "" {
+			lg.CheckError(json.Unmarshal([]byte(v), &e.Diff))
+		}
+		data := make(map[string]any, len(r))
+		for k, v := range r {
+			switch k {
+			case "history_id", "operation", "changed_at", "actor", "diff":
+				continue
+			}
+			if v != nil {
+				data[k] = v
+			}
+		}
+		row, err := decodeHookRow[T](data)
+		if err != nil && !errors.Is(err, ErrNoData) {
+			return nil, err
+		}
+		e.Row = row
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+func historyInt(v any) (int64, bool) {
+	switch vv := v.(type) {
+	case int64:
+		return vv, true
+	case int:
+		return int64(vv), true
+	case float64:
+		return int64(vv), true
+	case []byte:
+		var n int64
+		_, err := fmt.Sscan(string(vv), &n)
+		return n, err == nil
+	case string:
+		var n int64
+		_, err := fmt.Sscan(vv, &n)
+		return n, err == nil
+	}
+	return 0, false
+}
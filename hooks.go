@@ -0,0 +1,138 @@
+package korm
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/kamalshkeir/kstrct"
+	"github.com/kamalshkeir/lg"
+)
+
+// OnInsertOf add a hook called with the inserted row decoded into T, for tables migrated or used with the model T,
+// or only for tables if given
+//
+//	Example:
+//	  korm.OnInsertOf(func(ctx context.Context, u User) {
+//	  	lg.Info("new user", "email", u.Email)
+//	  })
+func OnInsertOf[T any](fn func(ctx context.Context, row T), tables ...string) {
+	OnInsertOfOn(defaultClient, fn, tables...)
+}
+
+// OnInsertOfOn is OnInsertOf using client c
+func OnInsertOfOn[T any](c *Client, fn func(ctx context.Context, row T), tables ...string) {
+	c.OnInsert(func(hd HookData) {
+		if !hookOfType[T](c, hd.Table, tables) {
+			return
+		}
+		row, err := decodeHookRow[T](hd.Data)
+		if err != nil {
+			lg.ErrorC("could not decode inserted row", "table", hd.Table, "err", err)
+			return
+		}
+		fn(hd.Context(), row)
+	})
+}
+
+// OnUpdateOf add a hook called with the row before and after the update decoded into T, for tables migrated or used with the model T,
+// or only for tables if given
+func OnUpdateOf[T any](fn func(ctx context.Context, old, new T), tables ...string) {
+	OnUpdateOfOn(defaultClient, fn, tables...)
+}
+
+// OnUpdateOfOn is OnUpdateOf using client c
+func OnUpdateOfOn[T any](c *Client, fn func(ctx context.Context, old, new T), tables ...string) {
+	c.OnSet(func(hd HookData) {
+		if !hookOfType[T](c, hd.Table, tables) {
+			return
+		}
+		old, err := decodeHookRow[T](hd.Old)
+		if err != nil {
+			lg.ErrorC("could not decode updated row", "table", hd.Table, "err", err)
+			return
+		}
+		row, err := decodeHookRow[T](hd.New)
+		if err != nil {
+			lg.ErrorC("could not decode updated row", "table", hd.Table, "err", err)
+			return
+		}
+		fn(hd.Context(), old, row)
+	})
+}
+
+// OnDeleteOf add a hook called with the deleted row decoded into T, for tables migrated or used with the model T,
+// or only for tables if given
+func OnDeleteOf[T any](fn func(ctx context.Context, row T), tables ...string) {
+	OnDeleteOfOn(defaultClient, fn, tables...)
+}
+
+// OnDeleteOfOn is OnDeleteOf using client c
+func OnDeleteOfOn[T any](c *Client, fn func(ctx context.Context, row T), tables ...string) {
+	c.OnDelete(func(hd HookData) {
+		if !hookOfType[T](c, hd.Table, tables) {
+			return
+		}
+		row, err := decodeHookRow[T](hd.Data)
+		if err != nil {
+			lg.ErrorC("could not decode deleted row", "table", hd.Table, "err", err)
+			return
+		}
+		fn(hd.Context(), row)
+	})
+}
+
+// hookOfType return true if table is in tables, or if tables is empty and the model of table in mModelTablename is T
+func hookOfType[T any](c *Client, table string, tables []string) bool {
+	if len(tables) > 0 {
+		return SliceContains(tables, table)
+	}
+	c.mutexModelTablename.RLock()
+	model, ok := c.mModelTablename[table]
+	c.mutexModelTablename.RUnlock()
+	if !ok || model == nil {
+		return false
+	}
+	rt := reflect.TypeOf(model)
+	if rt.Kind() == reflect.Pointer {
+		rt = rt.Elem()
+	}
+	return rt == reflect.TypeFor[T]()
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// decodeHookRow fill a T from a row of _triggers_queue, time columns stored as unix seconds are converted to time.Time
+func decodeHookRow[T any](data map[string]any) (T, error) {
+	var row T
+	if len(data) == 0 {
+		return row, ErrNoData
+	}
+	values := make(map[string]any, len(data))
+	for k, v := range data {
+		values[k] = v
+	}
+	rt := reflect.TypeFor[T]()
+	if rt.Kind() == reflect.Struct {
+		for i := 0; i < rt.NumField(); i++ {
+			f := rt.Field(i)
+			if f.Type != timeType && f.Type != reflect.PointerTo(timeType) {
+				continue
+			}
+			col := kstrct.ToSnakeCase(f.Name)
+			switch v := values[col].(type) {
+			case float64:
+				values[col] = time.Unix(int64(v), 0)
+			case int64:
+				values[col] = time.Unix(v, 0)
+			case json.Number:
+				if n, err := v.Int64(); err == nil {
+					values[col] = time.Unix(n, 0)
+				}
+			}
+		}
+	}
+	err := kstrct.FillM(&row, values)
+	return row, err
+}
@@ -0,0 +1,483 @@
+package korm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+const (
+	JobPending = "pending"
+	JobRunning = "running"
+)
+
+var (
+	ErrJobExists    = errors.New("a job with the same unique key is already queued")
+	ErrNoJobHandler = errors.New("no handler registered for job")
+	// JobsMaxRetries is the default number of retries of a failing job before moving it to _jobs_dead
+	JobsMaxRetries = 3
+	// JobsRetryBase is the delay before the first retry, doubled at every attempt up to JobsRetryMax
+	JobsRetryBase = 5 * time.Second
+	JobsRetryMax  = time.Hour
+)
+
+// Job is a background job stored in the _jobs table, Payload is the JSON encoded payload given to Enqueue
+type Job struct {
+	Id          uint      `korm:"pk" json:"id"`
+	Name        string    `korm:"size:100;index" json:"name"`
+	Payload     string    `korm:"text" json:"payload"`
+	UniqueKey   *string   `korm:"size:191" json:"unique_key,omitempty"`
+	Status      string    `korm:"size:20;index" json:"status"`
+	Attempts    int       `json:"attempts"`
+	MaxRetries  int       `json:"max_retries"`
+	LastError   string    `korm:"text" json:"last_error"`
+	RunAt       time.Time `korm:"index" json:"run_at"`
+	LockedBy    string    `korm:"size:100" json:"locked_by"`
+	LockedUntil time.Time `json:"locked_until"`
+	CreatedAt   time.Time `korm:"now" json:"created_at"`
+}
+
+// DeadJob is a job that failed more than MaxRetries times, moved to the _jobs_dead table
+type DeadJob struct {
+	Id         uint      `korm:"pk" json:"id"`
+	JobId      uint      `json:"job_id"`
+	Name       string    `korm:"size:100;index" json:"name"`
+	Payload    string    `korm:"text" json:"payload"`
+	Attempts   int       `json:"attempts"`
+	MaxRetries int       `json:"max_retries"`
+	LastError  string    `korm:"text" json:"last_error"`
+	CreatedAt  time.Time `json:"created_at"`
+	FailedAt   time.Time `korm:"now" json:"failed_at"`
+}
+
+// JobHandler handle a job claimed by a worker, returning an error retry the job later
+type JobHandler func(ctx context.Context, job *Job) error
+
+// JobOption configure a job added using Enqueue
+type JobOption func(*jobConfig)
+
+type jobConfig struct {
+	runAt      time.Time
+	maxRetries int
+	uniqueKey  string
+	database   string
+}
+
+// RunAt delay the job until t
+func RunAt(t time.Time) JobOption {
+	return func(jc *jobConfig) { jc.runAt = t }
+}
+
+// RunIn delay the job by d
+func RunIn(d time.Duration) JobOption {
+	return func(jc *jobConfig) { jc.runAt = time.Now().Add(d) }
+}
+
+// MaxRetries set the number of retries before moving the job to _jobs_dead, default korm.JobsMaxRetries
+func MaxRetries(n int) JobOption {
+	return func(jc *jobConfig) { jc.maxRetries = n }
+}
+
+// UniqueKey prevent enqueueing the job while another job having the same key is pending or running
+func UniqueKey(key string) JobOption {
+	return func(jc *jobConfig) { jc.uniqueKey = key }
+}
+
+// OnDatabase enqueue the job in dbName instead of the first connected database
+func OnDatabase(dbName string) JobOption {
+	return func(jc *jobConfig) { jc.database = dbName }
+}
+
+// JobsOptions configure workers started by StartJobs
+type JobsOptions struct {
+	Workers   int           // default 4
+	PollEvery time.Duration // default 1s, wait between polls when the queue is empty
+	Lease     time.Duration // default 5m, a job running longer is considered lost and claimed again
+	Database  string        // default first connected database
+}
+
+// HandleJob register fn as the handler of jobs named name, payload is decoded from JSON into T
+//
+//	Example:
+//	  korm.HandleJob("send_email", func(ctx context.Context, e Email) error {
+//	  	return mailer.Send(ctx, e.To, e.Subject)
+//	  })
+func HandleJob[T any](name string, fn func(ctx context.Context, payload T) error) {
+	HandleJobOn(defaultClient, name, fn)
+}
+
+// HandleJobOn is HandleJob using client c
+func HandleJobOn[T any](c *Client, name string, fn func(ctx context.Context, payload T) error) {
+	c.jobHandlers.Set(name, func(ctx context.Context, job *Job) error {
+		var payload T
+		if job.Payload != "" {
+			if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+				return fmt.Errorf("decode payload of job %s: %w", name, err)
+			}
+		}
+		return fn(ctx, payload)
+	})
+}
+
+// Enqueue add a job named name to the _jobs table, payload is encoded as JSON, it return the job id
+//
+//	Example:
+//	  id, err := korm.Enqueue(ctx, "send_email", Email{To: "a@b.c"}, korm.RunIn(time.Minute), korm.MaxRetries(5))
+func Enqueue(ctx context.Context, name string, payload any, opts ...JobOption) (uint, error) {
+	return defaultClient.Enqueue(ctx, name, payload, opts...)
+}
+
+// Enqueue is korm.Enqueue for client c
+func (c *Client) Enqueue(ctx context.Context, name string, payload any, opts ...JobOption) (uint, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	jc := jobConfig{maxRetries: JobsMaxRetries}
+	for _, opt := range opts {
+		opt(&jc)
+	}
+	if jc.runAt.IsZero() {
+		jc.runAt = time.Now()
+	}
+	db, err := c.jobsDatabase(jc.database)
+	if err != nil {
+		return 0, err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	job := Job{
+		Name:        name,
+		Payload:     string(data),
+		Status:      JobPending,
+		MaxRetries:  jc.maxRetries,
+		RunAt:       jc.runAt,
+		LockedUntil: time.Unix(0, 0),
+		CreatedAt:   time.Now(),
+	}
+	if jc.uniqueKey != "" {
+		job.UniqueKey = &jc.uniqueKey
+		if id, ok := c.jobByUniqueKey(ctx, db, jc.uniqueKey); ok {
+			return id, ErrJobExists
+		}
+	}
+	id, err := ModelOn[Job](c).Database(db.Name).Context(ctx).Insert(&job)
+	if err != nil {
+		if jc.uniqueKey != "" {
+			if id, ok := c.jobByUniqueKey(ctx, db, jc.uniqueKey); ok {
+				return id, ErrJobExists
+			}
+		}
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+func (c *Client) jobByUniqueKey(ctx context.Context, db *DatabaseEntity, key string) (uint, bool) {
+	q := dialectOf(db.Dialect).Quote
+	st := "SELECT " + q("id") + " FROM " + q("_jobs") + " WHERE " + q("unique_key") + " = ?"
+	AdaptPlaceholdersToDialect(&st, db.Dialect)
+	var id uint
+	err := db.readConn(db.writer == nil).QueryRowContext(ctx, st, key).Scan(&id)
+	return id, err == nil
+}
+
+// jobsDatabase return dbName after migrating _jobs and _jobs_dead tables on it
+func (c *Client) jobsDatabase(dbName string) (*DatabaseEntity, error) {
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.internalTables.Get(db.Name + "._jobs"); ok {
+		return db, nil
+	}
+	if err := AutoMigrateOn[Job](c, "_jobs", db.Name); err != nil {
+		return nil, err
+	}
+	// jobs without unique key have a NULL unique_key
+	if err := createUniqueNullableIndex(db, "_jobs", "unique_key"); err != nil {
+		return nil, err
+	}
+	if err := AutoMigrateOn[DeadJob](c, "_jobs_dead", db.Name); err != nil {
+		return nil, err
+	}
+	c.internalTables.Set(db.Name+"._jobs", struct{}{})
+	return db, nil
+}
+
+// StartJobs start a pool of workers executing jobs having a registered handler, workers stop on Shutdown after finishing their current job.
+// Jobs are claimed using FOR UPDATE SKIP LOCKED on postgres and cockroach, and a lease (locked_by, locked_until) updated atomically on other databases
+func StartJobs(opts ...JobsOptions) error {
+	return defaultClient.StartJobs(opts...)
+}
+
+// StartJobs is korm.StartJobs for client c
+func (c *Client) StartJobs(opts ...JobsOptions) error {
+	var o JobsOptions
+	if len(opts) > 0 {
+		o = opts[0]
+	}
+	if o.Workers <= 0 {
+		o.Workers = 4
+	}
+	if o.PollEvery <= 0 {
+		o.PollEvery = time.Second
+	}
+	if o.Lease <= 0 {
+		o.Lease = 5 * time.Minute
+	}
+	db, err := c.jobsDatabase(o.Database)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < o.Workers; i++ {
+		workerID := GenerateUUID()
+		c.goWorker("jobs worker "+strconv.Itoa(i+1)+" "+db.Name, db.Name, func(ctx context.Context) {
+			for {
+				job, err := c.claimJob(ctx, db, workerID, o.Lease)
+				if err != nil || job == nil {
+					if err != nil && ctx.Err() == nil {
+						lg.ErrorC("could not claim job", "db", db.Name, "err", err)
+					}
+					if !sleepCtx(ctx, o.PollEvery) {
+						return
+					}
+					continue
+				}
+				c.runJob(ctx, db, job, workerID, o.Lease)
+			}
+		})
+	}
+	return nil
+}
+
+// JobClaimer can be implemented by a Dialect claiming the next runnable job in a single statement, skipping jobs locked by other workers.
+// ClaimJob return the update set applied where runnable, returning cols, it take the args of set then those of runnable.
+// Jobs of other dialects are selected then claimed one at a time by a conditional update
+type JobClaimer interface {
+	ClaimJob(set, runnable, cols string) string
+}
+
+func (d postgresDialect) ClaimJob(set, runnable, cols string) string {
+	q := d.Quote
+	return set + " WHERE " + q("id") + " = (SELECT " + q("id") + " FROM " + q("_jobs") + " WHERE " + runnable +
+		" ORDER BY " + q("run_at") + " LIMIT 1 FOR UPDATE SKIP LOCKED) RETURNING " + cols
+}
+
+// claimJob lock the next runnable job for workerID until now+lease, it return nil if no job is available
+func (c *Client) claimJob(ctx context.Context, db *DatabaseEntity, workerID string, lease time.Duration) (*Job, error) {
+	names := c.jobHandlers.Keys()
+	if len(names) == 0 {
+		return nil, nil
+	}
+	d := dialectOf(db.Dialect)
+	q := d.Quote
+	now := time.Now().Unix()
+	setArgs := []any{workerID, time.Now().Add(lease).Unix()}
+	runnableArgs := []any{now, now}
+	for _, n := range names {
+		runnableArgs = append(runnableArgs, n)
+	}
+	runnable := "((" + q("status") + " = '" + JobPending + "' AND " + q("run_at") + " <= ?) OR (" +
+		q("status") + " = '" + JobRunning + "' AND " + q("locked_until") + " < ?)) AND " +
+		q("name") + " IN (" + strings.TrimSuffix(strings.Repeat("?,", len(names)), ",") + ")"
+	set := "UPDATE " + q("_jobs") + " SET " + q("status") + " = '" + JobRunning + "', " + q("locked_by") + " = ?, " +
+		q("locked_until") + " = ?, " + q("attempts") + " = " + q("attempts") + " + 1"
+	cols := q("id") + ", " + q("name") + ", " + q("payload") + ", " + q("attempts") + ", " + q("max_retries")
+
+	job := &Job{}
+	if jc, ok := d.(JobClaimer); ok {
+		st := jc.ClaimJob(set, runnable, cols)
+		AdaptPlaceholdersToDialect(&st, db.Dialect)
+		err := db.queryRowScan(ctx, []any{&job.Id, &job.Name, &job.Payload, &job.Attempts, &job.MaxRetries}, st, append(setArgs, runnableArgs...)...)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		st := "SELECT " + q("id") + " FROM " + q("_jobs") + " WHERE " + runnable + " ORDER BY " + q("run_at") + d.Limit(10, 0, true)
+		AdaptPlaceholdersToDialect(&st, db.Dialect)
+		rows, cancel, err := db.queryContext(ctx, db.writer == nil, st, runnableArgs...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		ids := []uint{}
+		for rows.Next() {
+			var id uint
+			if rows.Scan(&id) == nil {
+				ids = append(ids, id)
+			}
+		}
+		rows.Close()
+		cancel()
+		claim := set + " WHERE " + q("id") + " = ? AND " + runnable
+		AdaptPlaceholdersToDialect(&claim, db.Dialect)
+		claimed := uint(0)
+		for _, id := range ids {
+			args := append(append(append([]any{}, setArgs...), id), runnableArgs...)
+			res, err := db.execContext(ctx, claim, args...)
+			if err != nil {
+				return nil, err
+			}
+			// the lease is taken only if the job is still runnable, another worker may have claimed it first
+			if n, err := res.RowsAffected(); err == nil && n == 1 {
+				claimed = id
+				break
+			}
+		}
+		if claimed == 0 {
+			return nil, nil
+		}
+		st = "SELECT " + cols + " FROM " + q("_jobs") + " WHERE " + q("id") + " = ?"
+		AdaptPlaceholdersToDialect(&st, db.Dialect)
+		err = db.readConn(db.writer == nil).QueryRowContext(ctx, st, claimed).Scan(&job.Id, &job.Name, &job.Payload, &job.Attempts, &job.MaxRetries)
+		if err != nil {
+			return nil, err
+		}
+	}
+	job.Status = JobRunning
+	job.LockedBy = workerID
+	return job, nil
+}
+
+// runJob execute the handler of job, then delete it, schedule a retry or move it to _jobs_dead
+func (c *Client) runJob(ctx context.Context, db *DatabaseEntity, job *Job, workerID string, lease time.Duration) {
+	// the running job is not cancelled by Shutdown, only by its lease
+	jctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lease)
+	defer cancel()
+	var err error
+	if h, ok := c.jobHandlers.Get(job.Name); ok {
+		err = func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("job panic: %v", r)
+				}
+			}()
+			return h(jctx, job)
+		}()
+	} else {
+		err = fmt.Errorf("%w: %s", ErrNoJobHandler, job.Name)
+	}
+	q := dialectOf(db.Dialect).Quote
+	owned := " WHERE " + q("id") + " = ? AND " + q("locked_by") + " = ?"
+	bg := context.WithoutCancel(ctx)
+	del := "DELETE FROM " + q("_jobs") + owned
+	AdaptPlaceholdersToDialect(&del, db.Dialect)
+	if err == nil {
+		_, err := db.execContext(bg, del, job.Id, workerID)
+		lg.CheckError(err)
+		return
+	}
+	if job.Attempts > job.MaxRetries {
+		lg.ErrorC("job failed, moving it to _jobs_dead", "job", job.Name, "id", job.Id, "err", err)
+		created := time.Now()
+		if j, e := ModelOn[Job](c).Database(db.Name).NoCache().Where("id = ?", job.Id).One(); e == nil {
+			created = j.CreatedAt
+		}
+		_, e := ModelOn[DeadJob](c).Database(db.Name).Context(bg).Insert(&DeadJob{
+			JobId:      job.Id,
+			Name:       job.Name,
+			Payload:    job.Payload,
+			Attempts:   job.Attempts,
+			MaxRetries: job.MaxRetries,
+			LastError:  err.Error(),
+			CreatedAt:  created,
+			FailedAt:   time.Now(),
+		})
+		if !lg.CheckError(e) {
+			_, e = db.execContext(bg, del, job.Id, workerID)
+			lg.CheckError(e)
+		}
+		return
+	}
+	retry := "UPDATE " + q("_jobs") + " SET " + q("status") + " = '" + JobPending + "', " + q("run_at") + " = ?, " +
+		q("last_error") + " = ?, " + q("locked_by") + " = ''" + owned
+	AdaptPlaceholdersToDialect(&retry, db.Dialect)
+	_, e := db.execContext(bg, retry, time.Now().Add(jobBackoff(job.Attempts)).Unix(), err.Error(), job.Id, workerID)
+	lg.CheckError(e)
+}
+
+// jobBackoff return the delay before retrying a job that failed attempts times
+func jobBackoff(attempts int) time.Duration {
+	delay := JobsRetryBase
+	for i := 1; i < attempts && delay < JobsRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > JobsRetryMax {
+		delay = JobsRetryMax
+	}
+	return delay
+}
+
+// RetryJob run a pending job now, or requeue a dead job with its attempts reset and its MaxRetries kept, dead is true for ids of _jobs_dead
+func RetryJob(id uint, dead bool, dbName ...string) error {
+	return defaultClient.RetryJob(id, dead, dbName...)
+}
+
+// RetryJob is korm.RetryJob for client c
+func (c *Client) RetryJob(id uint, dead bool, dbName ...string) error {
+	name := ""
+	if len(dbName) > 0 {
+		name = dbName[0]
+	}
+	db, err := c.jobsDatabase(name)
+	if err != nil {
+		return err
+	}
+	if !dead {
+		_, err := ModelOn[Job](c).Database(db.Name).Where("id = ? AND status = ?", id, JobPending).Set("run_at = ?", time.Now())
+		return err
+	}
+	dj, err := ModelOn[DeadJob](c).Database(db.Name).NoCache().Where("id = ?", id).One()
+	if err != nil {
+		return err
+	}
+	_, err = ModelOn[Job](c).Database(db.Name).Insert(&Job{
+		Name:        dj.Name,
+		Payload:     dj.Payload,
+		Status:      JobPending,
+		MaxRetries:  dj.MaxRetries,
+		RunAt:       time.Now(),
+		LockedUntil: time.Unix(0, 0),
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ModelOn[DeadJob](c).Database(db.Name).Where("id = ?", id).Delete()
+	return err
+}
+
+// DeleteJob delete a job from _jobs, or from _jobs_dead if dead
+func DeleteJob(id uint, dead bool, dbName ...string) error {
+	return defaultClient.DeleteJob(id, dead, dbName...)
+}
+
+// DeleteJob is korm.DeleteJob for client c
+func (c *Client) DeleteJob(id uint, dead bool, dbName ...string) error {
+	name := ""
+	if len(dbName) > 0 {
+		name = dbName[0]
+	}
+	db, err := c.jobsDatabase(name)
+	if err != nil {
+		return err
+	}
+	if dead {
+		_, err = ModelOn[DeadJob](c).Database(db.Name).Where("id = ?", id).Delete()
+	} else {
+		_, err = ModelOn[Job](c).Database(db.Name).Where("id = ?", id).Delete()
+	}
+	return err
+}
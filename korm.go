@@ -12,7 +12,6 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"sync"
 
 	"github.com/kamalshkeir/kmap"
 	"github.com/kamalshkeir/ksmux"
@@ -22,19 +21,8 @@ import (
 )
 
 var (
-	// defaultDB keep tracking of the first database connected
-	defaultDB               = ""
 	useCache                = true
 	cacheMaxMemoryMb        = 100
-	databases               = []DatabaseEntity{}
-	mutexModelTablename     sync.RWMutex
-	mModelTablename         = map[string]any{}
-	cacheAllTables          = kmap.New[string, []string]()
-	cacheAllCols            = kmap.New[string, map[string]string]()
-	cacheAllColsOrdered     = kmap.New[string, []string]()
-	relationsMap            = kmap.New[string, struct{}]()
-	serverBus               *ksps.ServerBus
-	cacheQ                  = kmap.New[string, any](cacheMaxMemoryMb)
 	ErrTableNotFound        = errors.New("unable to find tableName")
 	ErrBigData              = kmap.ErrLargeData
 	logQueries              = false
@@ -58,82 +46,57 @@ var (
 //	  korm.New(korm.SQLITE, "db", sqlitedriver.Use(),"?disable_wal")
 //	  korm.New(korm.MYSQL,"dbName", mysqldriver.Use(), "user:password@localhost:3333")
 //	  korm.New(korm.POSTGRES,"dbName", pgdriver.Use(), "user:password@localhost:5432")
-func New(dbType Dialect, dbName string, dbDriver driver.Driver, dbDSN ...string) error {
-	var dsn string
+func New(dbType string, dbName string, dbDriver driver.Driver, dbDSN ...string) error {
+	return defaultClient.New(dbType, dbName, dbDriver, dbDSN...)
+}
+
+// New is korm.New for client c
+func (c *Client) New(dbType string, dbName string, dbDriver driver.Driver, dbDSN ...string) error {
+	return c.NewWithOptions(dbType, dbName, dbDriver, DbOptions{}, dbDSN...)
+}
+
+// NewWithOptions same as New but with per database pool sizes, statement timeout, sqlite pragmas and retry policy
+//
+//	Example:
+//	  korm.NewWithOptions(korm.POSTGRES, "dbName", pgdriver.Use(), korm.DbOptions{
+//	      MaxOpenConns:     20,
+//	      StatementTimeout: 5 * time.Second,
+//	      Retry:            &korm.RetryPolicy{MaxAttempts: 5, InitialBackoff: 50 * time.Millisecond},
+//	  }, "user:password@localhost:5432")
+//	  korm.NewWithOptions(korm.SQLITE, "db", sqlitedriver.Use(), korm.DbOptions{
+//	      SqlitePragmas: []string{"busy_timeout = 5000"},
+//	  })
+func NewWithOptions(dbType string, dbName string, dbDriver driver.Driver, opts DbOptions, dbDSN ...string) error {
+	return defaultClient.NewWithOptions(dbType, dbName, dbDriver, opts, dbDSN...)
+}
+
+// NewWithOptions is korm.NewWithOptions for client c
+func (c *Client) NewWithOptions(dbType string, dbName string, dbDriver driver.Driver, opts DbOptions, dbDSN ...string) error {
 	if dbDriver == nil {
 		err := fmt.Errorf("New expect a dbDriver, you can use sqlitedriver.Use that return a driver.Driver")
 		lg.ErrorC(err.Error())
 		return err
 	}
-	if defaultDB == "" {
-		defaultDB = dbName
+	if c.defaultDB == "" {
+		c.defaultDB = dbName
 	}
-	options := ""
-	if len(dbDSN) > 0 {
-		if strings.Contains(dbDSN[0], "?") {
-			sp := strings.Split(dbDSN[0], "?")
-			dbDSN[0] = sp[0]
-			options = sp[1]
-		}
-	}
-	switch dbType {
-	case POSTGRES, COCKROACH:
-		dbType = POSTGRES
-		if len(dbDSN) == 0 {
-			return errors.New("dbDSN for mysql cannot be empty")
-		}
-		dsn = "postgres://" + dbDSN[0] + "/" + dbName
-		if options != "" {
-			dsn += "?" + options
-		} else {
-			dsn += "?sslmode=disable"
-		}
-	case MYSQL, MARIA:
-		dbType = MYSQL
-		if len(dbDSN) == 0 {
-			return errors.New("dbDSN for mysql cannot be empty")
-		}
-		if strings.Contains(dbDSN[0], "tcp(") {
-			dsn = dbDSN[0] + "/" + dbName
-		} else {
-			split := strings.Split(dbDSN[0], "@")
-			if len(split) > 2 {
-				return errors.New("there is 2 or more @ symbol in dsn")
-			}
-			dsn = split[0] + "@" + "tcp(" + split[1] + ")/" + dbName
-		}
-		if options != "" {
-			dsn += "?" + options
-		}
-	case SQLITE:
-		if dsn == "" {
-			dsn = "db.sqlite3"
-		}
-		if !strings.Contains(dbName, SQLITE) {
-			dsn = dbName + ".sqlite3"
-		} else {
-			dsn = dbName
-		}
-		if options != "" {
-			dsn += "?" + options
-		}
-	default:
-		dbType = "sqlite3"
-		lg.ErrorC("not handled, choices are: postgres,mysql,sqlite3,maria,cockroach", "dbType", dbType)
-		dsn = dbName + ".sqlite3"
-		if dsn == "" {
-			dsn = "db.sqlite3"
-		}
-		if options != "" {
-			dsn += "?" + options
-		}
+	dbType, dsn, options, err := buildDSN(dbType, dbName, dbDSN...)
+	if err != nil {
+		return err
 	}
 
 	cstm := GenerateUUID()
+	var connInit []string
+	if dbType == SQLITE {
+		for _, p := range opts.SqlitePragmas {
+			connInit = append(connInit, "PRAGMA "+strings.TrimPrefix(strings.TrimSpace(p), "PRAGMA "))
+		}
+	}
+	// drivers are always wrapped so query hooks added by UseQueryHooks after New run too
 	if useCache {
-		sql.Register(cstm, Wrap(dbDriver, &logAndCacheHook{}))
+		sql.Register(cstm, &Driver{Driver: dbDriver, hooks: c.queryHooksFor(dbName, &logAndCacheHook{c: c, db: dbName}), connInit: connInit})
 	} else {
-		sql.Register(cstm, dbDriver)
+		sql.Register(cstm, &Driver{Driver: dbDriver, hooks: c.queryHooksFor(dbName, noopHooks{}), connInit: connInit})
 	}
 
 	conn, err := sql.Open(cstm, dsn)
@@ -173,37 +136,148 @@ func New(dbType Dialect, dbName string, dbDriver driver.Driver, dbDSN ...string)
 		if err != nil {
 			lg.ErrorC("failed to enable sqlite pragmas", "err", err)
 		}
+		if opts.MaxOpenConns > 0 {
+			conn.SetMaxOpenConns(opts.MaxOpenConns)
+		}
 	} else {
-		conn.SetMaxOpenConns(MaxOpenConns)
+		if opts.MaxOpenConns <= 0 {
+			opts.MaxOpenConns = MaxOpenConns
+		}
+		conn.SetMaxOpenConns(opts.MaxOpenConns)
 	}
 	dbFound := false
-	for _, dbb := range databases {
+	for _, dbb := range c.databases {
 		if dbb.Name == dbName {
 			dbFound = true
 		}
 	}
 
-	conn.SetMaxIdleConns(MaxIdleConns)
-	conn.SetConnMaxLifetime(MaxLifetime)
-	conn.SetConnMaxIdleTime(MaxIdleTime)
+	if opts.MaxIdleConns <= 0 {
+		opts.MaxIdleConns = MaxIdleConns
+	}
+	if opts.MaxLifetime <= 0 {
+		opts.MaxLifetime = MaxLifetime
+	}
+	if opts.MaxIdleTime <= 0 {
+		opts.MaxIdleTime = MaxIdleTime
+	}
+	conn.SetMaxIdleConns(opts.MaxIdleConns)
+	conn.SetConnMaxLifetime(opts.MaxLifetime)
+	conn.SetConnMaxIdleTime(opts.MaxIdleTime)
 
 	if !dbFound {
-		databases = append(databases, DatabaseEntity{
-			Name:    dbName,
-			Conn:    conn,
-			Dialect: dbType,
-			Tables:  []TableEntity{},
+		c.databases = append(c.databases, DatabaseEntity{
+			Name:     dbName,
+			Conn:     conn,
+			Dialect:  dbType,
+			Tables:   []TableEntity{},
+			Options:  opts,
+			replicas: &replicaSet{},
+			client:   c,
 		})
 	}
-	err = AutoMigrate[TablesInfos]("_tables_infos", dbName)
+	if dbType == SQLITE && opts.SqliteSingleWriter {
+		db, err := c.GetMemoryDatabase(dbName)
+		if err != nil {
+			return err
+		}
+		if db.writer == nil {
+			if err := openSqliteSingleWriter(db, dbDriver, dsn, connInit, opts); err != nil {
+				lg.ErrorC("failed to open sqlite read pool", "err", err)
+				return err
+			}
+		}
+	}
+	err = AutoMigrateOn[TablesInfos](c, "_tables_infos", dbName)
 	lg.CheckError(err)
-	err = AutoMigrate[TriggersQueue]("_triggers_queue", dbName)
+	err = AutoMigrateOn[TriggersQueue](c, "_triggers_queue", dbName)
 	lg.CheckError(err)
 
-	initCacheHooks()
+	c.cacheHooksOnce.Do(c.initCacheHooks)
+	c.started()
 	return nil
 }
 
+// buildDSN return the dialect, the dsn and the options used to open dbName
+func buildDSN(dbType string, dbName string, dbDSN ...string) (string, string, string, error) {
+	var dsn string
+	options := ""
+	if len(dbDSN) > 0 {
+		if strings.Contains(dbDSN[0], "?") {
+			sp := strings.Split(dbDSN[0], "?")
+			dbDSN[0] = sp[0]
+			options = sp[1]
+		}
+	}
+	switch dbType {
+	case POSTGRES, COCKROACH:
+		if len(dbDSN) == 0 {
+			return dbType, "", "", errors.New("dbDSN for mysql cannot be empty")
+		}
+		dsn = "postgres://" + dbDSN[0] + "/" + dbName
+		if options != "" {
+			dsn += "?" + options
+		} else {
+			dsn += "?sslmode=disable"
+		}
+	case MYSQL, MARIA:
+		if len(dbDSN) == 0 {
+			return dbType, "", "", errors.New("dbDSN for mysql cannot be empty")
+		}
+		if strings.Contains(dbDSN[0], "tcp(") {
+			dsn = dbDSN[0] + "/" + dbName
+		} else {
+			split := strings.Split(dbDSN[0], "@")
+			if len(split) > 2 {
+				return dbType, "", "", errors.New("there is 2 or more @ symbol in dsn")
+			}
+			dsn = split[0] + "@" + "tcp(" + split[1] + ")/" + dbName
+		}
+		if options != "" {
+			dsn += "?" + options
+		}
+	case MSSQL:
+		if len(dbDSN) == 0 {
+			return dbType, "", "", errors.New("dbDSN for sqlserver cannot be empty")
+		}
+		dsn = "sqlserver://" + dbDSN[0] + "?database=" + dbName
+		if options != "" {
+			dsn += "&" + options
+		}
+	case SQLITE:
+		if dsn == "" {
+			dsn = "db.sqlite3"
+		}
+		if !strings.Contains(dbName, SQLITE) {
+			dsn = dbName + ".sqlite3"
+		} else {
+			dsn = dbName
+		}
+		if options != "" {
+			dsn += "?" + options
+		}
+	default:
+		if _, ok := GetDialect(dbType); ok && len(dbDSN) > 0 {
+			// registered dialect, the dsn is used as is
+			dsn = dbDSN[0]
+			if options != "" {
+				dsn += "?" + options
+			}
+			return dbType, dsn, options, nil
+		}
+		dbType = "sqlite3"
+		lg.ErrorC("not handled, choices are: postgres,mysql,sqlite3,maria,cockroach or a dialect registered using RegisterDialect", "dbType", dbType)
+		dsn = dbName + ".sqlite3"
+		if dsn == "" {
+			dsn = "db.sqlite3"
+		}
+		if options != "" {
+			dsn += "?" + options
+		}
+	}
+	return dbType, dsn, options, nil
+}
+
 // WithShell enable shell, go run main.go shell
 // make sure you put it AFTER WithDashboard if dash used
 func WithShell() {
@@ -215,12 +289,17 @@ func WithShell() {
 
 // ManyToMany create m2m_table1_table2 many 2 many table
 func ManyToMany(table1, table2 string, dbName ...string) error {
+	return defaultClient.ManyToMany(table1, table2, dbName...)
+}
+
+// ManyToMany is korm.ManyToMany for client c
+func (c *Client) ManyToMany(table1, table2 string, dbName ...string) error {
 	var err error
-	mdbName := databases[0].Name
+	mdbName := c.databases[0].Name
 	if len(dbName) > 0 {
 		mdbName = dbName[0]
 	}
-	dben, err := GetMemoryDatabase(mdbName)
+	dben, err := c.GetMemoryDatabase(mdbName)
 	if err != nil {
 		return fmt.Errorf("database not found:%v", err)
 	}
@@ -229,14 +308,14 @@ func ManyToMany(table1, table2 string, dbName ...string) error {
 	autoinc := ""
 
 	defer func() {
-		relationsMap.Set("m2m_"+table1+"-"+mdbName+"-"+table2, struct{}{})
+		c.relationsMap.Set("m2m_"+table1+"-"+mdbName+"-"+table2, struct{}{})
 	}()
 
-	if _, ok := relationsMap.Get("m2m_" + table1 + "-" + mdbName + "-" + table2); ok {
+	if _, ok := c.relationsMap.Get("m2m_" + table1 + "-" + mdbName + "-" + table2); ok {
 		return nil
 	}
 
-	tables := GetAllTables(mdbName)
+	tables := c.GetAllTables(mdbName)
 	if len(tables) == 0 {
 		return fmt.Errorf("databse is empty: %v", tables)
 	}
@@ -245,15 +324,10 @@ func ManyToMany(table1, table2 string, dbName ...string) error {
 			return nil
 		}
 	}
-	switch dben.Dialect {
-	case SQLITE, "":
-		autoinc = "INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT"
-	case POSTGRES, COCKROACH:
-		autoinc = "SERIAL NOT NULL PRIMARY KEY"
-	case MYSQL, MARIA:
-		autoinc = "INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT"
-	default:
-		lg.ErrorC("allowed dialects: dialect can be sqlite3, postgres, cockroach or mysql,maria only")
+	if d, ok := GetDialect(dben.Dialect); ok {
+		autoinc = d.AutoIncrementPK()
+	} else {
+		lg.ErrorC("dialect not registered", "dialect", dben.Dialect)
 	}
 
 	fkeys = append(fkeys, foreignkeyStat(table1+"_id", table1, "cascade", "cascade"))
@@ -272,7 +346,7 @@ func ManyToMany(table1, table2 string, dbName ...string) error {
 	if Debug {
 		lg.Printfs("yl%s\n", st)
 	}
-	err = Exec(dben.Name, st)
+	err = c.Exec(dben.Name, st)
 	if err != nil {
 		return err
 	}
@@ -291,15 +365,20 @@ func ManyToMany(table1, table2 string, dbName ...string) error {
 
 // WithBus return ksbus.NewServer() that can be Run, RunTLS, RunAutoTLS
 func WithBus(config ...ksmux.Config) *ksps.ServerBus {
-	if serverBus == nil {
-		serverBus = ksps.NewServer(config...)
-		if strings.HasPrefix(serverBus.App().Address(), ":") {
-			serverBus.App().Config.Address = "localhost" + serverBus.App().Address()
+	return defaultClient.WithBus(config...)
+}
+
+// WithBus is korm.WithBus for client c
+func (c *Client) WithBus(config ...ksmux.Config) *ksps.ServerBus {
+	if c.serverBus == nil {
+		c.serverBus = ksps.NewServer(config...)
+		if strings.HasPrefix(c.serverBus.App().Address(), ":") {
+			c.serverBus.App().Config.Address = "localhost" + c.serverBus.App().Address()
 		}
 	} else {
 		lg.DebugC("another bus already registered, returning it")
 	}
-	return serverBus
+	return c.serverBus
 }
 
 type DashOpts struct {
@@ -317,6 +396,10 @@ type DashOpts struct {
 	RepoUser           string // default kamalshkeir
 	RepoName           string // default korm-dash
 	WithKanban         bool   // add kanban to the dashboard
+	WithJobs           bool   // add jobs page to inspect, retry and delete background jobs
+	WithSchedules      bool   // add schedules page showing cron schedules and their runs history
+	WithWebhooks       bool   // add webhooks page to manage endpoints and inspect, redeliver deliveries
+	WithSlowQueries    bool   // add slow queries page listing queries slower than SlowQueryThreshold with their plan
 	WithTracing        bool   // add tracing handling page in dash and enable tracing
 	WithTerminal       bool   // add terminal session handling page in dash
 	WithNodeManager    bool   // add node manager handling page in dash
@@ -364,7 +447,7 @@ func WithDashboard(addr string, options ...DashOpts) *ksps.ServerBus {
 			}
 			adminPathNameGroup = opts.Path
 		}
-		if serverBus == nil {
+		if defaultClient.serverBus == nil {
 			if opts.ServerOpts != nil {
 				if addr != "" && opts.ServerOpts.Address != addr {
 					if strings.HasPrefix(addr, ":") {
@@ -395,19 +478,31 @@ func WithDashboard(addr string, options ...DashOpts) *ksps.ServerBus {
 		}
 	}
 
-	if serverBus == nil {
+	if defaultClient.serverBus == nil {
 		if opts != nil {
-			serverBus = WithBus(*opts.ServerOpts)
+			defaultClient.serverBus = WithBus(*opts.ServerOpts)
 		} else {
-			serverBus = WithBus()
+			defaultClient.serverBus = WithBus()
 		}
 	}
 	if len(opts.FuncMaps) > 0 {
-		serverBus.App().NewFuncMap(opts.FuncMaps)
+		defaultClient.serverBus.App().NewFuncMap(opts.FuncMaps)
 	}
 	if opts != nil && opts.WithKanban {
 		kanbanUIEnabled = true
 	}
+	if opts != nil && opts.WithJobs {
+		jobsUIEnabled = true
+	}
+	if opts != nil && opts.WithSchedules {
+		schedulesUIEnabled = true
+	}
+	if opts != nil && opts.WithWebhooks {
+		webhooksUIEnabled = true
+	}
+	if opts != nil && opts.WithSlowQueries {
+		slowQueryUIEnabled = true
+	}
 	cloneAndMigrateDashboard(staticAndTemplatesEmbeded...)
 
 	reqqCounter := false
@@ -421,10 +516,10 @@ func WithDashboard(addr string, options ...DashOpts) *ksps.ServerBus {
 	if opts != nil && opts.WithTerminal {
 		terminalUIEnabled = true
 	}
-	if opts != nil && opts.WithNodeManager && nodeManager == nil {
+	if opts != nil && opts.WithNodeManager && defaultClient.nodeManager == nil {
 		WithNodeManager()
 	}
-	initAdminUrlPatterns(reqqCounter, serverBus.App())
+	initAdminUrlPatterns(reqqCounter, defaultClient.serverBus.App())
 	if len(os.Args) == 1 {
 		const razor = `
                                __
@@ -436,14 +531,14 @@ func WithDashboard(addr string, options ...DashOpts) *ksps.ServerBus {
 `
 		lg.Printfs("yl%s\n", razor)
 	}
-	return serverBus
+	return defaultClient.serverBus
 }
 
 // WithDocs enable swagger docs at DocsUrl default to '/docs/'
 func WithDocs(generateJsonDocs bool, outJsonDocs string, handlerMiddlewares ...func(handler ksmux.Handler) ksmux.Handler) *ksps.ServerBus {
-	if serverBus == nil {
+	if defaultClient.serverBus == nil {
 		lg.DebugC("using default bus :9313")
-		serverBus = WithBus()
+		defaultClient.serverBus = WithBus()
 	}
 
 	if outJsonDocs != "" {
@@ -453,7 +548,7 @@ func WithDocs(generateJsonDocs bool, outJsonDocs string, handlerMiddlewares ...f
 	}
 
 	// check swag install and init docs.Routes slice
-	serverBus.App().WithDocs(generateJsonDocs)
+	defaultClient.serverBus.App().WithDocs(generateJsonDocs)
 	webPath := docsUrl
 	if webPath[0] != '/' {
 		webPath = "/" + webPath
@@ -467,15 +562,15 @@ func WithDocs(generateJsonDocs bool, outJsonDocs string, handlerMiddlewares ...f
 			handler = mid(handler)
 		}
 	}
-	serverBus.App().Get(webPath+"/*path", handler)
-	return serverBus
+	defaultClient.serverBus.App().Get(webPath+"/*path", handler)
+	return defaultClient.serverBus
 }
 
 // WithEmbededDocs same as WithDocs but embeded, enable swagger docs at DocsUrl default to '/docs/'
 func WithEmbededDocs(embeded embed.FS, embededDirPath string, handlerMiddlewares ...func(handler ksmux.Handler) ksmux.Handler) *ksps.ServerBus {
-	if serverBus == nil {
+	if defaultClient.serverBus == nil {
 		lg.DebugC("using default bus :9313")
-		serverBus = WithBus()
+		defaultClient.serverBus = WithBus()
 	}
 	if embededDirPath != "" {
 		ksmux.DocsOutJson = embededDirPath
@@ -492,7 +587,7 @@ func WithEmbededDocs(embeded embed.FS, embededDirPath string, handlerMiddlewares
 	toembed_dir, err := fs.Sub(embeded, ksmux.DocsOutJson)
 	if err != nil {
 		lg.ErrorC("rdServeEmbededDir error", "err", err)
-		return serverBus
+		return defaultClient.serverBus
 	}
 	toembed_root := http.FileServer(http.FS(toembed_dir))
 	handler := func(c *ksmux.Context) {
@@ -503,37 +598,79 @@ func WithEmbededDocs(embeded embed.FS, embededDirPath string, handlerMiddlewares
 			handler = mid(handler)
 		}
 	}
-	serverBus.App().Get(webPath+"/*path", handler)
-	return serverBus
+	defaultClient.serverBus.App().Get(webPath+"/*path", handler)
+	return defaultClient.serverBus
 }
 
 // WithMetrics enable path /metrics (default), it take http.Handler like promhttp.Handler()
 func WithMetrics(httpHandler http.Handler) *ksps.ServerBus {
-	if serverBus == nil {
+	if defaultClient.serverBus == nil {
 		lg.DebugC("using default bus :9313")
-		serverBus = WithBus()
-		serverBus.App().WithMetrics(httpHandler)
-		return serverBus
+		defaultClient.serverBus = WithBus()
+		defaultClient.serverBus.App().WithMetrics(httpHandler)
+		return defaultClient.serverBus
 	}
-	serverBus.App().WithMetrics(httpHandler)
-	return serverBus
+	defaultClient.serverBus.App().WithMetrics(httpHandler)
+	return defaultClient.serverBus
 }
 
 // WithPprof enable std library pprof at /debug/pprof, prefix default to 'debug'
 func WithPprof(path ...string) *ksps.ServerBus {
-	if serverBus == nil {
+	if defaultClient.serverBus == nil {
 		lg.DebugC("using default bus :9313")
-		serverBus = WithBus()
-		serverBus.WithPprof(path...)
-		return serverBus
+		defaultClient.serverBus = WithBus()
+		defaultClient.serverBus.WithPprof(path...)
+		return defaultClient.serverBus
 	}
-	serverBus.WithPprof(path...)
-	return serverBus
+	defaultClient.serverBus.WithPprof(path...)
+	return defaultClient.serverBus
 }
 
 // Transaction create new database/sql transaction and return it, it can be rollback ...
 func Transaction(dbName ...string) (*sql.Tx, error) {
-	return GetConnection(dbName...).Begin()
+	return defaultClient.Transaction(dbName...)
+}
+
+// Transaction is korm.Transaction for client c
+func (c *Client) Transaction(dbName ...string) (*sql.Tx, error) {
+	return c.GetConnection(dbName...).Begin()
+}
+
+// RunInTransaction run fn in a transaction committed if fn return nil, the whole transaction is retried using the database
+// retry policy on serialization failures like cockroach 40001 errors, so fn should not have side effects outside tx.
+// On sqlite databases using SqliteSingleWriter the transaction hold the writer connection, other writes fail with ErrWriterInTransaction
+// until it end, so writes inside fn must use tx
+func RunInTransaction(ctx context.Context, fn func(tx *sql.Tx) error, dbName ...string) error {
+	return defaultClient.RunInTransaction(ctx, fn, dbName...)
+}
+
+// RunInTransaction is korm.RunInTransaction for client c
+func (c *Client) RunInTransaction(ctx context.Context, fn func(tx *sql.Tx) error, dbName ...string) error {
+	name := c.defaultDB
+	if len(dbName) > 0 {
+		name = dbName[0]
+	}
+	db, err := c.GetMemoryDatabase(name)
+	if err != nil {
+		return err
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return db.Options.retryPolicy().do(ctx, func() error {
+		if db.writer != nil {
+			defer db.writer.hold()()
+		}
+		tx, err := db.Conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := fn(tx); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	})
 }
 
 // FlushCache send msg to the cache system to Flush all the cache, safe to use in concurrent mode, and safe to use in general, flushed every (korm.FlushCacheEvery)
@@ -548,17 +685,22 @@ func DisableCache() {
 
 // GetConnection get connection of dbName, if not specified , it return default, first database connected
 func GetConnection(dbName ...string) *sql.DB {
+	return defaultClient.GetConnection(dbName...)
+}
+
+// GetConnection is korm.GetConnection for client c
+func (c *Client) GetConnection(dbName ...string) *sql.DB {
 	var name string
 	var db *DatabaseEntity
 	if len(dbName) > 0 {
 		var err error
-		db, err = GetMemoryDatabase(dbName[0])
+		db, err = c.GetMemoryDatabase(dbName[0])
 		if lg.CheckError(err) {
 			return nil
 		}
 	} else {
-		name = databases[0].Name
-		db = &databases[0]
+		name = c.databases[0].Name
+		db = &c.databases[0]
 	}
 
 	if db.Conn == nil {
@@ -569,19 +711,24 @@ func GetConnection(dbName ...string) *sql.DB {
 
 // GetAllTables get all tables for the optional dbName given, otherwise, if not args, it will return tables of the first connected database
 func GetAllTables(dbName ...string) []string {
+	return defaultClient.GetAllTables(dbName...)
+}
+
+// GetAllTables is korm.GetAllTables for client c
+func (c *Client) GetAllTables(dbName ...string) []string {
 	var name string
 	if len(dbName) == 0 {
-		name = databases[0].Name
+		name = c.databases[0].Name
 	} else {
 		name = dbName[0]
 	}
 
-	db, err := GetMemoryDatabase(name)
+	db, err := c.GetMemoryDatabase(name)
 	if err != nil {
 		return nil
 	}
 	if useCache {
-		if v, ok := cacheAllTables.Get(name); ok {
+		if v, ok := c.cacheAllTables.Get(name); ok {
 			if len(v) == len(db.Tables) {
 				return v
 			}
@@ -590,54 +737,23 @@ func GetAllTables(dbName ...string) []string {
 
 	tables := []string{}
 
-	switch db.Dialect {
-	case POSTGRES:
-		rows, err := db.Conn.Query(`select tablename FROM pg_catalog.pg_tables WHERE schemaname NOT IN ('pg_catalog','information_schema','crdb_internal','pg_extension') AND tableowner != 'node'`)
-		if lg.CheckError(err) {
-			return nil
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var table string
-			err := rows.Scan(&table)
-			if lg.CheckError(err) {
-				return nil
-			}
-			tables = append(tables, table)
-		}
-	case MYSQL, MARIA:
-		rows, err := db.Conn.Query("SELECT table_name,table_schema FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_TYPE = 'BASE TABLE' AND table_schema ='" + name + "'")
-		if lg.CheckError(err) {
-			return nil
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var table string
-			var table_schema string
-			err := rows.Scan(&table, &table_schema)
-			if lg.CheckError(err) {
-				return nil
-			}
-			tables = append(tables, table)
-		}
-	case SQLITE, "":
-		rows, err := db.Conn.Query(`select name FROM sqlite_master WHERE type ='table' AND name NOT LIKE 'sqlite_%';`)
+	d, ok := GetDialect(db.Dialect)
+	if !ok {
+		lg.ErrorC("database type not supported, register it using RegisterDialect", "dialect", db.Dialect)
+		return nil
+	}
+	rows, err := db.Conn.Query(d.TablesQuery(name))
+	if lg.CheckError(err) {
+		return nil
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		err := rows.Scan(&table)
 		if lg.CheckError(err) {
 			return nil
 		}
-		defer rows.Close()
-
-		for rows.Next() {
-			var table string
-			err := rows.Scan(&table)
-			if lg.CheckError(err) {
-				return nil
-			}
-			tables = append(tables, table)
-		}
-	default:
-		lg.ErrorC("database type not supported, should be sqlite3, postgres, cockroach, maria or mysql")
-		return nil
+		tables = append(tables, table)
 	}
 
 	for i := len(tables) - 1; i >= 0; i-- {
@@ -647,67 +763,39 @@ func GetAllTables(dbName ...string) []string {
 		}
 	}
 	if useCache && len(tables) > 0 {
-		cacheAllTables.Set(name, tables)
+		c.cacheAllTables.Set(name, tables)
 	}
 	return tables
 }
 
 // GetAllColumnsTypes get columns and types from the database
 func GetAllColumnsTypes(table string, dbName ...string) (map[string]string, []string) {
-	dName := databases[0].Name
+	return defaultClient.GetAllColumnsTypes(table, dbName...)
+}
+
+// GetAllColumnsTypes is korm.GetAllColumnsTypes for client c
+func (c *Client) GetAllColumnsTypes(table string, dbName ...string) (map[string]string, []string) {
+	dName := c.databases[0].Name
 	if len(dbName) > 0 {
 		dName = dbName[0]
 	}
 	if useCache {
-		if v, ok := cacheAllCols.Get(dName + table); ok {
-			if vv, ok := cacheAllColsOrdered.Get(dName + table); ok {
+		if v, ok := c.cacheAllCols.Get(dName + table); ok {
+			if vv, ok := c.cacheAllColsOrdered.Get(dName + table); ok {
 				return v, vv
 			}
 		}
 	}
 
-	db, err := GetMemoryDatabase(dName)
+	db, err := c.GetMemoryDatabase(dName)
 	if err != nil {
 		return nil, nil
 	}
 
-	var statement string
 	colsSlice := []string{}
 	columns := map[string]string{}
-	switch db.Dialect {
-	case POSTGRES:
-		statement = "select column_name,data_type FROM information_schema.columns WHERE table_name = '" + table + "'"
-	case MYSQL, MARIA:
-		statement = "select column_name,data_type FROM information_schema.columns WHERE table_name = '" + table + "' AND TABLE_SCHEMA = '" + db.Name + "'"
-	default:
-		statement = "pragma table_info(" + table + ");"
-		row, err := db.Conn.Query(statement)
-		if lg.CheckError(err) {
-			return nil, nil
-		}
-		defer row.Close()
-		var num int
-		var singleColName string
-		var singleColType string
-		var fake1 int
-		var fake2 any
-		var fake3 int
-		for row.Next() {
-			err := row.Scan(&num, &singleColName, &singleColType, &fake1, &fake2, &fake3)
-			if lg.CheckError(err) {
-				return nil, nil
-			}
-			columns[singleColName] = singleColType
-			colsSlice = append(colsSlice, singleColName)
-		}
-		if useCache {
-			cacheAllCols.Set(dName+table, columns)
-		}
-		return columns, colsSlice
-	}
-
+	statement := dialectOf(db.Dialect).ColumnsQuery(table, db.Name)
 	row, err := db.Conn.Query(statement)
-
 	if lg.CheckError(err) {
 		return nil, nil
 	}
@@ -723,41 +811,48 @@ func GetAllColumnsTypes(table string, dbName ...string) (map[string]string, []st
 		colsSlice = append(colsSlice, singleColName)
 	}
 	if useCache {
-		cacheAllCols.Set(dName+table, columns)
-		cacheAllColsOrdered.Set(dName+table, colsSlice)
+		c.cacheAllCols.Set(dName+table, columns)
+		c.cacheAllColsOrdered.Set(dName+table, colsSlice)
 	}
 	return columns, colsSlice
 }
 
-// Shutdown shutdown many database
+// Shutdown shutdown many database, their background workers are stopped before closing pools, without dbNames it is korm.ShutdownContext using korm.ShutdownTimeout
 func Shutdown(dbNames ...string) error {
-	if len(dbNames) > 0 {
-		for _, db := range databases {
-			if SliceContains(dbNames, db.Name) {
-				if err := db.Conn.Close(); err != nil {
-					return err
-				}
-			}
-		}
-		return nil
-	} else {
-		for i := range databases {
-			if err := databases[i].Conn.Close(); err != nil {
+	return defaultClient.Shutdown(dbNames...)
+}
+
+// Shutdown is korm.Shutdown for client c
+func (c *Client) Shutdown(dbNames ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if len(dbNames) == 0 {
+		return c.ShutdownContext(ctx)
+	}
+	lg.CheckError(c.stopWorkers(ctx, dbNames...))
+	for i := range c.databases {
+		if SliceContains(dbNames, c.databases[i].Name) {
+			if err := c.databases[i].close(); err != nil {
 				return err
 			}
 		}
-		return nil
 	}
+	return nil
 }
 
 // Exec exec sql and return error if any
 func Exec(dbName, query string, args ...any) error {
-	conn := GetConnection(dbName)
-	if conn == nil {
+	return defaultClient.Exec(dbName, query, args...)
+}
+
+// Exec is korm.Exec for client c
+func (c *Client) Exec(dbName, query string, args ...any) error {
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil || db.Conn == nil {
 		return errors.New("no connection found")
 	}
 	adaptTimeToUnixArgs(&args)
-	_, err := conn.Exec(query, args...)
+	_, err = db.execContext(context.Background(), query, args...)
 	if err != nil {
 		return err
 	}
@@ -766,12 +861,17 @@ func Exec(dbName, query string, args ...any) error {
 
 // ExecContext exec sql and return error if any
 func ExecContext(ctx context.Context, dbName, query string, args ...any) error {
-	conn := GetConnection(dbName)
-	if conn == nil {
+	return defaultClient.ExecContext(ctx, dbName, query, args...)
+}
+
+// ExecContext is korm.ExecContext for client c
+func (c *Client) ExecContext(ctx context.Context, dbName, query string, args ...any) error {
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil || db.Conn == nil {
 		return errors.New("no connection found")
 	}
 	adaptTimeToUnixArgs(&args)
-	_, err := conn.ExecContext(ctx, query, args...)
+	_, err = db.execContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -780,9 +880,14 @@ func ExecContext(ctx context.Context, dbName, query string, args ...any) error {
 
 // ExecNamed exec named sql and return error if any
 func ExecNamed(query string, args map[string]any, dbName ...string) error {
-	db := databases[0]
+	return defaultClient.ExecNamed(query, args, dbName...)
+}
+
+// ExecNamed is korm.ExecNamed for client c
+func (c *Client) ExecNamed(query string, args map[string]any, dbName ...string) error {
+	db := c.databases[0]
 	if len(dbName) > 0 && dbName[0] != "" {
-		dbb, err := GetMemoryDatabase(dbName[0])
+		dbb, err := c.GetMemoryDatabase(dbName[0])
 		if err != nil {
 			return errors.New("no connection found")
 		}
@@ -792,7 +897,7 @@ func ExecNamed(query string, args map[string]any, dbName ...string) error {
 	if err != nil {
 		return err
 	}
-	_, err = db.Conn.Exec(q, newargs...)
+	_, err = db.execContext(context.Background(), q, newargs...)
 	if err != nil {
 		return err
 	}
@@ -801,9 +906,14 @@ func ExecNamed(query string, args map[string]any, dbName ...string) error {
 
 // ExecContextNamed exec named sql and return error if any
 func ExecContextNamed(ctx context.Context, query string, args map[string]any, dbName ...string) error {
-	db := databases[0]
+	return defaultClient.ExecContextNamed(ctx, query, args, dbName...)
+}
+
+// ExecContextNamed is korm.ExecContextNamed for client c
+func (c *Client) ExecContextNamed(ctx context.Context, query string, args map[string]any, dbName ...string) error {
+	db := c.databases[0]
 	if len(dbName) > 0 && dbName[0] != "" {
-		dbb, err := GetMemoryDatabase(dbName[0])
+		dbb, err := c.GetMemoryDatabase(dbName[0])
 		if err != nil {
 			return errors.New("no connection found")
 		}
@@ -813,7 +923,7 @@ func ExecContextNamed(ctx context.Context, query string, args map[string]any, db
 	if err != nil {
 		return err
 	}
-	_, err = db.Conn.ExecContext(ctx, q, newargs...)
+	_, err = db.execContext(ctx, q, newargs...)
 	if err != nil {
 		return err
 	}
@@ -823,9 +933,13 @@ func ExecContextNamed(ctx context.Context, query string, args map[string]any, db
 type KV kstrct.KV
 
 func getTableName[T any]() string {
-	mutexModelTablename.RLock()
-	defer mutexModelTablename.RUnlock()
-	for k, v := range mModelTablename {
+	return getTableNameOn[T](defaultClient)
+}
+
+func getTableNameOn[T any](c *Client) string {
+	c.mutexModelTablename.RLock()
+	defer c.mutexModelTablename.RUnlock()
+	for k, v := range c.mModelTablename {
 		if _, ok := v.(T); ok {
 			return k
 		} else if _, ok := v.(*T); ok {
@@ -0,0 +1,228 @@
+package korm
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+var (
+	// KVExpireEvery is the interval between deletions of expired keys of _kv tables
+	KVExpireEvery = time.Minute
+	// KVIncrementRetries is the number of compare and swap attempts made by Increment under contention
+	KVIncrementRetries = 20
+)
+
+// KVEntry is a row of the _kv table, ExpiresAt is zero for keys without ttl
+type KVEntry struct {
+	Id        uint      `korm:"pk" json:"id"`
+	Name      string    `korm:"size:255;unique" json:"key"`
+	Value     string    `korm:"text" json:"value"`
+	ExpiresAt time.Time `korm:"index" json:"expires_at"`
+	UpdatedAt time.Time `korm:"update" json:"updated_at"`
+}
+
+// expired return true if e has a ttl that elapsed
+func (e *KVEntry) expired(now time.Time) bool {
+	return e.ExpiresAt.Unix() > 0 && !e.ExpiresAt.After(now)
+}
+
+// KeyValueStore is a persistent key value store backed by the _kv table of a database.
+// korm.KV is the key value pair used by queries, the store is returned by KVStore
+type KeyValueStore struct {
+	c   *Client
+	db  *DatabaseEntity
+	ctx context.Context
+}
+
+// KVStore return the key value store of dbName, or of the first database if empty, migrating _kv and starting the background expiry on first use.
+// Reads go through the query cache, writes flush it like any other query, and _kv is replicated by the node manager like any other table
+//
+//	Example:
+//	  kv, err := korm.KVStore("")
+//	  err = kv.Set("user:1:token", "abc", time.Hour)
+//	  token, err := kv.Get("user:1:token")
+//	  n, err := kv.Increment("visits", 1)
+func KVStore(dbName string) (*KeyValueStore, error) {
+	return defaultClient.KVStore(dbName)
+}
+
+// KVStore is korm.KVStore for client c
+func (c *Client) KVStore(dbName string) (*KeyValueStore, error) {
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.internalTables.Get(db.Name + "._kv"); !ok {
+		if err := AutoMigrateOn[KVEntry](c, "_kv", db.Name); err != nil {
+			return nil, err
+		}
+		c.internalTables.Set(db.Name+"._kv", struct{}{})
+		c.goWorker("kv expiry "+db.Name, db.Name, func(ctx context.Context) {
+			for sleepCtx(ctx, KVExpireEvery) {
+				_, err := ModelOn[KVEntry](c).Database(db.Name).Context(ctx).Where("expires_at > ? AND expires_at <= ?", 0, time.Now()).Delete()
+				if err != nil && ctx.Err() == nil {
+					lg.ErrorC("could not delete expired keys", "db", db.Name, "err", err)
+				}
+			}
+		})
+	}
+	return &KeyValueStore{c: c, db: db, ctx: context.Background()}, nil
+}
+
+// Context return a copy of kv using ctx for its queries
+func (kv *KeyValueStore) Context(ctx context.Context) *KeyValueStore {
+	return &KeyValueStore{c: kv.c, db: kv.db, ctx: ctx}
+}
+
+func (kv *KeyValueStore) model() *BuilderS[KVEntry] {
+	return ModelOn[KVEntry](kv.c).Database(kv.db.Name).Context(kv.ctx)
+}
+
+// Get return the value of key, ErrNoData if missing or expired
+func (kv *KeyValueStore) Get(key string) (string, error) {
+	e, err := kv.model().Where("name = ?", key).One()
+	if err != nil {
+		return "", err
+	}
+	if e.expired(time.Now()) {
+		return "", ErrNoData
+	}
+	return e.Value, nil
+}
+
+// Set set key to value, expiring after ttl if given
+func (kv *KeyValueStore) Set(key, value string, ttl ...time.Duration) error {
+	expires := kvExpiresAt(ttl)
+	n, err := kv.model().Where("name = ?", key).Set("value = ?, expires_at = ?", value, expires)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = kv.model().Insert(&KVEntry{Name: key, Value: value, ExpiresAt: expires})
+	if isUniqueViolation(err) {
+		// inserted by another writer in between
+		if n, e := kv.model().Where("name = ?", key).Set("value = ?, expires_at = ?", value, expires); e == nil && n > 0 {
+			return nil
+		}
+	}
+	return err
+}
+
+// Delete remove key, it is not an error if key does not exist
+func (kv *KeyValueStore) Delete(key string) error {
+	_, err := kv.model().Where("name = ?", key).Delete()
+	return err
+}
+
+// CAS set key to value only if its current value is old, an empty old match a missing or expired key.
+// It return false if the value changed in between
+func (kv *KeyValueStore) CAS(key, old, value string, ttl ...time.Duration) (bool, error) {
+	expires := kvExpiresAt(ttl)
+	now := time.Now()
+	e, err := kv.model().NoCache().Where("name = ?", key).One()
+	switch {
+	case errors.Is(err, ErrNoData):
+		if old != "" {
+			return false, nil
+		}
+		if _, err := kv.model().Insert(&KVEntry{Name: key, Value: value, ExpiresAt: expires}); err != nil {
+			if isUniqueViolation(err) {
+				// inserted by another writer in between
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	var n int
+	if e.expired(now) {
+		if old != "" {
+			return false, nil
+		}
+		n, err = kv.model().Where("name = ? AND expires_at = ?", key, e.ExpiresAt).Set("value = ?, expires_at = ?", value, expires)
+	} else {
+		if e.Value != old {
+			return false, nil
+		}
+		n, err = kv.model().Where("name = ? AND value = ? AND expires_at = ?", key, old, e.ExpiresAt).Set("value = ?, expires_at = ?", value, expires)
+	}
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Increment add delta to the integer value of key and return the result, a missing or expired key start from 0 without ttl.
+// The ttl of a live key is kept
+func (kv *KeyValueStore) Increment(key string, delta int64) (int64, error) {
+	for i := 0; i < KVIncrementRetries; i++ {
+		e, err := kv.model().NoCache().Where("name = ?", key).One()
+		if errors.Is(err, ErrNoData) {
+			_, err := kv.model().Insert(&KVEntry{Name: key, Value: strconv.FormatInt(delta, 10), ExpiresAt: kvExpiresAt(nil)})
+			if err == nil {
+				return delta, nil
+			}
+			if isUniqueViolation(err) {
+				// inserted by another writer in between
+				continue
+			}
+			return 0, err
+		}
+		if err != nil {
+			return 0, err
+		}
+		cur, expires := int64(0), e.ExpiresAt
+		if e.expired(time.Now()) {
+			// the expired key is replaced, its expiry must not hide the new value
+			expires = kvExpiresAt(nil)
+		} else if e.Value != "" {
+			cur, err = strconv.ParseInt(e.Value, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+		}
+		next := cur + delta
+		n, err := kv.model().Where("name = ? AND value = ? AND expires_at = ?", key, e.Value, e.ExpiresAt).Set("value = ?, expires_at = ?", strconv.FormatInt(next, 10), expires)
+		if err != nil {
+			return 0, err
+		}
+		if n == 1 {
+			return next, nil
+		}
+	}
+	return 0, errors.New("kv increment: too many concurrent updates of " + key)
+}
+
+// Scan return entries having keys starting with prefix, ordered by key, expired entries are skipped
+func (kv *KeyValueStore) Scan(prefix string) ([]KVEntry, error) {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	entries, err := kv.model().Where("name LIKE ? ESCAPE '!'", r.Replace(prefix)+"%").OrderBy("name").All()
+	if errors.Is(err, ErrNoData) {
+		return []KVEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	res := entries[:0]
+	for _, e := range entries {
+		if !e.expired(now) {
+			res = append(res, e)
+		}
+	}
+	return res, nil
+}
+
+// kvExpiresAt return the expiry of a ttl, or unix 0 if none
+func kvExpiresAt(ttl []time.Duration) time.Time {
+	if len(ttl) > 0 && ttl[0] > 0 {
+		return time.Now().Add(ttl[0])
+	}
+	return time.Unix(0, 0)
+}
@@ -0,0 +1,242 @@
+package korm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+var (
+	// ShutdownTimeout is the time given to background workers to stop when calling Shutdown without databases names
+	ShutdownTimeout = 10 * time.Second
+)
+
+// lifecycle track background workers of a client, workers of a database are stopped before closing its pools
+type lifecycle struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	dbs     map[string]context.CancelFunc
+	dbCtxs  map[string]context.Context
+	workers map[*worker]struct{}
+}
+
+type worker struct {
+	name string
+	db   string
+	done chan struct{}
+}
+
+// Start start background workers of the default client (cache flusher, slow queries recorder, spans exporter), they are stopped when ctx is done or on Shutdown.
+// Calling it is optional, New start them using context.Background if not already started
+//
+//	Example:
+//	  ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+//	  defer cancel()
+//	  korm.Start(ctx)
+//	  err := korm.New(korm.SQLITE, "db", sqlitedriver.Use())
+//	  <-ctx.Done()
+//	  err = korm.ShutdownContext(context.Background())
+func Start(ctx context.Context) error {
+	return defaultClient.Start(ctx)
+}
+
+// Start is korm.Start for client c
+func (c *Client) Start(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	c.life.mu.Lock()
+	if c.life.ctx != nil && c.life.ctx.Err() == nil {
+		c.life.mu.Unlock()
+		// already started by New, bind running workers to ctx too
+		context.AfterFunc(ctx, c.stopAll)
+		return nil
+	}
+	c.life.ctx, c.life.cancel = context.WithCancel(ctx)
+	c.life.dbs = map[string]context.CancelFunc{}
+	c.life.dbCtxs = map[string]context.Context{}
+	if c.life.workers == nil {
+		c.life.workers = map[*worker]struct{}{}
+	}
+	c.life.mu.Unlock()
+
+	if useCache {
+		c.goWorker("cache flusher", "", func(ctx context.Context) {
+			ticker := time.NewTicker(FlushCacheEvery)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					if useCache {
+						c.flushCache()
+					}
+				}
+			}
+		})
+	}
+	c.startSlowQueries()
+	c.startSpansExport()
+	return nil
+}
+
+// started start c using context.Background if Start was not called
+func (c *Client) started() {
+	c.life.mu.Lock()
+	ok := c.life.ctx != nil && c.life.ctx.Err() == nil
+	c.life.mu.Unlock()
+	if !ok {
+		_ = c.Start(context.Background())
+	}
+}
+
+// stopping return true if c was started and is shutting down
+func (c *Client) stopping() bool {
+	c.life.mu.Lock()
+	defer c.life.mu.Unlock()
+	return c.life.ctx != nil && c.life.ctx.Err() != nil
+}
+
+// goWorker run fn in a goroutine tracked by c, the context given to fn is cancelled when dbName or c is shutting down
+func (c *Client) goWorker(name, dbName string, fn func(ctx context.Context)) {
+	c.started()
+	c.life.mu.Lock()
+	ctx := c.life.ctx
+	if dbName != "" {
+		if dctx, ok := c.life.dbCtxs[dbName]; ok && dctx.Err() == nil {
+			ctx = dctx
+		} else {
+			dctx, cancel := context.WithCancel(c.life.ctx)
+			c.life.dbCtxs[dbName] = dctx
+			c.life.dbs[dbName] = cancel
+			ctx = dctx
+		}
+	}
+	w := &worker{name: name, db: dbName, done: make(chan struct{})}
+	c.life.workers[w] = struct{}{}
+	c.life.mu.Unlock()
+
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				lg.ErrorC("background worker panic", "worker", name, "err", r)
+			}
+			close(w.done)
+			c.life.mu.Lock()
+			delete(c.life.workers, w)
+			c.life.mu.Unlock()
+		}()
+		fn(ctx)
+	}()
+}
+
+// stopAll cancel all workers of c without waiting for them
+func (c *Client) stopAll() {
+	c.life.mu.Lock()
+	defer c.life.mu.Unlock()
+	if c.life.cancel != nil {
+		c.life.cancel()
+	}
+}
+
+// stopWorkers cancel workers of dbNames, or all workers if empty, and wait for them until ctx is done
+func (c *Client) stopWorkers(ctx context.Context, dbNames ...string) error {
+	c.life.mu.Lock()
+	waitFor := []*worker{}
+	if len(dbNames) == 0 {
+		if c.life.cancel != nil {
+			c.life.cancel()
+		}
+		for w := range c.life.workers {
+			waitFor = append(waitFor, w)
+		}
+	} else {
+		for _, name := range dbNames {
+			if cancel, ok := c.life.dbs[name]; ok {
+				cancel()
+				delete(c.life.dbs, name)
+				delete(c.life.dbCtxs, name)
+			}
+		}
+		for w := range c.life.workers {
+			if w.db != "" && SliceContains(dbNames, w.db) {
+				waitFor = append(waitFor, w)
+			}
+		}
+	}
+	c.life.mu.Unlock()
+
+	running := []string{}
+	for _, w := range waitFor {
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			select {
+			case <-w.done:
+			default:
+				running = append(running, w.name)
+			}
+		}
+	}
+	if len(running) > 0 {
+		sort.Strings(running)
+		return fmt.Errorf("%w: workers still running: %v", ctx.Err(), running)
+	}
+	return nil
+}
+
+// ShutdownContext stop background workers in order then close all databases of the default client:
+// change workers drain the trigger queues, the node manager notify other nodes, then writers, replicas and pools are closed.
+// If ctx is done before workers stop, pools are closed anyway and the returned error list still running workers
+func ShutdownContext(ctx context.Context) error {
+	return defaultClient.ShutdownContext(ctx)
+}
+
+// ShutdownContext is korm.ShutdownContext for client c
+func (c *Client) ShutdownContext(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var errs []error
+	if err := c.stopWorkers(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if c.nodeManager != nil {
+		c.nodeManager.Shutdown()
+	}
+	c.replicasCheckerStarted.Store(false)
+	for i := range c.databases {
+		if err := c.databases[i].close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.databases[i].Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// close close the writer, replicas and pool of db
+func (db *DatabaseEntity) close() error {
+	for _, r := range db.Replicas() {
+		lg.CheckError(r.Conn.Close())
+	}
+	db.closeWriter()
+	return db.Conn.Close()
+}
+
+// sleepCtx wait d, returning false if ctx was done before
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
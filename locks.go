@@ -0,0 +1,547 @@
+package korm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+var (
+	// ErrLockLost is returned by Refresh and Unlock when the lock expired or was taken by another holder
+	ErrLockLost = errors.New("lock lost")
+	// LockPollEvery is the interval between attempts to take a busy lock
+	LockPollEvery = 100 * time.Millisecond
+	// LockDefaultTTL is used when Lock is called with ttl <= 0
+	LockDefaultTTL = 30 * time.Second
+	// LockClusterTimeout is the maximum time to wait for other nodes to accept a lock
+	LockClusterTimeout = 2 * time.Second
+)
+
+// DistLock is a lock held on a database, and on all active nodes when the node manager is used
+type DistLock struct {
+	Key string
+	// Token is a fencing token incremented at every acquisition of Key, only set for lease locks (0 on postgres and mysql)
+	Token  int64
+	c      *Client
+	db     *DatabaseEntity
+	holder string
+	ttl    time.Duration
+	// cluster is true if the lock is also held on nodes of the node manager
+	cluster bool
+	// conn hold advisory locks, released using unlockSt and lockArg
+	conn     *sql.Conn
+	unlockSt string
+	lockArg  any
+	mu       sync.Mutex
+	done     bool
+}
+
+// AdvisoryLocker can be implemented by a Dialect having session advisory locks, Lock use them instead of a lease in _locks.
+// lock try to take key without waiting and unlock release it, both take arg as single argument and return a boolean,
+// timeout make the database end the session after ttl without statement. Dialects return an empty lock if they have none
+type AdvisoryLocker interface {
+	AdvisoryLock(key string, ttl time.Duration) (lock, unlock, timeout string, arg any)
+}
+
+func (postgresDialect) AdvisoryLock(key string, ttl time.Duration) (string, string, string, any) {
+	return "SELECT pg_try_advisory_lock($1)", "SELECT pg_advisory_unlock($1)", "SET idle_session_timeout = " + strconv.FormatInt(ttl.Milliseconds(), 10), advisoryKey(key)
+}
+
+// cockroach accept pg_advisory_lock but does not lock, leases are used
+func (cockroachDialect) AdvisoryLock(key string, ttl time.Duration) (string, string, string, any) {
+	return "", "", "", nil
+}
+
+func (mysqlDialect) AdvisoryLock(key string, ttl time.Duration) (string, string, string, any) {
+	return "SELECT GET_LOCK(?, 0)", "SELECT RELEASE_LOCK(?)", "SET SESSION wait_timeout = " + strconv.FormatInt(int64(math.Ceil(ttl.Seconds())), 10), mysqlLockName(key)
+}
+
+// lockLease is a row of _locks used by dialects without advisory locks
+type lockLease struct {
+	Id        uint      `korm:"pk"`
+	Name      string    `korm:"size:255;unique"`
+	Holder    string    `korm:"size:100"`
+	Token     int64     `korm:"default:0"`
+	ExpiresAt time.Time `korm:"index"`
+}
+
+// clusterLock is a lock granted to a holder on this node by the node manager
+type clusterLock struct {
+	holder  string
+	expires time.Time
+}
+
+// lockRegistry keep cluster locks granted by this node and replies waited by pending acquisitions
+type lockRegistry struct {
+	mu      sync.Mutex
+	locks   map[string]clusterLock
+	replies map[string]chan bool
+}
+
+// Lock block until key is locked on dbName or ctx is done, it use advisory locks of dialects implementing AdvisoryLocker,
+// like pg_advisory_lock on postgres and GET_LOCK on mysql and maria, and a lease in the _locks table with a fencing token on other databases. All locks expire after ttl unless refreshed:
+// advisory locks are held by a dedicated connection whose session is ended by the database after ttl without Refresh
+// (idle_session_timeout on postgres 14+, wait_timeout on mysql), releasing the lock of a crashed holder.
+// On postgres before 14 the ttl is not enforced, the lock is held until Unlock or the connection is closed.
+// When the node manager is used, the lock is also taken on all active nodes, so it span nodes having separate databases
+//
+//	Example:
+//	  l, err := korm.Lock(ctx, "", "billing", 30*time.Second)
+//	  if err != nil {
+//	  	return err
+//	  }
+//	  defer l.Unlock(context.Background())
+func Lock(ctx context.Context, dbName, key string, ttl time.Duration) (*DistLock, error) {
+	return defaultClient.Lock(ctx, dbName, key, ttl)
+}
+
+// Lock is korm.Lock for client c
+func (c *Client) Lock(ctx context.Context, dbName, key string, ttl time.Duration) (*DistLock, error) {
+	return c.lock(ctx, dbName, key, ttl, c.nodeManager != nil)
+}
+
+// lock take key on dbName, and on nodes of the node manager if cluster is true
+func (c *Client) lock(ctx context.Context, dbName, key string, ttl time.Duration, cluster bool) (*DistLock, error) {
+	if key == "" {
+		return nil, errors.New("lock key cannot be empty")
+	}
+	if ttl <= 0 {
+		ttl = LockDefaultTTL
+	}
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+	l := &DistLock{
+		Key:     key,
+		c:       c,
+		db:      db,
+		holder:  GenerateUUID(),
+		ttl:     ttl,
+		cluster: cluster,
+	}
+	if d := dialectOf(db.Dialect); usesLeases(d) {
+		err = l.lockLease(ctx)
+	} else {
+		err = l.lockAdvisory(ctx, d.(AdvisoryLocker))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !cluster {
+		return l, nil
+	}
+	for {
+		if c.acquireCluster(ctx, key, l.holder, ttl) {
+			return l, nil
+		}
+		// random wait so two nodes asking at the same time do not collide again
+		if !sleepCtx(ctx, LockPollEvery+time.Duration(rand.Int63n(int64(LockPollEvery)))) {
+			lg.CheckError(l.unlockDatabase(context.WithoutCancel(ctx)))
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Unlock release l on the database and on other nodes
+func (l *DistLock) Unlock(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return nil
+	}
+	l.done = true
+	if l.cluster {
+		l.c.releaseCluster(l.Key, l.holder)
+	}
+	return l.unlockDatabase(ctx)
+}
+
+// Refresh extend the lease, or the session of advisory locks, by the ttl of l, it return ErrLockLost if the lock expired
+func (l *DistLock) Refresh(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.done {
+		return ErrLockLost
+	}
+	if l.cluster {
+		l.c.refreshCluster(l.Key, l.holder, l.ttl)
+	}
+	if l.conn != nil {
+		if err := l.conn.PingContext(ctx); err != nil {
+			return fmt.Errorf("%w: %v", ErrLockLost, err)
+		}
+		return nil
+	}
+	q := dialectOf(l.db.Dialect).Quote
+	st := "UPDATE " + q("_locks") + " SET " + q("expires_at") + " = ? WHERE " + q("name") + " = ? AND " + q("holder") + " = ? AND " + q("token") + " = ?"
+	AdaptPlaceholdersToDialect(&st, l.db.Dialect)
+	res, err := l.db.execContext(ctx, st, time.Now().Add(l.ttl).Unix(), l.Key, l.holder, l.Token)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// usesLeases return true if d has no advisory locks, locks are then leases in _locks
+func usesLeases(d Dialect) bool {
+	al, ok := d.(AdvisoryLocker)
+	if !ok {
+		return true
+	}
+	lock, _, _, _ := al.AdvisoryLock("", LockDefaultTTL)
+	return lock == ""
+}
+
+// lockAdvisory take a session advisory lock of al on a dedicated connection
+func (l *DistLock) lockAdvisory(ctx context.Context, al AdvisoryLocker) error {
+	ttl := l.ttl
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	st, unlock, timeout, arg := al.AdvisoryLock(l.Key, ttl)
+	conn, err := l.db.Conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	// the database end the session after ttl without statement, Refresh ping the connection to keep it
+	if _, err := conn.ExecContext(ctx, timeout); err != nil {
+		lg.WarnC("lock ttl not enforced, the lock is held until Unlock or the connection is closed", "key", l.Key, "err", err)
+	}
+	for {
+		var ok sql.NullBool
+		if err := conn.QueryRowContext(ctx, st, arg).Scan(&ok); err != nil {
+			discardConn(conn)
+			return err
+		}
+		if ok.Valid && ok.Bool {
+			l.conn, l.unlockSt, l.lockArg = conn, unlock, arg
+			return nil
+		}
+		if !sleepCtx(ctx, LockPollEvery) {
+			discardConn(conn)
+			return ctx.Err()
+		}
+	}
+}
+
+// whileLocked call run holding the lock key on db until ctx is done, run is called again once the lock is taken back if it return
+// or if the lock is lost, then its context is done. The lock is only taken on db, so nodes of the node manager having their own database
+// do not wait for each other
+func (db *DatabaseEntity) whileLocked(ctx context.Context, key string, run func(ctx context.Context)) {
+	for ctx.Err() == nil {
+		l, err := db.client.lock(ctx, db.Name, key, LockDefaultTTL, false)
+		if err != nil {
+			if ctx.Err() == nil {
+				lg.ErrorC("could not take lock", "db", db.Name, "key", key, "err", err)
+				sleepCtx(ctx, 5*time.Second)
+			}
+			continue
+		}
+		lockCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			for sleepCtx(lockCtx, LockDefaultTTL/3) {
+				if err := l.Refresh(lockCtx); err != nil {
+					if lockCtx.Err() == nil {
+						lg.ErrorC("lock lost", "db", db.Name, "key", key, "err", err)
+					}
+					cancel()
+					return
+				}
+			}
+		}()
+		run(lockCtx)
+		cancel()
+		lg.CheckError(l.Unlock(context.WithoutCancel(ctx)))
+		sleepCtx(ctx, time.Second)
+	}
+}
+
+// discardConn close conn instead of returning it to the pool, so its session timeout is not used by other queries
+func discardConn(conn *sql.Conn) {
+	_ = conn.Raw(func(any) error { return driver.ErrBadConn })
+	_ = conn.Close()
+}
+
+// migrateLocks migrate _locks on db if not done yet
+func (c *Client) migrateLocks(db *DatabaseEntity) error {
+	if _, ok := c.internalTables.Get(db.Name + "._locks"); ok {
+		return nil
+	}
+	if err := AutoMigrateOn[lockLease](c, "_locks", db.Name); err != nil {
+		return err
+	}
+	c.internalTables.Set(db.Name+"._locks", struct{}{})
+	return nil
+}
+
+// lockLease take the lease of l.Key in _locks once it is free or expired, incrementing its fencing token
+func (l *DistLock) lockLease(ctx context.Context) error {
+	db := l.db
+	if err := l.c.migrateLocks(db); err != nil {
+		return err
+	}
+	q := dialectOf(db.Dialect).Quote
+	take := "UPDATE " + q("_locks") + " SET " + q("holder") + " = ?, " + q("token") + " = " + q("token") + " + 1, " +
+		q("expires_at") + " = ? WHERE " + q("name") + " = ? AND " + q("expires_at") + " < ?"
+	AdaptPlaceholdersToDialect(&take, db.Dialect)
+	token := "SELECT " + q("token") + ", " + q("holder") + " FROM " + q("_locks") + " WHERE " + q("name") + " = ?"
+	AdaptPlaceholdersToDialect(&token, db.Dialect)
+	insert := "INSERT INTO " + q("_locks") + " (" + q("name") + ", " + q("holder") + ", " + q("token") + ", " + q("expires_at") + ") VALUES (?, ?, 1, ?)"
+	AdaptPlaceholdersToDialect(&insert, db.Dialect)
+	for {
+		now := time.Now()
+		res, err := db.execContext(ctx, take, l.holder, now.Add(l.ttl).Unix(), l.Key, now.Unix())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 1 {
+			var holder string
+			err := db.readConn(db.writer == nil).QueryRowContext(ctx, token, l.Key).Scan(&l.Token, &holder)
+			if err != nil {
+				return err
+			}
+			if holder != l.holder {
+				return ErrLockLost
+			}
+			return nil
+		}
+		var tk int64
+		var holder string
+		err = db.readConn(db.writer == nil).QueryRowContext(ctx, token, l.Key).Scan(&tk, &holder)
+		if errors.Is(err, sql.ErrNoRows) {
+			_, err = db.execContext(ctx, insert, l.Key, l.holder, now.Add(l.ttl).Unix())
+			if err == nil {
+				l.Token = 1
+				return nil
+			}
+			// another holder may have inserted it first, the row must exist now
+			if e := db.readConn(db.writer == nil).QueryRowContext(ctx, token, l.Key).Scan(&tk, &holder); e != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+		if !sleepCtx(ctx, LockPollEvery) {
+			return ctx.Err()
+		}
+	}
+}
+
+// unlockDatabase release the advisory lock or the lease of l
+func (l *DistLock) unlockDatabase(ctx context.Context) error {
+	if l.conn != nil {
+		var ok sql.NullBool
+		err := l.conn.QueryRowContext(ctx, l.unlockSt, l.lockArg).Scan(&ok)
+		discardConn(l.conn)
+		if err != nil {
+			return err
+		}
+		if !ok.Valid || !ok.Bool {
+			return ErrLockLost
+		}
+		return nil
+	}
+	q := dialectOf(l.db.Dialect).Quote
+	st := "UPDATE " + q("_locks") + " SET " + q("expires_at") + " = ? WHERE " + q("name") + " = ? AND " + q("holder") + " = ? AND " + q("token") + " = ?"
+	AdaptPlaceholdersToDialect(&st, l.db.Dialect)
+	res, err := l.db.execContext(ctx, st, 0, l.Key, l.holder, l.Token)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// advisoryKey hash key into the bigint used by pg_advisory_lock
+func advisoryKey(key string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return int64(h.Sum64())
+}
+
+// mysqlLockName return key, or a hash of it if longer than the 64 characters allowed by GET_LOCK
+func mysqlLockName(key string) string {
+	if len(key) <= 64 {
+		return key
+	}
+	return "korm_" + strconv.FormatUint(uint64(advisoryKey(key)), 16)
+}
+
+// grantCluster give key to holder on this node if it is free, expired or already held by holder
+func (c *Client) grantCluster(key, holder string, ttl time.Duration) bool {
+	c.locks.mu.Lock()
+	defer c.locks.mu.Unlock()
+	if c.locks.locks == nil {
+		c.locks.locks = map[string]clusterLock{}
+	}
+	if cur, ok := c.locks.locks[key]; ok && cur.holder != holder && time.Now().Before(cur.expires) {
+		return false
+	}
+	c.locks.locks[key] = clusterLock{holder: holder, expires: time.Now().Add(ttl)}
+	return true
+}
+
+// revokeCluster remove key from this node if held by holder
+func (c *Client) revokeCluster(key, holder string) {
+	c.locks.mu.Lock()
+	defer c.locks.mu.Unlock()
+	if cur, ok := c.locks.locks[key]; ok && cur.holder == holder {
+		delete(c.locks.locks, key)
+	}
+}
+
+// acquireCluster grant key to holder locally then ask all active nodes, it return false and release granted nodes if one refused
+func (c *Client) acquireCluster(ctx context.Context, key, holder string, ttl time.Duration) bool {
+	if !c.grantCluster(key, holder, ttl) {
+		return false
+	}
+	nm := c.nodeManager
+	nodes := []*Node{}
+	for _, n := range nm.GetNodes() {
+		if n.Active {
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		return true
+	}
+	req := GenerateUUID()
+	replies := make(chan bool, len(nodes))
+	c.locks.mu.Lock()
+	if c.locks.replies == nil {
+		c.locks.replies = map[string]chan bool{}
+	}
+	c.locks.replies[req] = replies
+	c.locks.mu.Unlock()
+	defer func() {
+		c.locks.mu.Lock()
+		delete(c.locks.replies, req)
+		c.locks.mu.Unlock()
+	}()
+
+	expected := 0
+	for _, n := range nodes {
+		err := nm.server.PublishToServer(n.Address, map[string]any{
+			"mtype":  "lock_acquire",
+			"addr":   nm.server.App().Address(),
+			"id":     nm.server.ID,
+			"req":    req,
+			"key":    key,
+			"holder": holder,
+			"ttl_ms": ttl.Milliseconds(),
+		}, n.Secure)
+		if err == nil {
+			expected++
+		}
+	}
+	ok := expected == len(nodes)
+	timeout := time.NewTimer(LockClusterTimeout)
+	defer timeout.Stop()
+	for i := 0; ok && i < expected; i++ {
+		select {
+		case granted := <-replies:
+			ok = granted
+		case <-timeout.C:
+			ok = false
+		case <-ctx.Done():
+			ok = false
+		}
+	}
+	if !ok {
+		c.releaseCluster(key, holder)
+	}
+	return ok
+}
+
+// releaseCluster release key held by holder on this node and all other nodes
+func (c *Client) releaseCluster(key, holder string) {
+	c.revokeCluster(key, holder)
+	c.publishLock("lock_release", key, holder, 0)
+}
+
+// refreshCluster extend key held by holder on this node and all other nodes
+func (c *Client) refreshCluster(key, holder string, ttl time.Duration) {
+	c.grantCluster(key, holder, ttl)
+	c.publishLock("lock_refresh", key, holder, ttl)
+}
+
+func (c *Client) publishLock(mtype, key, holder string, ttl time.Duration) {
+	nm := c.nodeManager
+	for _, n := range nm.GetNodes() {
+		if !n.Active {
+			continue
+		}
+		err := nm.server.PublishToServer(n.Address, map[string]any{
+			"mtype":  mtype,
+			"addr":   nm.server.App().Address(),
+			"id":     nm.server.ID,
+			"key":    key,
+			"holder": holder,
+			"ttl_ms": ttl.Milliseconds(),
+		}, n.Secure)
+		if err != nil {
+			lg.ErrorC("could not send lock message", "mtype", mtype, "key", key, "node", n.Address, "err", err)
+		}
+	}
+}
+
+// onLockMessage handle lock messages received from other nodes
+func (c *Client) onLockMessage(msg map[string]any) {
+	key, _ := msg["key"].(string)
+	holder, _ := msg["holder"].(string)
+	ttlMs, _ := msg["ttl_ms"].(float64)
+	ttl := time.Duration(ttlMs) * time.Millisecond
+	switch msg["mtype"] {
+	case "lock_acquire":
+		addr, _ := msg["addr"].(string)
+		granted := c.grantCluster(key, holder, ttl)
+		nm := c.nodeManager
+		if nm == nil {
+			return
+		}
+		err := nm.server.PublishToServer(addr, map[string]any{
+			"mtype": "lock_reply",
+			"addr":  nm.server.App().Address(),
+			"id":    nm.server.ID,
+			"req":   msg["req"],
+			"ok":    granted,
+		}, nm.IsSecure(addr))
+		if err != nil {
+			lg.ErrorC("could not reply to lock request", "key", key, "node", addr, "err", err)
+			// the requester will time out and release
+			c.revokeCluster(key, holder)
+		}
+	case "lock_release":
+		c.revokeCluster(key, holder)
+	case "lock_refresh":
+		c.grantCluster(key, holder, ttl)
+	case "lock_reply":
+		req, _ := msg["req"].(string)
+		ok, _ := msg["ok"].(bool)
+		c.locks.mu.Lock()
+		ch, found := c.locks.replies[req]
+		c.locks.mu.Unlock()
+		if found {
+			select {
+			case ch <- ok:
+			default:
+			}
+		}
+	}
+}
@@ -12,13 +12,15 @@ import (
 	"github.com/kamalshkeir/lg"
 )
 
-var triggersTables = make(map[string]struct{}, 0)
-
 func GetTablesInfosFromDB(dbName string, tables ...string) []TableEntity {
+	return defaultClient.GetTablesInfosFromDB(dbName, tables...)
+}
+
+func (c *Client) GetTablesInfosFromDB(dbName string, tables ...string) []TableEntity {
 	if len(tables) == 0 {
-		tables = GetAllTables(dbName)
+		tables = c.GetAllTables(dbName)
 	}
-	tinfos, err := Model[TablesInfos]().Database(dbName).Where("name IN (?)", tables).All()
+	tinfos, err := ModelOn[TablesInfos](c).Database(dbName).Where("name IN (?)", tables).All()
 	if lg.CheckError(err) {
 		return nil
 	}
@@ -58,31 +60,59 @@ func GetTablesInfosFromDB(dbName string, tables ...string) []TableEntity {
 	return res
 }
 
+// CaseInsensitiveCollator can be implemented by a Dialect whose default collation compare strings ignoring case,
+// columns tagged 'iunique' then get a unique index on the column instead of LOWER(column)
+type CaseInsensitiveCollator interface {
+	CaseInsensitiveCollation() bool
+}
+
+func (mysqlDialect) CaseInsensitiveCollation() bool { return true }
+
+// mssql cannot index expressions, its default collation is case insensitive
+func (mssqlDialect) CaseInsensitiveCollation() bool { return true }
+
+// caseInsensitive return true if the default collation of dialect ignore case
+func caseInsensitive(dialect string) bool {
+	ci, ok := dialectOf(dialect).(CaseInsensitiveCollator)
+	return ok && ci.CaseInsensitiveCollation()
+}
+
+// UniqueNullableIndexer can be implemented by a Dialect whose unique indexes accept a single NULL, UniqueNullableIndex return the statement
+// creating the unique index name on col of table ignoring NULLs. Unique indexes of other dialects already accept many NULLs
+type UniqueNullableIndexer interface {
+	UniqueNullableIndex(name, table, col string) string
+}
+
+func (d mssqlDialect) UniqueNullableIndex(name, table, col string) string {
+	return "CREATE UNIQUE INDEX " + d.Quote(name) + " ON " + d.Quote(table) + " (" + d.Quote(col) + ") WHERE " + d.Quote(col) + " IS NOT NULL"
+}
+
+// createUniqueNullableIndex create a unique index on the nullable column col of table if it does not exist, rows having NULL are not compared
+func createUniqueNullableIndex(db *DatabaseEntity, table, col string) error {
+	name := "idx_" + table + "_" + col
+	if indexExists(db.Conn, table, name, db.Dialect) {
+		return nil
+	}
+	d := dialectOf(db.Dialect)
+	st := "CREATE UNIQUE INDEX " + d.Quote(name) + " ON " + d.Quote(table) + " (" + d.Quote(col) + ")"
+	if ui, ok := d.(UniqueNullableIndexer); ok {
+		st = ui.UniqueNullableIndex(name, table, col)
+	}
+	_, err := db.execContext(context.Background(), st)
+	return err
+}
+
 // CREATE TRIGGER IF NOT EXISTS users_update_trig AFTER UPDATE ON
 func checkUpdatedAtTrigger(dialect, tableName, col, pk string) map[string][]string {
-	triggers := map[string][]string{}
-	t := "strftime('%s', 'now')"
-	switch dialect {
-	case SQLITE, "sqlite":
-		st := "CREATE TRIGGER IF NOT EXISTS "
-		st += tableName + "_update_trig AFTER UPDATE ON " + tableName
-		st += " BEGIN update " + tableName + " SET " + col + " = " + t
-		st += " WHERE " + pk + " = " + "NEW." + pk + ";"
-		st += "End;"
-		triggers[col] = []string{st}
-	case POSTGRES:
-		st := "CREATE OR REPLACE FUNCTION updated_at_trig() RETURNS trigger AS $$"
-		st += " BEGIN NEW." + col + " = extract(epoch from now());RETURN NEW;"
-		st += "END;$$ LANGUAGE plpgsql;"
-		triggers[col] = []string{st}
-		trigCreate := "CREATE OR REPLACE TRIGGER " + tableName + "_update_trig"
-		trigCreate += " BEFORE UPDATE ON public." + tableName
-		trigCreate += " FOR EACH ROW EXECUTE PROCEDURE updated_at_trig();"
-		triggers[col] = append(triggers[col], trigCreate)
-	default:
+	d, ok := GetDialect(dialect)
+	if !ok {
+		return nil
+	}
+	stmts := d.UpdatedAtTrigger(tableName, col, pk)
+	if len(stmts) == 0 {
 		return nil
 	}
-	return triggers
+	return map[string][]string{col: stmts}
 }
 
 func autoMigrate[T any](model *T, db *DatabaseEntity, tableName string, execute bool) (string, error) {
@@ -196,43 +226,10 @@ func autoMigrate[T any](model *T, db *DatabaseEntity, tableName string, execute
 	var triggers map[string][]string
 
 	// check for update field to create a trigger
-	if db.Dialect != MYSQL && db.Dialect != MARIA {
-		for col, tags := range mFieldName_Tags {
-			for _, tag := range tags {
-				if tag == "update" {
-					triggers = checkUpdatedAtTrigger(db.Dialect, tableName, col, pk)
-				}
-			}
-		}
-	} else {
-		for col, tags := range mFieldName_Tags {
-			for _, tag := range tags {
-				switch tag {
-				case "now":
-					createTrigger := fmt.Sprintf(`CREATE TRIGGER before_insert_%s_%s
-					BEFORE INSERT ON %s
-					FOR EACH ROW
-					BEGIN
-						SET NEW.%s = UNIX_TIMESTAMP();
-					END`, tableName, col, tableName, col)
-
-					if triggers == nil {
-						triggers = make(map[string][]string)
-					}
-					triggers["mysql_triggers"] = append(triggers["mysql_triggers"], createTrigger)
-				case "update":
-					createTrigger := fmt.Sprintf(`CREATE TRIGGER before_update_%s_%s
-					BEFORE UPDATE ON %s
-					FOR EACH ROW
-					BEGIN
-						SET NEW.%s = UNIX_TIMESTAMP();
-					END`, tableName, col, tableName, col)
-
-					if triggers == nil {
-						triggers = make(map[string][]string)
-					}
-					triggers["mysql_triggers"] = append(triggers["mysql_triggers"], createTrigger)
-				}
+	for col, tags := range mFieldName_Tags {
+		for _, tag := range tags {
+			if tag == "update" {
+				triggers = checkUpdatedAtTrigger(db.Dialect, tableName, col, pk)
 			}
 		}
 	}
@@ -264,7 +261,7 @@ func autoMigrate[T any](model *T, db *DatabaseEntity, tableName string, execute
 						lg.Printfs("trigger updated_at %s: %s\n", tableName, st)
 					}
 					if execute {
-						err := Exec(db.Name, st)
+						err := db.client.Exec(db.Name, st)
 						if lg.CheckError(err) {
 							lg.Printfs("rdtrigger updated_at %s: %s\n", tableName, st)
 							return "", err
@@ -292,7 +289,7 @@ func autoMigrate[T any](model *T, db *DatabaseEntity, tableName string, execute
 		for col, tagValue := range *mi.uindexes {
 			sp := strings.Split(tagValue, ",")
 			for i := range sp {
-				if sp[i][0] == 'I' && db.Dialect != MYSQL && db.Dialect != MARIA {
+				if sp[i][0] == 'I' && !caseInsensitive(db.Dialect) {
 					sp[i] = "LOWER(" + sp[i][1:] + ")"
 				}
 			}
@@ -480,43 +477,10 @@ func autoMigrateAny(model any, db *DatabaseEntity, tableName string, execute boo
 	var triggers map[string][]string
 
 	// check for update field to create a trigger
-	if db.Dialect != MYSQL && db.Dialect != MARIA {
-		for col, tags := range mFieldName_Tags {
-			for _, tag := range tags {
-				if tag == "update" {
-					triggers = checkUpdatedAtTrigger(db.Dialect, tableName, col, pk)
-				}
-			}
-		}
-	} else {
-		for col, tags := range mFieldName_Tags {
-			for _, tag := range tags {
-				switch tag {
-				case "now":
-					createTrigger := fmt.Sprintf(`CREATE TRIGGER before_insert_%s_%s
-					BEFORE INSERT ON %s
-					FOR EACH ROW
-					BEGIN
-						SET NEW.%s = UNIX_TIMESTAMP();
-					END`, tableName, col, tableName, col)
-
-					if triggers == nil {
-						triggers = make(map[string][]string)
-					}
-					triggers["mysql_triggers"] = append(triggers["mysql_triggers"], createTrigger)
-				case "update":
-					createTrigger := fmt.Sprintf(`CREATE TRIGGER before_update_%s_%s
-					BEFORE UPDATE ON %s
-					FOR EACH ROW
-					BEGIN
-						SET NEW.%s = UNIX_TIMESTAMP();
-					END`, tableName, col, tableName, col)
-
-					if triggers == nil {
-						triggers = make(map[string][]string)
-					}
-					triggers["mysql_triggers"] = append(triggers["mysql_triggers"], createTrigger)
-				}
+	for col, tags := range mFieldName_Tags {
+		for _, tag := range tags {
+			if tag == "update" {
+				triggers = checkUpdatedAtTrigger(db.Dialect, tableName, col, pk)
 			}
 		}
 	}
@@ -548,7 +512,7 @@ func autoMigrateAny(model any, db *DatabaseEntity, tableName string, execute boo
 						lg.Printfs("trigger updated_at %s: %s\n", tableName, st)
 					}
 					if execute {
-						err := Exec(db.Name, st)
+						err := db.client.Exec(db.Name, st)
 						if lg.CheckError(err) {
 							lg.Printfs("rdtrigger updated_at %s: %s\n", tableName, st)
 							return "", err
@@ -576,7 +540,7 @@ func autoMigrateAny(model any, db *DatabaseEntity, tableName string, execute boo
 		for col, tagValue := range *mi.uindexes {
 			sp := strings.Split(tagValue, ",")
 			for i := range sp {
-				if sp[i][0] == 'I' && db.Dialect != MYSQL && db.Dialect != MARIA {
+				if sp[i][0] == 'I' && !caseInsensitive(db.Dialect) {
 					sp[i] = "LOWER(" + sp[i][1:] + ")"
 				}
 			}
@@ -649,29 +613,34 @@ func autoMigrateAny(model any, db *DatabaseEntity, tableName string, execute boo
 }
 
 func AutoMigrate[T any](tableName string, dbName ...string) error {
-	mutexModelTablename.Lock()
+	return AutoMigrateOn[T](defaultClient, tableName, dbName...)
+}
+
+// AutoMigrateOn is AutoMigrate using client c
+func AutoMigrateOn[T any](c *Client, tableName string, dbName ...string) error {
+	c.mutexModelTablename.Lock()
 	foundm := false
-	for k := range mModelTablename {
+	for k := range c.mModelTablename {
 		if k == tableName {
 			foundm = true
 		}
 	}
 	if !foundm {
-		mModelTablename[tableName] = *new(T)
+		c.mModelTablename[tableName] = *new(T)
 	}
-	mutexModelTablename.Unlock()
+	c.mutexModelTablename.Unlock()
 	var db *DatabaseEntity
 	var err error
 	dbname := ""
 	if len(dbName) == 1 {
 		dbname = dbName[0]
-		db, err = GetMemoryDatabase(dbname)
+		db, err = c.GetMemoryDatabase(dbname)
 		if err != nil || db == nil {
 			return errors.New("database not found")
 		}
 	} else if len(dbName) == 0 {
-		dbname = databases[0].Name
-		db, err = GetMemoryDatabase(dbname)
+		dbname = c.databases[0].Name
+		db, err = c.GetMemoryDatabase(dbname)
 		if err != nil || db == nil {
 			return errors.New("database not found")
 		}
@@ -679,7 +648,7 @@ func AutoMigrate[T any](tableName string, dbName ...string) error {
 		return errors.New("cannot migrate more than one database at the same time")
 	}
 	tbFoundDB := false
-	tables := GetAllTables(dbname)
+	tables := c.GetAllTables(dbname)
 	for _, t := range tables {
 		if t == tableName {
 			tbFoundDB = true
@@ -691,12 +660,12 @@ func AutoMigrate[T any](tableName string, dbName ...string) error {
 			return err
 		}
 	}
-	LinkModel[T](tableName, dbname)
+	LinkModelOn[T](c, tableName, dbname)
 	if tableName != "users" && !strings.HasPrefix(tableName, "_") {
-		if _, ok := triggersTables[tableName]; !ok {
-			err = AddChangesTrigger(tableName, dbname)
+		if _, ok := c.triggersTables.Get(dbname + "." + tableName); !ok {
+			err = c.AddChangesTrigger(tableName, dbname)
 			if !lg.CheckError(err) {
-				triggersTables[tableName] = struct{}{}
+				c.triggersTables.Set(dbname+"."+tableName, struct{}{})
 			}
 		}
 	}
@@ -727,14 +696,9 @@ func handleMigrationInt(mi *migrationInput) {
 		if !strings.Contains(tag, ":") {
 			switch tag {
 			case "autoinc", "pk":
-				switch mi.dialect {
-				case SQLITE, "":
-					autoinc = "INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT"
-				case POSTGRES:
-					autoinc = "SERIAL NOT NULL PRIMARY KEY"
-				case MYSQL, MARIA:
-					autoinc = "INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT"
-				default:
+				if d, ok := GetDialect(mi.dialect); ok {
+					autoinc = d.AutoIncrementPK()
+				} else {
 					lg.ErrorC("not supported dialect")
 				}
 			case "notnull":
@@ -962,7 +926,7 @@ func handleMigrationBool(mi *migrationInput) {
 }
 
 func handleMigrationString(mi *migrationInput) {
-	unique, notnull, text, json, defaultt, genas, size, checks := "", "", "", "", "", "", "", []string{}
+	unique, notnull, text, json, uuid, defaultt, genas, size, checks := "", "", "", "", "", "", "", "", []string{}
 	tags := (*mi.fTags)[mi.fName]
 	if len(tags) == 1 && tags[0] == "-" {
 		(*mi.res)[mi.fName] = ""
@@ -972,11 +936,13 @@ func handleMigrationString(mi *migrationInput) {
 		if !strings.Contains(tag, ":") {
 			switch tag {
 			case "text":
-				text = "TEXT"
+				text = dialectOf(mi.dialect).ColumnType("text", "")
 			case "json":
-				json = "TEXT"
-				if mi.dialect != SQLITE {
-					json = "JSONB"
+				json = dialectOf(mi.dialect).ColumnType("json", "")
+			case "uuid":
+				uuid = dialectOf(mi.dialect).ColumnType("uuid", "")
+				if d, ok := dialectOf(mi.dialect).(UUIDDefaulter); ok && defaultt == "" {
+					defaultt = " DEFAULT " + d.UUIDDefault()
 				}
 			case "notnull":
 				notnull = " NOT NULL"
@@ -989,7 +955,7 @@ func handleMigrationString(mi *migrationInput) {
 			case "iunique":
 				unique = " UNIQUE"
 				s := ""
-				if mi.dialect != "mysql" {
+				if !caseInsensitive(mi.dialect) {
 					s = "I"
 				}
 				(*mi.uindexes)[mi.fName] = s + mi.fName
@@ -1084,12 +1050,10 @@ func handleMigrationString(mi *migrationInput) {
 		(*mi.res)[mi.fName] = text
 	} else if json != "" {
 		(*mi.res)[mi.fName] = json
+	} else if uuid != "" {
+		(*mi.res)[mi.fName] = uuid
 	} else {
-		if size != "" {
-			(*mi.res)[mi.fName] = "VARCHAR(" + size + ")"
-		} else {
-			(*mi.res)[mi.fName] = "VARCHAR(255)"
-		}
+		(*mi.res)[mi.fName] = dialectOf(mi.dialect).ColumnType("string", size)
 	}
 
 	if notnull != "" {
@@ -1124,12 +1088,9 @@ func handleMigrationSliceByte(mi *migrationInput) {
 		if !strings.Contains(tag, ":") {
 			switch tag {
 			case "text":
-				json = "TEXT"
+				json = dialectOf(mi.dialect).ColumnType("text", "")
 			case "json":
-				json = "TEXT"
-				if mi.dialect != SQLITE {
-					json = "JSONB"
-				}
+				json = dialectOf(mi.dialect).ColumnType("json", "")
 			case "notnull":
 				notnull = " NOT NULL"
 			case "index", "+index", "index+":
@@ -1141,7 +1102,7 @@ func handleMigrationSliceByte(mi *migrationInput) {
 			case "iunique":
 				unique = " UNIQUE"
 				s := ""
-				if mi.dialect != "mysql" {
+				if !caseInsensitive(mi.dialect) {
 					s = "I"
 				}
 				(*mi.uindexes)[mi.fName] = s + mi.fName
@@ -1233,22 +1194,7 @@ func handleMigrationSliceByte(mi *migrationInput) {
 	}
 
 	if json == "" {
-		if size == "" {
-			if mi.dialect == "postgres" || mi.dialect == "pg" {
-				(*mi.res)[mi.fName] = "BYTEA"
-			} else {
-				(*mi.res)[mi.fName] = "BLOB"
-			}
-		} else {
-			switch mi.dialect {
-			case "mysql":
-				(*mi.res)[mi.fName] = "VARBINARY(" + size + ")"
-			case "postgres", "pg":
-				(*mi.res)[mi.fName] = "BIT VARYING(" + size + ")"
-			default:
-				(*mi.res)[mi.fName] = "BLOB"
-			}
-		}
+		(*mi.res)[mi.fName] = dialectOf(mi.dialect).ColumnType("bytes", size)
 	} else {
 		(*mi.res)[mi.fName] = json
 	}
@@ -1422,26 +1368,10 @@ func handleMigrationTime(mi *migrationInput) {
 			switch tag {
 			case "unique":
 				unique = " UNIQUE"
-			case "now":
-				switch mi.dialect {
-				case SQLITE, "":
-					defaultt = "BIGINT NOT NULL DEFAULT (strftime('%s', 'now'))"
-				case POSTGRES:
-					defaultt = "BIGINT NOT NULL DEFAULT extract(epoch from now())"
-				case MYSQL, MARIA:
-					defaultt = "BIGINT NOT NULL DEFAULT 0"
-				default:
-					lg.ErrorC("not handled Time for", "f", mi.fName, "type", mi.fType)
-				}
-			case "update":
-				switch mi.dialect {
-				case SQLITE, "":
-					defaultt = "BIGINT NOT NULL DEFAULT (strftime('%s', 'now'))"
-				case POSTGRES:
-					defaultt = "BIGINT NOT NULL DEFAULT extract(epoch from now())"
-				case MYSQL, MARIA:
-					defaultt = "BIGINT NOT NULL DEFAULT 0"
-				default:
+			case "now", "update":
+				if d, ok := GetDialect(mi.dialect); ok {
+					defaultt = d.ColumnType("time", "") + " NOT NULL DEFAULT " + d.NowUnix()
+				} else {
 					lg.ErrorC("not handled Time for", "f", mi.fName, "type", mi.fType)
 				}
 			case "index", "+index", "index+":
@@ -1546,47 +1476,19 @@ func handleMigrationTime(mi *migrationInput) {
 }
 
 func prepareCreateStatement(tbName string, fields map[string]string, fkeys, cols []string, dialect string) string {
-	var strBuilder strings.Builder
-	if dialect == POSTGRES || dialect == COCKROACH {
-		strBuilder.WriteString(`CREATE TABLE IF NOT EXISTS "` + tbName + `" (`)
-		for i, col := range cols {
-			fName := `"` + col + `"`
-			fType := fields[col]
-			if fType == "" {
-				continue
-			}
-			reste := ","
-			if i == len(cols)-1 {
-				reste = ""
-			}
-			strBuilder.WriteString(fName + " " + fType + reste)
+	d := dialectOf(dialect)
+	defs := make([]string, 0, len(cols)+len(fkeys))
+	for _, col := range cols {
+		fType := fields[col]
+		if fType == "" {
+			continue
 		}
-	} else {
-		strBuilder.WriteString("CREATE TABLE IF NOT EXISTS `" + tbName + "` (")
-		for i, col := range cols {
-			fName := "`" + col + "`"
-			fType := fields[col]
-			if fType == "" {
-				continue
-			}
-			reste := ","
-			if i == len(cols)-1 {
-				reste = ""
-			}
-			strBuilder.WriteString(fName + " " + fType + reste)
-		}
-	}
-	if len(fkeys) > 0 {
-		strBuilder.WriteString(",")
+		defs = append(defs, d.Quote(col)+" "+fType)
 	}
-	for i, k := range fkeys {
-		strBuilder.WriteString(k)
-		if i < len(fkeys)-1 {
-			strBuilder.WriteString(",")
+	for _, k := range fkeys {
+		if k != "" {
+			defs = append(defs, k)
 		}
 	}
-	st := strBuilder.String()
-	st = strings.TrimSuffix(st, ",")
-	st += ");"
-	return strings.ReplaceAll(st, ",,", ",")
+	return d.CreateTable(tbName, defs)
 }
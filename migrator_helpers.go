@@ -26,11 +26,16 @@ type kormFkey struct {
 
 // LinkModel link a struct model to a  db_table_name
 func LinkModel[T any](to_table_name string, dbName ...string) {
+	LinkModelOn[T](defaultClient, to_table_name, dbName...)
+}
+
+// LinkModelOn is LinkModel using client c
+func LinkModelOn[T any](c *Client, to_table_name string, dbName ...string) {
 	var db *DatabaseEntity
-	if len(dbName) == 0 && len(databases) > 0 {
-		db = &databases[0]
+	if len(dbName) == 0 && len(c.databases) > 0 {
+		db = &c.databases[0]
 	} else {
-		dbb, err := GetMemoryDatabase(dbName[0])
+		dbb, err := c.GetMemoryDatabase(dbName[0])
 		if lg.CheckError(err) {
 			return
 		}
@@ -45,7 +50,7 @@ func LinkModel[T any](to_table_name string, dbName ...string) {
 	}
 	var kfkeys = []kormFkey{}
 	// get columns from db
-	colsNameType, _ := GetAllColumnsTypes(to_table_name, db.Name)
+	colsNameType, _ := c.GetAllColumnsTypes(to_table_name, db.Name)
 	fields, _, ftypes, ftags := getStructInfos(new(T))
 	pk := ""
 	if !tFound {
@@ -128,7 +133,7 @@ func LinkModel[T any](to_table_name string, dbName ...string) {
 
 		if to_table_name != "_tables_infos" {
 			// insert tables infos into db
-			mTablesInfos, err := Model[TablesInfos]().Where("name = ?", to_table_name).One()
+			mTablesInfos, err := ModelOn[TablesInfos](c).Where("name = ?", to_table_name).One()
 			if err != nil {
 				// adapt table_infos and insert
 				fktbinfos := []string{}
@@ -152,7 +157,7 @@ func LinkModel[T any](to_table_name string, dbName ...string) {
 				for k, v := range te.Tags {
 					tags_in = append(tags_in, k+":"+strings.Join(v, ","))
 				}
-				_, err = Table("_tables_infos").Insert(map[string]any{
+				_, err = c.Table("_tables_infos").Insert(map[string]any{
 					"pk":          pk,
 					"name":        to_table_name,
 					"columns":     strings.Join(fields, ","),
@@ -217,7 +222,7 @@ func LinkModel[T any](to_table_name string, dbName ...string) {
 					for k, v := range te.Tags {
 						tags_in = append(tags_in, k+":"+strings.Join(v, ","))
 					}
-					_, err = Table("_tables_infos").Where("name = ?", to_table_name).SetM(map[string]any{
+					_, err = c.Table("_tables_infos").Where("name = ?", to_table_name).SetM(map[string]any{
 						"pk":          pk,
 						"name":        to_table_name,
 						"columns":     strings.Join(fields, ","),
@@ -269,7 +274,7 @@ func LinkModel[T any](to_table_name string, dbName ...string) {
 					if lg.CheckError(err) {
 						return
 					}
-					_, err = Table(to_table_name + "_old").Database(db.Name).Drop()
+					_, err = c.Table(to_table_name + "_old").Database(db.Name).Drop()
 					if lg.CheckError(err) {
 						return
 					}
@@ -319,7 +324,7 @@ func LinkModel[T any](to_table_name string, dbName ...string) {
 						lg.Printfs("query: %s\n", "INSERT INTO "+temp+" ("+cls+") SELECT "+cls+" FROM "+to_table_name)
 						return
 					}
-					_, err = Table(to_table_name + "_old").Database(db.Name).Drop()
+					_, err = c.Table(to_table_name + "_old").Database(db.Name).Drop()
 					if lg.CheckError(err) {
 						return
 					}
@@ -342,8 +347,12 @@ func LinkModel[T any](to_table_name string, dbName ...string) {
 }
 
 func flushCache() {
-	caches.Flush()
-	cacheQ.Flush()
-	cacheAllTables.Flush()
-	cacheAllCols.Flush()
+	defaultClient.flushCache()
+}
+
+func (c *Client) flushCache() {
+	c.caches.Flush()
+	c.cacheQ.Flush()
+	c.cacheAllTables.Flush()
+	c.cacheAllCols.Flush()
 }
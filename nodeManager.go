@@ -15,7 +15,6 @@ import (
 )
 
 var (
-	nodeManager      *NodeManager
 	nodeManagerDebug = false
 )
 
@@ -88,34 +87,34 @@ func (nm *NodeManager) startHeartbeat() {
 }
 
 func GetDefaultDbMem() *DatabaseEntity {
-	db, err := GetMemoryDatabase(defaultDB)
+	db, err := GetMemoryDatabase(defaultClient.defaultDB)
 	if err != nil {
-		if len(databases) == 0 {
+		if len(defaultClient.databases) == 0 {
 			return nil
 		} else {
-			return &databases[0]
+			return &defaultClient.databases[0]
 		}
 	}
 	return db
 }
 
 func WithNodeManager() *NodeManager {
-	if nodeManager != nil {
-		return nodeManager
+	if defaultClient.nodeManager != nil {
+		return defaultClient.nodeManager
 	}
-	if serverBus == nil {
-		serverBus = WithBus()
+	if defaultClient.serverBus == nil {
+		defaultClient.serverBus = WithBus()
 	}
 
-	serverBus.OnServerData(onServerData)
+	defaultClient.serverBus.OnServerData(onServerData)
 	// Create node manager after server is initialized
-	nodeManager = newNodeManager(serverBus)
-	initNodeManagerHooks(nodeManager)
-	if strings.HasPrefix(nodeManager.server.App().Address(), ":") {
-		nodeManager.server.App().Config.Address = "localhost" + nodeManager.server.App().Address()
+	defaultClient.nodeManager = newNodeManager(defaultClient.serverBus)
+	initNodeManagerHooks(defaultClient.nodeManager)
+	if strings.HasPrefix(defaultClient.nodeManager.server.App().Address(), ":") {
+		defaultClient.nodeManager.server.App().Config.Address = "localhost" + defaultClient.nodeManager.server.App().Address()
 	}
 	if nodeManagerDebug {
-		fmt.Println("Server ID:", nodeManager.server.ID)
+		fmt.Println("Server ID:", defaultClient.nodeManager.server.ID)
 	}
 	db := GetDefaultDbMem()
 	if db.Name != "" {
@@ -132,14 +131,14 @@ func WithNodeManager() *NodeManager {
 			}
 		}
 	}
-	initHandlersDashboard(nodeManager.server.App())
+	initHandlersDashboard(defaultClient.nodeManager.server.App())
 
-	return nodeManager
+	return defaultClient.nodeManager
 }
 
 func initHandlersDashboard(app *ksmux.Router) {
 	app.Get("/admin/nodemanager", Admin(func(c *ksmux.Context) {
-		nodes := nodeManager.GetNodes()
+		nodes := defaultClient.nodeManager.GetNodes()
 		secureNodes := 0
 		activeNodes := 0
 		for _, n := range nodes {
@@ -166,15 +165,15 @@ func initHandlersDashboard(app *ksmux.Router) {
 		}
 
 		// If it's our address, restart self
-		if data.Address == nodeManager.server.App().Address() ||
-			"localhost"+data.Address == nodeManager.server.App().Address() {
-			n := nodeManager.GetNode(nodeManager.server.App().Address())
+		if data.Address == defaultClient.nodeManager.server.App().Address() ||
+			"localhost"+data.Address == defaultClient.nodeManager.server.App().Address() {
+			n := defaultClient.nodeManager.GetNode(defaultClient.nodeManager.server.App().Address())
 			if n != nil {
 				n.Active = false
-				nodeManager.nodes.Set(nodeManager.server.App().Address(), n)
+				defaultClient.nodeManager.nodes.Set(defaultClient.nodeManager.server.App().Address(), n)
 				go func() {
 					time.Sleep(300 * time.Millisecond) // delay to allow response to be sent
-					lg.CheckError(nodeManager.gracefulRestart())
+					lg.CheckError(defaultClient.nodeManager.gracefulRestart())
 				}()
 			}
 			c.Status(200).Json(map[string]any{"message": "restarting self"})
@@ -182,14 +181,14 @@ func initHandlersDashboard(app *ksmux.Router) {
 		}
 
 		// Otherwise send restart message to remote node
-		err := nodeManager.server.PublishToServer(data.Address, map[string]any{
+		err := defaultClient.nodeManager.server.PublishToServer(data.Address, map[string]any{
 			"mtype": "restart_node",
-			"addr":  nodeManager.server.App().Address(),
-			"id":    nodeManager.server.ID,
-		}, nodeManager.IsSecure(data.Address))
+			"addr":  defaultClient.nodeManager.server.App().Address(),
+			"id":    defaultClient.nodeManager.server.ID,
+		}, defaultClient.nodeManager.IsSecure(data.Address))
 
 		if err != nil {
-			n := nodeManager.GetNode(data.Address)
+			n := defaultClient.nodeManager.GetNode(data.Address)
 			if n != nil && n.Active {
 				n.Active = false
 			}
@@ -200,7 +199,7 @@ func initHandlersDashboard(app *ksmux.Router) {
 		c.Status(200).Json(map[string]any{"message": "restart initiated"})
 	}))
 	app.Get("/admin/nodemanager/nodes/list", Admin(func(c *ksmux.Context) {
-		nodes := nodeManager.GetNodes()
+		nodes := defaultClient.nodeManager.GetNodes()
 		secureNodes := 0
 		activeNodes := 0
 		for _, n := range nodes {
@@ -231,7 +230,7 @@ func initHandlersDashboard(app *ksmux.Router) {
 			c.Error("invalid json")
 			return
 		}
-		n := nodeManager.GetNode(data.Address)
+		n := defaultClient.nodeManager.GetNode(data.Address)
 		if n != nil {
 			c.Error("node already exists")
 			return
@@ -240,7 +239,7 @@ func initHandlersDashboard(app *ksmux.Router) {
 			Address: data.Address,
 			Secure:  data.Secure,
 		}
-		err := nodeManager.AddNode(targetNode)
+		err := defaultClient.nodeManager.AddNode(targetNode)
 		if err != nil {
 			// nodeManager.RemoveNode(targetNode.Address)
 			c.Status(http.StatusServiceUnavailable).Json(map[string]any{
@@ -248,8 +247,8 @@ func initHandlersDashboard(app *ksmux.Router) {
 			})
 			return
 		}
-		nodeManager.SyncData(targetNode)
-		nodes := nodeManager.GetNodes()
+		defaultClient.nodeManager.SyncData(targetNode)
+		nodes := defaultClient.nodeManager.GetNodes()
 		secureNodes := 0
 		activeNodes := 0
 		for _, n := range nodes {
@@ -280,20 +279,20 @@ func initHandlersDashboard(app *ksmux.Router) {
 			c.Error("invalid json")
 			return
 		}
-		n := nodeManager.GetNode(data.Address)
+		n := defaultClient.nodeManager.GetNode(data.Address)
 		if n == nil {
 			fmt.Println("node not found")
 			c.Error("node not found")
 			return
 		}
 		// send to the removed server to remove me too
-		_ = nodeManager.server.PublishToServer(data.Address, map[string]any{
+		_ = defaultClient.nodeManager.server.PublishToServer(data.Address, map[string]any{
 			"mtype": "node_offline",
-			"addr":  nodeManager.server.App().Address(),
-			"id":    nodeManager.server.ID,
+			"addr":  defaultClient.nodeManager.server.App().Address(),
+			"id":    defaultClient.nodeManager.server.ID,
 		}, n.Secure)
-		nodeManager.RemoveNode(data.Address)
-		nodes := nodeManager.GetNodes()
+		defaultClient.nodeManager.RemoveNode(data.Address)
+		nodes := defaultClient.nodeManager.GetNodes()
 		secureNodes := 0
 		activeNodes := 0
 		for _, n := range nodes {
@@ -313,7 +312,7 @@ func initHandlersDashboard(app *ksmux.Router) {
 		})
 	}))
 	app.OnShutdown(func() error {
-		nodeManager.Shutdown()
+		defaultClient.nodeManager.Shutdown()
 		return nil
 	})
 }
@@ -325,11 +324,11 @@ func (nm *NodeManager) gracefulRestart() error {
 	return nil
 }
 
-func initNodeManagerHooks(nodeManager *NodeManager) {
+func initNodeManagerHooks(nm *NodeManager) {
 	// Add hook for data changes
 	OnInsert(func(hd HookData) {
-		if nodeManager != nil && !nodeManager.inSync {
-			nodes := nodeManager.GetNodes()
+		if nm != nil && !nm.inSync {
+			nodes := nm.GetNodes()
 			if nodeManagerDebug {
 				fmt.Println("----------------------------")
 				fmt.Println("OnInsert:", hd)
@@ -337,17 +336,17 @@ func initNodeManagerHooks(nodeManager *NodeManager) {
 				fmt.Println("----------------------------")
 			}
 			for _, node := range nodes {
-				if err := nodeManager.server.PublishToServer(node.Address, map[string]any{
+				if err := nm.server.PublishToServer(node.Address, map[string]any{
 					"mtype": "insert_rec",
-					"id":    nodeManager.server.ID,
-					"addr":  nodeManager.server.App().Address(),
+					"id":    nm.server.ID,
+					"addr":  nm.server.App().Address(),
 					"table": hd.Table,
 					"pk":    hd.Pk,
 					"data":  hd.Data,
 				}, node.Secure); err != nil {
 					if node.Active {
 						node.Active = false
-						nodeManager.nodes.Set(node.Address, node)
+						nm.nodes.Set(node.Address, node)
 					}
 					if nodeManagerDebug {
 						lg.ErrorC("Failed to sync insert:")
@@ -359,9 +358,9 @@ func initNodeManagerHooks(nodeManager *NodeManager) {
 
 	// Add hook for updates
 	OnSet(func(hd HookData) {
-		if nodeManager != nil && !nodeManager.inSync {
+		if nm != nil && !nm.inSync {
 			if !mapsEqual(hd.Old, hd.New) {
-				nodes := nodeManager.GetNodes()
+				nodes := nm.GetNodes()
 				if nodeManagerDebug {
 					fmt.Println("----------------------------")
 					fmt.Println("OnSet:", hd)
@@ -369,10 +368,10 @@ func initNodeManagerHooks(nodeManager *NodeManager) {
 					fmt.Println("----------------------------")
 				}
 				for _, node := range nodes {
-					if err := nodeManager.server.PublishToServer(node.Address, map[string]any{
+					if err := nm.server.PublishToServer(node.Address, map[string]any{
 						"mtype":    "update_rec",
-						"id":       nodeManager.server.ID,
-						"addr":     nodeManager.server.App().Address(),
+						"id":       nm.server.ID,
+						"addr":     nm.server.App().Address(),
 						"table":    hd.Table,
 						"pk":       hd.Pk,
 						"old_data": hd.Old,
@@ -380,7 +379,7 @@ func initNodeManagerHooks(nodeManager *NodeManager) {
 					}, node.Secure); err != nil {
 						if node.Active {
 							node.Active = false
-							nodeManager.nodes.Set(node.Address, node)
+							nm.nodes.Set(node.Address, node)
 						}
 						if nodeManagerDebug {
 							lg.ErrorC("Failed to sync set")
@@ -394,8 +393,8 @@ func initNodeManagerHooks(nodeManager *NodeManager) {
 
 	// Add hook for deletes
 	OnDelete(func(hd HookData) {
-		if nodeManager != nil && !nodeManager.inSync {
-			nodes := nodeManager.GetNodes()
+		if nm != nil && !nm.inSync {
+			nodes := nm.GetNodes()
 			if nodeManagerDebug {
 				fmt.Println("----------------------------")
 				fmt.Println("OnDelete:", hd)
@@ -403,10 +402,10 @@ func initNodeManagerHooks(nodeManager *NodeManager) {
 				fmt.Println("----------------------------")
 			}
 			for _, node := range nodes {
-				if err := nodeManager.server.PublishToServer(node.Address, map[string]any{
+				if err := nm.server.PublishToServer(node.Address, map[string]any{
 					"mtype": "delete_rec",
-					"id":    nodeManager.server.ID,
-					"addr":  nodeManager.server.App().Address(),
+					"id":    nm.server.ID,
+					"addr":  nm.server.App().Address(),
 					"table": hd.Table,
 					"pk":    hd.Pk,
 					"data":  hd.Data,
@@ -419,8 +418,8 @@ func initNodeManagerHooks(nodeManager *NodeManager) {
 
 	// Add hook for drops
 	OnDrop(func(hd HookData) {
-		if nodeManager != nil && !nodeManager.inSync {
-			nodes := nodeManager.GetNodes()
+		if nm != nil && !nm.inSync {
+			nodes := nm.GetNodes()
 			if nodeManagerDebug {
 				fmt.Println("----------------------------")
 				fmt.Println("OnDrop:", hd)
@@ -428,10 +427,10 @@ func initNodeManagerHooks(nodeManager *NodeManager) {
 				fmt.Println("----------------------------")
 			}
 			for _, node := range nodes {
-				if err := nodeManager.server.PublishToServer(node.Address, map[string]any{
+				if err := nm.server.PublishToServer(node.Address, map[string]any{
 					"mtype": "drop_table",
-					"id":    nodeManager.server.ID,
-					"addr":  nodeManager.server.App().Address(),
+					"id":    nm.server.ID,
+					"addr":  nm.server.App().Address(),
 					"table": hd.Table,
 				}, node.Secure); err != nil {
 					lg.ErrorC("Failed to sync insert")
@@ -442,14 +441,14 @@ func initNodeManagerHooks(nodeManager *NodeManager) {
 }
 
 func onServerData(msgAny ksps.Message) {
-	if nodeManager == nil {
+	if defaultClient.nodeManager == nil {
 		return
 	}
 	msg := msgAny.Data.(map[string]any)
-	nodeManager.inSync = true
+	defaultClient.nodeManager.inSync = true
 	defer func() {
-		if nodeManager != nil {
-			nodeManager.inSync = false
+		if defaultClient.nodeManager != nil {
+			defaultClient.nodeManager.inSync = false
 		}
 	}()
 	if nodeManagerDebug {
@@ -463,21 +462,23 @@ func onServerData(msgAny ksps.Message) {
 	switch msg["mtype"] {
 	case "node_offline":
 		if addr, ok := msg["addr"].(string); ok {
-			n := nodeManager.GetNode(addr)
+			n := defaultClient.nodeManager.GetNode(addr)
 			if n != nil {
 				n.Active = false
-				nodeManager.nodes.Set(n.Address, n)
+				defaultClient.nodeManager.nodes.Set(n.Address, n)
 			}
 		}
+	case "lock_acquire", "lock_release", "lock_refresh", "lock_reply":
+		defaultClient.onLockMessage(msg)
 	case "ping":
 		// Respond to ping
 		// id := msg["id"].(string)
 		addr := msg["addr"].(string)
-		err := nodeManager.server.PublishToServer(addr, map[string]any{
+		err := defaultClient.nodeManager.server.PublishToServer(addr, map[string]any{
 			"mtype": "pong",
-			"addr":  nodeManager.server.App().Address(),
-			"id":    nodeManager.server.ID,
-		}, nodeManager.IsSecure(addr))
+			"addr":  defaultClient.nodeManager.server.App().Address(),
+			"id":    defaultClient.nodeManager.server.ID,
+		}, defaultClient.nodeManager.IsSecure(addr))
 		if err != nil {
 			lg.ErrorC("Failed to respond to ping")
 		}
@@ -489,7 +490,7 @@ func onServerData(msgAny ksps.Message) {
 			fmt.Println("initsync sending all tables in chunks to remote", id, addr)
 			fmt.Println("----------------------------")
 		}
-		_ = nodeManager.SyncData(&Node{
+		_ = defaultClient.nodeManager.SyncData(&Node{
 			ID:      id,
 			Address: addr,
 		})
@@ -499,7 +500,7 @@ func onServerData(msgAny ksps.Message) {
 		statements := msg["statements"].([]any)
 		allTablesMemAny := msg["tablesMem"].([]any)
 		addr := msg["addr"].(string)
-		db, err := GetMemoryDatabase(defaultDB)
+		db, err := GetMemoryDatabase(defaultClient.defaultDB)
 		if lg.CheckError(err) {
 			return
 		}
@@ -602,18 +603,18 @@ func onServerData(msgAny ksps.Message) {
 					if nodeManagerDebug {
 						fmt.Println("adding changes trigger for", tr)
 					}
-					err = AddChangesTrigger(tr, defaultDB)
+					err = AddChangesTrigger(tr, defaultClient.defaultDB)
 					lg.CheckError(err)
 					m[tr] = true
 				}
 			}
 		}
 		flushCache()
-		if err := nodeManager.server.PublishToServer(addr, map[string]any{
+		if err := defaultClient.nodeManager.server.PublishToServer(addr, map[string]any{
 			"mtype": "initsync",
-			"addr":  nodeManager.server.App().Address(),
-			"id":    nodeManager.server.ID,
-		}, nodeManager.IsSecure(addr)); err != nil {
+			"addr":  defaultClient.nodeManager.server.App().Address(),
+			"id":    defaultClient.nodeManager.server.ID,
+		}, defaultClient.nodeManager.IsSecure(addr)); err != nil {
 			if nodeManagerDebug {
 				fmt.Println("ERROR: failed to sync data to node", "targetNode.Addr", addr, "err", err)
 			}
@@ -638,7 +639,7 @@ func onServerData(msgAny ksps.Message) {
 		nf := []string{}
 
 		// Always update the node with the latest ID
-		if existingNode, exists := nodeManager.nodes.Get(addr); exists {
+		if existingNode, exists := defaultClient.nodeManager.nodes.Get(addr); exists {
 			existingNode.ID = id
 			existingNode.Active = true
 			existingNode.Secure = secure
@@ -649,7 +650,7 @@ func onServerData(msgAny ksps.Message) {
 				Secure:  secure,
 				Active:  true,
 			}
-			err := nodeManager.AddNode(newNode)
+			err := defaultClient.nodeManager.AddNode(newNode)
 			if err != nil {
 				lg.ErrorC("unable to add node", "node", newNode.Address)
 				return
@@ -657,11 +658,11 @@ func onServerData(msgAny ksps.Message) {
 		}
 
 		// Send back our node info to update the remote node's list
-		if err := nodeManager.server.PublishToServer(addr, map[string]any{
+		if err := defaultClient.nodeManager.server.PublishToServer(addr, map[string]any{
 			"mtype":  "node_info",
-			"addr":   nodeManager.server.App().Address(),
-			"id":     nodeManager.server.ID,
-			"secure": nodeManager.secure,
+			"addr":   defaultClient.nodeManager.server.App().Address(),
+			"id":     defaultClient.nodeManager.server.ID,
+			"secure": defaultClient.nodeManager.secure,
 		}, secure); err != nil {
 			lg.ErrorC("failed to send node info")
 		}
@@ -686,7 +687,7 @@ func onServerData(msgAny ksps.Message) {
 			Dialect   string
 		}
 		dataToSend := []MMigration{}
-		db, _ := GetMemoryDatabase(defaultDB)
+		db, _ := GetMemoryDatabase(defaultClient.defaultDB)
 		for _, tname := range nf {
 			// use requested dialect instead of our dialect
 			vDB := *db
@@ -695,7 +696,7 @@ func onServerData(msgAny ksps.Message) {
 				Table:   tname,
 				Dialect: dialect,
 			}
-			if v, ok := mModelTablename[tname]; ok {
+			if v, ok := defaultClient.mModelTablename[tname]; ok {
 				// create migrate statement
 				stat, err := autoMigrateAny(v, &vDB, tname, false)
 				if lg.CheckError(err) {
@@ -710,14 +711,14 @@ func onServerData(msgAny ksps.Message) {
 			fmt.Println("tables not found on remote:", nf)
 		}
 		// send to remote migrate statement for missing tables
-		if err := nodeManager.server.PublishToServer(addr, map[string]any{
+		if err := defaultClient.nodeManager.server.PublishToServer(addr, map[string]any{
 			"mtype":      "migrate",
-			"addr":       nodeManager.server.App().Address(),
-			"id":         nodeManager.server.ID,
+			"addr":       defaultClient.nodeManager.server.App().Address(),
+			"id":         defaultClient.nodeManager.server.ID,
 			"tables":     nf,
 			"statements": dataToSend,
 			"tablesMem":  GetTablesInfosFromDB(db.Name),
-		}, nodeManager.IsSecure(addr)); err != nil {
+		}, defaultClient.nodeManager.IsSecure(addr)); err != nil {
 			lg.ErrorC("failed to sync data to node", "targetNode.Addr", addr)
 			return
 		}
@@ -732,14 +733,14 @@ func onServerData(msgAny ksps.Message) {
 		if addr, ok := msg["from_server"].(string); ok {
 			// after restart
 			secure := msg["from_secure"].(bool)
-			if _, ok := nodeManager.nodes.Get(addr); !ok {
-				lg.CheckError(nodeManager.AddNode(&Node{
+			if _, ok := defaultClient.nodeManager.nodes.Get(addr); !ok {
+				lg.CheckError(defaultClient.nodeManager.AddNode(&Node{
 					Address: addr,
 					Secure:  secure,
 					Active:  true,
 				}))
 			}
-			nodes := nodeManager.GetNodes()
+			nodes := defaultClient.nodeManager.GetNodes()
 			secureNodes := 0
 			activeNodes := 0
 			for _, n := range nodes {
@@ -750,7 +751,7 @@ func onServerData(msgAny ksps.Message) {
 					secureNodes++
 				}
 			}
-			nodeManager.server.Publish("korm_db_dashboard_nm", map[string]any{
+			defaultClient.nodeManager.server.Publish("korm_db_dashboard_nm", map[string]any{
 				"nodes":  nodes,
 				"total":  len(nodes),
 				"active": activeNodes,
@@ -867,7 +868,7 @@ func onServerData(msgAny ksps.Message) {
 		flushCache()
 		if dahsboardUsed {
 			data[pk] = pkID
-			nodeManager.server.Publish("korm_db_dashboard_hooks", msg)
+			defaultClient.nodeManager.server.Publish("korm_db_dashboard_hooks", msg)
 		}
 	case "update_rec":
 		id := msg["id"].(string)
@@ -899,10 +900,10 @@ func onServerData(msgAny ksps.Message) {
 				return
 			}
 			flushCache()
-			if dahsboardUsed && nodeManager != nil && nodeManager.server != nil {
+			if dahsboardUsed && defaultClient.nodeManager != nil && defaultClient.nodeManager.server != nil {
 				oldData[pk] = pkID
 				newData[pk] = pkID
-				nodeManager.server.Publish("korm_db_dashboard_hooks", msg)
+				defaultClient.nodeManager.server.Publish("korm_db_dashboard_hooks", msg)
 			}
 		}
 	case "delete_rec":
@@ -932,7 +933,7 @@ func onServerData(msgAny ksps.Message) {
 		flushCache()
 		if dahsboardUsed {
 			data[pk] = pkID
-			nodeManager.server.Publish("korm_db_dashboard_hooks", msg)
+			defaultClient.nodeManager.server.Publish("korm_db_dashboard_hooks", msg)
 		}
 	case "drop_table":
 		id := msg["id"].(string)
@@ -953,13 +954,13 @@ func onServerData(msgAny ksps.Message) {
 		}
 		flushCache()
 		if dahsboardUsed {
-			nodeManager.server.Publish("korm_db_dashboard_hooks", msg)
+			defaultClient.nodeManager.server.Publish("korm_db_dashboard_hooks", msg)
 		}
 	case "restart_node":
 		// Received restart command from another node
 		go func() {
 			time.Sleep(100 * time.Millisecond)
-			lg.CheckError(nodeManager.gracefulRestart())
+			lg.CheckError(defaultClient.nodeManager.gracefulRestart())
 		}()
 	case "node_info":
 		// Update node info received from remote node
@@ -967,7 +968,7 @@ func onServerData(msgAny ksps.Message) {
 		addr := msg["addr"].(string)
 		secure := msg["secure"].(bool)
 
-		if existingNode, exists := nodeManager.nodes.Get(addr); exists {
+		if existingNode, exists := defaultClient.nodeManager.nodes.Get(addr); exists {
 			existingNode.ID = id
 			existingNode.Active = true
 			existingNode.Secure = secure
@@ -998,7 +999,7 @@ func newNodeManager(server *ksps.ServerBus, secure ...bool) *NodeManager {
 	nm := &NodeManager{
 		nodes:    nodes,
 		server:   server,
-		database: defaultDB,
+		database: defaultClient.defaultDB,
 		inSync:   false,
 		secure:   sec,
 	}
@@ -1023,8 +1024,8 @@ func (nm *NodeManager) AddNode(node *Node) error {
 	node.Active = true
 	nm.nodes.Set(node.Address, node)
 
-	tables := GetAllTables(defaultDB)
-	db, _ := GetMemoryDatabase(defaultDB)
+	tables := GetAllTables(defaultClient.defaultDB)
+	db, _ := GetMemoryDatabase(defaultClient.defaultDB)
 	// connect
 	data := map[string]any{
 		"mtype":   "addNode",
@@ -1080,7 +1081,7 @@ func (nm *NodeManager) GetNode(addr string) *Node {
 }
 
 func GetNodeManager() *NodeManager {
-	return nodeManager
+	return defaultClient.nodeManager
 }
 
 // SyncData send all tables to targetNode sync_data
@@ -1094,7 +1095,7 @@ func (nm *NodeManager) SyncData(targetNode *Node) error {
 		return fmt.Errorf("no tables found")
 	}
 
-	db, _ := GetMemoryDatabase(defaultDB)
+	db, _ := GetMemoryDatabase(defaultClient.defaultDB)
 
 	// For each table, sync data
 	for _, table := range tables {
@@ -1198,5 +1199,5 @@ func (nm *NodeManager) Shutdown() {
 	}
 
 	// Set global nodeManager to nil
-	nodeManager = nil
+	defaultClient.nodeManager = nil
 }
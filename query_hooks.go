@@ -0,0 +1,194 @@
+package korm
+
+import (
+	"context"
+	"database/sql/driver"
+	"time"
+)
+
+// QueryRewriter hooks can replace a statement before it runs, returning an error abort it
+type QueryRewriter interface {
+	Rewrite(ctx context.Context, query string, args ...any) (string, error)
+}
+
+// QueryInfo describe a statement seen by query hooks, get it in hooks using QueryInfoFrom
+type QueryInfo struct {
+	Database string
+	Query    string
+	Args     []any
+	Start    time.Time
+	// Elapsed is set before After and OnError, for queries returning rows it is the time to the first response
+	Elapsed time.Duration
+	// RowsAffected is set before After for statements not returning rows, -1 if unknown
+	RowsAffected int64
+	// hooks are the user hooks of the statement, taken in Before so After run the same hooks
+	hooks []Hooks
+	// span is the span of the statement when WithTraceExport is used
+	span *Span
+}
+
+type queryInfoKey struct{}
+
+// QueryInfoFrom return the QueryInfo of the statement run with ctx, it is available in query hooks added by UseQueryHooks
+func QueryInfoFrom(ctx context.Context) (*QueryInfo, bool) {
+	info, ok := ctx.Value(queryInfoKey{}).(*QueryInfo)
+	return info, ok
+}
+
+// UseQueryHooks add hooks run around every statement of all databases of the default client, including databases added later.
+// Before and Rewrite run in the order hooks were added, After and OnError in reverse order, like middlewares.
+// An error returned by Before or Rewrite abort the statement without running it, an error returned by OnError replace the error of the statement.
+// Hooks implementing QueryRewriter can replace the query, and QueryInfoFrom(ctx) give the elapsed time and rows affected in After
+//
+//	Example:
+//	  korm.UseQueryHooks(&auditHook{}, &readOnlyHook{})
+//
+//	  func (h *auditHook) After(ctx context.Context, query string, args ...any) (context.Context, error) {
+//	  	info, _ := korm.QueryInfoFrom(ctx)
+//	  	log.Println(info.Database, query, info.Elapsed, info.RowsAffected)
+//	  	return ctx, nil
+//	  }
+func UseQueryHooks(h ...Hooks) {
+	defaultClient.UseQueryHooks(h...)
+}
+
+// UseQueryHooks is korm.UseQueryHooks for client c
+func (c *Client) UseQueryHooks(h ...Hooks) {
+	c.addQueryHooks("", h)
+}
+
+// UseQueryHooksFor add hooks run around statements of dbName only, after hooks added by UseQueryHooks
+func UseQueryHooksFor(dbName string, h ...Hooks) {
+	defaultClient.UseQueryHooksFor(dbName, h...)
+}
+
+// UseQueryHooksFor is korm.UseQueryHooksFor for client c
+func (c *Client) UseQueryHooksFor(dbName string, h ...Hooks) {
+	c.addQueryHooks(dbName, h)
+}
+
+func (c *Client) addQueryHooks(dbName string, h []Hooks) {
+	c.queryHooksMu.Lock()
+	defer c.queryHooksMu.Unlock()
+	v, _ := c.queryHooks.Get(dbName)
+	c.queryHooks.Set(dbName, append(append([]Hooks{}, v...), h...))
+}
+
+// userQueryHooks return hooks of all databases followed by hooks of dbName
+func (c *Client) userQueryHooks(dbName string) []Hooks {
+	all, _ := c.queryHooks.Get("")
+	own, _ := c.queryHooks.Get(dbName)
+	if len(own) == 0 {
+		return all
+	}
+	return append(append([]Hooks{}, all...), own...)
+}
+
+// chainHooks run base, the internal hook of a database, then user hooks of the database
+type chainHooks struct {
+	c    *Client
+	db   string
+	base Hooks
+}
+
+// queryHooksFor return the hooks installed on drivers of dbName
+func (c *Client) queryHooksFor(dbName string, base Hooks) Hooks {
+	if base == nil {
+		base = noopHooks{}
+	}
+	return &chainHooks{c: c, db: dbName, base: base}
+}
+
+func (h *chainHooks) Rewrite(ctx context.Context, query string, args ...any) (string, error) {
+	var err error
+	for _, uh := range h.c.userQueryHooks(h.db) {
+		if r, ok := uh.(QueryRewriter); ok {
+			if query, err = r.Rewrite(ctx, query, args...); err != nil {
+				return query, err
+			}
+		}
+	}
+	return query, nil
+}
+
+func (h *chainHooks) Before(ctx context.Context, query string, args ...any) (context.Context, error) {
+	ctx, err := h.base.Before(ctx, query, args...)
+	if err != nil {
+		return ctx, err
+	}
+	hooks := h.c.userQueryHooks(h.db)
+	traced := h.c.spans.enabled.Load()
+	if len(hooks) == 0 && !traced && !h.c.slow.tracked() {
+		return ctx, nil
+	}
+	info := &QueryInfo{
+		Database:     h.db,
+		Query:        query,
+		Args:         args,
+		Start:        time.Now(),
+		RowsAffected: -1,
+		hooks:        hooks,
+	}
+	if traced {
+		info.span = h.c.startQuerySpan(ctx, h.db, query)
+	}
+	ctx = context.WithValue(ctx, queryInfoKey{}, info)
+	for _, uh := range hooks {
+		if ctx, err = uh.Before(ctx, query, args...); err != nil {
+			endQuerySpan(info, err)
+			return ctx, err
+		}
+	}
+	return ctx, nil
+}
+
+func (h *chainHooks) After(ctx context.Context, query string, args ...any) (context.Context, error) {
+	// base flush caches, it run even if a user hook fail
+	ctx, err := h.base.After(ctx, query, args...)
+	if err != nil {
+		return ctx, err
+	}
+	if info, ok := QueryInfoFrom(ctx); ok {
+		h.c.slow.observe(ctx, info, nil)
+		defer endQuerySpan(info, nil)
+		for i := len(info.hooks) - 1; i >= 0; i-- {
+			if ctx, err = info.hooks[i].After(ctx, query, args...); err != nil {
+				return ctx, err
+			}
+		}
+	}
+	return ctx, nil
+}
+
+func (h *chainHooks) OnError(ctx context.Context, err error, query string, args ...any) error {
+	if info, ok := QueryInfoFrom(ctx); ok {
+		for i := len(info.hooks) - 1; i >= 0; i-- {
+			err = handlerErr(ctx, info.hooks[i], err, query, args...)
+		}
+		h.c.slow.observe(ctx, info, err)
+		endQuerySpan(info, err)
+	}
+	return handlerErr(ctx, h.base, err, query, args...)
+}
+
+// rewriteQuery return query rewritten by hooks if they implement QueryRewriter
+func rewriteQuery(ctx context.Context, hooks Hooks, query string, args ...any) (string, error) {
+	if r, ok := hooks.(QueryRewriter); ok {
+		return r.Rewrite(ctx, query, args...)
+	}
+	return query, nil
+}
+
+// endQuery set the elapsed time and rows affected of the statement run with ctx
+func endQuery(ctx context.Context, res driver.Result) {
+	info, ok := QueryInfoFrom(ctx)
+	if !ok {
+		return
+	}
+	info.Elapsed = time.Since(info.Start)
+	if res != nil {
+		if n, err := res.RowsAffected(); err == nil {
+			info.RowsAffected = n
+		}
+	}
+}
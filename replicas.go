@@ -0,0 +1,243 @@
+package korm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+// ReplicaPolicy is the strategy used to pick a read replica
+type ReplicaPolicy int
+
+const (
+	// RoundRobin rotate reads over all healthy replicas (default)
+	RoundRobin ReplicaPolicy = iota
+	// LeastLatency send reads to the healthy replica with the lowest last ping
+	LeastLatency
+)
+
+var (
+	// ReplicaHealthCheckEvery is the interval between replicas pings
+	ReplicaHealthCheckEvery = 10 * time.Second
+)
+
+// Replica hold a read only connection to a replica of a database
+type Replica struct {
+	DSN     string
+	Conn    *sql.DB
+	healthy atomic.Bool
+	latency atomic.Int64
+}
+
+// replicaSet hold the replicas of a database, the list is replaced on add so readers and the health checker can range over it
+// without lock while AddReplica run
+type replicaSet struct {
+	mu     sync.Mutex
+	list   atomic.Pointer[[]*Replica]
+	next   atomic.Uint64
+	policy atomic.Int32
+}
+
+// load return the current replicas, the returned slice should not be modified
+func (rs *replicaSet) load() []*Replica {
+	if rs == nil {
+		return nil
+	}
+	if l := rs.list.Load(); l != nil {
+		return *l
+	}
+	return nil
+}
+
+// add append r to a copy of the replicas and swap it
+func (rs *replicaSet) add(r *Replica) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	old := rs.load()
+	list := make([]*Replica, 0, len(old)+1)
+	list = append(list, old...)
+	list = append(list, r)
+	rs.list.Store(&list)
+}
+
+// Replicas return the read replicas of db
+func (db *DatabaseEntity) Replicas() []*Replica {
+	return db.replicas.load()
+}
+
+// Healthy return true if the last health check of the replica succeeded
+func (r *Replica) Healthy() bool {
+	return r.healthy.Load()
+}
+
+// Latency return the duration of the last successful ping
+func (r *Replica) Latency() time.Duration {
+	return time.Duration(r.latency.Load())
+}
+
+// AddReplica register a read replica for dbName, reads from builders and selectors will be routed to it
+//
+//	Example:
+//	  korm.AddReplica("dbName", pgdriver.Use(), "user:password@replica1:5432")
+func AddReplica(dbName string, dbDriver driver.Driver, dbDSN string) error {
+	return defaultClient.AddReplica(dbName, dbDriver, dbDSN)
+}
+
+// AddReplica is korm.AddReplica for client c
+func (c *Client) AddReplica(dbName string, dbDriver driver.Driver, dbDSN string) error {
+	if dbDriver == nil {
+		return errors.New("AddReplica expect a dbDriver")
+	}
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil {
+		return err
+	}
+	_, dsn, _, err := buildDSN(db.Dialect, db.Name, dbDSN)
+	if err != nil {
+		return err
+	}
+	if db.Dialect == SQLITE && dbDSN != "" {
+		dsn = dbDSN
+	}
+
+	cstm := GenerateUUID()
+	if useCache {
+		sql.Register(cstm, Wrap(dbDriver, c.queryHooksFor(db.Name, &logAndCacheHook{c: c, db: db.Name})))
+	} else {
+		sql.Register(cstm, Wrap(dbDriver, c.queryHooksFor(db.Name, noopHooks{})))
+	}
+	conn, err := sql.Open(cstm, dsn)
+	if lg.CheckError(err) {
+		return err
+	}
+	start := time.Now()
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return fmt.Errorf("replica of %s unreachable: %w", dbName, err)
+	}
+	if db.Options.MaxOpenConns > 0 {
+		conn.SetMaxOpenConns(db.Options.MaxOpenConns)
+	} else {
+		conn.SetMaxOpenConns(MaxOpenConns)
+	}
+	if db.Options.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(db.Options.MaxIdleConns)
+	} else {
+		conn.SetMaxIdleConns(MaxIdleConns)
+	}
+	if db.Options.MaxLifetime > 0 {
+		conn.SetConnMaxLifetime(db.Options.MaxLifetime)
+	} else {
+		conn.SetConnMaxLifetime(MaxLifetime)
+	}
+	if db.Options.MaxIdleTime > 0 {
+		conn.SetConnMaxIdleTime(db.Options.MaxIdleTime)
+	} else {
+		conn.SetConnMaxIdleTime(MaxIdleTime)
+	}
+
+	r := &Replica{
+		DSN:  dsn,
+		Conn: conn,
+	}
+	r.healthy.Store(true)
+	r.latency.Store(int64(time.Since(start)))
+	db.replicas.add(r)
+
+	if c.replicasCheckerStarted.CompareAndSwap(false, true) {
+		c.goWorker("replicas checker", "", func(ctx context.Context) {
+			for sleepCtx(ctx, ReplicaHealthCheckEvery) {
+				c.checkReplicas()
+			}
+		})
+	}
+	return nil
+}
+
+// SetReplicaPolicy set the strategy used to choose a replica for dbName, or the first connected database
+func SetReplicaPolicy(policy ReplicaPolicy, dbName ...string) error {
+	return defaultClient.SetReplicaPolicy(policy, dbName...)
+}
+
+// SetReplicaPolicy is korm.SetReplicaPolicy for client c
+func (c *Client) SetReplicaPolicy(policy ReplicaPolicy, dbName ...string) error {
+	name := ""
+	if len(dbName) > 0 {
+		name = dbName[0]
+	}
+	db, err := c.GetMemoryDatabase(name)
+	if err != nil {
+		return err
+	}
+	db.replicas.policy.Store(int32(policy))
+	return nil
+}
+
+// checkReplicas ping all replicas of c and mark them healthy or not
+func (c *Client) checkReplicas() {
+	for i := range c.databases {
+		for _, r := range c.databases[i].Replicas() {
+			start := time.Now()
+			if err := r.Conn.Ping(); err != nil {
+				if r.healthy.Swap(false) {
+					lg.ErrorC("replica unhealthy, skipping it", "db", c.databases[i].Name, "err", err)
+				}
+				continue
+			}
+			r.latency.Store(int64(time.Since(start)))
+			if !r.healthy.Swap(true) {
+				lg.InfoC("replica healthy again", "db", c.databases[i].Name)
+			}
+		}
+	}
+}
+
+// readConn return the connection to use for reads, a healthy replica if any, otherwise the read only pool or the primary
+func (db *DatabaseEntity) readConn(primary bool) *sql.DB {
+	if primary {
+		// the read only pool of a sqlite single writer read the same file, the writer connection is kept for writes and transactions
+		if db.readOnly != nil {
+			return db.readOnly
+		}
+		return db.Conn
+	}
+	replicas := db.Replicas()
+	if len(replicas) == 0 {
+		if db.readOnly != nil {
+			return db.readOnly
+		}
+		return db.Conn
+	}
+	switch ReplicaPolicy(db.replicas.policy.Load()) {
+	case LeastLatency:
+		var best *Replica
+		for _, r := range replicas {
+			if !r.Healthy() {
+				continue
+			}
+			if best == nil || r.Latency() < best.Latency() {
+				best = r
+			}
+		}
+		if best != nil {
+			return best.Conn
+		}
+	default:
+		n := len(replicas)
+		start := db.replicas.next.Add(1)
+		for i := 0; i < n; i++ {
+			r := replicas[(int(start)+i)%n]
+			if r.Healthy() {
+				return r.Conn
+			}
+		}
+	}
+	return db.Conn
+}
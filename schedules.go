@@ -0,0 +1,383 @@
+package korm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+var (
+	// ScheduleTick is the interval between checks of due schedules
+	ScheduleTick = time.Second
+	// ScheduleHistoryKeep is how long runs are kept in _schedules_runs
+	ScheduleHistoryKeep = 7 * 24 * time.Hour
+)
+
+// ScheduleEntry is the persisted state of a schedule in the _schedules table
+type ScheduleEntry struct {
+	Id         uint      `korm:"pk" json:"id"`
+	Name       string    `korm:"size:100;unique" json:"name"`
+	Spec       string    `korm:"size:100" json:"spec"`
+	LastRun    time.Time `json:"last_run"`
+	NextRun    time.Time `korm:"index" json:"next_run"`
+	LastError  string    `korm:"text" json:"last_error"`
+	LastNode   string    `korm:"size:100" json:"last_node"`
+	DurationMs int64     `json:"duration_ms"`
+	Runs       int       `json:"runs"`
+}
+
+// ScheduleRun is a run of a schedule kept in _schedules_runs
+type ScheduleRun struct {
+	Id         uint      `korm:"pk" json:"id"`
+	Name       string    `korm:"size:100;index" json:"name"`
+	Node       string    `korm:"size:100" json:"node"`
+	StartedAt  time.Time `korm:"index" json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Error      string    `korm:"text" json:"error"`
+}
+
+type scheduled struct {
+	spec *cronSpec
+	fn   func(ctx context.Context) error
+}
+
+// Schedule run fn at times matching the cron expression spec, 'sec min hour dom month dow' or 'min hour dom month dow',
+// @yearly, @monthly, @weekly, @daily and @hourly are also supported. The state is kept in the _schedules table of the first
+// connected database and each tick run on a single node of the cluster, the node that move next_run forward first
+//
+//	Example:
+//	  err := korm.Schedule("0 */5 * * * *", "cleanup", func(ctx context.Context) error {
+//	  	_, err := korm.Table("sessions").Where("expires_at < ?", time.Now()).Delete()
+//	  	return err
+//	  })
+func Schedule(spec, name string, fn func(ctx context.Context) error) error {
+	return defaultClient.Schedule(spec, name, fn)
+}
+
+// Schedule is korm.Schedule for client c
+func (c *Client) Schedule(spec, name string, fn func(ctx context.Context) error) error {
+	cs, err := parseCron(spec)
+	if err != nil {
+		return err
+	}
+	db, err := c.GetMemoryDatabase("")
+	if err != nil {
+		return err
+	}
+	if _, ok := c.internalTables.Get(db.Name + "._schedules"); !ok {
+		if err := AutoMigrateOn[ScheduleEntry](c, "_schedules", db.Name); err != nil {
+			return err
+		}
+		if err := AutoMigrateOn[ScheduleRun](c, "_schedules_runs", db.Name); err != nil {
+			return err
+		}
+		c.internalTables.Set(db.Name+"._schedules", struct{}{})
+	}
+	next := cs.next(time.Now())
+	entry, err := ModelOn[ScheduleEntry](c).Database(db.Name).NoCache().Where("name = ?", name).One()
+	switch {
+	case errors.Is(err, ErrNoData):
+		_, err = ModelOn[ScheduleEntry](c).Database(db.Name).Insert(&ScheduleEntry{
+			Name:    name,
+			Spec:    spec,
+			LastRun: time.Unix(0, 0),
+			NextRun: next,
+		})
+		if err != nil {
+			// another node registered it at the same time
+			if _, e := ModelOn[ScheduleEntry](c).Database(db.Name).NoCache().Where("name = ?", name).One(); e != nil {
+				return err
+			}
+		}
+	case err != nil:
+		return err
+	case entry.Spec != spec:
+		_, err = ModelOn[ScheduleEntry](c).Database(db.Name).Where("name = ?", name).Set("spec = ?, next_run = ?", spec, next)
+		if err != nil {
+			return err
+		}
+	}
+	c.schedules.Set(name, &scheduled{spec: cs, fn: fn})
+	c.schedulerOnce.Do(func() {
+		c.goWorker("scheduler", db.Name, func(ctx context.Context) {
+			for sleepCtx(ctx, ScheduleTick) {
+				c.runDueSchedules(ctx, db)
+			}
+		})
+	})
+	return nil
+}
+
+// Unschedule stop running name on this node, the row in _schedules is kept for other nodes
+func Unschedule(name string) {
+	defaultClient.Unschedule(name)
+}
+
+// Unschedule is korm.Unschedule for client c
+func (c *Client) Unschedule(name string) {
+	c.schedules.Delete(name)
+}
+
+// runDueSchedules claim and run schedules of db having next_run in the past
+func (c *Client) runDueSchedules(ctx context.Context, db *DatabaseEntity) {
+	q := dialectOf(db.Dialect).Quote
+	st := "SELECT " + q("name") + ", " + q("next_run") + " FROM " + q("_schedules") + " WHERE " + q("next_run") + " <= ?"
+	AdaptPlaceholdersToDialect(&st, db.Dialect)
+	rows, cancel, err := db.queryContext(ctx, true, st, time.Now().Unix())
+	if err != nil {
+		cancel()
+		if ctx.Err() == nil {
+			lg.ErrorC("could not read schedules", "err", err)
+		}
+		return
+	}
+	type due struct {
+		name    string
+		nextRun int64
+	}
+	dues := []due{}
+	for rows.Next() {
+		var d due
+		if rows.Scan(&d.name, &d.nextRun) == nil {
+			dues = append(dues, d)
+		}
+	}
+	rows.Close()
+	cancel()
+
+	claim := "UPDATE " + q("_schedules") + " SET " + q("next_run") + " = ?, " + q("last_run") + " = ?, " + q("last_node") + " = ?, " +
+		q("runs") + " = " + q("runs") + " + 1 WHERE " + q("name") + " = ? AND " + q("next_run") + " = ?"
+	AdaptPlaceholdersToDialect(&claim, db.Dialect)
+	node := c.nodeID()
+	for _, d := range dues {
+		s, ok := c.schedules.Get(d.name)
+		if !ok {
+			continue
+		}
+		now := time.Now()
+		// missed ticks are skipped, only the node moving next_run forward run this tick
+		res, err := db.execContext(ctx, claim, s.spec.next(now).Unix(), now.Unix(), node, d.name, d.nextRun)
+		if err != nil {
+			continue
+		}
+		if n, err := res.RowsAffected(); err != nil || n != 1 {
+			continue
+		}
+		name := d.name
+		c.goWorker("schedule "+name, db.Name, func(ctx context.Context) {
+			c.runSchedule(ctx, db, name, node, s)
+		})
+	}
+}
+
+// runSchedule execute s and record the run in _schedules and _schedules_runs
+func (c *Client) runSchedule(ctx context.Context, db *DatabaseEntity, name, node string, s *scheduled) {
+	start := time.Now()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("schedule panic: %v", r)
+			}
+		}()
+		return s.fn(ctx)
+	}()
+	duration := time.Since(start).Milliseconds()
+	errStr := ""
+	if err != nil {
+		errStr = err.Error()
+		lg.ErrorC("schedule failed", "name", name, "err", err)
+	}
+	bg := context.WithoutCancel(ctx)
+	_, e := ModelOn[ScheduleEntry](c).Database(db.Name).Context(bg).Where("name = ?", name).Set("last_error = ?, duration_ms = ?", errStr, duration)
+	lg.CheckError(e)
+	_, e = ModelOn[ScheduleRun](c).Database(db.Name).Context(bg).Insert(&ScheduleRun{
+		Name:       name,
+		Node:       node,
+		StartedAt:  start,
+		DurationMs: duration,
+		Error:      errStr,
+	})
+	lg.CheckError(e)
+	if ScheduleHistoryKeep > 0 {
+		_, _ = ModelOn[ScheduleRun](c).Database(db.Name).Context(bg).Where("name = ? AND started_at < ?", name, time.Now().Add(-ScheduleHistoryKeep)).Delete()
+	}
+}
+
+// nodeID return the node manager server id, or a random id kept for the life of c
+func (c *Client) nodeID() string {
+	if c.nodeManager != nil && c.nodeManager.server != nil {
+		return c.nodeManager.server.ID
+	}
+	return c.node
+}
+
+// cronSpec hold allowed values of each cron field as bits
+type cronSpec struct {
+	second, minute, hour, dom, month, dow uint64
+	domStar, dowStar                      bool
+}
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+var cronMonths = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6, "jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+var cronDays = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+
+// parseCron parse a cron expression with or without seconds field
+func parseCron(spec string) (*cronSpec, error) {
+	spec = strings.TrimSpace(spec)
+	if d, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+	fields := strings.Fields(spec)
+	if len(fields) == 5 {
+		fields = append([]string{"0"}, fields...)
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 or 6 fields", spec)
+	}
+	cs := &cronSpec{
+		domStar: fields[3] == "*" || fields[3] == "?",
+		dowStar: fields[5] == "*" || fields[5] == "?",
+	}
+	var err error
+	if cs.second, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if cs.minute, err = parseCronField(fields[1], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if cs.hour, err = parseCronField(fields[2], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if cs.dom, err = parseCronField(fields[3], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if cs.month, err = parseCronField(fields[4], 1, 12, cronMonths); err != nil {
+		return nil, err
+	}
+	if cs.dow, err = parseCronField(fields[5], 0, 7, cronDays); err != nil {
+		return nil, err
+	}
+	// 7 is sunday too
+	if cs.dow&(1<<7) != 0 {
+		cs.dow |= 1
+	}
+	return cs, nil
+}
+
+// parseCronField parse a comma separated list of '*', 'a', 'a-b' with optional '/step'
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	value := func(s string) (int, error) {
+		if v, ok := names[strings.ToLower(s)]; ok {
+			return v, nil
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil || v < min || v > max {
+			return 0, fmt.Errorf("invalid cron value %q, expected %d-%d", s, min, max)
+		}
+		return v, nil
+	}
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i > -1 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return 0, fmt.Errorf("invalid cron step %q", part)
+			}
+			rng, step = part[:i], s
+		}
+		start, end := min, max
+		switch {
+		case rng == "*" || rng == "?":
+		case strings.Contains(rng, "-"):
+			sp := strings.SplitN(rng, "-", 2)
+			var err error
+			if start, err = value(sp[0]); err != nil {
+				return 0, err
+			}
+			if end, err = value(sp[1]); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid cron range %q", rng)
+			}
+		default:
+			v, err := value(rng)
+			if err != nil {
+				return 0, err
+			}
+			start = v
+			if step == 1 {
+				end = v
+			}
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (cs *cronSpec) dayMatches(t time.Time) bool {
+	dom := cs.dom&(1<<uint(t.Day())) != 0
+	dow := cs.dow&(1<<uint(t.Weekday())) != 0
+	if cs.domStar || cs.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next return the first time after t matching cs, zero time if none in the next 5 years
+func (cs *cronSpec) next(t time.Time) time.Time {
+	t = t.Truncate(time.Second).Add(time.Second)
+	limit := t.Year() + 5
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for cs.month&(1<<uint(t.Month())) == 0 {
+		t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !cs.dayMatches(t) {
+		t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for cs.hour&(1<<uint(t.Hour())) == 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for cs.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	for cs.second&(1<<uint(t.Second())) == 0 {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
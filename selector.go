@@ -2,12 +2,10 @@ package korm
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -27,9 +25,11 @@ type Selector[T any] struct {
 	debug   bool
 	ctx     context.Context
 	db      *DatabaseEntity
+	c       *Client
 	dest    *[]T
 	nocache bool
 	trace   bool
+	primary bool
 }
 
 type JsonOption struct {
@@ -51,126 +51,22 @@ func JSON_EXTRACT(dataJson string, opt ...JsonOption) string {
 			// db specified
 			db, err := GetMemoryDatabase(opts.Database)
 			if err != nil {
-				db = &databases[0]
+				db = &defaultClient.databases[0]
 			}
 			if opts.Dialect == "" {
 				opts.Dialect = db.Dialect
 			}
 		} else {
-			opts.Dialect = databases[0].Dialect
+			opts.Dialect = defaultClient.databases[0].Dialect
 		}
 	}
 
-	isData := ""
-	if strings.HasPrefix(dataJson, "'") {
-		isData = "'"
-	} else if strings.HasPrefix(dataJson, "{") {
-		isData = "{"
-	}
-
-	switch opts.Dialect {
-	case SQLITE, MYSQL, "sqlite":
-		st := "JSON_EXTRACT("
-		if isData == "{" {
-			st += "'" + dataJson + "'"
-		} else {
-			st += dataJson
-		}
-		if len(opts.Params) == 0 {
-			st += "),'$'"
-			return st
-		}
-		st += ","
-
-		for i, pp := range opts.Params {
-			jsonParam := ""
-			if v, ok := pp.(string); ok {
-				jsonParam = v
-			} else {
-				jsonParam = fmt.Sprint(pp)
-			}
-			if i > 0 {
-				st += ","
-			}
-			if strings.HasPrefix(jsonParam, "'") || strings.HasPrefix(jsonParam, "`") {
-				jsonParam = jsonParam[1 : len(jsonParam)-2]
-			}
-			st += "'$"
-			if strings.Contains(jsonParam, ".") {
-				sp := strings.Split(jsonParam, ".")
-				for _, s := range sp {
-					// check if s is a number
-					if _, err := strconv.Atoi(s); err == nil {
-						st += "[" + s + "]"
-					} else {
-						st += "." + s
-					}
-				}
-				st += "'"
-			} else {
-				st += "." + jsonParam + "'"
-			}
-			if i == len(opts.Params)-1 {
-				st += ")"
-			}
-		}
-		if opts.As != "" {
-			st += " AS \"" + opts.As + "\""
-		}
-		return st
-	case POSTGRES, "pg":
-		if len(opts.Params) == 0 {
-			return dataJson
-		}
-		if isData == "'" || isData == "{" {
-			if isData == "{" {
-				dataJson = "'" + dataJson + "'::jsonb"
-			} else {
-				dataJson = dataJson + "::jsonb"
-			}
-		}
-
-		if len(opts.Params) == 1 {
-			ss := ""
-			if param, ok := opts.Params[0].(string); ok {
-				for _, s := range strings.Split(param, ".") {
-					ss += ", '" + s + "'"
-				}
-				return "JSONB_EXTRACT_PATH_TEXT(" + dataJson + ss + ")"
-			} else {
-				lg.ErrorC("param must be string", "param", param)
-				return dataJson
-			}
-		}
-		paramsString := ""
-		// opts.ParamsToExtract "a.2.name", "b.email", "b"
-		for i, extractParam := range opts.Params {
-			ep := ""
-			var ok bool
-			if ep, ok = extractParam.(string); !ok {
-				lg.ErrorC("param must be string", "param", extractParam)
-				return dataJson
-			}
-			if i > 0 {
-				paramsString += ", "
-			}
-			paramsString += "JSONB_EXTRACT_PATH_TEXT(" + dataJson
-			for _, s := range strings.Split(ep, ".") {
-				paramsString += ", '" + s + "'"
-			}
-			paramsString += ")"
-		}
-		if len(opts.Params) > 1 {
-			paramsString = "JSONB_BUILD_ARRAY(" + paramsString + ")"
-		}
-		if opts.As != "" {
-			paramsString += " AS \"" + opts.As + "\""
-		}
-		return paramsString
-	default:
+	d, ok := GetDialect(opts.Dialect)
+	if !ok {
 		lg.ErrorC("case not handled", "dialect", opts.Dialect)
 		return ""
 	}
+	return d.JSON().Extract(dataJson, opts)
 }
 
 func JSON_REMOVE(dataJson string, opt ...JsonOption) string {
@@ -185,128 +81,22 @@ func JSON_REMOVE(dataJson string, opt ...JsonOption) string {
 			// db specified
 			db, err := GetMemoryDatabase(opts.Database)
 			if err != nil {
-				db = &databases[0]
+				db = &defaultClient.databases[0]
 			}
 			if opts.Dialect == "" {
 				opts.Dialect = db.Dialect
 			}
 		} else {
-			opts.Dialect = databases[0].Dialect
+			opts.Dialect = defaultClient.databases[0].Dialect
 		}
 	}
 
-	isData := ""
-	if strings.HasPrefix(dataJson, "'") {
-		isData = "'"
-	} else if strings.HasPrefix(dataJson, "{") {
-		isData = "{"
-	}
-
-	switch opts.Dialect {
-	case SQLITE, MYSQL, "sqlite":
-		st := "JSON_REMOVE("
-		if isData == "{" {
-			st += "'" + dataJson + "'"
-		} else {
-			st += dataJson
-		}
-		if len(opts.Params) == 0 {
-			st += "),'$'"
-			return st
-		}
-		st += ","
-
-		for i, pp := range opts.Params {
-			if i > 0 {
-				st += ","
-			}
-			var jsonParam string
-			var ok bool
-			if jsonParam, ok = pp.(string); !ok {
-				lg.ErrorC("expected string", "param", pp)
-				return st
-			}
-
-			if i%2 == 0 {
-				st += "'$"
-				if strings.Contains(jsonParam, ".") {
-					sp := strings.Split(jsonParam, ".")
-					for _, s := range sp {
-						// check if s is a number
-						if _, err := strconv.Atoi(s); err == nil {
-							st += "[" + s + "]"
-						} else {
-							st += "." + s
-						}
-					}
-					st += "'"
-				} else {
-					jsonParam = strings.ReplaceAll(jsonParam, "'", "")
-					st += "." + jsonParam + "'"
-				}
-				if i == len(opts.Params)-1 {
-					st += ")"
-				}
-			} else {
-				st += "'$"
-				if _, err := strconv.Atoi(jsonParam); err == nil {
-					st += "[" + jsonParam + "]"
-				} else {
-					st += "." + jsonParam + "'"
-				}
-				if i == len(opts.Params)-1 {
-					st += ")"
-				}
-			}
-
-		}
-		if opts.As != "" {
-			st += " AS \"" + opts.As + "\""
-		}
-		return st
-	case POSTGRES, "pg":
-		// your_column_name #- '{a, 2, name}' #- '{b, email}'
-		if isData == "'" || isData == "{" {
-			if isData == "{" {
-				dataJson = "'" + dataJson + "'::jsonb"
-			} else {
-				dataJson = dataJson + "::jsonb"
-			}
-		}
-		st := dataJson
-		if len(opts.Params) == 0 {
-			return st
-		}
-		for i, pp := range opts.Params {
-			var jsonParam string
-			var ok bool
-			if jsonParam, ok = pp.(string); !ok && i%2 == 0 {
-				lg.ErrorC("expected string", "param", jsonParam)
-				return st
-			}
-			if i%2 == 0 && strings.Contains(jsonParam, ".") {
-				sp := strings.Split(jsonParam, ".")
-				tt := ""
-				for i, s := range sp {
-					if i > 0 {
-						tt += ","
-					}
-					tt += s
-				}
-				st += " #- '{" + tt + "}'"
-			} else {
-				st += " - " + jsonParam
-			}
-		}
-		if opts.As != "" {
-			st += " AS \"" + opts.As + "\""
-		}
-		return st
-
-	default:
+	d, ok := GetDialect(opts.Dialect)
+	if !ok {
 		lg.ErrorC("case not handled", "dialect", opts.Dialect)
 		return ""
 	}
+	return d.JSON().Remove(dataJson, opts)
 }
 
 func JSON_SET(dataJson string, opt ...JsonOption) string {
@@ -325,183 +115,26 @@ func JSON_SET(dataJson string, opt ...JsonOption) string {
 			// db specified
 			db, err := GetMemoryDatabase(opts.Database)
 			if err != nil {
-				db = &databases[0]
+				db = &defaultClient.databases[0]
 			}
 			if opts.Dialect == "" {
 				opts.Dialect = db.Dialect
 			}
 		} else {
-			opts.Dialect = databases[0].Dialect
+			opts.Dialect = defaultClient.databases[0].Dialect
 		}
 	}
 
-	isData := ""
-	if strings.HasPrefix(dataJson, "'") {
-		isData = "'"
-	} else if strings.HasPrefix(dataJson, "{") {
-		isData = "{"
-	}
-
-	switch opts.Dialect {
-	case SQLITE, MYSQL, "sqlite":
-		st := "JSON_SET("
-		if isData == "{" {
-			st += "'" + dataJson + "'"
-		} else {
-			st += dataJson
-		}
-		if len(opts.Params) == 0 {
-			st += "),'$'"
-			return st
-		}
-		st += ","
-
-		for i, pp := range opts.Params {
-			if i > 0 {
-				st += ","
-			}
-			var jsonParam string
-			var ok bool
-			if i%2 == 0 {
-				if jsonParam, ok = pp.(string); !ok {
-					lg.ErrorC("expected string", "param", pp)
-					return st
-				}
-				jsonParam = strings.ReplaceAll(jsonParam, "'", "")
-				st += "'$"
-				if strings.Contains(jsonParam, ".") {
-					sp := strings.Split(jsonParam, ".")
-					for _, s := range sp {
-						// check if s is a number
-						if _, err := strconv.Atoi(s); err == nil {
-							st += "[" + s + "]"
-						} else {
-							st += "." + s
-						}
-					}
-					st += "'"
-				} else {
-					st += "." + jsonParam + "'"
-				}
-			} else {
-				if jp, ok := pp.(string); ok {
-					if !strings.Contains(jp, "'") {
-						jp = "'" + jp + "'"
-					}
-					st += jp
-				} else {
-					st += fmt.Sprint(pp)
-				}
-			}
-
-			if i == len(opts.Params)-1 {
-				st += ")"
-			}
-		}
-		if opts.As != "" {
-			st += " AS \"" + opts.As + "\""
-		}
-		return st
-	case POSTGRES, "pg":
-		if isData == "'" || isData == "{" {
-			if isData == "{" {
-				dataJson = "'" + dataJson + "'::jsonb"
-			} else {
-				dataJson = dataJson + "::jsonb"
-			}
-		}
-		newws := make([]string, 0, len(opts.Params)/2)
-		st := "JSONB_SET(" + dataJson
-		if len(opts.Params) == 0 {
-			return st + ")"
-		} else if len(opts.Params) == 2 {
-			for i, pp := range opts.Params {
-				var jsonParam string
-				var ok bool
-				if i == 0 {
-					if jsonParam, ok = pp.(string); !ok {
-						lg.ErrorC("expected string", "param", pp)
-						return dataJson
-					}
-				}
-				st += ", "
-				if i%2 == 0 && strings.Contains(jsonParam, ".") {
-					tt := strings.ReplaceAll(jsonParam, ".", ",")
-					tt = strings.ReplaceAll(tt, "'", "")
-					st += " '{" + tt + "}'"
-				} else {
-					if v, ok := pp.(string); ok {
-						if !strings.Contains(v, "'") {
-							st += " '" + v + "'"
-						} else {
-							st += v
-						}
-					} else {
-						st += fmt.Sprint(pp)
-					}
-				}
-			}
-			st += ", 'true'"
-		} else {
-			new := "JSONB_SET(" + dataJson
-			for i, pp := range opts.Params {
-				var jsonParam string
-				var ok bool
-				if i%2 == 0 {
-					new = "JSONB_SET(%s"
-					if jsonParam, ok = pp.(string); !ok {
-						lg.ErrorC("expected string", "param", pp)
-						return dataJson
-					} else {
-						pp = strings.ReplaceAll(jsonParam, "'", "")
-					}
-				}
-				new += ", "
-				if i%2 == 0 {
-					tt := strings.ReplaceAll(jsonParam, ".", ",")
-					new += " '{" + tt + "}'"
-				} else {
-					if v, ok := pp.(string); ok && i%2 == 1 {
-						if !strings.Contains(v, "'") {
-							new += " '" + v + "', 'true'"
-						} else {
-							new += " " + v + ", 'true'"
-						}
-					} else {
-						new += fmt.Sprint(pp) + ", 'true'"
-					}
-					new += ")"
-					newws = append(newws, new)
-				}
-			}
-			res := newws[0]
-			for i, v := range newws {
-				if i == 0 {
-					continue
-				}
-				res = fmt.Sprintf(res, v)
-				if i == len(newws)-1 {
-					res = fmt.Sprintf(res, dataJson)
-				}
-			}
-			if opts.As != "" {
-				res += " AS \"" + opts.As + "\""
-			}
-			return res
-		}
-		st += ")"
-		if opts.As != "" {
-			st += " AS \"" + opts.As + "\""
-		}
-		return st
-	default:
+	d, ok := GetDialect(opts.Dialect)
+	if !ok {
 		lg.ErrorC("case not handled", "dialect", opts.Dialect)
 		return ""
 	}
+	return d.JSON().Set(dataJson, opts)
 }
 
 func JSON_ARRAY(values []any, as string, dialect ...string) string {
-	dbDialect := databases[0].Dialect
+	dbDialect := defaultClient.databases[0].Dialect
 	if len(dialect) > 0 {
 		dbDialect = dialect[0]
 	}
@@ -525,27 +158,16 @@ func JSON_ARRAY(values []any, as string, dialect ...string) string {
 		}
 	}
 
-	switch dbDialect {
-	case SQLITE, MYSQL, "sqlite":
-		st := "JSON_ARRAY(" + strings.Join(valuesString, ", ") + ")"
-		if as != "" {
-			st += " AS \"" + as + "\""
-		}
-		return st
-	case POSTGRES, "pg":
-		st := "JSONB_BUILD_ARRAY(" + strings.Join(valuesString, ", ") + ")"
-		if as != "" {
-			st += " AS \"" + as + "\""
-		}
-		return st
-	default:
+	d, ok := GetDialect(dbDialect)
+	if !ok {
 		lg.ErrorC("case not handled", "dialect", dbDialect)
 		return ""
 	}
+	return d.JSON().Array(valuesString, as)
 }
 
 func JSON_OBJECT(values []any, as string, dialect ...string) string {
-	dbDialect := databases[0].Dialect
+	dbDialect := defaultClient.databases[0].Dialect
 	if len(dialect) > 0 {
 		dbDialect = dialect[0]
 	}
@@ -584,53 +206,41 @@ func JSON_OBJECT(values []any, as string, dialect ...string) string {
 		valuesString = append(valuesString, vv)
 	}
 
-	switch dbDialect {
-	case SQLITE, MYSQL, "sqlite":
-		st := "JSON_OBJECT(" + strings.Join(valuesString, ", ") + ")"
-		if as != "" {
-			st += " AS \"" + as + "\""
-		}
-		return st
-	case POSTGRES, "pg":
-		st := "JSONB_BUILD_OBJECT(" + strings.Join(valuesString, ", ") + ")"
-		if as != "" {
-			st += " AS \"" + as + "\""
-		}
-		return st
-	default:
+	d, ok := GetDialect(dbDialect)
+	if !ok {
 		lg.ErrorC("case not handled", "dialect", dbDialect)
 		return ""
 	}
+	return d.JSON().Object(valuesString, as)
 }
 
 func JSON_CAST(value string, as string, dialect ...string) string {
 	value = strings.ReplaceAll(value, "'", "\"")
-	dbDialect := databases[0].Dialect
+	dbDialect := defaultClient.databases[0].Dialect
 	if len(dialect) > 0 {
 		dbDialect = dialect[0]
 	}
 	if !strings.HasPrefix(value, "'") {
 		value = "'" + value + "'"
 	}
-	var st string
-	switch dbDialect {
-	case SQLITE, "sqlite":
-		st = "JSON(" + value + ")"
-	case POSTGRES, "pg":
-		st = value + "::jsonb"
-	case MYSQL:
-		st = "JSON_EXTRACT(" + value + ", '$')"
-	}
-	if as != "" {
-		st += " AS \"" + as + "\""
+	d, ok := GetDialect(dbDialect)
+	if !ok {
+		lg.ErrorC("case not handled", "dialect", dbDialect)
+		return ""
 	}
-	return st
+	return d.JSON().Cast(value, as)
 }
 
 func To[T any](dest *[]T, nestedSlice ...bool) *Selector[T] {
+	return ToOn(defaultClient, dest, nestedSlice...)
+}
+
+// ToOn is To using databases of client c
+func ToOn[T any](c *Client, dest *[]T, nestedSlice ...bool) *Selector[T] {
 	s := &Selector[T]{
 		dest: dest,
-		db:   &databases[0],
+		db:   &c.databases[0],
+		c:    c,
 	}
 	if len(nestedSlice) > 0 && nestedSlice[0] {
 		s.nested = true
@@ -639,11 +249,11 @@ func To[T any](dest *[]T, nestedSlice ...bool) *Selector[T] {
 }
 
 func (sl *Selector[T]) Database(dbName string) *Selector[T] {
-	db, err := GetMemoryDatabase(dbName)
+	db, err := sl.c.GetMemoryDatabase(dbName)
 	if err == nil {
 		sl.db = db
 	} else if sl.db == nil {
-		sl.db = &databases[0]
+		sl.db = &sl.c.databases[0]
 	}
 	return sl
 }
@@ -663,6 +273,12 @@ func (sl *Selector[T]) NoCache() *Selector[T] {
 	return sl
 }
 
+// Primary force the query to use the primary connection even if replicas exist
+func (sl *Selector[T]) Primary() *Selector[T] {
+	sl.primary = true
+	return sl
+}
+
 func (sl *Selector[T]) Trace() *Selector[T] {
 	if sl == nil {
 		return nil
@@ -717,7 +333,10 @@ func (sl *Selector[T]) Query(statement string, args ...any) error {
 	if useCache && !sl.nocache {
 		// Include database name in cache key to prevent cross-database cache pollution
 		stt = sl.db.Name + "::" + statement + fmt.Sprint(args...)
-		if v, ok := cacheQ.Get(stt); ok {
+		if sl.primary {
+			stt += "::primary"
+		}
+		if v, ok := sl.c.cacheQ.Get(stt); ok {
 			if len(*sl.dest) == 0 {
 				*sl.dest = v.([]T)
 				return nil
@@ -734,17 +353,12 @@ func (sl *Selector[T]) Query(statement string, args ...any) error {
 	adaptTimeToUnixArgs(&args)
 	// Replace AS 'alias' with AS "alias" for PostgreSQL compatibility
 	statement = adaptAsSingleToDoubleQuotes(statement)
-	var rows *sql.Rows
-	var err error
 	if sl.debug {
 		lg.Info("DEBUG SELECTOR", "statement", statement, "args", fmt.Sprintf("%v", args))
 	}
 
-	if sl.ctx != nil {
-		rows, err = sl.db.Conn.QueryContext(sl.ctx, statement, args...)
-	} else {
-		rows, err = sl.db.Conn.Query(statement, args...)
-	}
+	rows, cancel, err := sl.db.queryContext(sl.ctx, sl.primary, statement, args...)
+	defer cancel()
 	if err != nil {
 		return err
 	}
@@ -1006,7 +620,7 @@ loop:
 		}
 	}
 	if useCache && !sl.nocache && !isChan && len(*sl.dest) > 0 {
-		cacheQ.Set(stt, *sl.dest)
+		sl.c.cacheQ.Set(stt, *sl.dest)
 	}
 	return nil
 }
@@ -1023,7 +637,10 @@ func (sl *Selector[T]) Named(statement string, args map[string]any, unsafe ...bo
 	if useCache && !sl.nocache {
 		// Include database name in cache key to prevent cross-database cache pollution
 		stt = sl.db.Name + "::" + statement + fmt.Sprint(args)
-		if v, ok := cacheQ.Get(stt); ok {
+		if sl.primary {
+			stt += "::primary"
+		}
+		if v, ok := sl.c.cacheQ.Get(stt); ok {
 			if len(*sl.dest) == 0 {
 				*sl.dest = v.([]T)
 				return nil
@@ -1073,16 +690,11 @@ func (sl *Selector[T]) Named(statement string, args map[string]any, unsafe ...bo
 	}
 	// Replace AS 'alias' with AS "alias" for PostgreSQL compatibility
 	query = adaptAsSingleToDoubleQuotes(query)
-	var rows *sql.Rows
-	var err error
 	if sl.debug {
 		lg.Printfs("yl%s , args: %v", query, newargs)
 	}
-	if sl.ctx != nil {
-		rows, err = sl.db.Conn.QueryContext(sl.ctx, query, newargs...)
-	} else {
-		rows, err = sl.db.Conn.Query(query, newargs...)
-	}
+	rows, cancel, err := sl.db.queryContext(sl.ctx, sl.primary, query, newargs...)
+	defer cancel()
 	if err != nil {
 		return err
 	}
@@ -1344,7 +956,7 @@ loop:
 		}
 	}
 	if useCache && !sl.nocache && !isChan && len(*sl.dest) > 0 {
-		cacheQ.Set(stt, *sl.dest)
+		sl.c.cacheQ.Set(stt, *sl.dest)
 	}
 	return nil
 }
@@ -0,0 +1,275 @@
+package korm
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/ksmux"
+	"github.com/kamalshkeir/lg"
+)
+
+var (
+	// SessionCookie is the name of the cookie holding the session id
+	SessionCookie = "session"
+	// SessionTTL is the lifetime of a session, extended on activity
+	SessionTTL = 7 * 24 * time.Hour
+	// SessionRenewEvery is the minimum interval between two renewals of a session, to avoid a write at every request
+	SessionRenewEvery = 10 * time.Minute
+	// SessionExpireEvery is the interval between deletions of expired sessions
+	SessionExpireEvery = 10 * time.Minute
+	// SessionTrustedProxies are the ips or CIDRs of reverse proxies allowed to give the client ip using X-Forwarded-For and X-Real-Ip,
+	// the ip of the connection is recorded if empty
+	SessionTrustedProxies []string
+)
+
+// Session is a row of the _sessions table, the session id itself is never stored, only its sha256 Hash
+type Session struct {
+	Id        uint      `korm:"pk" json:"id"`
+	Hash      string    `korm:"size:64;unique" json:"-"`
+	UserUuid  string    `korm:"size:40;index" json:"user_uuid"`
+	Ip        string    `korm:"size:64" json:"ip"`
+	UserAgent string    `korm:"size:255" json:"user_agent"`
+	CreatedAt time.Time `korm:"now" json:"created_at"`
+	LastSeen  time.Time `json:"last_seen"`
+	ExpiresAt time.Time `korm:"index" json:"expires_at"`
+}
+
+// SessionStore create, validate and revoke sessions kept in the _sessions table
+type SessionStore struct {
+	c  *Client
+	db *DatabaseEntity
+}
+
+// Sessions return the session store of the default database, migrating _sessions and starting the deletion of expired sessions on first use.
+// It is used by the dashboard Auth and Admin middlewares
+//
+//	Example:
+//	  s, err := korm.Sessions()
+//	  err = s.Login(c, user.Uuid)     // in a login handler
+//	  session, err := s.Current(c)    // in a handler
+//	  n, err := s.RevokeUser(user.Uuid) // logout everywhere
+func Sessions() (*SessionStore, error) {
+	return defaultClient.Sessions()
+}
+
+// Sessions is korm.Sessions for client c
+func (c *Client) Sessions() (*SessionStore, error) {
+	db, err := c.GetMemoryDatabase(c.defaultDB)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.internalTables.Get(db.Name + "._sessions"); !ok {
+		if err := AutoMigrateOn[Session](c, "_sessions", db.Name); err != nil {
+			return nil, err
+		}
+		c.internalTables.Set(db.Name+"._sessions", struct{}{})
+		c.goWorker("sessions expiry", db.Name, func(ctx context.Context) {
+			for sleepCtx(ctx, SessionExpireEvery) {
+				_, err := ModelOn[Session](c).Database(db.Name).Context(ctx).Where("expires_at <= ?", time.Now()).Delete()
+				if err != nil && ctx.Err() == nil {
+					lg.ErrorC("could not delete expired sessions", "err", err)
+				}
+			}
+		})
+	}
+	return &SessionStore{c: c, db: db}, nil
+}
+
+func (s *SessionStore) model() *BuilderS[Session] {
+	return ModelOn[Session](s.c).Database(s.db.Name)
+}
+
+// Create create a session of userUuid valid for SessionTTL and return its id, r is used to record the client ip and user agent
+func (s *SessionStore) Create(userUuid string, r *http.Request) (string, Session, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", Session{}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now()
+	session := Session{
+		Hash:      hashSessionId(id),
+		UserUuid:  userUuid,
+		LastSeen:  now,
+		ExpiresAt: now.Add(SessionTTL),
+	}
+	if r != nil {
+		session.Ip = requestIP(r)
+		session.UserAgent = r.UserAgent()
+		if len(session.UserAgent) > 255 {
+			session.UserAgent = session.UserAgent[:255]
+		}
+	}
+	n, err := s.model().Insert(&session)
+	if err != nil {
+		return "", Session{}, err
+	}
+	session.Id = uint(n)
+	session.CreatedAt = now
+	return id, session, nil
+}
+
+// Get return the session of id, ErrNoData if unknown, revoked or expired.
+// The expiry is moved to now + SessionTTL if the session was not renewed during SessionRenewEvery
+func (s *SessionStore) Get(id string) (Session, error) {
+	session, _, err := s.get(id)
+	return session, err
+}
+
+func (s *SessionStore) get(id string) (Session, bool, error) {
+	if id == "" {
+		return Session{}, false, ErrNoData
+	}
+	hash := hashSessionId(id)
+	// a cached row or a lagging replica could return a revoked session
+	session, err := s.model().NoCache().Primary().Where("hash = ?", hash).One()
+	if err != nil {
+		return Session{}, false, err
+	}
+	now := time.Now()
+	if !session.ExpiresAt.After(now) {
+		return Session{}, false, ErrNoData
+	}
+	if now.Sub(session.LastSeen) < SessionRenewEvery {
+		return session, false, nil
+	}
+	session.LastSeen = now
+	session.ExpiresAt = now.Add(SessionTTL)
+	if _, err := s.model().Where("hash = ?", hash).Set("last_seen = ?, expires_at = ?", session.LastSeen, session.ExpiresAt); err != nil {
+		return Session{}, false, err
+	}
+	return session, true, nil
+}
+
+// List return active sessions of userUuid, or of all users if empty, most recently seen first
+func (s *SessionStore) List(userUuid string) ([]Session, error) {
+	q := s.model().NoCache()
+	if userUuid != "" {
+		q = q.Where("user_uuid = ? AND expires_at > ?", userUuid, time.Now())
+	} else {
+		q = q.Where("expires_at > ?", time.Now())
+	}
+	sessions, err := q.OrderBy("-last_seen").All()
+	if errors.Is(err, ErrNoData) {
+		return []Session{}, nil
+	}
+	return sessions, err
+}
+
+// Revoke delete the session of id
+func (s *SessionStore) Revoke(id string) error {
+	_, err := s.model().Where("hash = ?", hashSessionId(id)).Delete()
+	return err
+}
+
+// RevokeId delete the session having the primary key id, as listed by List
+func (s *SessionStore) RevokeId(id uint) error {
+	_, err := s.model().Where("id = ?", id).Delete()
+	return err
+}
+
+// RevokeUser delete all sessions of userUuid (logout everywhere) and return how many were deleted
+func (s *SessionStore) RevokeUser(userUuid string) (int, error) {
+	return s.model().Where("user_uuid = ?", userUuid).Delete()
+}
+
+// Login create a session of userUuid for the request of c and set the session cookie
+func (s *SessionStore) Login(c *ksmux.Context, userUuid string) error {
+	id, _, err := s.Create(userUuid, c.Request)
+	if err != nil {
+		return err
+	}
+	c.SetCookie(SessionCookie, id, SessionTTL)
+	return nil
+}
+
+// Logout revoke the session of c and delete the session cookie
+func (s *SessionStore) Logout(c *ksmux.Context) error {
+	id, err := c.GetCookie(SessionCookie)
+	c.DeleteCookie(SessionCookie)
+	if err != nil || id == "" {
+		return nil
+	}
+	return s.Revoke(id)
+}
+
+// Current return the session of c, renewing the cookie when the session is renewed, the cookie is deleted if the session is not valid
+func (s *SessionStore) Current(c *ksmux.Context) (Session, error) {
+	id, err := c.GetCookie(SessionCookie)
+	if err != nil || id == "" {
+		return Session{}, ErrNoData
+	}
+	session, renewed, err := s.get(id)
+	if err != nil {
+		c.DeleteCookie(SessionCookie)
+		return Session{}, err
+	}
+	if renewed {
+		c.SetCookie(SessionCookie, id, SessionTTL)
+	}
+	return session, nil
+}
+
+func hashSessionId(id string) string {
+	h := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(h[:])
+}
+
+// requestIP return the ip of the connection of r, or the client ip given by X-Forwarded-For and X-Real-Ip if the connection come from SessionTrustedProxies
+func requestIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if trustedProxy(ip) {
+		// proxies append the address they received the request from, the client is the last address not added by a trusted proxy
+		client := ""
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		for i := len(forwarded) - 1; i >= 0; i-- {
+			if addr := strings.TrimSpace(forwarded[i]); addr != "" {
+				client = addr
+				if !trustedProxy(addr) {
+					break
+				}
+			}
+		}
+		if client == "" {
+			client = strings.TrimSpace(r.Header.Get("X-Real-Ip"))
+		}
+		if client != "" {
+			ip = client
+		}
+	}
+	if len(ip) > 64 {
+		ip = ip[:64]
+	}
+	return ip
+}
+
+// trustedProxy return true if ip is in SessionTrustedProxies
+func trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range SessionTrustedProxies {
+		if prefix, err := netip.ParsePrefix(p); err == nil {
+			if prefix.Contains(addr) {
+				return true
+			}
+		} else if a, err := netip.ParseAddr(p); err == nil && a.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,407 @@
+package korm
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/kstrct"
+)
+
+var (
+	ErrNoShardFound = errors.New("no shard found for key")
+)
+
+// ShardFunc return the database name that hold the row having key as shard key value
+type ShardFunc func(key any) string
+
+// ShardRange map shard key values between From and To (inclusive) to Database
+type ShardRange struct {
+	From     int64
+	To       int64
+	Database string
+}
+
+type shardConfig struct {
+	key       string
+	fn        ShardFunc
+	databases []string
+	c         *Client
+}
+
+// HashShard return a ShardFunc distributing keys over dbNames using a fnv hash of the key
+func HashShard(dbNames ...string) ShardFunc {
+	return func(key any) string {
+		if len(dbNames) == 0 {
+			return ""
+		}
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(fmt.Sprint(key)))
+		return dbNames[h.Sum32()%uint32(len(dbNames))]
+	}
+}
+
+// RangeShard return a ShardFunc mapping numeric keys to the database of the range containing them
+func RangeShard(ranges ...ShardRange) ShardFunc {
+	return func(key any) string {
+		k, err := strconv.ParseInt(fmt.Sprint(key), 10, 64)
+		if err != nil {
+			return ""
+		}
+		for _, r := range ranges {
+			if k >= r.From && k <= r.To {
+				return r.Database
+			}
+		}
+		return ""
+	}
+}
+
+// ShardModel register model T as sharded over dbNames using column shardKey, if fn is nil HashShard(dbNames...) is used
+//
+// The table should be migrated on every database in dbNames, after that Model[T]() will insert into the right shard,
+// route queries having 'shardKey = ?' in their Where to a single shard and fan out other queries to all shards.
+// Auto increment primary keys are generated by each shard and collide across shards, use a globally unique key like a uuid or ulid
+// for the primary key, or give each shard its own id range, and look up rows by shard key and pk
+//
+//	Example:
+//	  korm.ShardModel[Order]("customer_id", []string{"shard0", "shard1"}, nil)
+func ShardModel[T any](shardKey string, dbNames []string, fn ShardFunc) error {
+	return ShardModelOn[T](defaultClient, shardKey, dbNames, fn)
+}
+
+// ShardModelOn is ShardModel using client c
+func ShardModelOn[T any](c *Client, shardKey string, dbNames []string, fn ShardFunc) error {
+	tName := getTableNameOn[T](c)
+	if tName == "" {
+		return ErrTableNotFound
+	}
+	if len(dbNames) == 0 {
+		return errors.New("ShardModel expect at least one database")
+	}
+	for _, name := range dbNames {
+		if _, err := c.GetMemoryDatabase(name); err != nil {
+			return err
+		}
+	}
+	if fn == nil {
+		fn = HashShard(dbNames...)
+	}
+	c.shardings.Set(tName, &shardConfig{
+		key:       shardKey,
+		fn:        fn,
+		databases: dbNames,
+		c:         c,
+	})
+	return nil
+}
+
+func (sc *shardConfig) database(key any) (*DatabaseEntity, error) {
+	name := sc.fn(key)
+	if name == "" {
+		return nil, fmt.Errorf("%w: %v", ErrNoShardFound, key)
+	}
+	return sc.c.GetMemoryDatabase(name)
+}
+
+// shardConfig return the sharding of the builder table if the database was not chosen explicitly
+func (b *BuilderS[T]) shardConfig() *shardConfig {
+	if b == nil || b.dbChosen || b.tableName == "" {
+		return nil
+	}
+	sc, ok := b.c.shardings.Get(b.tableName)
+	if !ok {
+		return nil
+	}
+	return sc
+}
+
+// shardTargets return all shards a query without shard key should be executed on, nil if not sharded or already routed
+func (b *BuilderS[T]) shardTargets() []*DatabaseEntity {
+	sc := b.shardConfig()
+	if sc == nil || b.shardRouted {
+		return nil
+	}
+	dbs := make([]*DatabaseEntity, 0, len(sc.databases))
+	for _, name := range sc.databases {
+		if db, err := b.c.GetMemoryDatabase(name); err == nil {
+			dbs = append(dbs, db)
+		}
+	}
+	return dbs
+}
+
+// routeShard set the builder database to the shard of the shard key value found in the where query
+func (b *BuilderS[T]) routeShard(query string, args []any) {
+	sc := b.shardConfig()
+	if sc == nil {
+		return
+	}
+	v, ok := shardKeyValue(query, args, sc.key)
+	if !ok {
+		return
+	}
+	if db, err := sc.database(v); err == nil {
+		b.db = db
+		b.shardRouted = true
+	}
+}
+
+// routeShardModel set the builder database to the shard of model
+func (b *BuilderS[T]) routeShardModel(model *T) error {
+	sc := b.shardConfig()
+	if sc == nil {
+		return nil
+	}
+	_, values, _, _ := getStructInfos(model)
+	v, ok := values[sc.key]
+	if !ok {
+		return fmt.Errorf("shard key %s not found in model", sc.key)
+	}
+	db, err := sc.database(v)
+	if err != nil {
+		return err
+	}
+	b.db = db
+	b.shardRouted = true
+	return nil
+}
+
+// shardKeyValue find the arg compared for equality to key in a where query using '?' placeholders
+func shardKeyValue(query string, args []any, key string) (any, bool) {
+	lower := strings.ToLower(query)
+	if strings.Contains(lower, " or ") {
+		return nil, false
+	}
+	parts := strings.Split(query, "?")
+	for i := 0; i < len(parts)-1 && i < len(args); i++ {
+		p := strings.TrimSpace(parts[i])
+		if !strings.HasSuffix(p, "=") || strings.HasSuffix(p, "!=") || strings.HasSuffix(p, "<=") || strings.HasSuffix(p, ">=") {
+			continue
+		}
+		col := strings.TrimSpace(strings.TrimSuffix(p, "="))
+		if sp := strings.Fields(col); len(sp) > 0 {
+			col = sp[len(sp)-1]
+		}
+		if i := strings.LastIndex(col, "."); i > -1 {
+			col = col[i+1:]
+		}
+		col = strings.Trim(col, "`\"(")
+		if col == key {
+			return args[i], true
+		}
+	}
+	return nil, false
+}
+
+// shardKeyValueNamed find the named arg compared for equality to key in a named where query
+func shardKeyValueNamed(query string, args map[string]any, key string) (any, bool) {
+	if strings.Contains(strings.ToLower(query), " or ") {
+		return nil, false
+	}
+	for name, v := range args {
+		for _, pattern := range []string{key + " = :" + name, key + "=:" + name, key + " =:" + name, key + "= :" + name} {
+			if idx := strings.Index(query, pattern); idx > -1 {
+				end := idx + len(pattern)
+				if end == len(query) || strings.ContainsAny(query[end:end+1], " ,)") {
+					return v, true
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+// allShards execute All on every shard and merge results using order by, limit and page
+func (b *BuilderS[T]) allShards(dbs []*DatabaseEntity, one bool) ([]T, error) {
+	limit := b.limit
+	if one {
+		limit = 1
+	} else if limit > 0 && b.page > 0 {
+		limit = b.limit * b.page
+	}
+	res := []T{}
+	for _, db := range dbs {
+		clone := *b
+		clone.db = db
+		clone.dbChosen = true
+		clone.limit = limit
+		clone.page = 0
+		clone.statement = ""
+		rows, err := clone.All()
+		if err != nil && !errors.Is(err, ErrNoData) {
+			return nil, err
+		}
+		res = append(res, rows...)
+	}
+	sortByOrderBys(res, b.orderBys)
+	if b.limit > 0 && !one {
+		start := 0
+		if b.page > 0 {
+			start = (b.page - 1) * b.limit
+		}
+		if start >= len(res) {
+			return []T{}, nil
+		}
+		end := start + b.limit
+		if end > len(res) {
+			end = len(res)
+		}
+		res = res[start:end]
+	}
+	return res, nil
+}
+
+// execShards execute fn on a copy of the builder for every shard and sum affected rows
+func (b *BuilderS[T]) execShards(dbs []*DatabaseEntity, fn func(clone *BuilderS[T]) (int, error)) (int, error) {
+	total := 0
+	for _, db := range dbs {
+		clone := *b
+		clone.db = db
+		clone.dbChosen = true
+		clone.args = append([]any{}, b.args...)
+		n, err := fn(&clone)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// sortByOrderBys sort rows using 'ORDER BY table.col ASC,col2 DESC' clause
+func sortByOrderBys[T any](rows []T, orderBys string) {
+	orderBys = strings.TrimSpace(strings.TrimPrefix(orderBys, "ORDER BY "))
+	if orderBys == "" || len(rows) < 2 {
+		return
+	}
+	type orderField struct {
+		col  string
+		desc bool
+	}
+	fields := []orderField{}
+	for _, o := range strings.Split(orderBys, ",") {
+		sp := strings.Fields(o)
+		if len(sp) == 0 {
+			continue
+		}
+		col := sp[0]
+		if i := strings.LastIndex(col, "."); i > -1 {
+			col = col[i+1:]
+		}
+		fields = append(fields, orderField{
+			col:  col,
+			desc: len(sp) > 1 && strings.EqualFold(sp[1], "DESC"),
+		})
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		vi, vj := reflect.ValueOf(rows[i]), reflect.ValueOf(rows[j])
+		for _, f := range fields {
+			c := compareAny(fieldByColumn(vi, f.col), fieldByColumn(vj, f.col))
+			if c == 0 {
+				continue
+			}
+			if f.desc {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+}
+
+// fieldByColumn return the value of the struct field mapped to column col
+func fieldByColumn(v reflect.Value, col string) any {
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Map {
+		mv := v.MapIndex(reflect.ValueOf(col))
+		if !mv.IsValid() {
+			return nil
+		}
+		return mv.Interface()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if kstrct.ToSnakeCase(t.Field(i).Name) == col {
+			f := v.Field(i)
+			if f.Kind() == reflect.Ptr {
+				if f.IsNil() {
+					return nil
+				}
+				f = f.Elem()
+			}
+			return f.Interface()
+		}
+	}
+	return nil
+}
+
+// compareAny compare 2 values of the same kind, returning -1, 0 or 1
+func compareAny(a, b any) int {
+	if a == nil || b == nil {
+		switch {
+		case a == nil && b == nil:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	switch va.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if vb.CanInt() {
+			return cmpOrdered(va.Int(), vb.Int())
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if vb.CanUint() {
+			return cmpOrdered(va.Uint(), vb.Uint())
+		}
+	case reflect.Float32, reflect.Float64:
+		if vb.CanFloat() {
+			return cmpOrdered(va.Float(), vb.Float())
+		}
+	case reflect.Bool:
+		if vb.Kind() == reflect.Bool {
+			ba, bb := 0, 0
+			if va.Bool() {
+				ba = 1
+			}
+			if vb.Bool() {
+				bb = 1
+			}
+			return cmpOrdered(ba, bb)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func cmpOrdered[V int | int64 | uint64 | float64](a, b V) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
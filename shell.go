@@ -104,8 +104,8 @@ func InitShell() bool {
 			handleCommand(args[1:])
 			return true
 		}
-		databases := GetMemoryDatabases()
-		usedDB = databases[0]
+		dbs := GetMemoryDatabases()
+		usedDB = dbs[0]
 		defer usedDB.Conn.Close()
 		fmt.Printf(yellow, commandsS)
 		for {
@@ -340,7 +340,7 @@ func getRow(tbName, where string) {
 }
 
 func migratefromfile(path string) error {
-	if !SliceContains([]string{POSTGRES, SQLITE, MYSQL, MARIA}, databases[0].Dialect) {
+	if !SliceContains([]string{POSTGRES, COCKROACH, SQLITE, MYSQL, MARIA}, defaultClient.databases[0].Dialect) {
 		fmt.Printf(red, "database is neither postgres, sqlite3 or mysql ")
 		return errors.New("database is neither postgres, sqlite3 or mysql ")
 	}
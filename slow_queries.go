@@ -0,0 +1,323 @@
+package korm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+var (
+	// SlowQueriesMax is the number of slow queries kept in _slow_queries of each database, older are deleted
+	SlowQueriesMax = 1000
+	// SlowQueryRedactArgs replace args of slow queries by their type before storing them
+	SlowQueryRedactArgs = false
+	// SlowQueriesBuffer is the number of slow queries waiting to be explained and stored, more are dropped
+	SlowQueriesBuffer = 100
+)
+
+// SlowQuery is a statement slower than SlowQueryThreshold, stored in the _slow_queries table of its database
+type SlowQuery struct {
+	Id         uint      `korm:"pk" json:"id"`
+	Query      string    `korm:"text" json:"query"`
+	Args       string    `korm:"text" json:"args"`
+	DurationMs float64   `korm:"index" json:"duration_ms"`
+	Caller     string    `korm:"size:300" json:"caller"`
+	Plan       string    `korm:"text" json:"plan"`
+	Error      string    `korm:"text" json:"error"`
+	CreatedAt  time.Time `korm:"now;index" json:"created_at"`
+}
+
+// Explainer can be implemented by a Dialect to capture plans of slow queries, 'EXPLAIN query' is used otherwise.
+// Statements are run in order on one connection, planAt is the index of the statement returning the plan, it receive the args of the query
+type Explainer interface {
+	Explain(query string) (stmts []string, planAt int)
+}
+
+func (sqliteDialect) Explain(query string) ([]string, int) {
+	return []string{"EXPLAIN QUERY PLAN " + query}, 0
+}
+
+func (mssqlDialect) Explain(query string) ([]string, int) {
+	return []string{"SET SHOWPLAN_TEXT ON", query, "SET SHOWPLAN_TEXT OFF"}, 1
+}
+
+// slowQueryLog hold the threshold and the queue of slow queries of a client
+type slowQueryLog struct {
+	threshold atomic.Int64
+	once      sync.Once
+	queue     chan slowQueryEvent
+	// running is true while the worker recording queued slow queries run
+	running atomic.Bool
+	// migrating is true while _slow_queries is migrated, migration queries cannot carry slowQueryInternalKey
+	migrating atomic.Bool
+}
+
+type slowQueryEvent struct {
+	db       string
+	query    string
+	args     []any
+	duration time.Duration
+	caller   string
+	err      string
+}
+
+type slowQueryInternalKey struct{}
+
+// SlowQueryThreshold record statements of all databases running longer than d in _slow_queries, with their args, caller and plan.
+// Plans are captured in the background using EXPLAIN, or EXPLAIN QUERY PLAN for sqlite. A duration <= 0 stop recording
+//
+//	Example:
+//	  korm.SlowQueryThreshold(200 * time.Millisecond)
+//	  slowest, err := korm.SlowQueries("", "duration", 20)
+func SlowQueryThreshold(d time.Duration) {
+	defaultClient.SlowQueryThreshold(d)
+}
+
+// SlowQueryThreshold is korm.SlowQueryThreshold for client c
+func (c *Client) SlowQueryThreshold(d time.Duration) {
+	if d <= 0 {
+		c.slow.threshold.Store(0)
+		return
+	}
+	c.slow.once.Do(func() {
+		c.slow.queue = make(chan slowQueryEvent, max(SlowQueriesBuffer, 1))
+	})
+	c.slow.threshold.Store(int64(d))
+	c.startSlowQueries()
+}
+
+// startSlowQueries start the worker recording slow queries if a threshold is set and it is not running, Start call it after a Shutdown
+func (c *Client) startSlowQueries() {
+	if !c.slow.tracked() || !c.slow.running.CompareAndSwap(false, true) {
+		return
+	}
+	c.goWorker("slow queries", "", func(ctx context.Context) {
+		defer c.slow.running.Store(false)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev := <-c.slow.queue:
+				c.recordSlowQuery(ctx, ev)
+			}
+		}
+	})
+}
+
+// SlowQueries return the last limit slow queries of dbName, or of the first database if empty, sorted by "duration" (slowest first) or "recent"
+func SlowQueries(dbName, sortBy string, limit int) ([]SlowQuery, error) {
+	return defaultClient.SlowQueries(dbName, sortBy, limit)
+}
+
+// SlowQueries is korm.SlowQueries for client c
+func (c *Client) SlowQueries(dbName, sortBy string, limit int) ([]SlowQuery, error) {
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+	if !SliceContains(c.GetAllTables(db.Name), "_slow_queries") {
+		return []SlowQuery{}, nil
+	}
+	order := "-duration_ms"
+	if sortBy == "recent" {
+		order = "-id"
+	}
+	res, err := ModelOn[SlowQuery](c).Database(db.Name).NoCache().OrderBy(order).Limit(limit).All()
+	if errors.Is(err, ErrNoData) {
+		return []SlowQuery{}, nil
+	}
+	return res, err
+}
+
+// ClearSlowQueries delete slow queries of dbName, or of the first database if empty
+func ClearSlowQueries(dbName string) error {
+	return defaultClient.ClearSlowQueries(dbName)
+}
+
+// ClearSlowQueries is korm.ClearSlowQueries for client c
+func (c *Client) ClearSlowQueries(dbName string) error {
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil {
+		return err
+	}
+	if !SliceContains(c.GetAllTables(db.Name), "_slow_queries") {
+		return nil
+	}
+	_, err = ModelOn[SlowQuery](c).Database(db.Name).Where("id > ?", 0).Delete()
+	if errors.Is(err, ErrNoData) {
+		return nil
+	}
+	return err
+}
+
+// tracked return true if statements need a QueryInfo to detect slow queries
+func (l *slowQueryLog) tracked() bool {
+	return l.threshold.Load() > 0
+}
+
+// observe queue the statement of info if it is slower than the threshold, the caller is taken from the current stack
+func (l *slowQueryLog) observe(ctx context.Context, info *QueryInfo, err error) {
+	threshold := l.threshold.Load()
+	if threshold <= 0 || info.Elapsed < time.Duration(threshold) || l.migrating.Load() || ctx.Value(slowQueryInternalKey{}) != nil {
+		return
+	}
+	ev := slowQueryEvent{
+		db:       info.Database,
+		query:    info.Query,
+		args:     info.Args,
+		duration: info.Elapsed,
+		caller:   slowQueryCaller(),
+	}
+	if err != nil {
+		ev.err = err.Error()
+	}
+	select {
+	case l.queue <- ev:
+	default:
+		lg.WarnC("slow queries buffer full, dropping slow query", "query", info.Query)
+	}
+}
+
+// slowQueryCaller return file:line of the first caller outside korm and database/sql, tests of korm excepted
+func slowQueryCaller() string {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := frames.Next()
+		internal := strings.HasPrefix(f.Function, "database/sql.") || strings.HasPrefix(f.Function, "runtime.") ||
+			(strings.HasPrefix(f.Function, "github.com/kamalshkeir/korm.") && !strings.HasSuffix(f.File, "_test.go"))
+		if !internal && f.File != "" {
+			return f.File + ":" + strconv.Itoa(f.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// recordSlowQuery explain ev and store it in _slow_queries of its database, deleting queries over SlowQueriesMax
+func (c *Client) recordSlowQuery(ctx context.Context, ev slowQueryEvent) {
+	db, err := c.GetMemoryDatabase(ev.db)
+	if err != nil {
+		return
+	}
+	ctx = context.WithValue(ctx, slowQueryInternalKey{}, true)
+	if _, ok := c.internalTables.Get(db.Name + "._slow_queries"); !ok {
+		c.slow.migrating.Store(true)
+		err := AutoMigrateOn[SlowQuery](c, "_slow_queries", db.Name)
+		c.slow.migrating.Store(false)
+		if lg.CheckError(err) {
+			return
+		}
+		c.internalTables.Set(db.Name+"._slow_queries", struct{}{})
+	}
+	plan, err := db.explain(ctx, ev.query, ev.args)
+	if err != nil {
+		plan = "could not explain query: " + err.Error()
+	}
+	args := ev.args
+	if SlowQueryRedactArgs {
+		args = make([]any, len(ev.args))
+		for i, a := range ev.args {
+			args[i] = fmt.Sprintf("<%T>", a)
+		}
+	}
+	argsJson, _ := json.Marshal(args)
+	id, err := ModelOn[SlowQuery](c).Database(db.Name).Context(ctx).Insert(&SlowQuery{
+		Query:      ev.query,
+		Args:       string(argsJson),
+		DurationMs: float64(ev.duration) / float64(time.Millisecond),
+		Caller:     ev.caller,
+		Plan:       plan,
+		Error:      ev.err,
+		CreatedAt:  time.Now(),
+	})
+	if lg.CheckError(err) {
+		return
+	}
+	if id > SlowQueriesMax {
+		_, err = ModelOn[SlowQuery](c).Database(db.Name).Context(ctx).Where("id <= ?", id-SlowQueriesMax).Delete()
+		if err != nil && !errors.Is(err, ErrNoData) {
+			lg.CheckError(err)
+		}
+	}
+}
+
+// explain return the plan of query, one line per row, or empty for statements other than SELECT, INSERT, UPDATE, DELETE and WITH
+func (db *DatabaseEntity) explain(ctx context.Context, query string, args []any) (string, error) {
+	switch op, _ := queryTarget(query); op {
+	case "SELECT", "INSERT", "UPDATE", "DELETE", "WITH":
+	default:
+		return "", nil
+	}
+	stmts, planAt := []string{"EXPLAIN " + query}, 0
+	if e, ok := dialectOf(db.Dialect).(Explainer); ok {
+		stmts, planAt = e.Explain(query)
+	}
+	conn, err := db.Conn.Conn(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	plan := ""
+	for i, st := range stmts {
+		if i != planAt {
+			if _, err := conn.ExecContext(ctx, st); err != nil {
+				return plan, err
+			}
+			continue
+		}
+		if plan, err = explainRows(ctx, conn, st, args); err != nil {
+			// run the remaining statements, like SET SHOWPLAN_TEXT OFF, before returning
+			for _, rest := range stmts[i+1:] {
+				conn.ExecContext(ctx, rest)
+			}
+			return "", err
+		}
+	}
+	return plan, nil
+}
+
+// explainRows run st and return its rows, columns separated by ' | '
+func explainRows(ctx context.Context, conn *sql.Conn, st string, args []any) (string, error) {
+	rows, err := conn.QueryContext(ctx, st, args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	lines := []string{}
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return "", err
+		}
+		parts := make([]string, len(values))
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			parts[i] = fmt.Sprint(v)
+		}
+		lines = append(lines, strings.Join(parts, " | "))
+	}
+	return strings.Join(lines, "\n"), rows.Err()
+}
@@ -0,0 +1,483 @@
+package korm
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kamalshkeir/ksmux"
+	"github.com/kamalshkeir/lg"
+)
+
+var (
+	// SpansBatchSize is the number of spans exported at once, a batch is exported when full or every SpansFlushEvery
+	SpansBatchSize  = 256
+	SpansFlushEvery = 5 * time.Second
+	// SpansMaxQueue is the maximum number of spans waiting for export, older spans are dropped when exporters are too slow
+	SpansMaxQueue = 8192
+	// SpansTimeout is the timeout of an export
+	SpansTimeout = 10 * time.Second
+)
+
+// Span is an operation of a trace, exported using the OpenTelemetry data model. Ids are hex encoded, 32 chars for traces and 16 for spans
+type Span struct {
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_id,omitempty"`
+	Name       string         `json:"name"`
+	StartTime  time.Time      `json:"start_time"`
+	EndTime    time.Time      `json:"end_time"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	c          *Client
+	mu         sync.Mutex
+}
+
+// SpanExporter send finished spans of service to a tracing backend
+type SpanExporter interface {
+	Export(ctx context.Context, service string, spans []*Span) error
+}
+
+// spanExport hold spans waiting for export
+type spanExport struct {
+	enabled   atomic.Bool
+	mu        sync.Mutex
+	service   string
+	exporters []SpanExporter
+	pending   []*Span
+	dropped   int
+	wake      chan struct{}
+	// running is true while the worker exporting spans run
+	running atomic.Bool
+}
+
+type spanKey struct{}
+
+// WithTraceExport export spans of queries of all databases to exporters, like NewOTLPExporter or NewFileExporter.
+// Spans of a statement are children of the span in its context: a span started by StartSpan, or the span of the ksmux request
+// when ksmux tracing is enabled, so builders using Context(c.Request.Context()) correlate queries with requests.
+// Spans are exported by batches in the background, remaining spans are exported on Shutdown
+//
+//	Example:
+//	  korm.WithTraceExport("shop", korm.NewOTLPExporter("http://localhost:4318/v1/traces", nil))
+//	  users, err := korm.Model[User]().Context(c.Request.Context()).Where("is_admin = ?", true).All()
+func WithTraceExport(service string, exporters ...SpanExporter) {
+	defaultClient.WithTraceExport(service, exporters...)
+}
+
+// WithTraceExport is korm.WithTraceExport for client c
+func (c *Client) WithTraceExport(service string, exporters ...SpanExporter) {
+	c.spans.mu.Lock()
+	c.spans.service = service
+	c.spans.exporters = append(c.spans.exporters, exporters...)
+	if c.spans.wake == nil {
+		c.spans.wake = make(chan struct{}, 1)
+	}
+	c.spans.mu.Unlock()
+	c.spans.enabled.Store(true)
+	c.startSpansExport()
+}
+
+// startSpansExport start the worker exporting spans if WithTraceExport was called and it is not running, Start call it after a Shutdown
+func (c *Client) startSpansExport() {
+	if !c.spans.enabled.Load() || !c.spans.running.CompareAndSwap(false, true) {
+		return
+	}
+	c.goWorker("spans exporter", "", func(ctx context.Context) {
+		defer c.spans.running.Store(false)
+		c.exportSpansWorker(ctx)
+	})
+}
+
+// StartSpan start a span named name, child of the span of ctx if any, statements run using the returned context are children of this span.
+// The span is exported when ended, if WithTraceExport was called
+//
+//	Example:
+//	  ctx, span := korm.StartSpan(ctx, "checkout")
+//	  defer func() { span.End(err) }()
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	return defaultClient.StartSpan(ctx, name)
+}
+
+// StartSpan is korm.StartSpan for client c
+func (c *Client) StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s := &Span{
+		SpanID:     randomHex(8),
+		Name:       name,
+		StartTime:  time.Now(),
+		Attributes: map[string]any{},
+		c:          c,
+	}
+	s.TraceID, s.ParentID = spanParent(ctx)
+	if s.TraceID == "" {
+		s.TraceID = randomHex(16)
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SpanFrom return the span of ctx started by StartSpan
+func SpanFrom(ctx context.Context) (*Span, bool) {
+	s, ok := ctx.Value(spanKey{}).(*Span)
+	return s, ok
+}
+
+// SetAttribute set an attribute of s, values are strings, bools, ints or floats
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// End end s with err, queuing it for export
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.EndTime = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.mu.Unlock()
+	if s.c != nil {
+		s.c.spans.add(s)
+	}
+}
+
+// spanParent return the trace id and parent span id of ctx, from a korm span or from the ksmux request span
+func spanParent(ctx context.Context) (string, string) {
+	if s, ok := SpanFrom(ctx); ok {
+		return s.TraceID, s.SpanID
+	}
+	if id, ok := ctx.Value(ksmux.ContextKey("trace_id")).(string); ok && id != "" {
+		parent, _ := ctx.Value(ksmux.ContextKey("span_id")).(string)
+		if parent != "" {
+			parent = otelID(parent, 16)
+		}
+		return otelID(id, 32), parent
+	}
+	return "", ""
+}
+
+// traceIDOf return the trace id of ctx, or empty if ctx is not traced
+func traceIDOf(ctx context.Context) string {
+	id, _ := spanParent(ctx)
+	return id
+}
+
+// otelID turn id, like the uuids of ksmux, into n hex chars
+func otelID(id string, n int) string {
+	h := strings.ToLower(strings.ReplaceAll(id, "-", ""))
+	if _, err := hex.DecodeString(h); err != nil || len(h) < n {
+		sum := sha256.Sum256([]byte(id))
+		h = hex.EncodeToString(sum[:])
+	}
+	return h[:n]
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startQuerySpan start the span of a statement of dbName, named after its operation and table
+func (c *Client) startQuerySpan(ctx context.Context, dbName, query string) *Span {
+	_, s := c.StartSpan(ctx, dbName)
+	op, table := queryTarget(query)
+	if op != "" {
+		s.Name = op + " " + dbName
+		if table != "" {
+			s.Name = op + " " + table
+		}
+	}
+	s.Attributes["db.system"] = "other_sql"
+	if db, err := c.GetMemoryDatabase(dbName); err == nil {
+		if ds, ok := dialectOf(db.Dialect).(DBSystemer); ok {
+			s.Attributes["db.system"] = ds.DBSystem()
+		}
+	}
+	s.Attributes["db.name"] = dbName
+	s.Attributes["db.statement"] = query
+	if op != "" {
+		s.Attributes["db.operation"] = op
+	}
+	if table != "" {
+		s.Attributes["db.sql.table"] = table
+	}
+	return s
+}
+
+// endQuerySpan end the span of the statement of info
+func endQuerySpan(info *QueryInfo, err error) {
+	if info.span == nil {
+		return
+	}
+	if info.RowsAffected >= 0 {
+		info.span.SetAttribute("db.rows_affected", info.RowsAffected)
+	}
+	info.span.End(err)
+	info.span = nil
+}
+
+// DBSystemer can be implemented by a Dialect to set the OpenTelemetry db.system of its query spans, 'other_sql' is used otherwise
+type DBSystemer interface {
+	DBSystem() string
+}
+
+func (sqliteDialect) DBSystem() string { return "sqlite" }
+
+func (postgresDialect) DBSystem() string { return "postgresql" }
+
+func (cockroachDialect) DBSystem() string { return "cockroachdb" }
+
+func (mysqlDialect) DBSystem() string { return "mysql" }
+
+func (mariaDialect) DBSystem() string { return "mariadb" }
+
+func (mssqlDialect) DBSystem() string { return "mssql" }
+
+// queryTarget return the upper case operation of query and its table if found
+func queryTarget(query string) (string, string) {
+	q := strings.TrimSpace(query)
+	op := q
+	if i := strings.IndexAny(q, " \n\t("); i > 0 {
+		op = q[:i]
+	}
+	op = strings.ToUpper(op)
+	if _, table := historyTarget(q); table != "" {
+		return op, table
+	}
+	if op != "SELECT" {
+		return op, ""
+	}
+	i := strings.Index(strings.ToUpper(q), " FROM ")
+	if i < 0 {
+		return op, ""
+	}
+	table := strings.TrimSpace(q[i+6:])
+	if j := strings.IndexAny(table, " ,;)\n\t"); j > 0 {
+		table = table[:j]
+	}
+	if strings.HasPrefix(table, "(") {
+		return op, ""
+	}
+	return op, strings.Trim(table, "\"`[]")
+}
+
+// add queue s for export, dropping the oldest spans over SpansMaxQueue
+func (e *spanExport) add(s *Span) {
+	if !e.enabled.Load() {
+		return
+	}
+	e.mu.Lock()
+	e.pending = append(e.pending, s)
+	if over := len(e.pending) - SpansMaxQueue; over > 0 {
+		e.pending = e.pending[over:]
+		e.dropped += over
+	}
+	full := len(e.pending) >= SpansBatchSize
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (c *Client) exportSpansWorker(ctx context.Context) {
+	ticker := time.NewTicker(SpansFlushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), SpansTimeout)
+			lg.CheckError(c.FlushSpans(fctx))
+			cancel()
+			return
+		case <-ticker.C:
+		case <-c.spans.wake:
+		}
+		if err := c.FlushSpans(ctx); err != nil && ctx.Err() == nil {
+			lg.ErrorC("could not export spans", "err", err)
+		}
+	}
+}
+
+// FlushSpans export spans waiting for export now
+func FlushSpans(ctx context.Context) error {
+	return defaultClient.FlushSpans(ctx)
+}
+
+// FlushSpans is korm.FlushSpans for client c
+func (c *Client) FlushSpans(ctx context.Context) error {
+	e := &c.spans
+	e.mu.Lock()
+	pending, exporters, service, dropped := e.pending, e.exporters, e.service, e.dropped
+	e.pending, e.dropped = nil, 0
+	e.mu.Unlock()
+	if dropped > 0 {
+		lg.WarnC("spans dropped, exporters are too slow", "dropped", dropped)
+	}
+	var errs []error
+	for len(pending) > 0 {
+		n := min(len(pending), max(SpansBatchSize, 1))
+		for _, exp := range exporters {
+			ectx, cancel := context.WithTimeout(ctx, SpansTimeout)
+			if err := exp.Export(ectx, service, pending[:n]); err != nil {
+				errs = append(errs, err)
+			}
+			cancel()
+		}
+		pending = pending[n:]
+	}
+	return errors.Join(errs...)
+}
+
+// otlpRequest return the OTLP/JSON ExportTraceServiceRequest of spans
+func otlpRequest(service string, spans []*Span) map[string]any {
+	out := make([]map[string]any, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := map[string]any{
+			"traceId":           s.TraceID,
+			"spanId":            s.SpanID,
+			"name":              s.Name,
+			"kind":              3, // SPAN_KIND_CLIENT
+			"startTimeUnixNano": strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.Attributes),
+		}
+		if s.ParentID != "" {
+			span["parentSpanId"] = s.ParentID
+		}
+		if s.Error != "" {
+			span["status"] = map[string]any{"code": 2, "message": s.Error} // STATUS_CODE_ERROR
+		}
+		s.mu.Unlock()
+		out = append(out, span)
+	}
+	return map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": service}),
+			},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "github.com/kamalshkeir/korm"},
+				"spans": out,
+			}},
+		}},
+	}
+}
+
+// otlpAttributes encode attrs as OTLP/JSON key values, int64 are strings as in the protobuf JSON mapping
+func otlpAttributes(attrs map[string]any) []map[string]any {
+	out := make([]map[string]any, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch vv := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": vv}
+		case bool:
+			value = map[string]any{"boolValue": vv}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(vv)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(vv, 10)}
+		case float64:
+			if math.IsNaN(vv) || math.IsInf(vv, 0) {
+				value = map[string]any{"stringValue": fmt.Sprint(vv)}
+			} else {
+				value = map[string]any{"doubleValue": vv}
+			}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(vv)}
+		}
+		out = append(out, map[string]any{"key": k, "value": value})
+	}
+	return out
+}
+
+// otlpExporter post spans as OTLP/JSON over HTTP
+type otlpExporter struct {
+	endpoint string
+	headers  map[string]string
+	client   *http.Client
+}
+
+// NewOTLPExporter return an exporter posting spans as OTLP/JSON to endpoint, usually http://collector:4318/v1/traces, with headers like authorization
+func NewOTLPExporter(endpoint string, headers map[string]string) SpanExporter {
+	return &otlpExporter{endpoint: endpoint, headers: headers, client: &http.Client{}}
+}
+
+func (e *otlpExporter) Export(ctx context.Context, service string, spans []*Span) error {
+	body, err := json.Marshal(otlpRequest(service, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("otlp collector responded %s", resp.Status)
+	}
+	return nil
+}
+
+// fileExporter append spans to a file
+type fileExporter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileExporter return an exporter appending spans to path, one OTLP/JSON request per line like the file exporter of the OpenTelemetry collector
+func NewFileExporter(path string) (SpanExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileExporter{f: f}, nil
+}
+
+func (e *fileExporter) Export(ctx context.Context, service string, spans []*Span) error {
+	line, err := json.Marshal(otlpRequest(service, spans))
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.f.Write(append(line, '\n'))
+	return err
+}
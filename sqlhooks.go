@@ -18,7 +18,11 @@ type Hooks interface {
 	After(ctx context.Context, query string, args ...interface{}) (context.Context, error)
 }
 
-type logAndCacheHook struct{}
+// logAndCacheHook log queries, and flush caches of c after writes of database db
+type logAndCacheHook struct {
+	c  *Client
+	db string
+}
 
 func (h *logAndCacheHook) Before(ctx context.Context, query string, args ...any) (context.Context, error) {
 	if ctx.Value(traceEnabledKey) != nil {
@@ -35,11 +39,11 @@ func (h *logAndCacheHook) After(ctx context.Context, query string, args ...any)
 	// Check for cache invalidation FIRST (before early return)
 	queryUpper := strings.ToUpper(query)
 	if strings.Contains(queryUpper, "INSERT") || strings.Contains(queryUpper, "UPDATE") || strings.Contains(queryUpper, "DELETE") || strings.Contains(queryUpper, "DROP") {
-		flushCache()
+		h.c.flushCache()
 
 		// Call appropriate hooks
 		if strings.Contains(queryUpper, "DROP") {
-			if v, ok := hooks.Get("drop"); ok {
+			if v, ok := h.c.hooks.Get("drop"); ok {
 				for _, vv := range v {
 					vv(HookData{
 						Operation: "drop",
@@ -61,15 +65,16 @@ func (h *logAndCacheHook) After(ctx context.Context, query string, args ...any)
 	startTime, _ := ctx.Value(ksmux.ContextKey("trace_start")).(time.Time)
 	duration := time.Since(startTime)
 
-	if ctx.Value(traceEnabledKey) != nil && defaultTracer.enabled {
+	if ctx.Value(traceEnabledKey) != nil && h.c.tracer.enabled {
 		trace := TraceData{
-			Database:  defaultDB,
+			Database:  h.db,
+			TraceID:   traceIDOf(ctx),
 			Query:     query,
 			Args:      args,
 			StartTime: startTime,
 			Duration:  duration,
 		}
-		defaultTracer.addTrace(trace)
+		h.c.tracer.addTrace(trace)
 	}
 
 	if logQueries {
@@ -100,10 +105,22 @@ func handlerErr(ctx context.Context, hooks Hooks, err error, query string, args
 	return err
 }
 
+type noopHooks struct{}
+
+func (noopHooks) Before(ctx context.Context, query string, args ...any) (context.Context, error) {
+	return ctx, nil
+}
+
+func (noopHooks) After(ctx context.Context, query string, args ...any) (context.Context, error) {
+	return ctx, nil
+}
+
 // Driver implements a database/sql/driver.Driver
 type Driver struct {
 	driver.Driver
 	hooks Hooks
+	// connInit statements executed on every new connection, like sqlite pragmas
+	connInit []string
 }
 
 // Open opens a connection
@@ -112,6 +129,19 @@ func (drv *Driver) Open(name string) (driver.Conn, error) {
 	if err != nil {
 		return conn, err
 	}
+	if len(drv.connInit) > 0 {
+		execer, ok := conn.(driver.ExecerContext)
+		if !ok {
+			conn.Close()
+			return nil, errors.New("driver must implement driver.ExecerContext to run connection init statements")
+		}
+		for _, st := range drv.connInit {
+			if _, err := execer.ExecContext(context.Background(), st, nil); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
 
 	// Drivers that don't implement driver.ConnBeginTx are not supported.
 	if _, ok := conn.(driver.ConnBeginTx); !ok {
@@ -155,6 +185,9 @@ func (conn *driverConn) PrepareContext(ctx context.Context, query string) (drive
 		err  error
 	)
 
+	if query, err = rewriteQuery(ctx, conn.hooks, query); err != nil {
+		return nil, err
+	}
 	if c, ok := conn.Conn.(driver.ConnPrepareContext); ok {
 		stmt, err = c.PrepareContext(ctx, query)
 	} else {
@@ -208,6 +241,9 @@ func (conn *execerContext) ExecContext(ctx context.Context, query string, args [
 
 	list := namedValueToAny(args)
 
+	if query, err = rewriteQuery(ctx, conn.hooks, query, list...); err != nil {
+		return nil, err
+	}
 	// Exec `Before` Hooks
 	if ctx, err = conn.hooks.Before(ctx, query, list...); err != nil {
 		return nil, err
@@ -215,8 +251,10 @@ func (conn *execerContext) ExecContext(ctx context.Context, query string, args [
 
 	results, err := conn.execContext(ctx, query, args)
 	if err != nil {
+		endQuery(ctx, nil)
 		return results, handlerErr(ctx, conn.hooks, err, query, list...)
 	}
+	endQuery(ctx, results)
 
 	if _, err := conn.hooks.After(ctx, query, list...); err != nil {
 		return nil, err
@@ -262,6 +300,9 @@ func (conn *queryerContext) QueryContext(ctx context.Context, query string, args
 
 	list := namedValueToAny(args)
 
+	if query, err = rewriteQuery(ctx, conn.hooks, query, list...); err != nil {
+		return nil, err
+	}
 	// Query `Before` Hooks
 	if ctx, err = conn.hooks.Before(ctx, query, list...); err != nil {
 		return nil, err
@@ -269,8 +310,10 @@ func (conn *queryerContext) QueryContext(ctx context.Context, query string, args
 
 	results, err := conn.queryContext(ctx, query, args)
 	if err != nil {
+		endQuery(ctx, nil)
 		return results, handlerErr(ctx, conn.hooks, err, query, list...)
 	}
+	endQuery(ctx, nil)
 
 	if _, err := conn.hooks.After(ctx, query, list...); err != nil {
 		return nil, err
@@ -332,8 +375,10 @@ func (stmt *Stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (dr
 
 	results, err := stmt.execContext(ctx, args)
 	if err != nil {
+		endQuery(ctx, nil)
 		return results, handlerErr(ctx, stmt.hooks, err, stmt.query, list...)
 	}
+	endQuery(ctx, results)
 
 	if _, err := stmt.hooks.After(ctx, stmt.query, list...); err != nil {
 		return nil, err
@@ -366,8 +411,10 @@ func (stmt *Stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (d
 
 	rows, err := stmt.queryContext(ctx, args)
 	if err != nil {
+		endQuery(ctx, nil)
 		return rows, handlerErr(ctx, stmt.hooks, err, stmt.query, list...)
 	}
+	endQuery(ctx, nil)
 
 	if _, err := stmt.hooks.After(ctx, stmt.query, list...); err != nil {
 		return nil, err
@@ -404,7 +451,7 @@ func (stmt *Stmt) Query(args []driver.Value) (driver.Rows, error) {
 }
 
 func Wrap(driver driver.Driver, hooks Hooks) driver.Driver {
-	return &Driver{driver, hooks}
+	return &Driver{Driver: driver, hooks: hooks}
 }
 
 func WrapConn(conn driver.Conn, hooks Hooks) driver.Conn {
@@ -0,0 +1,322 @@
+package korm
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+var ErrWriterClosed = errors.New("sqlite writer closed")
+
+// ErrWriterInTransaction is returned by writes queued while RunInTransaction hold the writer connection, the retry policy retry it
+// like SQLITE_BUSY. Writes made inside the transaction must use its tx, they would otherwise wait for the end of the transaction
+var ErrWriterInTransaction = errors.New("sqlite writer held by a transaction")
+
+// sqliteWriter funnel all writes of a sqlite database through a single connection, batching queued statements in one transaction
+type sqliteWriter struct {
+	conn      *sql.DB
+	jobs      chan *writeJob
+	batchSize int
+	wait      time.Duration
+	closeOnce sync.Once
+	mu        sync.RWMutex
+	closed    bool
+	done      chan struct{}
+	stop      chan struct{}
+	// txs count transactions of RunInTransaction holding or waiting for conn
+	txs atomic.Int32
+}
+
+type writeJob struct {
+	ctx   context.Context
+	query string
+	args  []any
+	// actor is set if the actor of the write is recorded for change triggers, the job then always run in a transaction
+	actor *actorWrite
+	res   chan writeResult
+	// state is jobQueued until the writer run the job or the caller stop waiting for it
+	state atomic.Int32
+}
+
+const (
+	jobQueued int32 = iota
+	jobRunning
+	jobCancelled
+)
+
+// take mark j as running, it return false if the context of the caller is done, the job is then skipped
+func (j *writeJob) take() bool {
+	if j.ctx.Err() == nil && j.state.CompareAndSwap(jobQueued, jobRunning) {
+		return true
+	}
+	j.state.CompareAndSwap(jobQueued, jobCancelled)
+	j.res <- writeResult{err: j.ctx.Err()}
+	return false
+}
+
+// run exec the query of j using tx, setting its actor if any
+func (j *writeJob) run(ctx context.Context, tx *sql.Tx) (res sql.Result, err error) {
+	if j.actor == nil {
+		return tx.ExecContext(ctx, j.query, j.args...)
+	}
+	err = j.actor.run(ctx, tx, func() error {
+		res, err = tx.ExecContext(ctx, j.query, j.args...)
+		return err
+	})
+	return res, err
+}
+
+type writeResult struct {
+	res sql.Result
+	err error
+}
+
+// batchResult is returned to jobs committed as part of a group, RowsAffected and LastInsertId are read before commit
+type batchResult struct {
+	lastInsertId int64
+	rowsAffected int64
+	idErr        error
+	affErr       error
+}
+
+func (r batchResult) LastInsertId() (int64, error) { return r.lastInsertId, r.idErr }
+func (r batchResult) RowsAffected() (int64, error) { return r.rowsAffected, r.affErr }
+
+// openSqliteSingleWriter turn conn into the only writer connection of db and open a separate read only pool
+func openSqliteSingleWriter(db *DatabaseEntity, dbDriver driver.Driver, dsn string, connInit []string, opts DbOptions) error {
+	db.Conn.SetMaxOpenConns(1)
+
+	readInit := append(append([]string{}, connInit...), "PRAGMA query_only = ON")
+	cstm := GenerateUUID()
+	if useCache {
+		sql.Register(cstm, &Driver{Driver: dbDriver, hooks: db.client.queryHooksFor(db.Name, &logAndCacheHook{c: db.client, db: db.Name}), connInit: readInit})
+	} else {
+		sql.Register(cstm, &Driver{Driver: dbDriver, hooks: db.client.queryHooksFor(db.Name, noopHooks{}), connInit: readInit})
+	}
+	readPool, err := sql.Open(cstm, dsn)
+	if err != nil {
+		return err
+	}
+	if err := readPool.Ping(); err != nil {
+		readPool.Close()
+		return err
+	}
+	if opts.MaxOpenConns > 0 {
+		readPool.SetMaxOpenConns(opts.MaxOpenConns)
+	} else {
+		readPool.SetMaxOpenConns(10)
+	}
+	readPool.SetMaxIdleConns(opts.MaxIdleConns)
+	readPool.SetConnMaxLifetime(opts.MaxLifetime)
+	readPool.SetConnMaxIdleTime(opts.MaxIdleTime)
+	db.readOnly = readPool
+
+	batchSize := opts.GroupCommitSize
+	if batchSize <= 0 {
+		batchSize = 64
+	}
+	w := &sqliteWriter{
+		conn:      db.Conn,
+		jobs:      make(chan *writeJob, batchSize*4),
+		batchSize: batchSize,
+		wait:      opts.GroupCommitWait,
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+	db.writer = w
+	go w.run()
+
+	checkpointEvery := opts.WalCheckpointEvery
+	if checkpointEvery == 0 {
+		checkpointEvery = 5 * time.Minute
+	}
+	if checkpointEvery > 0 {
+		go func() {
+			ticker := time.NewTicker(checkpointEvery)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-w.stop:
+					return
+				case <-ticker.C:
+					if _, err := w.exec(context.Background(), "PRAGMA wal_checkpoint(PASSIVE)"); err != nil && !errors.Is(err, ErrWriterClosed) && !errors.Is(err, ErrWriterInTransaction) {
+						lg.ErrorC("wal checkpoint failed", "db", db.Name, "err", err)
+					}
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// exec queue query and wait for its result, if ctx is done before the writer take the job it is never run
+func (w *sqliteWriter) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return w.execAs(ctx, nil, query, args...)
+}
+
+// execAs is exec recording the actor aw of the write if not nil
+func (w *sqliteWriter) execAs(ctx context.Context, aw *actorWrite, query string, args ...any) (sql.Result, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if w.txs.Load() > 0 {
+		return nil, ErrWriterInTransaction
+	}
+	job := &writeJob{
+		ctx:   ctx,
+		query: query,
+		args:  args,
+		actor: aw,
+		res:   make(chan writeResult, 1),
+	}
+	w.mu.RLock()
+	if w.closed {
+		w.mu.RUnlock()
+		return nil, ErrWriterClosed
+	}
+	select {
+	case w.jobs <- job:
+		w.mu.RUnlock()
+	case <-ctx.Done():
+		w.mu.RUnlock()
+		return nil, ctx.Err()
+	}
+	select {
+	case r := <-job.res:
+		return r.res, r.err
+	case <-ctx.Done():
+		if job.state.CompareAndSwap(jobQueued, jobCancelled) {
+			return nil, ctx.Err()
+		}
+		// the writer already took the job, wait for the result of the write
+		r := <-job.res
+		return r.res, r.err
+	}
+}
+
+// hold reject writes with ErrWriterInTransaction until release is called, it is used by transactions taking conn
+func (w *sqliteWriter) hold() (release func()) {
+	w.txs.Add(1)
+	return func() { w.txs.Add(-1) }
+}
+
+// run execute queued jobs, grouping jobs available at the same time in a single transaction
+func (w *sqliteWriter) run() {
+	defer close(w.done)
+	for job := range w.jobs {
+		batch := []*writeJob{job}
+		var timer <-chan time.Time
+		if w.wait > 0 {
+			timer = time.After(w.wait)
+		}
+	collect:
+		for len(batch) < w.batchSize {
+			if timer != nil {
+				select {
+				case j, ok := <-w.jobs:
+					if !ok {
+						break collect
+					}
+					batch = append(batch, j)
+				case <-timer:
+					break collect
+				}
+			} else {
+				select {
+				case j, ok := <-w.jobs:
+					if !ok {
+						break collect
+					}
+					batch = append(batch, j)
+				default:
+					break collect
+				}
+			}
+		}
+		w.commit(batch)
+	}
+}
+
+// commit execute batch in a single transaction, a failing job is rolled back to its savepoint without failing the others.
+// Jobs cancelled while queued are skipped
+func (w *sqliteWriter) commit(queued []*writeJob) {
+	batch := queued[:0]
+	for _, j := range queued {
+		if j.take() {
+			batch = append(batch, j)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+	ctx := context.Background()
+	if len(batch) == 1 && batch[0].actor == nil {
+		res, err := w.conn.ExecContext(ctx, batch[0].query, batch[0].args...)
+		batch[0].res <- writeResult{res: res, err: err}
+		return
+	}
+	tx, err := w.conn.BeginTx(ctx, nil)
+	if err != nil {
+		for _, j := range batch {
+			j.res <- writeResult{err: err}
+		}
+		return
+	}
+	results := make([]writeResult, len(batch))
+	for i, j := range batch {
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT korm_job"); err != nil {
+			results[i].err = err
+			continue
+		}
+		res, err := j.run(ctx, tx)
+		if err != nil {
+			_, _ = tx.ExecContext(ctx, "ROLLBACK TO korm_job")
+			_, _ = tx.ExecContext(ctx, "RELEASE korm_job")
+			results[i].err = err
+			continue
+		}
+		_, _ = tx.ExecContext(ctx, "RELEASE korm_job")
+		br := batchResult{}
+		br.lastInsertId, br.idErr = res.LastInsertId()
+		br.rowsAffected, br.affErr = res.RowsAffected()
+		results[i].res = br
+	}
+	if err := tx.Commit(); err != nil {
+		for i := range results {
+			if results[i].err == nil {
+				results[i] = writeResult{err: err}
+			}
+		}
+	}
+	for i, j := range batch {
+		j.res <- results[i]
+	}
+}
+
+// close stop accepting jobs, and wait for queued ones to be committed
+func (w *sqliteWriter) close() {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		close(w.jobs)
+		close(w.stop)
+		w.mu.Unlock()
+		<-w.done
+	})
+}
+
+// closeWriter flush the writer queue and close the read only pool if the database use a single writer
+func (db *DatabaseEntity) closeWriter() {
+	if db.writer != nil {
+		db.writer.close()
+	}
+	if db.readOnly != nil {
+		lg.CheckError(db.readOnly.Close())
+	}
+}
@@ -25,6 +25,7 @@ type TraceData struct {
 	StartTime time.Time     // When the query started
 	Duration  time.Duration // How long it took
 	Error     error         // Any error that occurred
+	TraceID   string        // Trace of the request or span of the query context, see StartSpan
 }
 
 // Tracer handles query tracing functionality
@@ -35,46 +36,38 @@ type Tracer struct {
 	maxSize int // Maximum number of traces to keep
 }
 
-var (
-	defaultTracer = &Tracer{
-		enabled: false,
-		traces:  make([]TraceData, 0),
-		maxSize: 500, // Default to keeping last 1000 traces
-	}
-)
-
 // WithTracing turns on tracing db + api
 func WithTracing() {
 	SetMaxDBTraces(MaxDbTraces)
-	defaultTracer.enabled = true
+	defaultClient.tracer.enabled = true
 	// enable ksmux tracing
 	ksmux.EnableTracing(nil)
 }
 
 // DisableTracing turns off query tracing
 func DisableTracing() {
-	defaultTracer.enabled = false
+	defaultClient.tracer.enabled = false
 	ksmux.DisableTracing()
 }
 
 // SetMaxDBTraces sets the maximum number of traces to keep
 func SetMaxDBTraces(max int) {
-	defaultTracer.maxSize = max
+	defaultClient.tracer.maxSize = max
 	ksmux.SetMaxTraces(max)
 }
 
 // ClearDBTraces removes all stored traces
 func ClearDBTraces() {
-	defaultTracer.mu.Lock()
-	defaultTracer.traces = make([]TraceData, 0)
-	defaultTracer.mu.Unlock()
+	defaultClient.tracer.mu.Lock()
+	defaultClient.tracer.traces = make([]TraceData, 0)
+	defaultClient.tracer.mu.Unlock()
 }
 
 // GetDBTraces returns all stored traces
 func GetDBTraces() []TraceData {
-	defaultTracer.mu.RLock()
-	defer defaultTracer.mu.RUnlock()
-	return defaultTracer.traces
+	defaultClient.tracer.mu.RLock()
+	defer defaultClient.tracer.mu.RUnlock()
+	return defaultClient.tracer.traces
 }
 
 // addTrace adds a new trace entry
@@ -116,7 +109,7 @@ func TraceQuery(ctx context.Context, db *DatabaseEntity, query string, args ...a
 	trace.Error = err
 
 	// Add trace to storage
-	defaultTracer.addTrace(trace)
+	defaultClient.tracer.addTrace(trace)
 
 	if err != nil {
 		lg.ErrorC("Query failed",
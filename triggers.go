@@ -1,37 +1,29 @@
 package korm
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
-	"github.com/kamalshkeir/kmap"
 	"github.com/kamalshkeir/lg"
 )
 
-func init() {
-	if useCache {
-		go RunEvery(FlushCacheEvery, func(cancelChan chan struct{}) {
-			if !useCache {
-				cancelChan <- struct{}{}
-			}
-			flushCache()
-		})
-	}
-}
-
-var (
-	hooks = kmap.New[string, []HookFunc]()
-)
-
-type sizeDb struct {
-	Size float64
-}
 type TriggersQueue struct {
 	Id   uint   `korm:"pk"`
 	Data string `korm:"text"`
 }
 
+// changefeedCursor is a row of _changefeed_cursors, Cursor is the last resolved timestamp of the cockroach changefeed of table Name
+type changefeedCursor struct {
+	Id     uint   `korm:"pk"`
+	Name   string `korm:"size:255;unique"`
+	Cursor string `korm:"size:64"`
+}
+
 type HookFunc func(HookData)
 
 type HookData struct {
@@ -41,168 +33,207 @@ type HookData struct {
 	Data      map[string]any `json:"data"`
 	Old       map[string]any `json:"old"`
 	New       map[string]any `json:"new"`
+	// Actor is the actor of the write set using WithActor, recorded by dialects implementing ActorRecorder
+	Actor string `json:"actor,omitempty"`
+	// database is the name of the database of the change
+	database string
+	ctx      context.Context
 }
 
-func OnInsert(fn HookFunc) {
-	if v, ok := hooks.Get("insert"); ok {
-		v = append(v, fn)
-		go hooks.Set("insert", v)
-	} else {
-		hooks.Set("insert", []HookFunc{fn})
+// Context return the context of the worker running the hook, it is done on Shutdown
+func (hd HookData) Context() context.Context {
+	if hd.ctx == nil {
+		return context.Background()
 	}
+	return hd.ctx
 }
 
-func OnSet(fn HookFunc) {
-	if v, ok := hooks.Get("update"); ok {
-		v = append(v, fn)
-		go hooks.Set("update", v)
-	} else {
-		hooks.Set("update", []HookFunc{fn})
+// OnInsert add a hook called after inserts, on all tables or only on tables if given
+func OnInsert(fn HookFunc, tables ...string) {
+	defaultClient.OnInsert(fn, tables...)
+}
+
+func (c *Client) OnInsert(fn HookFunc, tables ...string) {
+	c.addHook("insert", onTables(fn, tables))
+}
+
+// OnSet add a hook called after updates, on all tables or only on tables if given
+func OnSet(fn HookFunc, tables ...string) {
+	defaultClient.OnSet(fn, tables...)
+}
+
+func (c *Client) OnSet(fn HookFunc, tables ...string) {
+	c.addHook("update", onTables(fn, tables))
+}
+
+// OnDelete add a hook called after deletes, on all tables or only on tables if given
+func OnDelete(fn HookFunc, tables ...string) {
+	defaultClient.OnDelete(fn, tables...)
+}
+
+func (c *Client) OnDelete(fn HookFunc, tables ...string) {
+	c.addHook("delete", onTables(fn, tables))
+}
+
+func OnDrop(fn HookFunc) {
+	defaultClient.OnDrop(fn)
+}
+
+func (c *Client) OnDrop(fn HookFunc) {
+	c.addHook("drop", fn)
+}
+
+// addHook register fn before returning, so changes made after it run fn
+func (c *Client) addHook(operation string, fn HookFunc) {
+	c.hooksMu.Lock()
+	defer c.hooksMu.Unlock()
+	v, _ := c.hooks.Get(operation)
+	c.hooks.Set(operation, append(append([]HookFunc{}, v...), fn))
+}
+
+// HookErrFunc is a hook returning an error, the change is delivered again with a backoff until all hooks succeed,
+// then moved to _triggers_dead after HooksMaxAttempts
+type HookErrFunc func(HookData) error
+
+// OnInsertE add a hook called after inserts until it succeed, on all tables or only on tables if given
+func OnInsertE(fn HookErrFunc, tables ...string) {
+	defaultClient.OnInsertE(fn, tables...)
+}
+
+// OnInsertE is korm.OnInsertE for client c
+func (c *Client) OnInsertE(fn HookErrFunc, tables ...string) {
+	c.addErrHook("insert", fn, tables)
+}
+
+// OnSetE add a hook called after updates until it succeed, on all tables or only on tables if given
+func OnSetE(fn HookErrFunc, tables ...string) {
+	defaultClient.OnSetE(fn, tables...)
+}
+
+// OnSetE is korm.OnSetE for client c
+func (c *Client) OnSetE(fn HookErrFunc, tables ...string) {
+	c.addErrHook("update", fn, tables)
+}
+
+// OnDeleteE add a hook called after deletes until it succeed, on all tables or only on tables if given
+func OnDeleteE(fn HookErrFunc, tables ...string) {
+	defaultClient.OnDeleteE(fn, tables...)
+}
+
+// OnDeleteE is korm.OnDeleteE for client c
+func (c *Client) OnDeleteE(fn HookErrFunc, tables ...string) {
+	c.addErrHook("delete", fn, tables)
+}
+
+func (c *Client) addErrHook(operation string, fn HookErrFunc, tables []string) {
+	if len(tables) > 0 {
+		h := fn
+		fn = func(hd HookData) error {
+			if !SliceContains(tables, hd.Table) {
+				return nil
+			}
+			return h(hd)
+		}
 	}
+	c.errHooksMu.Lock()
+	defer c.errHooksMu.Unlock()
+	v, _ := c.errHooks.Get(operation)
+	c.errHooks.Set(operation, append(append([]HookErrFunc{}, v...), fn))
 }
 
-func OnDelete(fn HookFunc) {
-	if v, ok := hooks.Get("delete"); ok {
-		v = append(v, fn)
-		go hooks.Set("delete", v)
-	} else {
-		hooks.Set("delete", []HookFunc{fn})
+// onTables return fn called only for changes of tables, or fn if tables is empty
+func onTables(fn HookFunc, tables []string) HookFunc {
+	if len(tables) == 0 {
+		return fn
+	}
+	return func(hd HookData) {
+		if SliceContains(tables, hd.Table) {
+			fn(hd)
+		}
 	}
 }
 
-func OnDrop(fn HookFunc) {
-	if v, ok := hooks.Get("drop"); ok {
-		v = append(v, fn)
-		go hooks.Set("drop", v)
-	} else {
-		hooks.Set("drop", []HookFunc{fn})
+// runHooks call hooks registered for hd.Operation, ctx is given to hooks through hd.Context.
+// It return errors returned by hooks and panics recovered from them
+func (c *Client) runHooks(ctx context.Context, hd HookData) error {
+	hd.ctx = ctx
+	var errs []error
+	if hhh, ok := c.hooks.Get(hd.Operation); ok {
+		for _, h := range hhh {
+			errs = append(errs, safeHook(func() error {
+				h(hd)
+				return nil
+			}))
+		}
 	}
+	if hhh, ok := c.errHooks.Get(hd.Operation); ok {
+		for _, h := range hhh {
+			errs = append(errs, safeHook(func() error {
+				return h(hd)
+			}))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// safeHook call fn, returning a panic as an error
+func safeHook(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("hook panic: %v", r)
+		}
+	}()
+	return fn()
 }
 
 func initCacheHooks() {
+	defaultClient.initCacheHooks()
+}
+
+func (c *Client) initCacheHooks() {
 	// Add hook for data changes
-	OnInsert(func(hd HookData) {
-		flushCache()
+	c.OnInsert(func(hd HookData) {
+		c.flushCache()
 	})
 
 	// Add hook for updates
-	OnSet(func(hd HookData) {
-		flushCache()
+	c.OnSet(func(hd HookData) {
+		c.flushCache()
 	})
 
 	// Add hook for deletes
-	OnDelete(func(hd HookData) {
-		flushCache()
+	c.OnDelete(func(hd HookData) {
+		c.flushCache()
 	})
 
 	// Add hook for drops
-	OnDrop(func(hd HookData) {
-		flushCache()
+	c.OnDrop(func(hd HookData) {
+		c.flushCache()
 	})
 }
 
 // AddTrigger add trigger tablename_trig if col empty and tablename_trig_col if not
 func AddTrigger(onTable, col, bf_af_UpdateInsertDelete string, stmt string, dbName ...string) {
-	stat := []string{}
+	defaultClient.AddTrigger(onTable, col, bf_af_UpdateInsertDelete, stmt, dbName...)
+}
+
+// AddTrigger is korm.AddTrigger for client c
+func (c *Client) AddTrigger(onTable, col, bf_af_UpdateInsertDelete string, stmt string, dbName ...string) {
 	if len(dbName) == 0 {
-		dbName = append(dbName, databases[0].Name)
+		dbName = append(dbName, c.databases[0].Name)
 	}
 	var dialect = ""
-	db, err := GetMemoryDatabase(dbName[0])
+	db, err := c.GetMemoryDatabase(dbName[0])
 	if !lg.CheckError(err) {
 		dialect = db.Dialect
 	}
-	switch dialect {
-	case "sqlite", "sqlite3", "":
-		// Drop existing trigger first
-		dropSt := "DROP TRIGGER IF EXISTS " + onTable + "_trig"
-		if strings.Contains(bf_af_UpdateInsertDelete, "INSERT") {
-			dropSt += "_insert"
-		} else if strings.Contains(bf_af_UpdateInsertDelete, "UPDATE") {
-			dropSt += "_update"
-		} else if strings.Contains(bf_af_UpdateInsertDelete, "DELETE") {
-			dropSt += "_delete"
-		}
-		if col != "" {
-			dropSt += "_" + col
-		}
-		stat = append(stat, dropSt)
-
-		// Create new trigger with unique name
-		st := "CREATE TRIGGER IF NOT EXISTS " + onTable + "_trig"
-		if strings.Contains(bf_af_UpdateInsertDelete, "INSERT") {
-			st += "_insert"
-		} else if strings.Contains(bf_af_UpdateInsertDelete, "UPDATE") {
-			st += "_update"
-		} else if strings.Contains(bf_af_UpdateInsertDelete, "DELETE") {
-			st += "_delete"
-		}
-		if col != "" {
-			st += "_" + col
-		}
-		st += " " + bf_af_UpdateInsertDelete
-		if col != "" {
-			st += " OF " + col
-		}
-		st += " ON " + onTable + " FOR EACH ROW"
-		st += " BEGIN " + stmt + "; END;"
-		stat = append(stat, st)
-	case POSTGRES, "cockroach", "pg", "cockroachdb":
-		// Drop existing trigger first
-		if col != "" {
-			stat = append(stat, `DROP TRIGGER IF EXISTS "`+onTable+`_trig_`+col+`" ON "`+onTable+`";`)
-			stat = append(stat, `DROP FUNCTION IF EXISTS "`+onTable+`_trig_`+col+`_func"();`)
-		}
-		stat = append(stat, `DROP TRIGGER IF EXISTS "`+onTable+`_trig_`+strings.ToLower(strings.Split(bf_af_UpdateInsertDelete, " ")[1])+`" ON "`+onTable+`";`)
-		stat = append(stat, `DROP FUNCTION IF EXISTS "`+onTable+`_trig_`+strings.ToLower(strings.Split(bf_af_UpdateInsertDelete, " ")[1])+`_func"();`)
-
-		// Create function for trigger
-		name := onTable + "_trig"
-		if col != "" {
-			name += "_" + col
-		} else {
-			name += "_" + strings.ToLower(strings.Split(bf_af_UpdateInsertDelete, " ")[1])
-		}
-		st := `CREATE OR REPLACE FUNCTION "` + name + `_func"() RETURNS trigger AS $$ 
-BEGIN 
-    RAISE NOTICE 'Trigger executing for %s', TG_TABLE_NAME;
-    ` + stmt + ` 
-    RAISE NOTICE 'Trigger completed for %s', TG_TABLE_NAME;
-    IF (TG_OP = 'DELETE') THEN
-        RETURN OLD;
-    ELSE
-        RETURN NEW;
-    END IF;
-END; 
-$$ LANGUAGE plpgsql;`
-		stat = append(stat, st)
-
-		// Create trigger
-		trigCreate := `CREATE TRIGGER "` + name + `" ` + bf_af_UpdateInsertDelete + ` ON "` + onTable + `" FOR EACH ROW EXECUTE FUNCTION "` + name + `_func"();`
-		stat = append(stat, trigCreate)
-	case MYSQL, MARIA:
-		// Drop existing triggers first
-		dropTriggerName := onTable + "_trig"
-		if strings.Contains(bf_af_UpdateInsertDelete, "INSERT") {
-			dropTriggerName += "_insert"
-		} else if strings.Contains(bf_af_UpdateInsertDelete, "UPDATE") {
-			dropTriggerName += "_update"
-		} else if strings.Contains(bf_af_UpdateInsertDelete, "DELETE") {
-			dropTriggerName += "_delete"
-		}
-		if col != "" {
-			dropTriggerName += "_" + col
-		}
-		stat = append(stat, "DROP TRIGGER IF EXISTS `"+dropTriggerName+"`;")
-
-		// Create trigger with operation-specific name
-		st := "CREATE TRIGGER `" + dropTriggerName + "` " + bf_af_UpdateInsertDelete + " ON `" + onTable + "` FOR EACH ROW BEGIN " + stmt
-		if !strings.HasSuffix(stmt, ";") {
-			st += ";"
-		}
-		st += " END;"
-		stat = append(stat, st)
-	default:
+	d, ok := GetDialect(dialect)
+	if !ok {
+		return
+	}
+	stat := d.TriggerDDL(onTable, col, bf_af_UpdateInsertDelete, stmt)
+	if len(stat) == 0 {
+		lg.ErrorC("triggers not supported", "dialect", d.Name())
 		return
 	}
 
@@ -211,7 +242,7 @@ $$ LANGUAGE plpgsql;`
 	}
 
 	for _, s := range stat {
-		err := Exec(dbName[0], s)
+		err := c.Exec(dbName[0], s)
 		if err != nil {
 			if !strings.Contains(err.Error(), "Trigger does not exist") {
 				lg.ErrorC("could not add trigger", "err", err)
@@ -223,6 +254,11 @@ $$ LANGUAGE plpgsql;`
 
 // DropTrigger drop trigger tablename_trig if column empty and tablename_trig_column if not
 func DropTrigger(tableName, column string, dbName ...string) {
+	defaultClient.DropTrigger(tableName, column, dbName...)
+}
+
+// DropTrigger is korm.DropTrigger for client c
+func (c *Client) DropTrigger(tableName, column string, dbName ...string) {
 	stat := "DROP TRIGGER " + tableName + "_trig"
 	if column != "" {
 		stat += "_" + column
@@ -231,11 +267,11 @@ func DropTrigger(tableName, column string, dbName ...string) {
 	if Debug {
 		lg.InfoC("debug", "stat", stat)
 	}
-	n := databases[0].Name
+	n := c.databases[0].Name
 	if len(dbName) > 0 {
 		n = dbName[0]
 	}
-	err := Exec(n, stat)
+	err := c.Exec(n, stat)
 	if err != nil {
 		if !strings.Contains(err.Error(), "Trigger does not exist") {
 			return
@@ -245,40 +281,68 @@ func DropTrigger(tableName, column string, dbName ...string) {
 }
 
 func StorageSize(dbName string) float64 {
-	db, err := GetMemoryDatabase(dbName)
+	return defaultClient.StorageSize(dbName)
+}
+
+func (c *Client) StorageSize(dbName string) float64 {
+	db, err := c.GetMemoryDatabase(dbName)
 	if lg.CheckError(err) {
 		return -1
 	}
-	var statement string
-	switch db.Dialect {
-	case SQLITE:
-		statement = "select (page_count * page_size) as size FROM pragma_page_count(), pragma_page_size();"
-	case POSTGRES, COCKROACH:
-		statement = "select pg_database_size('" + db.Name + "') as size;"
-	case MYSQL, MARIA:
-		statement = "select SUM(data_length + index_length) as size FROM information_schema.tables WHERE table_schema = '" + db.Name + "';"
-	default:
+	size, err := db.size(context.Background())
+	if lg.CheckError(err) {
 		return -1
 	}
+	return size / (1024 * 1024)
+}
 
-	m, err := Model[sizeDb]().Database(db.Name).QueryS(statement)
-	if lg.CheckError(err) {
-		return -1
+// SizeQuerier can be implemented by a Dialect to report the storage used by databases, SizeQuery select the size of dbName in bytes
+type SizeQuerier interface {
+	SizeQuery(dbName string) string
+}
+
+func (sqliteDialect) SizeQuery(dbName string) string {
+	return "select (page_count * page_size) as size FROM pragma_page_count(), pragma_page_size();"
+}
+
+func (postgresDialect) SizeQuery(dbName string) string {
+	return "select pg_database_size('" + dbName + "') as size;"
+}
+
+func (mysqlDialect) SizeQuery(dbName string) string {
+	return "select SUM(data_length + index_length) as size FROM information_schema.tables WHERE table_schema = '" + dbName + "';"
+}
+
+func (mssqlDialect) SizeQuery(dbName string) string {
+	return "SELECT SUM(CAST(size AS BIGINT)) * 8192 AS size FROM sys.database_files;"
+}
+
+// size return the size of db in bytes
+func (db *DatabaseEntity) size(ctx context.Context) (float64, error) {
+	sq, ok := dialectOf(db.Dialect).(SizeQuerier)
+	if !ok {
+		return 0, fmt.Errorf("size of %s databases is not supported", db.Dialect)
 	}
-	if len(m) > 0 {
-		return m[0].Size / (1024 * 1024)
+	var size sql.NullFloat64
+	if err := db.Conn.QueryRowContext(ctx, sq.SizeQuery(db.Name)).Scan(&size); err != nil {
+		return 0, err
 	}
-	return -1
+	return size.Float64, nil
 }
 
 // AddChangesTrigger
 func AddChangesTrigger(tableName string, dbName ...string) error {
-	dName := defaultDB
+	return defaultClient.AddChangesTrigger(tableName, dbName...)
+}
+
+// AddChangesTrigger is korm.AddChangesTrigger for client c
+func (c *Client) AddChangesTrigger(tableName string, dbName ...string) error {
+	dName := c.defaultDB
 	if len(dbName) > 0 {
 		dName = dbName[0]
 	}
 
-	db, err := GetMemoryDatabase(dName)
+	db, err := c.GetMemoryDatabase(dName)
 	if err != nil {
 		return err
 	}
@@ -296,220 +360,252 @@ func AddChangesTrigger(tableName string, dbName ...string) error {
 		return ErrTableNotFound
 	}
 
-	switch db.Dialect {
-	case SQLITE:
-		// SQLite trigger for each operation
-		insertStmt := `INSERT INTO _triggers_queue(data) VALUES (json_object('operation','insert','table','` + tableName + `','data',json_object(` + buildJsonFields("NEW", cols) + `)))`
-		updateStmt := `INSERT INTO _triggers_queue(data) VALUES (json_object('operation','update','table','` + tableName + `','old',json_object(` + buildJsonFields("OLD", cols) + `),'new',json_object(` + buildJsonFields("NEW", cols) + `)))`
-		deleteStmt := `INSERT INTO _triggers_queue(data) VALUES (json_object('operation','delete','table','` + tableName + `','data',json_object(` + buildJsonFields("OLD", cols) + `)))`
-
-		// Drop all existing triggers first
-		Exec(dName, `DROP TRIGGER IF EXISTS `+tableName+`_trig_insert`)
-		Exec(dName, `DROP TRIGGER IF EXISTS `+tableName+`_trig_update`)
-		Exec(dName, `DROP TRIGGER IF EXISTS `+tableName+`_trig_delete`)
-
-		// Create triggers for each operation with unique names
-		AddTrigger(tableName, "", "AFTER INSERT", insertStmt, dName)
-		AddTrigger(tableName, "", "AFTER UPDATE", updateStmt, dName)
-		AddTrigger(tableName, "", "AFTER DELETE", deleteStmt, dName)
-
-		// Start background worker to publish changes
-		go func() {
-			for {
-				tx, err := db.Conn.Begin()
-				if err != nil {
-					time.Sleep(time.Second)
-					continue
-				}
-
-				// Get rows with exclusive lock
-				rows, err := tx.Query("SELECT rowid, data FROM _triggers_queue")
-				if err != nil {
-					tx.Rollback()
-					time.Sleep(time.Second)
-					continue
-				}
-				hasRows := false
-				doFlush := false
-				for rows.Next() {
-					doFlush = true
-					hasRows = true
-					var jsonData string
-					var rowid int64
-					if err := rows.Scan(&rowid, &jsonData); err != nil {
-						continue
-					}
-
-					ddd := HookData{}
-					err = json.Unmarshal([]byte(jsonData), &ddd)
-					if lg.CheckError(err) {
-						continue
-					}
-					ddd.Pk = t.Pk
-					// Delete processed row within transaction
-					if _, err := tx.Exec("DELETE FROM _triggers_queue WHERE rowid = ?", rowid); err == nil {
-						if hhh, ok := hooks.Get(ddd.Operation); ok {
-							for _, h := range hhh {
-								h(ddd)
-							}
-						}
-					}
-				}
-				if doFlush {
-					flushCache()
-				}
-				rows.Close()
-				if !hasRows {
-					tx.Rollback()
-					time.Sleep(time.Second)
-					continue
-				}
-
-				if err := tx.Commit(); err != nil {
-					lg.Error("Failed to commit transaction:", err)
-					tx.Rollback()
-				}
-
-				time.Sleep(time.Second)
+	d, ok := GetDialect(db.Dialect)
+	if !ok {
+		return fmt.Errorf("dialect %s not registered", db.Dialect)
+	}
+	insertStmt, updateStmt, deleteStmt := d.ChangeTriggers(tableName, t.Pk, cols)
+	if Debug {
+		lg.InfoC("debug change trigger statements",
+			"insert", insertStmt,
+			"update", updateStmt,
+			"delete", deleteStmt)
+	}
+
+	if ar, ok := d.(ActorRecorder); ok {
+		for _, st := range ar.ActorSetup() {
+			if _, err := db.execContext(context.Background(), st); err != nil {
+				return err
 			}
-		}()
-
-	case POSTGRES:
-		// Postgres trigger for each operation
-		insertStmt := `INSERT INTO "_triggers_queue"(data) VALUES (jsonb_build_object('operation', 'insert', 'table', '` + tableName + `', 'data', to_jsonb(NEW)));`
-		updateStmt := `INSERT INTO "_triggers_queue"(data) VALUES (jsonb_build_object('operation', 'update', 'table', '` + tableName + `', 'old', to_jsonb(OLD), 'new', to_jsonb(NEW)));`
-		deleteStmt := `INSERT INTO "_triggers_queue"(data) VALUES (jsonb_build_object('operation', 'delete', 'table', '` + tableName + `', 'data', to_jsonb(OLD)));`
-
-		// Create triggers for each operation
-		AddTrigger(tableName, "", "AFTER INSERT", insertStmt, dName)
-		AddTrigger(tableName, "", "AFTER UPDATE", updateStmt, dName)
-		AddTrigger(tableName, "", "AFTER DELETE", deleteStmt, dName)
-
-		// Start background worker to publish changes
-		go func() {
-			for {
-				// Start transaction
-				tx, err := db.Conn.Begin()
-				if err != nil {
-					time.Sleep(time.Second)
-					continue
-				}
-
-				// Get and lock a single row
-				var jsonData string
-				row := tx.QueryRow("SELECT data FROM \"_triggers_queue\" LIMIT 1 FOR UPDATE SKIP LOCKED")
-				err = row.Scan(&jsonData)
-				if err != nil {
-					tx.Rollback()
-					time.Sleep(time.Second)
-					continue
-				}
-
-				ddd := HookData{}
-				err = json.Unmarshal([]byte(jsonData), &ddd)
-				if lg.CheckError(err) {
-					continue
-				}
-				ddd.Pk = t.Pk
-				// Delete the processed row
-				_, err = tx.Exec("DELETE FROM \"_triggers_queue\" WHERE data = $1", jsonData)
-				if err != nil {
-					tx.Rollback()
-					time.Sleep(time.Second)
-					continue
-				}
-
-				// Commit transaction
-				err = tx.Commit()
-				if err != nil {
-					tx.Rollback()
-					time.Sleep(time.Second)
-					continue
-				}
-				flushCache()
-				if hhh, ok := hooks.Get(ddd.Operation); ok {
-					for _, h := range hhh {
-						h(ddd)
-					}
-				}
-				time.Sleep(time.Second)
+		}
+	}
+	// Create triggers for each operation, dialects without triggers return empty statements
+	if insertStmt != "" {
+		c.AddTrigger(tableName, "", "AFTER INSERT", insertStmt, dName)
+		c.AddTrigger(tableName, "", "AFTER UPDATE", updateStmt, dName)
+		c.AddTrigger(tableName, "", "AFTER DELETE", deleteStmt, dName)
+	}
+
+	// Start background worker to publish changes, a single worker consume the queue of a database in order.
+	// Workers hold locks on the database, the leases table is migrated before they start
+	if usesLeases(d) {
+		if err := c.migrateLocks(db); err != nil {
+			return err
+		}
+	}
+	if cs, ok := d.(ChangesStreamer); ok {
+		if err := cs.SetupChanges(c, dName); err != nil {
+			return err
+		}
+		c.goWorker("changefeed "+dName+"."+tableName, dName, func(ctx context.Context) { cs.StreamChanges(ctx, db, tableName) })
+	}
+	c.triggersTables.Set(dName+"."+tableName, struct{}{})
+	if _, ok := c.internalTables.Get(dName + "._triggers_queue"); ok {
+		return nil
+	}
+	c.internalTables.Set(dName+"._triggers_queue", struct{}{})
+	c.goWorker("changes "+dName, dName, func(ctx context.Context) { changesWorker(ctx, db) })
+	return nil
+}
+
+// ChangesStreamer can be implemented by a Dialect streaming changes instead of using triggers, SetupChanges is called by AddChangesTrigger
+// then StreamChanges run until ctx is done, queuing changes of table in _triggers_queue from where they are delivered like changes of triggers
+type ChangesStreamer interface {
+	SetupChanges(c *Client, dbName string) error
+	StreamChanges(ctx context.Context, db *DatabaseEntity, table string)
+}
+
+func (cockroachDialect) SetupChanges(c *Client, dbName string) error {
+	if _, ok := c.internalTables.Get(dbName + "._changefeed_cursors"); ok {
+		return nil
+	}
+	if err := AutoMigrateOn[changefeedCursor](c, "_changefeed_cursors", dbName); err != nil {
+		return err
+	}
+	c.internalTables.Set(dbName+"._changefeed_cursors", struct{}{})
+	return nil
+}
+
+func (cockroachDialect) StreamChanges(ctx context.Context, db *DatabaseEntity, table string) {
+	cockroachChangesWorker(ctx, db, table)
+}
+
+// changesWorker publish changes queued in _triggers_queue by triggers and streamers of db
+func changesWorker(ctx context.Context, db *DatabaseEntity) {
+	selectSt, deleteSt := queueStatements(dialectOf(db.Dialect))
+	lockedQueueWorker(ctx, db, selectSt, deleteSt)
+}
+
+// queueStatements return the statements of d selecting the next batch of _triggers_queue and deleting a delivered change by id
+func queueStatements(d Dialect) (string, string) {
+	st := "SELECT " + d.Quote("id") + ", " + d.Quote("data") + " FROM " + d.Quote("_triggers_queue") + " ORDER BY " + d.Quote("id") + d.Limit(ChangesBatchSize, 0, true)
+	del := "DELETE FROM " + d.Quote("_triggers_queue") + " WHERE " + d.Quote("id") + " = " + d.Placeholder(1)
+	return st, del
+}
+
+// lockedQueueWorker publish changes of _triggers_queue holding the lock 'changes' on db, so nodes sharing the database consume the queue
+// one batch at a time and in order, without keeping rows locked while hooks run
+func lockedQueueWorker(ctx context.Context, db *DatabaseEntity, selectSt, deleteSt string) {
+	db.whileLocked(ctx, "changes", func(ctx context.Context) {
+		queueWorker(ctx, func() bool {
+			return db.processQueue(ctx, selectSt, deleteSt)
+		})
+	})
+}
+
+// queueWorker call process until ctx is done, waiting a second when no change was delivered, the queue is drained before returning
+func queueWorker(ctx context.Context, process func() bool) {
+	for {
+		if process() {
+			continue
+		}
+		if !sleepCtx(ctx, time.Second) {
+			return
+		}
+	}
+}
+
+// processQueue read changes of _triggers_queue using selectSt, run their hooks, then delete delivered changes using deleteSt,
+// it return false if no change was delivered. Changes whose deletion fail are delivered again
+func (db *DatabaseEntity) processQueue(ctx context.Context, selectSt, deleteSt string) bool {
+	rows, err := db.readConn(db.writer == nil).QueryContext(ctx, selectSt)
+	if err != nil {
+		if ctx.Err() == nil {
+			lg.ErrorC("could not read changes", "db", db.Name, "err", err)
+		}
+		return false
+	}
+	delivered := db.deliverQueued(ctx, db.scanQueued(rows))
+	for _, id := range delivered {
+		if _, err := db.execContext(ctx, deleteSt, id); err != nil {
+			lg.ErrorC("could not delete delivered change", "db", db.Name, "id", id, "err", err)
+			return false
+		}
+	}
+	return len(delivered) > 0
+}
+
+// cockroachChangesWorker queue changes of table streamed by a core changefeed in _triggers_queue, where they are delivered like changes of triggers.
+// The feed run on a single node at a time, holding the lock 'changefeed.<table>' on the database, other nodes wait for it.
+// The feed emit resolved timestamps, once all changes up to one are queued it is saved in _changefeed_cursors and the feed resume from it
+// after a restart, a connection error or on the node taking the lock, changes queued after the last resolved timestamp are queued again.
+// Rangefeeds should be enabled using 'SET CLUSTER SETTING kv.rangefeed.enabled = true'
+func cockroachChangesWorker(ctx context.Context, db *DatabaseEntity, table string) {
+	db.whileLocked(ctx, "changefeed."+table, func(ctx context.Context) {
+		db.runChangefeed(ctx, table)
+	})
+}
+
+// runChangefeed stream changes of table from its saved cursor until ctx is done or the feed fail
+func (db *DatabaseEntity) runChangefeed(ctx context.Context, table string) {
+	d := dialectOf(db.Dialect)
+	var cursor string
+	err := db.Conn.QueryRowContext(ctx, `SELECT "cursor" FROM "_changefeed_cursors" WHERE "name" = $1`, table).Scan(&cursor)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		if ctx.Err() == nil {
+			lg.ErrorC("could not read changefeed cursor", "table", table, "err", err)
+			sleepCtx(ctx, 5*time.Second)
+		}
+		return
+	}
+	st := "EXPERIMENTAL CHANGEFEED FOR " + d.Quote(table) + " WITH diff, updated, resolved"
+	if cursor != "" {
+		st += ", cursor = '" + cursor + "'"
+	} else {
+		st += ", initial_scan = 'no'"
+	}
+	rows, err := db.Conn.QueryContext(ctx, st)
+	if err != nil {
+		if ctx.Err() == nil {
+			lg.ErrorC("could not start changefeed", "table", table, "err", err)
+			sleepCtx(ctx, 5*time.Second)
+		}
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tbl sql.NullString
+		var key, value []byte
+		// the feed is restarted from the saved cursor on errors, so later resolved timestamps do not skip the failed change
+		if err := rows.Scan(&tbl, &key, &value); err != nil {
+			lg.ErrorC("could not read change", "table", table, "err", err)
+			return
+		}
+		if err := db.saveChangefeedRow(ctx, table, value); err != nil {
+			if ctx.Err() == nil {
+				lg.ErrorC("could not queue change", "table", table, "err", err)
 			}
-		}()
-
-	case MYSQL:
-		// MySQL trigger for each operation
-		insertStmt := `INSERT INTO ` + "`_triggers_queue`" + `(data) VALUES (JSON_OBJECT('operation', 'insert', 'table', '` + tableName + `', 'data', JSON_OBJECT(` + buildJsonFields("NEW", cols) + `)))`
-		updateStmt := `INSERT INTO ` + "`_triggers_queue`" + `(data) VALUES (JSON_OBJECT('operation', 'update', 'table', '` + tableName + `', 'old', JSON_OBJECT(` + buildJsonFields("OLD", cols) + `), 'new', JSON_OBJECT(` + buildJsonFields("NEW", cols) + `)))`
-		deleteStmt := `INSERT INTO ` + "`_triggers_queue`" + `(data) VALUES (JSON_OBJECT('operation', 'delete', 'table', '` + tableName + `', 'data', JSON_OBJECT(` + buildJsonFields("OLD", cols) + `)))`
-
-		if Debug {
-			lg.InfoC("debug mysql trigger statements",
-				"insert", insertStmt,
-				"update", updateStmt,
-				"delete", deleteStmt)
+			return
 		}
+	}
+	if ctx.Err() == nil {
+		lg.CheckError(rows.Err())
+	}
+}
 
-		// Create triggers for each operation
-		AddTrigger(tableName, "", "AFTER INSERT", insertStmt, dName)
-		AddTrigger(tableName, "", "AFTER UPDATE", updateStmt, dName)
-		AddTrigger(tableName, "", "AFTER DELETE", deleteStmt, dName)
-
-		// Start background worker to publish changes
-		go func() {
-			for {
-				// Start transaction
-				tx, err := db.Conn.Begin()
-				if err != nil {
-					time.Sleep(time.Second)
-					continue
-				}
-
-				// Get and lock a single row
-				var jsonData string
-				var id int64
-				row := tx.QueryRow("SELECT id, JSON_UNQUOTE(data) FROM `_triggers_queue` LIMIT 1 FOR UPDATE")
-				err = row.Scan(&id, &jsonData)
-				if err != nil {
-					tx.Rollback()
-					time.Sleep(time.Second)
-					continue
-				}
-
-				// Delete the row within the transaction
-				_, err = tx.Exec("DELETE FROM `_triggers_queue` WHERE id = ?", id)
-				if err != nil {
-					tx.Rollback()
-					time.Sleep(time.Second)
-					continue
-				}
-
-				// Commit transaction
-				err = tx.Commit()
-				if err != nil {
-					tx.Rollback()
-					time.Sleep(time.Second)
-					continue
-				}
-
-				// Publish change only after successful commit
-				ddd := HookData{}
-				ddd.Pk = t.Pk
-				err = json.Unmarshal([]byte(jsonData), &ddd)
-				if lg.CheckError(err) {
-					continue
-				}
-				flushCache()
-				if hhh, ok := hooks.Get(ddd.Operation); ok {
-					for _, h := range hhh {
-						h(ddd)
-					}
-				}
-				time.Sleep(time.Second)
+// saveChangefeedRow queue the change of a changefeed value in _triggers_queue, or save the cursor of table if value is a resolved timestamp
+func (db *DatabaseEntity) saveChangefeedRow(ctx context.Context, table string, value []byte) error {
+	ddd, ts, ok := parseCockroachChange(table, value)
+	if ok {
+		if ts != "" {
+			// actors are saved with the timestamp of the transaction of the write, see cockroachDialect.ActorStatements
+			var actor sql.NullString
+			err := db.Conn.QueryRowContext(ctx, `SELECT "actor" FROM "_triggers_actors" WHERE "ts" = $1::DECIMAL`, ts).Scan(&actor)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
 			}
-		}()
+			ddd.Actor = actor.String
+		}
+		data, err := json.Marshal(ddd)
+		if err != nil {
+			return err
+		}
+		_, err = db.Conn.ExecContext(ctx, `INSERT INTO "_triggers_queue" ("data") VALUES ($1)`, string(data))
+		return err
 	}
+	if ts == "" {
+		return nil
+	}
+	_, err := db.Conn.ExecContext(ctx, `INSERT INTO "_changefeed_cursors" ("name", "cursor") VALUES ($1, $2) ON CONFLICT ("name") DO UPDATE SET "cursor" = excluded."cursor"`, table, ts)
+	if err != nil {
+		return err
+	}
+	// actors older than the cursors of all feeds will not be read again
+	_, err = db.Conn.ExecContext(ctx, `DELETE FROM "_triggers_actors" WHERE "ts" < (SELECT min("cursor"::DECIMAL) FROM "_changefeed_cursors")`)
+	return err
+}
 
-	return nil
+// parseCockroachChange convert a changefeed value using diff and updated options to HookData and return its updated timestamp,
+// it return false and the resolved timestamp if value is not a change
+func parseCockroachChange(table string, value []byte) (HookData, string, bool) {
+	var change struct {
+		After    map[string]any `json:"after"`
+		Before   map[string]any `json:"before"`
+		Updated  string         `json:"updated"`
+		Resolved string         `json:"resolved"`
+	}
+	if len(value) == 0 || json.Unmarshal(value, &change) != nil {
+		return HookData{}, "", false
+	}
+	if change.Resolved != "" {
+		return HookData{}, change.Resolved, false
+	}
+	ddd := HookData{Table: table}
+	switch {
+	case change.Before == nil && change.After != nil:
+		ddd.Operation = "insert"
+		ddd.Data = change.After
+	case change.Before != nil && change.After != nil:
+		ddd.Operation = "update"
+		ddd.Old = change.Before
+		ddd.New = change.After
+	case change.Before != nil:
+		ddd.Operation = "delete"
+		ddd.Data = change.Before
+	default:
+		return HookData{}, "", false
+	}
+	return ddd, change.Updated, true
 }
 
 // Helper function to build JSON field pairs for triggers
@@ -0,0 +1,300 @@
+package korm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+var (
+	// HooksMaxAttempts is the number of deliveries of a change whose hooks fail before moving it to _triggers_dead
+	HooksMaxAttempts = 5
+	// HooksRetryBase is the delay before the first redelivery of a change, doubled at every attempt up to HooksRetryMax
+	HooksRetryBase = time.Second
+	HooksRetryMax  = time.Minute
+	// ChangesBatchSize is the number of changes read from _triggers_queue at once by change workers
+	ChangesBatchSize = 100
+)
+
+// DeadChange is a change whose hooks failed HooksMaxAttempts times, moved to the _triggers_dead table
+type DeadChange struct {
+	Id       uint      `korm:"pk" json:"id"`
+	Data     string    `korm:"text" json:"data"`
+	Attempts int       `json:"attempts"`
+	Error    string    `korm:"text" json:"error"`
+	FailedAt time.Time `korm:"now" json:"failed_at"`
+}
+
+// QueueStats is the state of the delivery of changes of a database
+type QueueStats struct {
+	// Depth is the number of changes waiting in _triggers_queue
+	Depth int64 `json:"depth"`
+	// Retrying is the number of changes whose hooks failed and that wait for a redelivery
+	Retrying int `json:"retrying"`
+	// Dead is the number of changes in _triggers_dead
+	Dead int64 `json:"dead"`
+	// Lag is the age of the oldest change being delivered or retried
+	Lag time.Duration `json:"lag"`
+}
+
+// deliveries track changes of _triggers_queue being delivered or waiting for a retry,
+// workers of tables share the queue so a change is never given to two workers at once
+type deliveries struct {
+	mu    sync.Mutex
+	items map[string]*delivery
+}
+
+type delivery struct {
+	db       string
+	attempts int
+	started  time.Time
+	next     time.Time
+	inflight bool
+	err      error
+}
+
+type queuedChange struct {
+	id   int64
+	data string
+}
+
+// scanQueued read id and data of rows, then close rows, rows that cannot be scanned are logged and left in the queue
+func (db *DatabaseEntity) scanQueued(rows *sql.Rows) []queuedChange {
+	defer rows.Close()
+	queued := []queuedChange{}
+	for rows.Next() {
+		var q queuedChange
+		if err := rows.Scan(&q.id, &q.data); err != nil {
+			lg.ErrorC("could not read queued change", "db", db.Name, "err", err)
+			continue
+		}
+		queued = append(queued, q)
+	}
+	if err := rows.Err(); err != nil {
+		lg.ErrorC("could not read queued changes", "db", db.Name, "err", err)
+	}
+	return queued
+}
+
+// claim return the delivery of key if it is not delivered by another worker and its retry delay elapsed
+func (d *deliveries) claim(db, key string, now time.Time) (*delivery, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.items == nil {
+		d.items = map[string]*delivery{}
+	}
+	dl, ok := d.items[key]
+	if !ok {
+		dl = &delivery{db: db, started: now}
+		d.items[key] = dl
+	}
+	if dl.inflight || now.Before(dl.next) {
+		return nil, false
+	}
+	dl.inflight = true
+	dl.attempts++
+	return dl, true
+}
+
+// done record the result of a delivery, forgetting it if it succeeded or is dead
+func (d *deliveries) done(key string, dl *delivery, err error, forget bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dl.inflight = false
+	dl.err = err
+	if forget {
+		delete(d.items, key)
+		return
+	}
+	dl.next = time.Now().Add(hookBackoff(dl.attempts))
+}
+
+// prune forget changes not claimed again long after their retry delay, they were delivered by another node
+func (d *deliveries) prune(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, dl := range d.items {
+		if !dl.inflight && now.Sub(dl.next) > 2*HooksRetryMax {
+			delete(d.items, key)
+		}
+	}
+}
+
+// deliverQueued run hooks of queued changes and return ids of changes that can be deleted from the queue,
+// because their hooks succeeded or they were moved to _triggers_dead.
+// Changes of a table are delivered in order, a change is held back while a previous change of its table is not delivered
+func (db *DatabaseEntity) deliverQueued(ctx context.Context, queued []queuedChange) []int64 {
+	c := db.client
+	delivered := []int64{}
+	held := map[string]bool{}
+	flushed := false
+	c.deliveries.prune(time.Now())
+	for _, q := range queued {
+		ddd, decodeErr := db.decodeQueued(q.data)
+		if held[ddd.Table] {
+			continue
+		}
+		key := db.Name + ":" + strconv.FormatInt(q.id, 10)
+		dl, ok := c.deliveries.claim(db.Name, key, time.Now())
+		if !ok {
+			held[ddd.Table] = true
+			continue
+		}
+		if !flushed {
+			c.flushCache()
+			flushed = true
+		}
+		if decodeErr != nil {
+			if db.deadLetter(q.data, decodeErr, dl.attempts) {
+				delivered = append(delivered, q.id)
+			}
+			c.deliveries.done(key, dl, decodeErr, true)
+			continue
+		}
+		if dl.attempts > HooksMaxAttempts {
+			// hooks failed but the change could not be moved to _triggers_dead
+			if db.deadLetter(q.data, dl.err, dl.attempts-1) {
+				delivered = append(delivered, q.id)
+				c.deliveries.done(key, dl, dl.err, true)
+			} else {
+				held[ddd.Table] = true
+				c.deliveries.done(key, dl, dl.err, false)
+			}
+			continue
+		}
+		err := db.publishChange(ctx, ddd, dl.attempts == 1)
+		switch {
+		case err == nil:
+			delivered = append(delivered, q.id)
+			c.deliveries.done(key, dl, nil, true)
+		case dl.attempts >= HooksMaxAttempts:
+			lg.ErrorC("change hooks failed, moving it to _triggers_dead", "db", db.Name, "table", ddd.Table, "attempts", dl.attempts, "err", err)
+			dead := db.deadLetter(q.data, err, dl.attempts)
+			if dead {
+				delivered = append(delivered, q.id)
+			} else {
+				held[ddd.Table] = true
+			}
+			c.deliveries.done(key, dl, err, dead)
+		default:
+			lg.WarnC("change hooks failed, retrying", "db", db.Name, "table", ddd.Table, "attempt", dl.attempts, "err", err)
+			held[ddd.Table] = true
+			c.deliveries.done(key, dl, err, false)
+		}
+	}
+	return delivered
+}
+
+// decodeQueued decode a change queued by triggers, the table is set even if the change is invalid when it could be read
+func (db *DatabaseEntity) decodeQueued(data string) (HookData, error) {
+	ddd := HookData{}
+	if err := json.Unmarshal([]byte(data), &ddd); err != nil {
+		return ddd, err
+	}
+	switch {
+	case ddd.Table == "":
+		return ddd, errors.New("change without table")
+	case ddd.Operation == "update" && (ddd.Old == nil || ddd.New == nil):
+		return ddd, errors.New("update change without old and new rows")
+	case (ddd.Operation == "insert" || ddd.Operation == "delete") && ddd.Data == nil:
+		return ddd, errors.New(ddd.Operation + " change without data")
+	case ddd.Operation != "insert" && ddd.Operation != "update" && ddd.Operation != "delete":
+		return ddd, errors.New("unknown change operation " + ddd.Operation)
+	}
+	ddd.Pk = db.tablePk(ddd.Table)
+	return ddd, nil
+}
+
+// tablePk return the primary key of table, or empty if table is unknown
+func (db *DatabaseEntity) tablePk(table string) string {
+	for _, t := range db.Tables {
+		if t.Name == table {
+			return t.Pk
+		}
+	}
+	return ""
+}
+
+// deadLetter insert data in _triggers_dead, migrating it on first use, it return false if the change could not be saved
+func (db *DatabaseEntity) deadLetter(data string, err error, attempts int) bool {
+	c := db.client
+	if _, ok := c.internalTables.Get(db.Name + "._triggers_dead"); !ok {
+		if e := AutoMigrateOn[DeadChange](c, "_triggers_dead", db.Name); lg.CheckError(e) {
+			return false
+		}
+		c.internalTables.Set(db.Name+"._triggers_dead", struct{}{})
+	}
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	_, e := ModelOn[DeadChange](c).Database(db.Name).Insert(&DeadChange{
+		Data:     data,
+		Attempts: attempts,
+		Error:    msg,
+		FailedAt: time.Now(),
+	})
+	return !lg.CheckError(e)
+}
+
+// hookBackoff return the delay before delivering again a change whose hooks failed attempts times
+func hookBackoff(attempts int) time.Duration {
+	delay := HooksRetryBase
+	for i := 1; i < attempts && delay < HooksRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > HooksRetryMax {
+		delay = HooksRetryMax
+	}
+	return delay
+}
+
+// GetQueueStats return the depth of _triggers_queue, the number of changes retried and dead, and the delivery lag of dbName, or of the first database if empty
+func GetQueueStats(dbName string) (QueueStats, error) {
+	return defaultClient.GetQueueStats(dbName)
+}
+
+// GetQueueStats is korm.GetQueueStats for client c
+func (c *Client) GetQueueStats(dbName string) (QueueStats, error) {
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil {
+		return QueueStats{}, err
+	}
+	return db.queueStats(context.Background())
+}
+
+func (db *DatabaseEntity) queueStats(ctx context.Context) (QueueStats, error) {
+	stats := QueueStats{}
+	now := time.Now()
+	dls := &db.client.deliveries
+	dls.mu.Lock()
+	for _, dl := range dls.items {
+		if dl.db != db.Name {
+			continue
+		}
+		if dl.err != nil {
+			stats.Retrying++
+		}
+		if lag := now.Sub(dl.started); lag > stats.Lag {
+			stats.Lag = lag
+		}
+	}
+	dls.mu.Unlock()
+	d := dialectOf(db.Dialect)
+	conn := db.readConn(false)
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+d.Quote("_triggers_queue")).Scan(&stats.Depth); err != nil {
+		return stats, err
+	}
+	if SliceContains(db.client.GetAllTables(db.Name), "_triggers_dead") {
+		if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+d.Quote("_triggers_dead")).Scan(&stats.Dead); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
@@ -9,16 +9,18 @@ var (
 )
 
 const (
-	MIGRATION_FOLDER         = "migrations"
-	SQLITE           Dialect = "sqlite3"
-	POSTGRES         Dialect = "postgres"
-	MYSQL            Dialect = "mysql"
-	MARIA            Dialect = "maria"
-	COCKROACH        Dialect = "cockroach"
+	MIGRATION_FOLDER = "migrations"
 )
 
-// Dialect db dialects are SQLITE, POSTGRES, MYSQL, MARIA, COCKROACH
-type Dialect = string
+// builtin dialects names, see Dialect
+const (
+	SQLITE    = "sqlite3"
+	POSTGRES  = "postgres"
+	MYSQL     = "mysql"
+	MARIA     = "maria"
+	COCKROACH = "cockroach"
+	MSSQL     = "sqlserver"
+)
 
 // DatabaseEntity hold table state
 type TableEntity struct {
@@ -44,10 +46,15 @@ type TablesInfos struct {
 
 // DatabaseEntity hold memory db state
 type DatabaseEntity struct {
-	Tables  []TableEntity
-	Name    string
-	Dialect string
-	Conn    *sql.DB
+	Tables   []TableEntity
+	Name     string
+	Dialect  string
+	Conn     *sql.DB
+	Options  DbOptions
+	replicas *replicaSet
+	writer   *sqliteWriter
+	readOnly *sql.DB
+	client   *Client
 }
 
 type dbCache struct {
@@ -61,6 +68,7 @@ type dbCache struct {
 	offset     string
 	statement  string
 	args       string
+	primary    bool
 }
 
 type DocsSuccess struct {
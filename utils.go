@@ -140,11 +140,16 @@ func Benchmark(f func(), name string, iterations int) {
 
 // GetMemoryTable get a table from memory for specified or first connected db
 func GetMemoryTable(tbName string, dbName ...string) (TableEntity, error) {
-	dName := databases[0].Name
+	return defaultClient.GetMemoryTable(tbName, dbName...)
+}
+
+// GetMemoryTable is korm.GetMemoryTable for client c
+func (c *Client) GetMemoryTable(tbName string, dbName ...string) (TableEntity, error) {
+	dName := c.databases[0].Name
 	if len(dbName) > 0 {
 		dName = dbName[0]
 	}
-	db, err := GetMemoryDatabase(dName)
+	db, err := c.GetMemoryDatabase(dName)
 	if err != nil {
 		return TableEntity{}, err
 	}
@@ -158,11 +163,16 @@ func GetMemoryTable(tbName string, dbName ...string) (TableEntity, error) {
 
 // GetMemoryTable get a table from memory for specified or first connected db
 func GetMemoryTableAndDB(tbName string, dbName ...string) (TableEntity, DatabaseEntity, error) {
-	dName := databases[0].Name
+	return defaultClient.GetMemoryTableAndDB(tbName, dbName...)
+}
+
+// GetMemoryTableAndDB is korm.GetMemoryTableAndDB for client c
+func (c *Client) GetMemoryTableAndDB(tbName string, dbName ...string) (TableEntity, DatabaseEntity, error) {
+	dName := c.databases[0].Name
 	if len(dbName) > 0 {
 		dName = dbName[0]
 	}
-	db, err := GetMemoryDatabase(dName)
+	db, err := c.GetMemoryDatabase(dName)
 	if err != nil {
 		return TableEntity{}, DatabaseEntity{}, err
 	}
@@ -176,26 +186,39 @@ func GetMemoryTableAndDB(tbName string, dbName ...string) (TableEntity, Database
 
 // GetMemoryDatabases get all databases from memory
 func GetMemoryDatabases() []DatabaseEntity {
-	return databases
+	return defaultClient.GetMemoryDatabases()
+}
+
+// GetMemoryDatabases is korm.GetMemoryDatabases for client c
+func (c *Client) GetMemoryDatabases() []DatabaseEntity {
+	return c.databases
 }
 
 // GetMemoryDatabase return the first connected database korm.DefaultDatabase if dbName "" or "default" else the matched db
 func GetMemoryDatabase(dbName string) (*DatabaseEntity, error) {
-	if defaultDB == "" {
-		defaultDB = databases[0].Name
+	return defaultClient.GetMemoryDatabase(dbName)
+}
+
+// GetMemoryDatabase is korm.GetMemoryDatabase for client c
+func (c *Client) GetMemoryDatabase(dbName string) (*DatabaseEntity, error) {
+	if len(c.databases) == 0 {
+		return nil, ErrNoConnection
+	}
+	if c.defaultDB == "" {
+		c.defaultDB = c.databases[0].Name
 	}
 	switch dbName {
 	case "", "default":
-		for i := range databases {
-			if databases[i].Name == defaultDB {
-				return &databases[i], nil
+		for i := range c.databases {
+			if c.databases[i].Name == c.defaultDB {
+				return &c.databases[i], nil
 			}
 		}
 		return nil, errors.New(dbName + "database not found")
 	default:
-		for i := range databases {
-			if databases[i].Name == dbName {
-				return &databases[i], nil
+		for i := range c.databases {
+			if c.databases[i].Name == dbName {
+				return &c.databases[i], nil
 			}
 		}
 		return nil, errors.New(dbName + "database not found")
@@ -260,21 +283,12 @@ func getStructInfos[T any](strctt *T, ignoreZeroValues ...bool) (fields []string
 	return fields, fValues, fTypes, fTags
 }
 
-func indexExists(conn *sql.DB, tableName, indexName string, dialect Dialect) bool {
-	var query string
-	switch dialect {
-	case SQLITE:
-		query = `SELECT name FROM sqlite_master 
-				 WHERE type='index' AND tbl_name=? AND name=?`
-	case POSTGRES:
-		query = `SELECT indexname FROM pg_indexes 
-				 WHERE tablename = $1 AND indexname = $2`
-	case MYSQL, MARIA:
-		query = `SELECT INDEX_NAME FROM information_schema.statistics 
-				 WHERE table_name = ? AND index_name = ?`
-	default:
+func indexExists(conn *sql.DB, tableName, indexName string, dialect string) bool {
+	d, ok := GetDialect(dialect)
+	if !ok {
 		return false
 	}
+	query := d.IndexQuery()
 
 	var name string
 	err := conn.QueryRow(query, tableName, indexName).Scan(&name)
@@ -326,7 +340,7 @@ func SetCacheMaxMemory(megaByte int) {
 		megaByte = 100
 	}
 	cacheMaxMemoryMb = megaByte
-	caches = kmap.New[string, *kmap.SafeMap[dbCache, any]](cacheMaxMemoryMb)
+	defaultClient.caches = kmap.New[string, *kmap.SafeMap[dbCache, any]](cacheMaxMemoryMb)
 }
 
 // SystemMetrics holds memory and runtime statistics for the application
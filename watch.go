@@ -0,0 +1,466 @@
+package korm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+var (
+	// ChangesRetention is how long changes are kept in _changes for watchers resuming from a sequence number
+	ChangesRetention = 24 * time.Hour
+	// WatchPollEvery is the maximum delay before a watcher read new changes of another process
+	WatchPollEvery = time.Second
+	// WatchBuffer is the size of channels returned by Watch
+	WatchBuffer = 100
+)
+
+// ChangeEntry is a row of the _changes log, Id is the sequence number of the change
+type ChangeEntry struct {
+	Id        uint64    `korm:"pk" json:"seq"`
+	TableName string    `korm:"size:255;index" json:"table"`
+	Op        string    `korm:"size:10" json:"op"`
+	Data      string    `korm:"text" json:"data"`
+	At        time.Time `korm:"index" json:"at"`
+}
+
+// Change is a typed change event, Old is empty for inserts and New for deletes
+type Change[T any] struct {
+	Seq   uint64    `json:"seq"`
+	Op    string    `json:"op"` // insert, update or delete
+	Table string    `json:"table"`
+	Old   T         `json:"old"`
+	New   T         `json:"new"`
+	At    time.Time `json:"at"`
+}
+
+// WatchOption configure Watch
+type WatchOption func(*watchOptions)
+
+type watchOptions struct {
+	database string
+	tables   []string
+	from     uint64
+	where    string
+	args     []any
+}
+
+// Filter keep changes whose row match where, a list of 'column op ?' joined by AND, op being =, !=, <>, <, <=, >, >=, IN, NOT IN, LIKE,
+// IS NULL or IS NOT NULL. Updates are kept if the old or the new row match
+func Filter(where string, args ...any) WatchOption {
+	return func(o *watchOptions) {
+		o.where = where
+		o.args = args
+	}
+}
+
+// FromSeq resume watching after the change seq, usually the Seq of the last change handled before a restart
+func FromSeq(seq uint64) WatchOption {
+	return func(o *watchOptions) { o.from = seq }
+}
+
+// WatchTables watch tables instead of tables having the model T
+func WatchTables(tables ...string) WatchOption {
+	return func(o *watchOptions) { o.tables = tables }
+}
+
+// WatchDatabase watch dbName instead of the first database
+func WatchDatabase(dbName string) WatchOption {
+	return func(o *watchOptions) { o.database = dbName }
+}
+
+// changeLog hold databases checked for a _changes table and wake up watchers on new changes
+type changeLog struct {
+	mu      sync.Mutex
+	checked map[string]bool
+	signal  chan struct{}
+}
+
+// Watch return a channel of changes of tables having the model T, read from the _changes log filled by change workers from _triggers_queue.
+// Calling it once create _changes, changes are then logged for ChangesRetention even when nobody watch, so a consumer can restart
+// using FromSeq with the Seq of the last change it handled. The channel is closed when ctx is done or on Shutdown
+//
+//	Example:
+//	  changes, err := korm.Watch[Order](ctx, korm.Filter("status = ?", "paid"), korm.FromSeq(lastSeq))
+//	  for ch := range changes {
+//	  	fmt.Println(ch.Seq, ch.Op, ch.Old, ch.New)
+//	  }
+func Watch[T any](ctx context.Context, opts ...WatchOption) (<-chan Change[T], error) {
+	return WatchOn[T](defaultClient, ctx, opts...)
+}
+
+// WatchOn is Watch using client c
+func WatchOn[T any](c *Client, ctx context.Context, opts ...WatchOption) (<-chan Change[T], error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	o := watchOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	conds, err := parseWatchFilter(o.where, o.args)
+	if err != nil {
+		return nil, err
+	}
+	db, err := c.changeLogDatabase(o.database)
+	if err != nil {
+		return nil, err
+	}
+	out := make(chan Change[T], WatchBuffer)
+	c.goWorker("watch "+reflect.TypeFor[T]().Name(), db.Name, func(wctx context.Context) {
+		defer close(out)
+		last := o.from
+		for {
+			signal := c.changesSignal()
+			entries, err := ModelOn[ChangeEntry](c).Database(db.Name).Context(ctx).NoCache().Where("id > ?", last).OrderBy("id").Limit(500).All()
+			if err != nil && !errors.Is(err, ErrNoData) && ctx.Err() == nil && wctx.Err() == nil {
+				lg.ErrorC("could not read changes", "err", err)
+			}
+			for _, e := range entries {
+				last = e.Id
+				if !hookOfType[T](c, e.TableName, o.tables) {
+					continue
+				}
+				ch, ok, err := decodeChange[T](e, conds)
+				if err != nil {
+					lg.ErrorC("could not decode change", "table", e.TableName, "seq", e.Id, "err", err)
+					continue
+				}
+				if !ok {
+					continue
+				}
+				select {
+				case out <- ch:
+				case <-ctx.Done():
+					return
+				case <-wctx.Done():
+					return
+				}
+			}
+			if len(entries) == 500 {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-wctx.Done():
+				return
+			case <-signal:
+			case <-time.After(WatchPollEvery):
+			}
+		}
+	})
+	return out, nil
+}
+
+// changeLogDatabase migrate _changes of dbName and start pruning changes older than ChangesRetention
+func (c *Client) changeLogDatabase(dbName string) (*DatabaseEntity, error) {
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.internalTables.Get(db.Name + "._changes"); ok {
+		return db, nil
+	}
+	if err := AutoMigrateOn[ChangeEntry](c, "_changes", db.Name); err != nil {
+		return nil, err
+	}
+	c.internalTables.Set(db.Name+"._changes", struct{}{})
+	c.goWorker("changes retention "+db.Name, db.Name, func(ctx context.Context) {
+		for sleepCtx(ctx, time.Minute) {
+			_, err := ModelOn[ChangeEntry](c).Database(db.Name).Context(ctx).Where("at < ?", time.Now().Add(-ChangesRetention)).Delete()
+			if err != nil && ctx.Err() == nil {
+				lg.ErrorC("could not prune changes", "db", db.Name, "err", err)
+			}
+		}
+	})
+	return db, nil
+}
+
+// changeLogEnabled return true if db has a _changes table, created by a Watch of this or a previous run
+func (c *Client) changeLogEnabled(db *DatabaseEntity) bool {
+	if _, ok := c.internalTables.Get(db.Name + "._changes"); ok {
+		return true
+	}
+	c.changes.mu.Lock()
+	if c.changes.checked == nil {
+		c.changes.checked = map[string]bool{}
+	}
+	checked := c.changes.checked[db.Name]
+	c.changes.checked[db.Name] = true
+	c.changes.mu.Unlock()
+	if checked || !SliceContains(c.GetAllTables(db.Name), "_changes") {
+		return false
+	}
+	_, err := c.changeLogDatabase(db.Name)
+	return !lg.CheckError(err)
+}
+
+// changesSignal return a channel closed on the next logged change
+func (c *Client) changesSignal() <-chan struct{} {
+	c.changes.mu.Lock()
+	defer c.changes.mu.Unlock()
+	if c.changes.signal == nil {
+		c.changes.signal = make(chan struct{})
+	}
+	return c.changes.signal
+}
+
+// publishChange append hd to the _changes log if enabled and logChange, run hooks, then wake up watchers.
+// It return the errors of hooks, logChange is false when a change is delivered again
+func (db *DatabaseEntity) publishChange(ctx context.Context, hd HookData, logChange bool) error {
+	c := db.client
+	hd.database = db.Name
+	logged := false
+	if logChange && c.changeLogEnabled(db) {
+		data, err := json.Marshal(hd)
+		if err == nil {
+			_, err = ModelOn[ChangeEntry](c).Database(db.Name).Context(context.WithoutCancel(ctx)).Insert(&ChangeEntry{
+				TableName: hd.Table,
+				Op:        hd.Operation,
+				Data:      string(data),
+				At:        time.Now(),
+			})
+		}
+		logged = !lg.CheckError(err)
+	}
+	err := c.runHooks(ctx, hd)
+	if logged {
+		c.changes.mu.Lock()
+		if c.changes.signal != nil {
+			close(c.changes.signal)
+			c.changes.signal = nil
+		}
+		c.changes.mu.Unlock()
+	}
+	return err
+}
+
+// decodeChange decode e into a Change[T], ok is false if the row does not match conds
+func decodeChange[T any](e ChangeEntry, conds []watchCond) (Change[T], bool, error) {
+	ch := Change[T]{Seq: e.Id, Op: e.Op, Table: e.TableName, At: e.At}
+	hd := HookData{}
+	if err := json.Unmarshal([]byte(e.Data), &hd); err != nil {
+		return ch, false, err
+	}
+	var err error
+	switch e.Op {
+	case "insert":
+		if !matchWatchConds(conds, hd.Data) {
+			return ch, false, nil
+		}
+		ch.New, err = decodeHookRow[T](hd.Data)
+	case "delete":
+		if !matchWatchConds(conds, hd.Data) {
+			return ch, false, nil
+		}
+		ch.Old, err = decodeHookRow[T](hd.Data)
+	case "update":
+		if !matchWatchConds(conds, hd.Old) && !matchWatchConds(conds, hd.New) {
+			return ch, false, nil
+		}
+		if ch.Old, err = decodeHookRow[T](hd.Old); err == nil {
+			ch.New, err = decodeHookRow[T](hd.New)
+		}
+	default:
+		return ch, false, nil
+	}
+	return ch, err == nil, err
+}
+
+// watchCond is a 'column op ?' condition of a Filter
+type watchCond struct {
+	col  string
+	op   string
+	args []any
+}
+
+var (
+	watchAndRe  = regexp.MustCompile(`(?i)\s+AND\s+`)
+	watchCondRe = regexp.MustCompile(`(?i)^\s*([a-z_][a-z0-9_]*)\s*(=|!=|<>|<=|>=|<|>|NOT\s+IN|IN|NOT\s+LIKE|LIKE|IS\s+NOT\s+NULL|IS\s+NULL)\s*(.*?)\s*$`)
+)
+
+// parseWatchFilter parse a Filter where clause into conditions evaluated on rows of changes
+func parseWatchFilter(where string, args []any) ([]watchCond, error) {
+	if strings.TrimSpace(where) == "" {
+		return nil, nil
+	}
+	conds := []watchCond{}
+	argIndex := 0
+	for _, part := range watchAndRe.Split(strings.TrimSpace(where), -1) {
+		m := watchCondRe.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("unsupported watch filter %q", part)
+		}
+		cond := watchCond{col: strings.ToLower(m[1]), op: strings.ToUpper(strings.Join(strings.Fields(m[2]), " "))}
+		rest := strings.TrimSpace(m[3])
+		switch cond.op {
+		case "IS NULL", "IS NOT NULL":
+			if rest != "" {
+				return nil, fmt.Errorf("unsupported watch filter %q", part)
+			}
+		default:
+			if rest != "?" && rest != "(?)" {
+				return nil, fmt.Errorf("watch filter %q should use a placeholder", part)
+			}
+			if argIndex >= len(args) {
+				return nil, fmt.Errorf("missing argument for watch filter %q", part)
+			}
+			arg := args[argIndex]
+			argIndex++
+			if cond.op == "IN" || cond.op == "NOT IN" {
+				rv := reflect.ValueOf(arg)
+				if rv.Kind() != reflect.Slice {
+					return nil, fmt.Errorf("watch filter %q expect a slice", part)
+				}
+				for i := 0; i < rv.Len(); i++ {
+					cond.args = append(cond.args, rv.Index(i).Interface())
+				}
+			} else {
+				cond.args = []any{arg}
+			}
+		}
+		conds = append(conds, cond)
+	}
+	if argIndex != len(args) {
+		return nil, fmt.Errorf("watch filter %q expect %d arguments, got %d", where, argIndex, len(args))
+	}
+	return conds, nil
+}
+
+// matchWatchConds return true if row match all conds
+func matchWatchConds(conds []watchCond, row map[string]any) bool {
+	if row == nil {
+		return false
+	}
+	for _, cond := range conds {
+		v := row[cond.col]
+		switch cond.op {
+		case "IS NULL":
+			if v != nil {
+				return false
+			}
+		case "IS NOT NULL":
+			if v == nil {
+				return false
+			}
+		case "IN", "NOT IN":
+			found := false
+			for _, a := range cond.args {
+				if cmp, ok := compareWatchValues(v, a); ok && cmp == 0 {
+					found = true
+					break
+				}
+			}
+			if found != (cond.op == "IN") {
+				return false
+			}
+		case "LIKE", "NOT LIKE":
+			pattern, _ := cond.args[0].(string)
+			s, ok := v.(string)
+			if !ok || likeMatch(pattern, s) != (cond.op == "LIKE") {
+				return false
+			}
+		default:
+			cmp, ok := compareWatchValues(v, cond.args[0])
+			if !ok {
+				return false
+			}
+			switch cond.op {
+			case "=":
+				ok = cmp == 0
+			case "!=", "<>":
+				ok = cmp != 0
+			case "<":
+				ok = cmp < 0
+			case "<=":
+				ok = cmp <= 0
+			case ">":
+				ok = cmp > 0
+			case ">=":
+				ok = cmp >= 0
+			}
+			if !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// compareWatchValues compare a json value of a row with a filter argument, numbers, bools and times are compared as numbers
+func compareWatchValues(v, arg any) (int, bool) {
+	if v == nil || arg == nil {
+		return 0, false
+	}
+	if a, ok := watchNumber(arg); ok {
+		if b, ok := watchNumber(v); ok {
+			switch {
+			case b < a:
+				return -1, true
+			case b > a:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return strings.Compare(fmt.Sprint(v), fmt.Sprint(arg)), true
+}
+
+func watchNumber(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case bool:
+		if n {
+			return 1, true
+		}
+		return 0, true
+	case time.Time:
+		return float64(n.Unix()), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32:
+		return rv.Float(), true
+	}
+	return 0, false
+}
+
+// likeMatch match s against a sql LIKE pattern using % and _
+func likeMatch(pattern, s string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	ok, _ := regexp.MatchString(b.String(), s)
+	return ok
+}
@@ -0,0 +1,423 @@
+package korm
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/lg"
+)
+
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookDead      = "dead"
+)
+
+var (
+	ErrWebhookSignature = errors.New("invalid webhook signature")
+	// WebhooksMaxAttempts is the number of attempts of a delivery before it is marked dead
+	WebhooksMaxAttempts = 8
+	// WebhooksRetryBase is the delay before the first retry of a delivery, doubled at every attempt up to WebhooksRetryMax
+	WebhooksRetryBase = 10 * time.Second
+	WebhooksRetryMax  = time.Hour
+	// WebhooksTimeout is the timeout of a request to an endpoint
+	WebhooksTimeout = 10 * time.Second
+	// WebhooksPollEvery is the interval between polls of pending deliveries
+	WebhooksPollEvery = time.Second
+	// WebhooksConcurrency is the number of deliveries sent at the same time
+	WebhooksConcurrency = 4
+	// WebhooksRetention is how long delivered and dead deliveries are kept in the delivery log
+	WebhooksRetention = 7 * 24 * time.Hour
+	// WebhooksTolerance is the maximum age of a signature accepted by VerifyWebhook
+	WebhooksTolerance = 5 * time.Minute
+)
+
+// Webhook is an endpoint of the _webhooks table receiving changes of Tables for Operations, both comma separated and empty for all
+type Webhook struct {
+	Id         uint      `korm:"pk" json:"id"`
+	Url        string    `korm:"size:500" json:"url"`
+	Tables     string    `korm:"size:500" json:"tables"`
+	Operations string    `korm:"size:50" json:"operations"`
+	Secret     string    `korm:"size:100" json:"-"`
+	Active     bool      `json:"active"`
+	CreatedAt  time.Time `korm:"now" json:"created_at"`
+}
+
+// WebhookDelivery is a change sent to a webhook, stored in the _webhooks_deliveries table used as delivery log
+type WebhookDelivery struct {
+	Id          uint      `korm:"pk" json:"id"`
+	WebhookId   uint      `korm:"index" json:"webhook_id"`
+	Event       string    `korm:"size:150" json:"event"`
+	Payload     string    `korm:"text" json:"payload"`
+	Status      string    `korm:"size:20;index" json:"status"`
+	Attempts    int       `json:"attempts"`
+	StatusCode  int       `json:"status_code"`
+	Error       string    `korm:"text" json:"error"`
+	NextAt      time.Time `korm:"index" json:"next_at"`
+	CreatedAt   time.Time `korm:"now" json:"created_at"`
+	DeliveredAt time.Time `json:"delivered_at"`
+}
+
+// matches return true if w receive changes of operation on table
+func (w *Webhook) matches(table, operation string) bool {
+	return w.Active && webhookListMatch(w.Tables, table) && webhookListMatch(w.Operations, operation)
+}
+
+func webhookListMatch(list, v string) bool {
+	if strings.TrimSpace(list) == "" {
+		return true
+	}
+	for _, s := range strings.Split(list, ",") {
+		if strings.TrimSpace(s) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookStore manage webhooks and deliveries of a database
+type WebhookStore struct {
+	c  *Client
+	db *DatabaseEntity
+}
+
+// Webhooks return the webhooks of dbName, or of the first database if empty. The first call migrate _webhooks and _webhooks_deliveries,
+// add hooks queuing a delivery per matching webhook for every change, and start the delivery worker, so it should be called at startup.
+// Deliveries are POST of the JSON HookData, signed using the secret of the webhook, see VerifyWebhook
+//
+//	Example:
+//	  wh, err := korm.Webhooks("")
+//	  hook, err := wh.Add(korm.Webhook{Url: "https://example.com/hooks", Tables: "orders", Operations: "insert,update"})
+//	  // hook.Secret is used by the receiver to verify deliveries
+func Webhooks(dbName string) (*WebhookStore, error) {
+	return defaultClient.Webhooks(dbName)
+}
+
+// Webhooks is korm.Webhooks for client c
+func (c *Client) Webhooks(dbName string) (*WebhookStore, error) {
+	db, err := c.GetMemoryDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := c.internalTables.Get(db.Name + "._webhooks"); !ok {
+		if err := AutoMigrateOn[Webhook](c, "_webhooks", db.Name); err != nil {
+			return nil, err
+		}
+		if err := AutoMigrateOn[WebhookDelivery](c, "_webhooks_deliveries", db.Name); err != nil {
+			return nil, err
+		}
+		c.internalTables.Set(db.Name+"._webhooks", struct{}{})
+		s := &WebhookStore{c: c, db: db}
+		c.OnInsertE(s.enqueue)
+		c.OnSetE(s.enqueue)
+		c.OnDeleteE(s.enqueue)
+		c.goWorker("webhooks "+db.Name, db.Name, s.worker)
+		c.goWorker("webhooks retention "+db.Name, db.Name, func(ctx context.Context) {
+			for sleepCtx(ctx, time.Hour) {
+				_, err := ModelOn[WebhookDelivery](c).Database(db.Name).Context(ctx).Where("status <> ? AND created_at < ?", WebhookPending, time.Now().Add(-WebhooksRetention)).Delete()
+				if err != nil && !errors.Is(err, ErrNoData) && ctx.Err() == nil {
+					lg.ErrorC("could not delete old webhook deliveries", "db", db.Name, "err", err)
+				}
+			}
+		})
+	}
+	return &WebhookStore{c: c, db: db}, nil
+}
+
+func (s *WebhookStore) hooks() *BuilderS[Webhook] {
+	return ModelOn[Webhook](s.c).Database(s.db.Name)
+}
+
+func (s *WebhookStore) deliveries() *BuilderS[WebhookDelivery] {
+	return ModelOn[WebhookDelivery](s.c).Database(s.db.Name)
+}
+
+// Add register w, a random Secret is generated if empty, the returned webhook has its Id and Secret
+func (s *WebhookStore) Add(w Webhook) (Webhook, error) {
+	if !strings.HasPrefix(w.Url, "http://") && !strings.HasPrefix(w.Url, "https://") {
+		return w, fmt.Errorf("invalid webhook url %q", w.Url)
+	}
+	if w.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return w, err
+		}
+		w.Secret = hex.EncodeToString(b)
+	}
+	w.Active = true
+	w.CreatedAt = time.Now()
+	id, err := s.hooks().Insert(&w)
+	if err != nil {
+		return w, err
+	}
+	w.Id = uint(id)
+	return w, nil
+}
+
+// SetActive enable or disable the webhook id, disabled webhooks do not receive new changes
+func (s *WebhookStore) SetActive(id uint, active bool) error {
+	_, err := s.hooks().Where("id = ?", id).Set("active = ?", active)
+	return err
+}
+
+// Remove delete the webhook id and its deliveries
+func (s *WebhookStore) Remove(id uint) error {
+	if _, err := s.deliveries().Where("webhook_id = ?", id).Delete(); err != nil && !errors.Is(err, ErrNoData) {
+		return err
+	}
+	_, err := s.hooks().Where("id = ?", id).Delete()
+	return err
+}
+
+// List return all webhooks
+func (s *WebhookStore) List() ([]Webhook, error) {
+	hooks, err := s.hooks().OrderBy("id").All()
+	if errors.Is(err, ErrNoData) {
+		return []Webhook{}, nil
+	}
+	return hooks, err
+}
+
+// Deliveries return the last limit deliveries of webhookId, or of all webhooks if 0, most recent first
+func (s *WebhookStore) Deliveries(webhookId uint, limit int) ([]WebhookDelivery, error) {
+	q := s.deliveries().NoCache()
+	if webhookId > 0 {
+		q = q.Where("webhook_id = ?", webhookId)
+	}
+	res, err := q.OrderBy("-id").Limit(limit).All()
+	if errors.Is(err, ErrNoData) {
+		return []WebhookDelivery{}, nil
+	}
+	return res, err
+}
+
+// Redeliver send again the delivery id now, with its attempts reset
+func (s *WebhookStore) Redeliver(id uint) error {
+	n, err := s.deliveries().Where("id = ?", id).Set("status = ?, attempts = ?, next_at = ?", WebhookPending, 0, time.Now())
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoData
+	}
+	s.c.wakeWebhooks()
+	return nil
+}
+
+// enqueue add a delivery of hd for each matching webhook, it is called by change hooks
+func (s *WebhookStore) enqueue(hd HookData) error {
+	if hd.database != "" && hd.database != s.db.Name {
+		return nil
+	}
+	hooks, err := s.hooks().Where("active = ?", true).All()
+	if errors.Is(err, ErrNoData) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	queued := false
+	for _, w := range hooks {
+		if !w.matches(hd.Table, hd.Operation) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(hd); err != nil {
+				return err
+			}
+		}
+		_, err := s.deliveries().Context(hd.Context()).Insert(&WebhookDelivery{
+			WebhookId: w.Id,
+			Event:     hd.Table + "." + hd.Operation,
+			Payload:   string(payload),
+			Status:    WebhookPending,
+			NextAt:    time.Now(),
+			CreatedAt: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		queued = true
+	}
+	if queued {
+		s.c.wakeWebhooks()
+	}
+	return nil
+}
+
+func (c *Client) wakeWebhooks() {
+	select {
+	case c.webhooksWake <- struct{}{}:
+	default:
+	}
+}
+
+// worker send pending deliveries until ctx is done
+func (s *WebhookStore) worker(ctx context.Context) {
+	for ctx.Err() == nil {
+		if s.deliverPending(ctx) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-s.c.webhooksWake:
+		case <-time.After(WebhooksPollEvery):
+		}
+	}
+}
+
+// deliverPending send due deliveries, it return false if none was due
+func (s *WebhookStore) deliverPending(ctx context.Context) bool {
+	due, err := s.deliveries().Context(ctx).NoCache().Where("status = ? AND next_at <= ?", WebhookPending, time.Now()).OrderBy("id").Limit(50).All()
+	if err != nil {
+		if !errors.Is(err, ErrNoData) && ctx.Err() == nil {
+			lg.ErrorC("could not read webhook deliveries", "db", s.db.Name, "err", err)
+		}
+		return false
+	}
+	hooks := map[uint]*Webhook{}
+	sem := make(chan struct{}, max(WebhooksConcurrency, 1))
+	var wg sync.WaitGroup
+	for _, d := range due {
+		// lease the delivery so other nodes do not send it at the same time
+		n, err := s.deliveries().Context(ctx).Where("id = ? AND status = ? AND next_at = ?", d.Id, WebhookPending, d.NextAt).Set("next_at = ?", time.Now().Add(2*WebhooksTimeout))
+		if err != nil || n != 1 {
+			continue
+		}
+		w, ok := hooks[d.WebhookId]
+		if !ok {
+			hook, err := s.hooks().Context(ctx).Where("id = ?", d.WebhookId).One()
+			if err != nil {
+				s.finish(ctx, d, 0, fmt.Errorf("webhook %d: %w", d.WebhookId, err), true)
+				continue
+			}
+			w = &hook
+			hooks[d.WebhookId] = w
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(d WebhookDelivery) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			code, err := sendWebhook(ctx, w, &d)
+			s.finish(ctx, d, code, err, false)
+		}(d)
+	}
+	wg.Wait()
+	return len(due) > 0
+}
+
+// finish record the result of an attempt of d, scheduling a retry with a backoff on failure until WebhooksMaxAttempts
+func (s *WebhookStore) finish(ctx context.Context, d WebhookDelivery, code int, err error, dead bool) {
+	ctx = context.WithoutCancel(ctx)
+	d.Attempts++
+	var e error
+	switch {
+	case err == nil:
+		_, e = s.deliveries().Context(ctx).Where("id = ?", d.Id).Set("status = ?, attempts = ?, status_code = ?, error = ?, delivered_at = ?", WebhookDelivered, d.Attempts, code, "", time.Now())
+	case dead || d.Attempts >= WebhooksMaxAttempts:
+		lg.ErrorC("webhook delivery failed, marking it dead", "webhook", d.WebhookId, "delivery", d.Id, "err", err)
+		_, e = s.deliveries().Context(ctx).Where("id = ?", d.Id).Set("status = ?, attempts = ?, status_code = ?, error = ?", WebhookDead, d.Attempts, code, err.Error())
+	default:
+		_, e = s.deliveries().Context(ctx).Where("id = ?", d.Id).Set("attempts = ?, status_code = ?, error = ?, next_at = ?", d.Attempts, code, err.Error(), time.Now().Add(webhookBackoff(d.Attempts)))
+	}
+	lg.CheckError(e)
+}
+
+// webhookBackoff return the delay before retrying a delivery that failed attempts times
+func webhookBackoff(attempts int) time.Duration {
+	delay := WebhooksRetryBase
+	for i := 1; i < attempts && delay < WebhooksRetryMax; i++ {
+		delay *= 2
+	}
+	if delay > WebhooksRetryMax {
+		delay = WebhooksRetryMax
+	}
+	return delay
+}
+
+// sendWebhook POST the payload of d to w, a response status outside 2xx is an error
+func sendWebhook(ctx context.Context, w *Webhook, d *WebhookDelivery) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, WebhooksTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Url, strings.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "korm-webhooks")
+	req.Header.Set("X-Korm-Event", d.Event)
+	req.Header.Set("X-Korm-Delivery", strconv.FormatUint(uint64(d.Id), 10))
+	req.Header.Set("X-Korm-Timestamp", ts)
+	req.Header.Set("X-Korm-Signature", "sha256="+signWebhook(w.Secret, ts, []byte(d.Payload)))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// signWebhook return the hex hmac sha256 of timestamp.body using secret
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook check the signature of a delivery received by r using secret, and return its body decoded into HookData.
+// Signatures older than WebhooksTolerance are refused
+//
+//	Example:
+//	  http.HandleFunc("/hooks", func(w http.ResponseWriter, r *http.Request) {
+//	  	hd, err := korm.VerifyWebhook(secret, r)
+//	  	if err != nil {
+//	  		w.WriteHeader(http.StatusUnauthorized)
+//	  		return
+//	  	}
+//	  	fmt.Println(hd.Table, hd.Operation, hd.Data)
+//	  })
+func VerifyWebhook(secret string, r *http.Request) (HookData, error) {
+	hd := HookData{}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return hd, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	ts := r.Header.Get("X-Korm-Timestamp")
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return hd, ErrWebhookSignature
+	}
+	if age := time.Since(time.Unix(sec, 0)); age > WebhooksTolerance || age < -WebhooksTolerance {
+		return hd, ErrWebhookSignature
+	}
+	sig := strings.TrimPrefix(r.Header.Get("X-Korm-Signature"), "sha256=")
+	if !hmac.Equal([]byte(sig), []byte(signWebhook(secret, ts, body))) {
+		return hd, ErrWebhookSignature
+	}
+	err = json.Unmarshal(body, &hd)
+	return hd, err
+}